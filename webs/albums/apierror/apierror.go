@@ -0,0 +1,221 @@
+// Package apierror writes the error responses of the album services: the
+// success/error/code envelope in the format the client negotiated, or an
+// RFC 7807 problem+json body when the client asks for that. Each service
+// names its own codes in a Catalogue; the ones every service needs are here.
+package apierror
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"example.com/albums/catalog"
+	"example.com/albums/negotiate"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/jackc/pgx/v5/pgconn"
+	"gorm.io/gorm"
+)
+
+// Code is the machine-readable reason sent with every error response
+type Code string
+
+const (
+	CodeValidationFailed     Code = "VALIDATION_FAILED"
+	CodeDuplicateResource    Code = "DUPLICATE_RESOURCE"
+	CodeInvalidReference     Code = "INVALID_REFERENCE"
+	CodeNotAcceptable        Code = "NOT_ACCEPTABLE"
+	CodeUnsupportedMediaType Code = "UNSUPPORTED_MEDIA_TYPE"
+	CodeInternal             Code = "INTERNAL_ERROR"
+)
+
+// Catalogue maps each code to its HTTP status and default message, so a code
+// can never be sent with the wrong status
+type Catalogue map[Code]struct {
+	Status  int
+	Message string
+}
+
+// common are the codes Writer itself sends
+var common = Catalogue{
+	CodeValidationFailed:     {http.StatusBadRequest, "Request validation failed"},
+	CodeDuplicateResource:    {http.StatusConflict, "A record with the same unique value already exists"},
+	CodeInvalidReference:     {http.StatusUnprocessableEntity, "The request references a record that does not exist"},
+	CodeNotAcceptable:        {http.StatusNotAcceptable, "Unsupported Accept header. Use JSON, XML, YAML or MessagePack"},
+	CodeUnsupportedMediaType: {http.StatusUnsupportedMediaType, "Unsupported Content-Type. Use JSON, XML, YAML or MessagePack"},
+	CodeInternal:             {http.StatusInternalServerError, "Internal server error"},
+}
+
+// MIMEProblemJSON is the RFC 7807 media type clients can ask for in Accept
+const MIMEProblemJSON = "application/problem+json"
+
+// ProblemDetails is the RFC 7807 body sent when the client accepts problem+json
+type ProblemDetails struct {
+	Type      string `json:"type"`
+	Title     string `json:"title"`
+	Status    int    `json:"status"`
+	Detail    string `json:"detail,omitempty"`
+	Instance  string `json:"instance,omitempty"`
+	Code      Code   `json:"code"`
+	RequestID string `json:"request_id,omitempty"`
+
+	Errors []ValidationIssue `json:"errors,omitempty"` // extension member for VALIDATION_FAILED
+}
+
+// ValidationIssue pinpoints one invalid part of a request
+type ValidationIssue struct {
+	Location string `json:"location" xml:"location"` // path, query, header or body
+	Field    string `json:"field,omitempty" xml:"field,omitempty"`
+	Message  string `json:"message" xml:"message"`
+}
+
+// Response is the standard error envelope
+type Response struct {
+	Success   bool   `json:"success" xml:"success"`
+	Error     string `json:"error" xml:"error"`
+	Code      Code   `json:"code" xml:"code"`
+	RequestID string `json:"request_id,omitempty" xml:"request_id,omitempty"`
+
+	Details []ValidationIssue `json:"details,omitempty" xml:"details>issue,omitempty"`
+}
+
+// Writer writes the errors of one service
+type Writer struct {
+	// Catalogue holds the service's codes and the common ones
+	Catalogue Catalogue
+
+	service string
+}
+
+// New returns the Writer of the service called name, which prefixes its
+// problem types (urn:<name>:error:album-not-found). codes adds the service's
+// own codes to the common ones.
+func New(name string, codes Catalogue) *Writer {
+	w := &Writer{Catalogue: Catalogue{}, service: name}
+	for code, entry := range common {
+		w.Catalogue[code] = entry
+	}
+	for code, entry := range codes {
+		w.Catalogue[code] = entry
+	}
+	return w
+}
+
+// RequestID returns the request ID the services' RequestID middleware set
+func RequestID(c *gin.Context) string {
+	return c.GetString("request_id")
+}
+
+// NewResponse builds the standard error envelope for a code.
+// An empty message falls back to the catalogue default.
+func (w *Writer) NewResponse(c *gin.Context, code Code, message string) Response {
+	if message == "" {
+		message = w.Catalogue[code].Message
+	}
+	return Response{
+		Success:   false,
+		Error:     message,
+		Code:      code,
+		RequestID: RequestID(c),
+	}
+}
+
+// Respond writes an error for the given code, as problem+json when the
+// client explicitly asked for it and in the negotiated format otherwise.
+// issues, if any, say what failed validation.
+func (w *Writer) Respond(c *gin.Context, code Code, message string, issues []ValidationIssue) {
+	entry := w.Catalogue[code]
+	body := w.NewResponse(c, code, message)
+	body.Details = issues
+
+	if WantsProblemJSON(c) {
+		c.Header("Content-Type", MIMEProblemJSON)
+		c.JSON(entry.Status, ProblemDetails{
+			Type:      "urn:" + w.service + ":error:" + strings.ToLower(strings.ReplaceAll(string(code), "_", "-")),
+			Title:     entry.Message,
+			Status:    entry.Status,
+			Detail:    body.Error,
+			Instance:  c.Request.URL.Path,
+			Code:      code,
+			RequestID: body.RequestID,
+			Errors:    issues,
+		})
+		return
+	}
+
+	negotiate.Respond(c, entry.Status, body)
+}
+
+// Abort is Respond for middleware that must stop the chain
+func (w *Writer) Abort(c *gin.Context, code Code, message string) {
+	c.Abort()
+	w.Respond(c, code, message, nil)
+}
+
+// RespondDB maps a GORM/pgx or album service error to a client error where
+// one applies (notFound is the code to use for a missing record). Anything
+// else is logged with the request ID and answered with a generic 500.
+func (w *Writer) RespondDB(c *gin.Context, err error, notFound Code) {
+	var invalid *catalog.ValidationError
+	if errors.As(err, &invalid) {
+		w.Respond(c, CodeValidationFailed, "", Issues(invalid))
+		return
+	}
+	if code, ok := ClientCode(err, notFound); ok {
+		w.Respond(c, code, "", nil)
+		return
+	}
+	w.Internal(c, err)
+}
+
+// Internal logs err server-side and hides it from the client
+func (w *Writer) Internal(c *gin.Context, err error) {
+	log.Printf("[%s] %s %s: %v", RequestID(c), c.Request.Method, c.Request.URL.Path, err)
+	w.Respond(c, CodeInternal, "", nil)
+}
+
+// ContentNegotiation rejects requests whose Accept or Content-Type header
+// names a format we don't speak, before any handler does work.
+func (w *Writer) ContentNegotiation() gin.HandlerFunc {
+	return negotiate.Middleware([]string{MIMEProblemJSON},
+		func(c *gin.Context) { w.Abort(c, CodeNotAcceptable, "") },
+		func(c *gin.Context) { w.Abort(c, CodeUnsupportedMediaType, "") })
+}
+
+// ClientCode returns the client error a GORM/pgx or album validation error
+// stands for, or false for anything else.
+func ClientCode(err error, notFound Code) (Code, bool) {
+	var pgErr *pgconn.PgError
+	var invalid *catalog.ValidationError
+
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return notFound, true
+	case errors.As(err, &invalid):
+		return CodeValidationFailed, true
+	case errors.Is(err, gorm.ErrDuplicatedKey):
+		return CodeDuplicateResource, true
+	case errors.Is(err, gorm.ErrForeignKeyViolated):
+		return CodeInvalidReference, true
+	case errors.As(err, &pgErr) && (pgErr.Code == "23502" || pgErr.Code == "22P02"):
+		// not_null_violation, invalid_text_representation
+		return CodeValidationFailed, true
+	default:
+		return "", false
+	}
+}
+
+// Issues lists what is wrong with an album as body issues
+func Issues(invalid *catalog.ValidationError) []ValidationIssue {
+	issues := make([]ValidationIssue, len(invalid.Issues))
+	for i, issue := range invalid.Issues {
+		issues[i] = ValidationIssue{Location: "body", Field: issue.Field, Message: issue.Message}
+	}
+	return issues
+}
+
+// WantsProblemJSON reports whether problem+json is the client's preferred format
+func WantsProblemJSON(c *gin.Context) bool {
+	return c.NegotiateFormat(binding.MIMEJSON, MIMEProblemJSON) == MIMEProblemJSON
+}
@@ -0,0 +1,101 @@
+package apierror
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"example.com/albums/catalog"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const codeAlbumNotFound Code = "ALBUM_NOT_FOUND"
+
+// newRouter answers GET /fail with RespondDB for the error the test sets
+func newRouter(w *Writer, err error) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(func(c *gin.Context) { c.Set("request_id", "req-1") })
+	router.Use(w.ContentNegotiation())
+	router.GET("/fail", func(c *gin.Context) { w.RespondDB(c, err, codeAlbumNotFound) })
+	return router
+}
+
+func TestNewMergesTheCommonCodes(t *testing.T) {
+	w := New("test", Catalogue{codeAlbumNotFound: {http.StatusNotFound, "Album not found"}})
+	for code, entry := range common {
+		if w.Catalogue[code] != entry {
+			t.Errorf("Catalogue[%s] = %+v, want %+v", code, w.Catalogue[code], entry)
+		}
+	}
+	if w.Catalogue[codeAlbumNotFound].Status != http.StatusNotFound {
+		t.Errorf("Catalogue[%s] = %+v, want the service's entry", codeAlbumNotFound, w.Catalogue[codeAlbumNotFound])
+	}
+}
+
+func TestRespondDB(t *testing.T) {
+	w := New("test", Catalogue{codeAlbumNotFound: {http.StatusNotFound, "Album not found"}})
+	invalid := &catalog.ValidationError{Issues: []catalog.Issue{{Field: "price", Message: "must not be negative"}}}
+
+	tests := []struct {
+		name       string
+		err        error
+		accept     string
+		wantStatus int
+		wantCode   Code
+		wantIssues int
+	}{
+		{"missing record", gorm.ErrRecordNotFound, "", http.StatusNotFound, codeAlbumNotFound, 0},
+		{"wrapped missing record", fmt.Errorf("find: %w", gorm.ErrRecordNotFound), "", http.StatusNotFound, codeAlbumNotFound, 0},
+		{"invalid album", invalid, "", http.StatusBadRequest, CodeValidationFailed, 1},
+		{"duplicate", gorm.ErrDuplicatedKey, "", http.StatusConflict, CodeDuplicateResource, 0},
+		{"foreign key", gorm.ErrForeignKeyViolated, "", http.StatusUnprocessableEntity, CodeInvalidReference, 0},
+		{"anything else", errors.New("connection refused"), "", http.StatusInternalServerError, CodeInternal, 0},
+		{"problem+json", gorm.ErrRecordNotFound, MIMEProblemJSON, http.StatusNotFound, codeAlbumNotFound, 0},
+		{"unknown Accept", gorm.ErrRecordNotFound, "text/csv", http.StatusNotAcceptable, CodeNotAcceptable, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/fail", nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			rec := httptest.NewRecorder()
+			newRouter(w, tt.err).ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+
+			if tt.accept == MIMEProblemJSON {
+				var problem ProblemDetails
+				if err := json.Unmarshal(rec.Body.Bytes(), &problem); err != nil {
+					t.Fatalf("decode %s: %v", rec.Body, err)
+				}
+				if rec.Header().Get("Content-Type") != MIMEProblemJSON {
+					t.Errorf("Content-Type = %q, want %s", rec.Header().Get("Content-Type"), MIMEProblemJSON)
+				}
+				if problem.Type != "urn:test:error:album-not-found" || problem.Code != tt.wantCode || problem.RequestID != "req-1" {
+					t.Errorf("problem = %+v, want type urn:test:error:album-not-found, code %s and request ID req-1", problem, tt.wantCode)
+				}
+				return
+			}
+
+			var body Response
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("decode %s: %v", rec.Body, err)
+			}
+			if body.Code != tt.wantCode || body.Error != w.Catalogue[tt.wantCode].Message || body.RequestID != "req-1" {
+				t.Errorf("body = %+v, want code %s with its default message and request ID req-1", body, tt.wantCode)
+			}
+			if len(body.Details) != tt.wantIssues {
+				t.Errorf("details = %+v, want %d", body.Details, tt.wantIssues)
+			}
+		})
+	}
+}
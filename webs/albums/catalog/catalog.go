@@ -0,0 +1,84 @@
+// Package catalog is the album code the album services share: the fields
+// every album has and the rules for them, a Repository to keep albums in
+// (Gorm in a database, Memory in memory) and the Service the handlers call.
+// Each service has its own album type, with its own ID; it takes part by
+// implementing Album.
+package catalog
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// ErrNotFound means no album has the ID. It is GORM's error, so handlers
+// map it the same way whichever Repository the service runs on.
+var ErrNotFound = gorm.ErrRecordNotFound
+
+// ErrDuplicateID means another album already has the ID. It wraps GORM's
+// error for a unique violation.
+var ErrDuplicateID = fmt.Errorf("an album with this id already exists (%w)", gorm.ErrDuplicatedKey)
+
+// ErrMissingID means the album was sent without an ID where the client
+// picks them
+var ErrMissingID = errors.New("id is required")
+
+// Album is implemented by each service's album type
+type Album[K comparable, T any] interface {
+	// AlbumID is the album's ID, the zero value for one not yet stored
+	AlbumID() K
+	// WithAlbumID is a copy of the album with its ID set to id
+	WithAlbumID(id K) T
+	// AlbumFields are the fields every album has
+	AlbumFields() Fields
+}
+
+// Fields are what every album has, whatever else a service keeps with it
+type Fields struct {
+	Title  string
+	Artist string
+	Price  float64
+}
+
+// Issue is one thing wrong with an album's fields
+type Issue struct {
+	Field   string
+	Message string
+}
+
+// ValidationError lists what is wrong with an album's fields
+type ValidationError struct {
+	Issues []Issue
+}
+
+func (e *ValidationError) Error() string {
+	problems := make([]string, len(e.Issues))
+	for i, issue := range e.Issues {
+		problems[i] = issue.Field + " " + issue.Message
+	}
+	return "invalid album: " + strings.Join(problems, "; ")
+}
+
+// Validate returns a *ValidationError unless the fields make an album. The
+// checks don't rely on the body's format: XML and MessagePack carry NaN and
+// infinite prices that no JSON schema sees.
+func (f Fields) Validate() error {
+	var issues []Issue
+	if f.Title == "" {
+		issues = append(issues, Issue{Field: "title", Message: "must not be empty"})
+	}
+	if f.Artist == "" {
+		issues = append(issues, Issue{Field: "artist", Message: "must not be empty"})
+	}
+	if math.IsNaN(f.Price) || math.IsInf(f.Price, 0) || f.Price < 0 {
+		issues = append(issues, Issue{Field: "price", Message: "must be a number of at least 0"})
+	}
+
+	if issues != nil {
+		return &ValidationError{Issues: issues}
+	}
+	return nil
+}
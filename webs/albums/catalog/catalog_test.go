@@ -0,0 +1,190 @@
+package catalog
+
+import (
+	"errors"
+	"math"
+	"sync"
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+)
+
+// album is an album type with client-picked IDs, like web-service-gin's
+type album struct {
+	ID     string
+	Title  string
+	Artist string
+	Price  float64
+}
+
+func (a album) AlbumID() string             { return a.ID }
+func (a album) WithAlbumID(id string) album { a.ID = id; return a }
+func (a album) AlbumFields() Fields         { return Fields{a.Title, a.Artist, a.Price} }
+
+// numbered is an album type with IDs the store gives, like gorm-queries'
+type numbered struct {
+	ID     uint `gorm:"primaryKey"`
+	Title  string
+	Artist string
+	Price  float64
+}
+
+func (a numbered) AlbumID() uint                { return a.ID }
+func (a numbered) WithAlbumID(id uint) numbered { a.ID = id; return a }
+func (a numbered) AlbumFields() Fields          { return Fields{a.Title, a.Artist, a.Price} }
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		fields Fields
+		want   []string // fields with issues
+	}{
+		{"complete", Fields{"Jeru", "Gerry Mulligan", 17.99}, nil},
+		{"free", Fields{"Jeru", "Gerry Mulligan", 0}, nil},
+		{"no title or artist", Fields{"", "", 1}, []string{"title", "artist"}},
+		{"negative price", Fields{"Jeru", "Gerry Mulligan", -1}, []string{"price"}},
+		{"NaN price", Fields{"Jeru", "Gerry Mulligan", math.NaN()}, []string{"price"}},
+		{"infinite price", Fields{"Jeru", "Gerry Mulligan", math.Inf(1)}, []string{"price"}},
+		{"negative infinite price", Fields{"Jeru", "Gerry Mulligan", math.Inf(-1)}, []string{"price"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.fields.Validate()
+			var invalid *ValidationError
+			if tt.want == nil {
+				if err != nil {
+					t.Fatalf("Validate = %v, want nil", err)
+				}
+				return
+			}
+			if !errors.As(err, &invalid) || len(invalid.Issues) != len(tt.want) {
+				t.Fatalf("Validate = %v, want issues with %v", err, tt.want)
+			}
+			for i, issue := range invalid.Issues {
+				if issue.Field != tt.want[i] {
+					t.Errorf("issue %d is about %q, want %q", i, issue.Field, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestServiceOnEachRepository(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file:"+t.Name()+"?mode=memory&cache=shared"), &gorm.Config{TranslateError: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&numbered{}); err != nil {
+		t.Fatal(err)
+	}
+
+	repositories := []struct {
+		name   string
+		albums Repository[uint, numbered]
+	}{
+		{"gorm", NewGorm[uint, numbered](db)},
+		{"memory", NewMemory[uint, numbered](Sequence())},
+	}
+	for _, repo := range repositories {
+		t.Run(repo.name, func(t *testing.T) {
+			service := NewService(repo.albums)
+
+			created, err := service.Create(numbered{ID: 42, Title: "Jeru", Artist: "Gerry Mulligan", Price: 17.99})
+			if err != nil || created.ID == 0 || created.ID == 42 {
+				t.Fatalf("Create = %+v, %v; want a new ID", created, err)
+			}
+			if _, err := service.Create(numbered{Title: "Jeru", Artist: "Gerry Mulligan", Price: math.NaN()}); err == nil {
+				t.Error("Create saved a NaN price")
+			}
+
+			updated, err := service.Update(created.ID, numbered{ID: 7, Title: "Jeru", Artist: "Gerry Mulligan", Price: 3})
+			if err != nil || updated.ID != created.ID || updated.Price != 3 {
+				t.Fatalf("Update = %+v, %v; want the price changed under the same ID", updated, err)
+			}
+			if _, err := service.Update(created.ID, numbered{Title: "Jeru", Artist: "Gerry Mulligan", Price: math.Inf(1)}); err == nil {
+				t.Error("Update saved an infinite price")
+			}
+			if got, err := service.Get(created.ID); err != nil || got.Price != 3 {
+				t.Errorf("Get = %+v, %v; want the updated album", got, err)
+			}
+			if _, err := service.Update(created.ID+100, numbered{Title: "Jeru", Artist: "Gerry Mulligan", Price: 3}); !errors.Is(err, ErrNotFound) {
+				t.Errorf("Update of a missing album = %v, want ErrNotFound", err)
+			}
+			if _, err := service.Get(created.ID + 100); !errors.Is(err, ErrNotFound) {
+				t.Errorf("Get after updating a missing album = %v, want ErrNotFound", err)
+			}
+			if updated, err := service.Update(created.ID, numbered{Title: "Jeru", Artist: "Gerry Mulligan"}); err != nil || updated.Price != 0 {
+				t.Errorf("Update to a free album = %+v, %v", updated, err)
+			}
+			if got, _ := service.Get(created.ID); got.Price != 0 {
+				t.Errorf("price after updating to 0 = %v, want 0", got.Price)
+			}
+
+			if err := service.Delete(created.ID); err != nil {
+				t.Fatalf("Delete = %v", err)
+			}
+			if _, err := service.Get(created.ID); !errors.Is(err, ErrNotFound) {
+				t.Errorf("Get after Delete = %v, want ErrNotFound", err)
+			}
+			if err := service.Delete(created.ID); !errors.Is(err, ErrNotFound) {
+				t.Errorf("Delete again = %v, want ErrNotFound", err)
+			}
+		})
+	}
+}
+
+func TestMemoryWithClientIDs(t *testing.T) {
+	service := NewService(NewMemory[string, album](nil, album{ID: "1", Title: "Blue Train", Artist: "John Coltrane", Price: 56.99}))
+
+	tests := []struct {
+		name    string
+		album   album
+		wantErr error
+	}{
+		{"new ID", album{ID: "2", Title: "Jeru", Artist: "Gerry Mulligan", Price: 17.99}, nil},
+		{"taken ID", album{ID: "1", Title: "Jeru", Artist: "Gerry Mulligan", Price: 17.99}, ErrDuplicateID},
+		{"no ID", album{Title: "Jeru", Artist: "Gerry Mulligan", Price: 17.99}, ErrMissingID},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := service.Create(tt.album); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Create = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	albums, _ := service.List()
+	if len(albums) != 2 || albums[0].ID != "1" || albums[1].ID != "2" {
+		t.Errorf("List = %+v, want albums 1 and 2 in the order they were added", albums)
+	}
+	if !errors.Is(ErrDuplicateID, gorm.ErrDuplicatedKey) {
+		t.Error("ErrDuplicateID doesn't match GORM's unique violation")
+	}
+}
+
+// Albums created together with the same ID: exactly one is stored
+func TestMemoryCreateIsAtomic(t *testing.T) {
+	albums := NewMemory[string, album](nil)
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	created := 0
+	for range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			a := album{ID: "1", Title: "Jeru", Artist: "Gerry Mulligan", Price: 17.99}
+			if err := albums.Create(&a); err == nil {
+				mu.Lock()
+				created++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if list, _ := albums.List(); created != 1 || len(list) != 1 {
+		t.Errorf("%d creates succeeded leaving %d albums, want 1", created, len(list))
+	}
+}
@@ -0,0 +1,180 @@
+package catalog
+
+import (
+	"sync"
+
+	"gorm.io/gorm"
+)
+
+// Repository is where a Service keeps albums of type T with IDs of type K.
+// Lookups return ErrNotFound for an album that isn't there.
+type Repository[K comparable, T Album[K, T]] interface {
+	List() ([]T, error)
+	Get(id K) (T, error)
+	Create(album *T) error
+	Update(album *T) error
+	Delete(id K) error
+}
+
+// Gorm keeps albums in a database, which gives them their IDs
+type Gorm[K comparable, T Album[K, T]] struct {
+	db *gorm.DB
+}
+
+// NewGorm keeps albums in db
+func NewGorm[K comparable, T Album[K, T]](db *gorm.DB) *Gorm[K, T] {
+	return &Gorm[K, T]{db: db}
+}
+
+func (r *Gorm[K, T]) List() ([]T, error) {
+	var albums []T
+	err := r.db.Order("id").Find(&albums).Error
+	return albums, err
+}
+
+func (r *Gorm[K, T]) Get(id K) (T, error) {
+	var album T
+	err := r.db.First(&album, "id = ?", id).Error
+	return album, err
+}
+
+// Create inserts album under the next ID, replacing any it has
+func (r *Gorm[K, T]) Create(album *T) error {
+	var zero K
+	*album = (*album).WithAlbumID(zero)
+	return r.db.Create(album).Error
+}
+
+// Update writes every field of album over the one with its ID. Unlike Save
+// it never inserts, so an album that isn't there stays ErrNotFound.
+func (r *Gorm[K, T]) Update(album *T) error {
+	result := r.db.Model(album).Where("id = ?", (*album).AlbumID()).Select("*").Updates(album)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *Gorm[K, T]) Delete(id K) error {
+	result := r.db.Delete(new(T), "id = ?", id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Memory keeps albums in memory, in the order they were added, for running
+// without a database. It is safe for concurrent use.
+type Memory[K comparable, T Album[K, T]] struct {
+	mu     sync.RWMutex
+	next   func() K
+	ids    []K
+	albums map[K]T
+}
+
+// NewMemory starts with albums. With next, Create gives every album the
+// next ID it returns that isn't taken; without it the client picks them, and
+// Create refuses an album without one or with one already taken.
+func NewMemory[K comparable, T Album[K, T]](next func() K, albums ...T) *Memory[K, T] {
+	r := &Memory[K, T]{next: next, albums: map[K]T{}}
+	for _, album := range albums {
+		r.Create(&album)
+	}
+	return r
+}
+
+func (r *Memory[K, T]) List() ([]T, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	albums := make([]T, 0, len(r.ids))
+	for _, id := range r.ids {
+		albums = append(albums, r.albums[id])
+	}
+	return albums, nil
+}
+
+func (r *Memory[K, T]) Get(id K) (T, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	album, ok := r.albums[id]
+	if !ok {
+		var zero T
+		return zero, ErrNotFound
+	}
+	return album, nil
+}
+
+// Create checks the ID and stores the album under one lock, so two albums
+// created together can't end up with the same one
+func (r *Memory[K, T]) Create(album *T) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var zero K
+	id := (*album).AlbumID()
+	if r.next != nil {
+		for id = r.next(); id == zero || r.taken(id); id = r.next() {
+		}
+	} else if id == zero {
+		return ErrMissingID
+	} else if r.taken(id) {
+		return ErrDuplicateID
+	}
+
+	*album = (*album).WithAlbumID(id)
+	r.ids = append(r.ids, id)
+	r.albums[id] = *album
+	return nil
+}
+
+func (r *Memory[K, T]) taken(id K) bool {
+	_, ok := r.albums[id]
+	return ok
+}
+
+func (r *Memory[K, T]) Update(album *T) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	id := (*album).AlbumID()
+	if !r.taken(id) {
+		return ErrNotFound
+	}
+	r.albums[id] = *album
+	return nil
+}
+
+func (r *Memory[K, T]) Delete(id K) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.taken(id) {
+		return ErrNotFound
+	}
+	delete(r.albums, id)
+	for i := range r.ids {
+		if r.ids[i] == id {
+			r.ids = append(r.ids[:i], r.ids[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+// Sequence returns 1, 2, 3... on each call, the next func for albums with
+// numeric IDs
+func Sequence() func() uint {
+	var last uint
+	return func() uint {
+		last++
+		return last
+	}
+}
@@ -0,0 +1,53 @@
+package catalog
+
+// Service is what the handlers do with albums, on whichever Repository main
+// gives it. It checks the fields on every write and returns
+// *ValidationError, ErrNotFound and the repository's other errors for the
+// handlers to map to responses.
+type Service[K comparable, T Album[K, T]] struct {
+	albums Repository[K, T]
+}
+
+// NewService works on the albums in albums
+func NewService[K comparable, T Album[K, T]](albums Repository[K, T]) *Service[K, T] {
+	return &Service[K, T]{albums: albums}
+}
+
+// List returns every album
+func (s *Service[K, T]) List() ([]T, error) {
+	return s.albums.List()
+}
+
+// Get returns one album
+func (s *Service[K, T]) Get(id K) (T, error) {
+	return s.albums.Get(id)
+}
+
+// Create saves a new album; the repository decides whether its ID is kept
+func (s *Service[K, T]) Create(album T) (T, error) {
+	if err := album.AlbumFields().Validate(); err != nil {
+		var zero T
+		return zero, err
+	}
+	err := s.albums.Create(&album)
+	return album, err
+}
+
+// Update saves album as album id; the ID itself can't be changed
+func (s *Service[K, T]) Update(id K, album T) (T, error) {
+	album = album.WithAlbumID(id)
+	if err := album.AlbumFields().Validate(); err != nil {
+		var zero T
+		return zero, err
+	}
+	if err := s.albums.Update(&album); err != nil {
+		var zero T
+		return zero, err
+	}
+	return album, nil
+}
+
+// Delete deletes an album
+func (s *Service[K, T]) Delete(id K) error {
+	return s.albums.Delete(id)
+}
@@ -0,0 +1,88 @@
+// Package database opens the database an album service runs on: Postgres
+// unless DB_DRIVER=sqlite, which needs no server. DB_PATH is then the SQLite
+// file, the service's name with .db by default, or :memory: for one that
+// lasts as long as the process. The services' queries work on both; what is
+// Postgres-only is the schema, with a SQLite twin that migrate applies, and
+// the lock taken while migrating.
+package database
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// Open connects to the database DB_DRIVER names for the service called name
+func Open(name string) (*gorm.DB, error) {
+	dialector, err := Dialector(name)
+	if err != nil {
+		return nil, err
+	}
+	return gorm.Open(dialector, &gorm.Config{
+		TranslateError: true, // unique/foreign key violations become gorm.ErrDuplicatedKey etc.
+	})
+}
+
+// Dialector picks the GORM driver for DB_DRIVER
+func Dialector(name string) (gorm.Dialector, error) {
+	switch driver := os.Getenv("DB_DRIVER"); driver {
+	case "", "postgres":
+		dsn, err := PostgresDSN()
+		if err != nil {
+			return nil, err
+		}
+		return postgres.Open(dsn), nil
+	case "sqlite":
+		return sqlite.Open(SQLiteDSN(name, os.Getenv("DB_PATH"))), nil
+	default:
+		return nil, fmt.Errorf("DB_DRIVER must be postgres or sqlite, not %q", driver)
+	}
+}
+
+// SQLiteDSN opens the SQLite file at path (name.db when it is empty) with
+// foreign keys enforced, as Postgres does
+func SQLiteDSN(name, path string) string {
+	if path == "" {
+		path = name + ".db"
+	}
+	if path == ":memory:" {
+		// Every connection in the pool has to see the same database
+		return "file:" + name + "?mode=memory&cache=shared&_pragma=foreign_keys(1)"
+	}
+	return path + "?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)"
+}
+
+// PostgresDSN builds the connection string from DB_HOST (localhost),
+// DB_PORT (5432), DB_SSLMODE (disable) and the required DB_USER, DB_PASSWORD
+// and DB_NAME
+func PostgresDSN() (string, error) {
+	host := os.Getenv("DB_HOST")
+	user := os.Getenv("DB_USER")
+	password := os.Getenv("DB_PASSWORD")
+	dbname := os.Getenv("DB_NAME")
+	port := os.Getenv("DB_PORT")
+	sslmode := os.Getenv("DB_SSLMODE")
+
+	if host == "" {
+		host = "localhost"
+	}
+	if port == "" {
+		port = "5432"
+	}
+	if sslmode == "" {
+		sslmode = "disable"
+	}
+
+	if user == "" || password == "" || dbname == "" {
+		return "", errors.New("missing required database credentials: DB_USER, DB_PASSWORD, or DB_NAME")
+	}
+
+	return fmt.Sprintf(
+		"host=%s user=%s password=%s dbname=%s port=%s sslmode=%s",
+		host, user, password, dbname, port, sslmode,
+	), nil
+}
@@ -0,0 +1,56 @@
+package database
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSQLiteDSN(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"", "albums.db?_pragma=foreign_keys(1)"},
+		{"/var/lib/albums/data.db", "/var/lib/albums/data.db?_pragma=foreign_keys(1)"},
+		{":memory:", "file:albums?mode=memory&cache=shared&_pragma=foreign_keys(1)"},
+	}
+	for _, tt := range tests {
+		if got := SQLiteDSN("albums", tt.path); !strings.HasPrefix(got, tt.want) {
+			t.Errorf("SQLiteDSN(%q) = %q, want it to start with %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestOpen(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		wantErr string
+	}{
+		{"sqlite in memory", map[string]string{"DB_DRIVER": "sqlite", "DB_PATH": ":memory:"}, ""},
+		{"postgres without credentials", map[string]string{"DB_DRIVER": "postgres", "DB_USER": ""}, "DB_USER"},
+		{"unknown driver", map[string]string{"DB_DRIVER": "mysql"}, `not "mysql"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+
+			db, err := Open("albums")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Open = %v, want an error mentioning %s", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Open = %v", err)
+			}
+			var foreignKeys int
+			if err := db.Raw("PRAGMA foreign_keys").Scan(&foreignKeys).Error; err != nil || foreignKeys != 1 {
+				t.Errorf("foreign_keys = %d, %v; want 1", foreignKeys, err)
+			}
+		})
+	}
+}
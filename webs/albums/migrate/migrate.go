@@ -0,0 +1,424 @@
+// Package migrate builds a service's schema from versioned SQL files
+// compiled into its binary: NNNN_name.up.sql makes a change and
+// NNNN_name.down.sql undoes it. Each database has its own set, in a postgres
+// and a sqlite directory with the same versions. A service records the
+// ones it has applied in a table of its own, so services sharing a database
+// never see each other's history.
+package migrate
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// lockKey is the Postgres advisory lock held while migrating, so that two
+// instances starting together don't both apply a migration. Every service
+// takes the same one: they may share a database, and its tables. SQLite is
+// a local file with no other instances to wait for.
+const lockKey = 7265634871
+
+// Dialects are the databases there are migrations for
+var Dialects = []string{"postgres", "sqlite"}
+
+var (
+	filePattern  = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+	tablePattern = regexp.MustCompile(`^[a-z_][a-z0-9_]*$`)
+)
+
+// Migration is one versioned schema change
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Status is a migration and when it was applied, if it has been
+type Status struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+// Migrator applies one service's migrations
+type Migrator struct {
+	Files fs.FS  // the migrations, usually an embed.FS
+	Dir   string // the directory in Files (and on disk, for Create) holding postgres/ and sqlite/
+	Table string // records the applied migrations; one per service
+}
+
+// Load reads the migrations in fsys, in version order. Every version needs
+// both an up and a down file.
+func Load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, entry := range entries {
+		match := filePattern.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || match == nil {
+			return nil, fmt.Errorf("migration %s: name must be NNNN_name.up.sql or NNNN_name.down.sql", entry.Name())
+		}
+		version, _ := strconv.Atoi(match[1])
+		sql, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		m := byVersion[version]
+		if m == nil {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		} else if m.Name != match[2] {
+			return nil, fmt.Errorf("migration %d is named both %s and %s", version, m.Name, match[2])
+		}
+		if match[3] == "up" {
+			m.Up = string(sql)
+		} else {
+			m.Down = string(sql)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %04d_%s needs both an up and a down file", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// Migrations are the service's migrations for dialect, postgres or sqlite
+func (m Migrator) Migrations(dialect string) ([]Migration, error) {
+	if !knownDialect(dialect) {
+		return nil, fmt.Errorf("no migrations for %s", dialect)
+	}
+	fsys, err := fs.Sub(m.Files, path.Join(m.Dir, dialect))
+	if err != nil {
+		return nil, err
+	}
+	return Load(fsys)
+}
+
+// Statuses lists every migration, applied or not, in version order
+func (m Migrator) Statuses(db *gorm.DB) ([]Status, error) {
+	var statuses []Status
+	err := m.withLock(db, func(conn *gorm.DB) error {
+		var err error
+		statuses, err = m.statuses(conn)
+		return err
+	})
+	return statuses, err
+}
+
+// Up applies up to steps pending migrations, all of them if steps is 0, and
+// returns the ones it applied. Each runs in a transaction of its own.
+func (m Migrator) Up(db *gorm.DB, steps int) ([]Migration, error) {
+	var applied []Migration
+	err := m.withLock(db, func(conn *gorm.DB) error {
+		statuses, err := m.statuses(conn)
+		if err != nil {
+			return err
+		}
+		for _, status := range statuses {
+			if status.AppliedAt != nil {
+				continue
+			}
+			if steps > 0 && len(applied) == steps {
+				break
+			}
+			migration := status.Migration
+			err := conn.Transaction(func(tx *gorm.DB) error {
+				if err := tx.Exec(migration.Up).Error; err != nil {
+					return err
+				}
+				return tx.Exec("INSERT INTO "+m.Table+" (version, name) VALUES (?, ?)", migration.Version, migration.Name).Error
+			})
+			if err != nil {
+				return fmt.Errorf("migration %04d_%s: %w", migration.Version, migration.Name, err)
+			}
+			applied = append(applied, migration)
+		}
+		return nil
+	})
+	return applied, err
+}
+
+// Down undoes the last steps applied migrations, newest first, and returns
+// the ones it undid
+func (m Migrator) Down(db *gorm.DB, steps int) ([]Migration, error) {
+	var undone []Migration
+	err := m.withLock(db, func(conn *gorm.DB) error {
+		statuses, err := m.statuses(conn)
+		if err != nil {
+			return err
+		}
+		for i := len(statuses) - 1; i >= 0 && len(undone) < steps; i-- {
+			if statuses[i].AppliedAt == nil {
+				continue
+			}
+			migration := statuses[i].Migration
+			if migration.Down == "" {
+				return fmt.Errorf("migration %04d_%s was applied but isn't in this build", migration.Version, migration.Name)
+			}
+			err := conn.Transaction(func(tx *gorm.DB) error {
+				if err := tx.Exec(migration.Down).Error; err != nil {
+					return err
+				}
+				return tx.Exec("DELETE FROM "+m.Table+" WHERE version = ?", migration.Version).Error
+			})
+			if err != nil {
+				return fmt.Errorf("migration %04d_%s: %w", migration.Version, migration.Name, err)
+			}
+			undone = append(undone, migration)
+		}
+		return nil
+	})
+	return undone, err
+}
+
+// OnStart brings the schema up to date when the server starts. With
+// MIGRATE_ON_START=false that is left to `migrate up`, and the server won't
+// start on a schema that is behind.
+func (m Migrator) OnStart(db *gorm.DB) error {
+	if os.Getenv("MIGRATE_ON_START") == "false" {
+		statuses, err := m.Statuses(db)
+		if err != nil {
+			return err
+		}
+		for _, status := range statuses {
+			if status.AppliedAt == nil {
+				return fmt.Errorf("migration %04d_%s is pending; run `migrate up` first", status.Version, status.Name)
+			}
+		}
+		return nil
+	}
+
+	applied, err := m.Up(db, 0)
+	for _, migration := range applied {
+		log.Printf("Applied migration %04d_%s", migration.Version, migration.Name)
+	}
+	return err
+}
+
+// withLock runs fn on a connection holding the migration lock, with the
+// service's migrations table created if need be
+func (m Migrator) withLock(db *gorm.DB, fn func(conn *gorm.DB) error) error {
+	dialect := db.Dialector.Name()
+	if !knownDialect(dialect) {
+		return fmt.Errorf("no migrations for %s", dialect)
+	}
+	if !tablePattern.MatchString(m.Table) {
+		return fmt.Errorf("migrations table %q must be a lower-case SQL name", m.Table)
+	}
+	return db.Connection(func(conn *gorm.DB) error {
+		if dialect == "postgres" {
+			if err := conn.Exec("SELECT pg_advisory_lock(?)", lockKey).Error; err != nil {
+				return err
+			}
+			defer conn.Exec("SELECT pg_advisory_unlock(?)", lockKey)
+		}
+
+		if err := conn.Exec(m.createTable(dialect)).Error; err != nil {
+			return err
+		}
+		return fn(conn)
+	})
+}
+
+// createTable creates the migrations table in dialect
+func (m Migrator) createTable(dialect string) string {
+	if dialect == "postgres" {
+		return `CREATE TABLE IF NOT EXISTS ` + m.Table + ` (
+			version bigint PRIMARY KEY,
+			name text NOT NULL,
+			applied_at timestamptz NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)`
+	}
+	return `CREATE TABLE IF NOT EXISTS ` + m.Table + ` (
+		version integer PRIMARY KEY,
+		name text NOT NULL,
+		applied_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP
+	)`
+}
+
+// statuses matches the service's migrations with its table. One applied by
+// a newer build shows up without its SQL.
+func (m Migrator) statuses(conn *gorm.DB) ([]Status, error) {
+	migrations, err := m.Migrations(conn.Dialector.Name())
+	if err != nil {
+		return nil, err
+	}
+	var rows []struct {
+		Version   int
+		Name      string
+		AppliedAt time.Time
+	}
+	if err := conn.Raw("SELECT version, name, applied_at FROM " + m.Table).Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*Status{}
+	statuses := make([]Status, 0, len(migrations))
+	for _, migration := range migrations {
+		statuses = append(statuses, Status{Migration: migration})
+	}
+	for i := range statuses {
+		byVersion[statuses[i].Version] = &statuses[i]
+	}
+	for _, row := range rows {
+		appliedAt := row.AppliedAt
+		if status, ok := byVersion[row.Version]; ok {
+			status.AppliedAt = &appliedAt
+		} else {
+			statuses = append(statuses, Status{Migration: Migration{Version: row.Version, Name: row.Name}, AppliedAt: &appliedAt})
+		}
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Version < statuses[j].Version })
+	return statuses, nil
+}
+
+func knownDialect(dialect string) bool {
+	for _, known := range Dialects {
+		if dialect == known {
+			return true
+		}
+	}
+	return false
+}
+
+// Create writes an empty pair of files for the next version in each
+// dialect's directory under dir and returns their paths
+func Create(dir, name string) ([]string, error) {
+	name = strings.Trim(regexp.MustCompile(`[^a-z0-9]+`).ReplaceAllString(strings.ToLower(name), "_"), "_")
+	if name == "" {
+		return nil, errors.New("migration name must contain letters or digits")
+	}
+
+	next := 1
+	for _, dialect := range Dialects {
+		entries, err := os.ReadDir(filepath.Join(dir, dialect))
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if match := filePattern.FindStringSubmatch(entry.Name()); match != nil {
+				if version, _ := strconv.Atoi(match[1]); version >= next {
+					next = version + 1
+				}
+			}
+		}
+	}
+
+	var paths []string
+	for _, dialect := range Dialects {
+		base := filepath.Join(dir, dialect, fmt.Sprintf("%04d_%s", next, name))
+		up, down := base+".up.sql", base+".down.sql"
+		if err := os.WriteFile(up, []byte("-- "+name+"\n"), 0o644); err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(down, []byte("-- Undo "+name+"\n"), 0o644); err != nil {
+			return nil, err
+		}
+		paths = append(paths, up, down)
+	}
+	return paths, nil
+}
+
+// Command runs `migrate up [N]`, `migrate down [N]`, `migrate status` or
+// `migrate create NAME` for the server binary. up applies every pending
+// migration unless given a number; down undoes one unless given a number.
+// connect is only called once the arguments are known to be good.
+func (m Migrator) Command(args []string, connect func() *gorm.DB) error {
+	usage := errors.New("usage: migrate up [N] | down [N] | status | create NAME")
+	if len(args) == 0 {
+		return usage
+	}
+
+	steps := 0
+	switch args[0] {
+	case "create":
+		if len(args) != 2 {
+			return usage
+		}
+		paths, err := Create(m.Dir, args[1])
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Created %s; rebuild to include them\n", strings.Join(paths, ", "))
+		return nil
+	case "up", "down":
+		if args[0] == "down" {
+			steps = 1
+		}
+		if len(args) > 2 {
+			return usage
+		}
+		if len(args) == 2 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				return usage
+			}
+			steps = n
+		}
+	case "status":
+		if len(args) != 1 {
+			return usage
+		}
+	default:
+		return usage
+	}
+
+	db := connect()
+	switch args[0] {
+	case "up":
+		applied, err := m.Up(db, steps)
+		for _, migration := range applied {
+			fmt.Printf("Applied %04d_%s\n", migration.Version, migration.Name)
+		}
+		if err == nil && len(applied) == 0 {
+			fmt.Println("Nothing to apply")
+		}
+		return err
+	case "down":
+		undone, err := m.Down(db, steps)
+		for _, migration := range undone {
+			fmt.Printf("Undid %04d_%s\n", migration.Version, migration.Name)
+		}
+		if err == nil && len(undone) == 0 {
+			fmt.Println("Nothing to undo")
+		}
+		return err
+	default:
+		statuses, err := m.Statuses(db)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED")
+		for _, status := range statuses {
+			applied := "pending"
+			if status.AppliedAt != nil {
+				applied = status.AppliedAt.Local().Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%04d\t%s\t%s\n", status.Version, status.Name, applied)
+		}
+		return w.Flush()
+	}
+}
@@ -0,0 +1,153 @@
+package migrate
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+)
+
+func file(sql string) *fstest.MapFile { return &fstest.MapFile{Data: []byte(sql)} }
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   fstest.MapFS
+		want    []string // version_name of each, in order
+		wantErr string
+	}{
+		{"in version order", fstest.MapFS{
+			"0010_later.up.sql":   file("up 10"),
+			"0010_later.down.sql": file("down 10"),
+			"0002_first.up.sql":   file("up 2"),
+			"0002_first.down.sql": file("down 2"),
+		}, []string{"2_first", "10_later"}, ""},
+		{"missing down", fstest.MapFS{"0001_first.up.sql": file("up")}, nil, "needs both"},
+		{"bad name", fstest.MapFS{"first.sql": file("up")}, nil, "name must be"},
+		{"two names for a version", fstest.MapFS{
+			"0001_first.up.sql":   file("up"),
+			"0001_other.down.sql": file("down"),
+		}, nil, "named both"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			migrations, err := Load(tt.files)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, m := range migrations {
+				got = append(got, strconv.Itoa(m.Version)+"_"+m.Name)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("migrations = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCreate(t *testing.T) {
+	dir := t.TempDir()
+	os.Mkdir(filepath.Join(dir, "postgres"), 0o755)
+	os.Mkdir(filepath.Join(dir, "sqlite"), 0o755)
+	for _, name := range []string{"postgres/0001_first.up.sql", "postgres/0001_first.down.sql", "sqlite/0007_seventh.up.sql", "sqlite/0007_seventh.down.sql", "sqlite/notes.txt"} {
+		os.WriteFile(filepath.Join(dir, name), nil, 0o644)
+	}
+
+	paths, err := Create(dir, "Add Album Genres")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"postgres/0008_add_album_genres.up.sql", "postgres/0008_add_album_genres.down.sql",
+		"sqlite/0008_add_album_genres.up.sql", "sqlite/0008_add_album_genres.down.sql",
+	}
+	for i := range want {
+		want[i] = filepath.Join(dir, want[i])
+	}
+	if strings.Join(paths, ",") != strings.Join(want, ",") {
+		t.Errorf("created %v, want version 8 for each database", paths)
+	}
+	if _, err := Create(dir, "!!!"); err == nil {
+		t.Error("a name without letters or digits was accepted")
+	}
+}
+
+// Two services sharing a database each keep their own history, and undoing
+// one's migrations leaves the other's alone
+func TestServicesKeepTheirOwnHistory(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file:"+t.Name()+"?mode=memory&cache=shared"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, _ := db.DB()
+	t.Cleanup(func() { sqlDB.Close() })
+
+	migrator := func(table, name string) Migrator {
+		return Migrator{Table: table, Dir: "migrations", Files: fstest.MapFS{
+			"migrations/postgres/0001_" + name + ".up.sql":   file("CREATE TABLE " + name + " (id integer)"),
+			"migrations/postgres/0001_" + name + ".down.sql": file("DROP TABLE " + name),
+			"migrations/sqlite/0001_" + name + ".up.sql":     file("CREATE TABLE " + name + " (id integer)"),
+			"migrations/sqlite/0001_" + name + ".down.sql":   file("DROP TABLE " + name),
+			"migrations/sqlite/0002_nothing.up.sql":          file("-- nothing to do"),
+			"migrations/sqlite/0002_nothing.down.sql":        file("-- nothing to undo"),
+		}}
+	}
+	first, second := migrator("first_migrations", "artists"), migrator("second_migrations", "genres")
+
+	tests := []struct {
+		name     string
+		run      func() ([]Migration, error)
+		want     int
+		artists  bool
+		genres   bool
+		firstLen int // migrations first has applied afterwards
+	}{
+		{"first up", func() ([]Migration, error) { return first.Up(db, 0) }, 2, true, false, 2},
+		{"second up", func() ([]Migration, error) { return second.Up(db, 1) }, 1, true, true, 2},
+		{"second up again", func() ([]Migration, error) { return second.Up(db, 0) }, 1, true, true, 2},
+		{"first down", func() ([]Migration, error) { return first.Down(db, 2) }, 2, false, true, 0},
+		{"first down with nothing applied", func() ([]Migration, error) { return first.Down(db, 1) }, 0, false, true, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			migrations, err := tt.run()
+			if err != nil || len(migrations) != tt.want {
+				t.Fatalf("ran %+v, %v; want %d migrations", migrations, err, tt.want)
+			}
+			if got := db.Migrator().HasTable("artists"); got != tt.artists {
+				t.Errorf("artists table exists = %v, want %v", got, tt.artists)
+			}
+			if got := db.Migrator().HasTable("genres"); got != tt.genres {
+				t.Errorf("genres table exists = %v, want %v", got, tt.genres)
+			}
+			var applied int64
+			db.Table("first_migrations").Count(&applied)
+			if applied != int64(tt.firstLen) {
+				t.Errorf("first has %d migrations applied, want %d", applied, tt.firstLen)
+			}
+		})
+	}
+}
+
+func TestMigratorRejectsBadTableName(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file:"+t.Name()+"?mode=memory&cache=shared"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := Migrator{Table: "migrations; DROP TABLE users", Dir: ".", Files: fstest.MapFS{}}
+	if _, err := m.Up(db, 0); err == nil || !strings.Contains(err.Error(), "lower-case SQL name") {
+		t.Errorf("Up = %v, want the table name refused", err)
+	}
+}
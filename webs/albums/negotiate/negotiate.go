@@ -0,0 +1,86 @@
+// Package negotiate picks the format of responses and request bodies for
+// the album services: JSON (the default), XML, YAML or MessagePack, from the
+// Accept and Content-Type headers.
+package negotiate
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/gin-gonic/gin/render"
+)
+
+// Formats we can answer with, in order of preference (JSON stays the default)
+var formats = []string{
+	binding.MIMEJSON,
+	binding.MIMEXML,
+	binding.MIMEXML2,
+	binding.MIMEYAML,
+	binding.MIMEYAML2,
+	binding.MIMEMSGPACK,
+	binding.MIMEMSGPACK2,
+}
+
+// Middleware rejects requests whose Accept or Content-Type header names a
+// format we don't speak, before any handler does work. extra lists other
+// types the service answers with (its error format, say); notAcceptable
+// and unsupported write the 406 and 415 and must abort the chain.
+func Middleware(extra []string, notAcceptable, unsupported gin.HandlerFunc) gin.HandlerFunc {
+	accepted := append(append([]string(nil), formats...), extra...)
+
+	return func(c *gin.Context) {
+		if c.NegotiateFormat(accepted...) == "" {
+			notAcceptable(c)
+			return
+		}
+
+		// Only check Content-Type when there is a body to decode
+		if c.Request.ContentLength != 0 && !IsBodyFormat(c.ContentType()) {
+			unsupported(c)
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// Respond writes obj in the format picked from the Accept header
+func Respond(c *gin.Context, code int, obj interface{}) {
+	switch c.NegotiateFormat(formats...) {
+	case binding.MIMEXML, binding.MIMEXML2:
+		c.XML(code, obj)
+	case binding.MIMEYAML, binding.MIMEYAML2:
+		c.YAML(code, obj)
+	case binding.MIMEMSGPACK, binding.MIMEMSGPACK2:
+		c.Render(code, render.MsgPack{Data: obj})
+	default:
+		c.JSON(code, obj)
+	}
+}
+
+// BindBody decodes the request body according to its Content-Type.
+// A missing Content-Type is treated as JSON, like BindJSON always did.
+func BindBody(c *gin.Context, obj interface{}) error {
+	switch c.ContentType() {
+	case binding.MIMEXML, binding.MIMEXML2:
+		return c.ShouldBindXML(obj)
+	case binding.MIMEYAML, binding.MIMEYAML2:
+		return c.ShouldBindYAML(obj)
+	case binding.MIMEMSGPACK, binding.MIMEMSGPACK2:
+		return c.ShouldBindWith(obj, binding.MsgPack)
+	default:
+		return c.ShouldBindJSON(obj)
+	}
+}
+
+// IsBodyFormat reports whether we know how to decode a body of this type
+func IsBodyFormat(contentType string) bool {
+	if contentType == "" {
+		return true
+	}
+	for _, format := range formats {
+		if contentType == format {
+			return true
+		}
+	}
+	return false
+}
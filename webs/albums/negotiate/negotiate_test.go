@@ -0,0 +1,120 @@
+package negotiate
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+type record struct {
+	Title string  `json:"title" xml:"title"`
+	Price float64 `json:"price" xml:"price"`
+}
+
+// newRouter echoes a record back, answering 406, 415 and 400 without a body
+func newRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(Middleware([]string{"application/problem+json"},
+		func(c *gin.Context) { c.AbortWithStatus(http.StatusNotAcceptable) },
+		func(c *gin.Context) { c.AbortWithStatus(http.StatusUnsupportedMediaType) }))
+	router.POST("/echo", func(c *gin.Context) {
+		var r record
+		if err := BindBody(c, &r); err != nil {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+		Respond(c, http.StatusOK, r)
+	})
+	return router
+}
+
+func send(router *gin.Engine, accept, contentType, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/echo", strings.NewReader(body))
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestRespondNegotiatesFormat(t *testing.T) {
+	router := newRouter()
+	body := `{"title":"Blue Train","price":56.99}`
+
+	tests := []struct {
+		accept      string
+		wantStatus  int
+		contentType string
+	}{
+		{"", http.StatusOK, "application/json"},
+		{"*/*", http.StatusOK, "application/json"},
+		{"application/xml", http.StatusOK, "application/xml"},
+		{"text/xml", http.StatusOK, "application/xml"},
+		{"application/x-yaml", http.StatusOK, "application/yaml"},
+		{"application/yaml", http.StatusOK, "application/yaml"},
+		{"application/msgpack", http.StatusOK, "application/msgpack"},
+		{"text/html, application/xml;q=0.5", http.StatusOK, "application/xml"},
+		{"application/problem+json", http.StatusOK, "application/json"}, // only errors use it
+		{"text/html", http.StatusNotAcceptable, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.accept, func(t *testing.T) {
+			w := send(router, tt.accept, "application/json", body)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if got := w.Header().Get("Content-Type"); tt.contentType != "" && !strings.HasPrefix(got, tt.contentType) {
+				t.Errorf("Content-Type = %q, want %s", got, tt.contentType)
+			}
+		})
+	}
+}
+
+func TestBindBodyDecodesFormat(t *testing.T) {
+	router := newRouter()
+
+	// There is no MessagePack encoder to hand, so take the server's own
+	msgpack := send(router, "application/msgpack", "", `{"title":"Blue Train","price":56.99}`).Body.String()
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		wantStatus  int
+	}{
+		{"json", "application/json", `{"title":"Blue Train","price":56.99}`, http.StatusOK},
+		{"no content type", "", `{"title":"Blue Train","price":56.99}`, http.StatusOK},
+		{"xml", "application/xml", `<record><title>Blue Train</title><price>56.99</price></record>`, http.StatusOK},
+		{"text xml", "text/xml", `<record><title>Blue Train</title><price>56.99</price></record>`, http.StatusOK},
+		{"yaml", "application/yaml", "title: Blue Train\nprice: 56.99\n", http.StatusOK},
+		{"x-yaml", "application/x-yaml", "title: Blue Train\nprice: 56.99\n", http.StatusOK},
+		{"msgpack", "application/msgpack", msgpack, http.StatusOK},
+		{"x-msgpack", "application/x-msgpack", msgpack, http.StatusOK},
+		{"malformed json", "application/json", `{"title":`, http.StatusBadRequest},
+		{"unsupported", "text/plain", "Blue Train", http.StatusUnsupportedMediaType},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := send(router, "application/json", tt.contentType, tt.body)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if w.Code != http.StatusOK {
+				return
+			}
+			var got record
+			if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil || got != (record{"Blue Train", 56.99}) {
+				t.Errorf("decoded %+v (%v), want Blue Train at 56.99", got, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,395 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// What DELETE /me does with the account's albums
+const (
+	AlbumsDelete   = "delete"
+	AlbumsOrphan   = "orphan"   // keep them without an owner, for others to claim
+	AlbumsTransfer = "transfer" // give them to another user
+)
+
+// DeletedAccountAlbums is the album policy when DELETE /me doesn't pick one
+// (ACCOUNT_DELETION_ALBUMS), and DeletedAccountAlbumsTo the email of the user
+// who gets them when that policy is transfer (ACCOUNT_DELETION_TRANSFER_TO)
+var (
+	DeletedAccountAlbums   = AlbumsOrphan
+	DeletedAccountAlbumsTo string
+)
+
+// ErrWrongPassword means a sensitive account change was refused because the
+// current password didn't match
+var ErrWrongPassword = errors.New("password is wrong")
+
+// AccountHandlers serve the routes for logging in and looking after one's
+// own account, in the API and the web UI
+type AccountHandlers struct {
+	db *gorm.DB
+}
+
+// NewAccountHandlers keeps accounts and their tokens in db
+func NewAccountHandlers(db *gorm.DB) *AccountHandlers {
+	return &AccountHandlers{db: db}
+}
+
+// PATCH /me - Protected: change the caller's first and last name
+func (h *AccountHandlers) patchCurrentUser(c *gin.Context) {
+	var req UpdateProfileRequest
+	if err := BindBody(c, &req); err != nil {
+		RespondError(c, CodeValidationFailed, err.Error())
+		return
+	}
+
+	changes := map[string]interface{}{}
+	var issues []ValidationIssue
+	fields := []struct {
+		name  string
+		value *string
+	}{{"first_name", req.FirstName}, {"last_name", req.LastName}}
+	for _, field := range fields {
+		if field.value == nil {
+			continue
+		}
+		if trimmed := strings.TrimSpace(*field.value); trimmed != "" {
+			changes[field.name] = trimmed
+		} else {
+			issues = append(issues, ValidationIssue{Location: "body", Field: field.name, Message: "must not be blank"})
+		}
+	}
+	if len(issues) > 0 {
+		RespondValidationError(c, "", issues)
+		return
+	}
+
+	user, _ := GetCurrentUser(c)
+	if len(changes) > 0 {
+		if err := h.db.Model(&user).Updates(changes).Error; err != nil {
+			RespondDBError(c, err, CodeUserNotFound)
+			return
+		}
+	}
+
+	Respond(c, http.StatusOK, SuccessResponse{
+		Success: true,
+		Data:    user,
+	})
+}
+
+// POST /me/email - Protected: start moving the account to a new email
+// address, stored in lower case like every other. Nothing changes until the
+// token sent to the new address comes back through POST /email/change/confirm.
+func (h *AccountHandlers) postChangeEmail(c *gin.Context) {
+	var req ChangeEmailRequest
+	if err := BindBody(c, &req); err != nil {
+		RespondError(c, CodeValidationFailed, err.Error())
+		return
+	}
+
+	user, _ := GetCurrentUser(c)
+	if err := reauthenticate(h.db, user, req.Password, req.OTPCode); err != nil {
+		RespondDBError(c, err, CodeUserNotFound)
+		return
+	}
+	email := strings.ToLower(strings.TrimSpace(req.Email))
+	if email == strings.ToLower(user.Email) {
+		RespondValidationError(c, "", []ValidationIssue{{Location: "body", Field: "email", Message: "is already your email address"}})
+		return
+	}
+
+	var taken int64
+	if err := h.db.Model(&User{}).Where("LOWER(email) = ?", email).Count(&taken).Error; err != nil {
+		RespondInternalError(c, err)
+		return
+	}
+	if taken > 0 {
+		RespondError(c, CodeDuplicateResource, "Another account uses that email address")
+		return
+	}
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		token, err := issueEmailChangeToken(tx, user.ID, email)
+		if err != nil {
+			return err
+		}
+		return QueueEmail(tx, email, "email_change", gin.H{
+			"User": user, "NewEmail": email, "Token": token, "Hours": int(emailVerificationTTL.Hours()),
+		})
+	})
+	if err != nil {
+		RespondInternalError(c, err)
+		return
+	}
+
+	Respond(c, http.StatusAccepted, SuccessResponse{
+		Success: true,
+		Data:    gin.H{"message": "A confirmation token has been sent to " + email},
+	})
+}
+
+// POST /email/change/confirm - Public: finish an email change with the token
+// sent to the new address. Tokens sent to the old address stop working and
+// it is told about the change.
+func (h *AccountHandlers) postConfirmEmailChange(c *gin.Context) {
+	var req VerifyEmailRequest
+	if err := BindBody(c, &req); err != nil {
+		RespondError(c, CodeValidationFailed, err.Error())
+		return
+	}
+
+	var user User
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		token, err := consumeTokenRow(tx, req.Token, TokenEmailChange)
+		if err != nil {
+			return err
+		}
+		if err := tx.First(&user, token.UserID).Error; err != nil {
+			return err
+		}
+
+		old := user
+		now := time.Now()
+		if err := tx.Model(&user).Updates(map[string]interface{}{"email": token.Email, "email_verified_at": now}).Error; err != nil {
+			return err
+		}
+		if err := revokeTokens(tx, user.ID); err != nil {
+			return err
+		}
+		return QueueEmail(tx, old.Email, "email_changed", gin.H{"User": old, "NewEmail": token.Email, "Time": now.UTC()})
+	})
+	if err != nil {
+		RespondDBError(c, err, CodeTokenInvalid)
+		return
+	}
+
+	Respond(c, http.StatusOK, SuccessResponse{
+		Success: true,
+		Data:    user,
+	})
+}
+
+// POST /me/password - Protected: change the password, given the current one.
+// With sign_out_everywhere the API key is replaced (the response has the new
+// one) and web sessions end too.
+func (h *AccountHandlers) postChangePassword(c *gin.Context) {
+	var req ChangePasswordRequest
+	if err := BindBody(c, &req); err != nil {
+		RespondError(c, CodeValidationFailed, err.Error())
+		return
+	}
+
+	user, _ := GetCurrentUser(c)
+	if err := reauthenticate(h.db, user, req.CurrentPassword, req.OTPCode); err != nil {
+		RespondDBError(c, err, CodeUserNotFound)
+		return
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		RespondValidationError(c, "", []ValidationIssue{{Location: "body", Field: "new_password", Message: "must be at most 72 bytes"}})
+		return
+	}
+
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		changes := map[string]interface{}{"password": string(hash)}
+		if req.SignOutEverywhere {
+			user.APIKey = uuid.NewString()
+			changes["api_key"] = user.APIKey
+			changes["sessions_revoked_at"] = time.Now()
+		}
+		if err := tx.Model(&user).Updates(changes).Error; err != nil {
+			return err
+		}
+		if err := revokeTokens(tx, user.ID, TokenPasswordReset); err != nil {
+			return err
+		}
+		return QueueEmail(tx, user.Email, "password_changed", gin.H{"User": user, "Time": time.Now().UTC()})
+	})
+	if err != nil {
+		RespondDBError(c, err, CodeUserNotFound)
+		return
+	}
+
+	var data interface{} = user
+	if req.SignOutEverywhere {
+		data = UserCredentials{User: user, APIKey: user.APIKey}
+	}
+	Respond(c, http.StatusOK, SuccessResponse{
+		Success: true,
+		Data:    data,
+	})
+}
+
+// DELETE /me - Protected: delete the caller's account. Its albums are
+// deleted, orphaned or transferred as the body (or the server default) says.
+func (h *AccountHandlers) deleteCurrentUser(c *gin.Context) {
+	var req DeleteAccountRequest
+	if err := BindBody(c, &req); err != nil {
+		RespondError(c, CodeValidationFailed, err.Error())
+		return
+	}
+
+	user, _ := GetCurrentUser(c)
+	if err := reauthenticate(h.db, user, req.Password, req.OTPCode); err != nil {
+		RespondDBError(c, err, CodeUserNotFound)
+		return
+	}
+
+	policy := req.Albums
+	if policy == "" {
+		policy = DeletedAccountAlbums
+	}
+
+	var recipient *User
+	if policy == AlbumsTransfer {
+		email := req.TransferTo
+		if email == "" {
+			email = DeletedAccountAlbumsTo
+		}
+		if email == "" {
+			RespondValidationError(c, "", []ValidationIssue{{Location: "body", Field: "transfer_to", Message: "is required when albums is transfer"}})
+			return
+		}
+
+		found, ok := findRecipient(c, h.db, "transfer_to", email, user.ID)
+		if !ok {
+			return
+		}
+		recipient = &found
+	}
+
+	count, err := DeleteAccount(h.db, user, policy, recipient)
+	if err != nil {
+		RespondDBError(c, err, CodeUserNotFound)
+		return
+	}
+
+	Respond(c, http.StatusOK, SuccessResponse{
+		Success: true,
+		Data:    gin.H{"message": fmt.Sprintf("Account deleted, %d albums %s", count, albumPolicyDone[policy])},
+	})
+}
+
+var albumPolicyDone = map[string]string{
+	AlbumsDelete:   "deleted",
+	AlbumsOrphan:   "left without an owner",
+	AlbumsTransfer: "transferred",
+}
+
+// reauthenticate confirms a sensitive account change with the password, and
+// the second factor when 2FA is on, so a leaked API key alone can't take
+// over the account
+func reauthenticate(db *gorm.DB, user User, password, otpCode string) error {
+	if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)) != nil {
+		return ErrWrongPassword
+	}
+	return checkSecondFactor(db, user, otpCode)
+}
+
+// DeleteAccount soft-deletes user and applies policy to their albums,
+// returning how many there were. The row stays for the record, but its email
+// is freed for a new account and its API key, tokens, SSO links, recovery
+// codes, webhooks and collaborations are gone. The album feed announces each
+// album once the deletion has committed; a transferred album is also sent to
+// the new owner's webhooks, and keeps its collaborators. The only owner of an
+// organization gets ErrLastOwner until they make someone else an owner.
+func DeleteAccount(db *gorm.DB, user User, policy string, recipient *User) (int, error) {
+	var albums []Album
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var memberships []OrganizationMember
+		if err := tx.Where("user_id = ?", user.ID).Find(&memberships).Error; err != nil {
+			return err
+		}
+		for _, membership := range memberships {
+			if err := keepAnOwner(tx, membership.OrganizationID, user.ID); err != nil {
+				return err
+			}
+		}
+
+		if err := tx.Where("user_id = ?", user.ID).Order("id").Find(&albums).Error; err != nil {
+			return err
+		}
+		for i := range albums {
+			album := &albums[i]
+			var err error
+			switch policy {
+			case AlbumsDelete:
+				if err = tx.Delete(album).Error; err == nil {
+					err = unshareAlbum(tx, album.ID)
+				}
+			case AlbumsOrphan:
+				album.UserID = nil
+				if err = tx.Model(album).Update("user_id", nil).Error; err == nil {
+					err = unshareAlbum(tx, album.ID)
+				}
+			case AlbumsTransfer:
+				album.UserID = &recipient.ID
+				err = tx.Model(album).Update("user_id", recipient.ID).Error
+				if err == nil {
+					err = cancelTransfers(tx, album.ID)
+				}
+				if err == nil {
+					err = tx.Where("album_id = ? AND user_id = ?", album.ID, recipient.ID).Delete(&AlbumCollaborator{}).Error
+				}
+				if err == nil {
+					err = addOutboxEvent(tx, EventAlbumUpdated, *album)
+				}
+			default:
+				err = fmt.Errorf("unknown album policy %q", policy)
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+		for _, owned := range []interface{}{&UserToken{}, &RecoveryCode{}, &UserIdentity{}, &Webhook{}, &AlbumCollaborator{}, &OrganizationMember{}} {
+			if err := tx.Where("user_id = ?", user.ID).Delete(owned).Error; err != nil {
+				return err
+			}
+		}
+		err := tx.Model(&AlbumTransfer{}).Where("to_user_id = ? AND status = ?", user.ID, TransferPending).
+			Updates(map[string]interface{}{"status": TransferCancelled, "responded_at": time.Now()}).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Model(&AlbumClaim{}).Where("user_id = ? AND status = ?", user.ID, ClaimPending).
+			Update("status", ClaimWithdrawn).Error
+		if err != nil {
+			return err
+		}
+
+		err = tx.Model(&user).Updates(map[string]interface{}{
+			"email":               fmt.Sprintf("deleted-%d@deleted.invalid", user.ID),
+			"api_key":             uuid.NewString(),
+			"password":            "",
+			"totp_secret":         "",
+			"sessions_revoked_at": time.Now(),
+		}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Delete(&user).Error
+	})
+	if err != nil {
+		return len(albums), err
+	}
+
+	eventType := EventAlbumUpdated
+	if policy == AlbumsDelete {
+		eventType = EventAlbumDeleted
+	}
+	for _, album := range albums {
+		Events.Publish(eventType, album)
+	}
+	return len(albums), nil
+}
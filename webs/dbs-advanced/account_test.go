@@ -0,0 +1,232 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestUpdateProfile(t *testing.T) {
+	setupTestDB(t)
+	user := createTestUser(t, "renamed@example.com")
+	server := httptest.NewServer(setupRouter(NewServices(DB)))
+	t.Cleanup(server.Close)
+
+	var updated User
+	if status := apiCall(t, server, user.APIKey, http.MethodPatch, "/me", UpdateProfileRequest{FirstName: strPtr("  Ada ")}, &updated); status != http.StatusOK {
+		t.Fatalf("PATCH /me = %d", status)
+	}
+	if updated.FirstName != "Ada" || updated.LastName != user.LastName {
+		t.Errorf("after PATCH /me: %q %q, want Ada %q", updated.FirstName, updated.LastName, user.LastName)
+	}
+
+	if status := apiCall(t, server, user.APIKey, http.MethodPatch, "/me", UpdateProfileRequest{LastName: strPtr("   ")}, nil); status != http.StatusBadRequest {
+		t.Errorf("PATCH /me with a blank last name = %d, want 400", status)
+	}
+}
+
+func TestChangeEmail(t *testing.T) {
+	setupTestDB(t)
+	user := createTestUser(t, "old@example.com")
+	createTestUser(t, "taken@example.com")
+	setTestPassword(t, user)
+	server := httptest.NewServer(setupRouter(NewServices(DB)))
+	t.Cleanup(server.Close)
+
+	// A verification email for the old address is still outstanding
+	apiCall(t, server, user.APIKey, http.MethodPost, "/email/verify/send", nil, nil)
+	oldVerification := tokenFromEmail(t, "verify_email")
+
+	tests := []struct {
+		name string
+		req  ChangeEmailRequest
+		want int
+	}{
+		{"wrong password", ChangeEmailRequest{Email: "new@example.com", Password: "wrong"}, http.StatusForbidden},
+		{"taken address", ChangeEmailRequest{Email: "Taken@example.com", Password: testPassword}, http.StatusConflict},
+		{"same address", ChangeEmailRequest{Email: user.Email, Password: testPassword}, http.StatusBadRequest},
+		{"same address in capitals", ChangeEmailRequest{Email: "OLD@example.com", Password: testPassword}, http.StatusBadRequest},
+		{"new address", ChangeEmailRequest{Email: "New@Example.com", Password: testPassword}, http.StatusAccepted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if status := apiCall(t, server, user.APIKey, http.MethodPost, "/me/email", tt.req, nil); status != tt.want {
+				t.Errorf("POST /me/email = %d, want %d", status, tt.want)
+			}
+		})
+	}
+
+	var unchanged User
+	DB.First(&unchanged, user.ID)
+	if unchanged.Email != user.Email {
+		t.Fatalf("email changed to %s before it was confirmed", unchanged.Email)
+	}
+
+	var changed User
+	confirm := VerifyEmailRequest{Token: tokenFromEmail(t, "email_change")}
+	if status := apiCall(t, server, "", http.MethodPost, "/email/change/confirm", confirm, &changed); status != http.StatusOK {
+		t.Fatalf("POST /email/change/confirm = %d", status)
+	}
+	if changed.Email != "new@example.com" || changed.EmailVerifiedAt == nil {
+		t.Errorf("after confirming: email %s, verified %v; want new@example.com, verified", changed.Email, changed.EmailVerifiedAt != nil)
+	}
+
+	if status := apiCall(t, server, "", http.MethodPost, "/email/verify", VerifyEmailRequest{Token: oldVerification}, nil); status != http.StatusBadRequest {
+		t.Errorf("verification token for the old address = %d, want 400", status)
+	}
+	var notice QueuedEmail
+	if err := DB.Where("template = ? AND \"to\" = ?", "email_changed", user.Email).First(&notice).Error; err != nil {
+		t.Errorf("old address was not told about the change: %v", err)
+	}
+}
+
+func TestChangePassword(t *testing.T) {
+	setupTestDB(t)
+	user := createTestUser(t, "rekey@example.com")
+	setTestPassword(t, user)
+	server := httptest.NewServer(setupRouter(NewServices(DB)))
+	t.Cleanup(server.Close)
+
+	wrong := ChangePasswordRequest{CurrentPassword: "wrong", NewPassword: "new-password"}
+	if status := apiCall(t, server, user.APIKey, http.MethodPost, "/me/password", wrong, nil); status != http.StatusForbidden {
+		t.Errorf("POST /me/password with the wrong password = %d, want 403", status)
+	}
+
+	var updated UserCredentials
+	req := ChangePasswordRequest{CurrentPassword: testPassword, NewPassword: "new-password", SignOutEverywhere: true}
+	if status := apiCall(t, server, user.APIKey, http.MethodPost, "/me/password", req, &updated); status != http.StatusOK {
+		t.Fatalf("POST /me/password = %d", status)
+	}
+	if updated.APIKey == "" || updated.APIKey == user.APIKey {
+		t.Errorf("sign_out_everywhere answered the key %q, want a new one", updated.APIKey)
+	}
+	if status := apiCall(t, server, updated.APIKey, http.MethodGet, "/me", nil, nil); status != http.StatusOK {
+		t.Errorf("GET /me with the new key = %d, want 200", status)
+	}
+	if status := apiCall(t, server, user.APIKey, http.MethodGet, "/me", nil, nil); status != http.StatusUnauthorized {
+		t.Errorf("GET /me with the old key = %d, want 401", status)
+	}
+	if status := apiCall(t, server, "", http.MethodPost, "/login", LoginRequest{Email: user.Email, Password: "new-password"}, nil); status != http.StatusOK {
+		t.Errorf("login with the new password = %d, want 200", status)
+	}
+	var notices int64
+	DB.Model(&QueuedEmail{}).Where("template = ?", "password_changed").Count(&notices)
+	if notices != 1 {
+		t.Errorf("%d password_changed emails queued, want 1", notices)
+	}
+}
+
+func TestDeleteAccount(t *testing.T) {
+	tests := []struct {
+		albums     string
+		wantExists bool
+		wantHeir   bool // owned by the heir, rather than by nobody
+	}{
+		{AlbumsDelete, false, false},
+		{AlbumsOrphan, true, false},
+		{AlbumsTransfer, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.albums, func(t *testing.T) {
+			setupTestDB(t)
+			user := createTestUser(t, "leaving@example.com")
+			heir := createTestUser(t, "heir@example.com")
+			setTestPassword(t, user)
+			album := createTestAlbum(t, "Kind of Blue", &user.ID)
+			server := httptest.NewServer(setupRouter(NewServices(DB)))
+			t.Cleanup(server.Close)
+
+			var webhook Webhook
+			apiCall(t, server, user.APIKey, http.MethodPost, "/webhooks", WebhookInput{URL: "https://example.com/hook", Events: []string{EventAlbumUpdated}}, &webhook)
+
+			req := DeleteAccountRequest{Password: testPassword, Albums: tt.albums}
+			if tt.albums == AlbumsTransfer {
+				req.TransferTo = heir.Email
+			}
+			if status := apiCall(t, server, user.APIKey, http.MethodDelete, "/me", req, nil); status != http.StatusOK {
+				t.Fatalf("DELETE /me = %d", status)
+			}
+
+			var remaining Album
+			err := DB.First(&remaining, album.ID).Error
+			if exists := err == nil; exists != tt.wantExists {
+				t.Fatalf("album exists = %v, want %v", exists, tt.wantExists)
+			}
+			if tt.wantExists {
+				if tt.wantHeir && (remaining.UserID == nil || *remaining.UserID != heir.ID) ||
+					!tt.wantHeir && remaining.UserID != nil {
+					t.Errorf("album owner = %v, want the heir: %v", remaining.UserID, tt.wantHeir)
+				}
+			}
+
+			if status := apiCall(t, server, user.APIKey, http.MethodGet, "/me", nil, nil); status != http.StatusUnauthorized {
+				t.Errorf("GET /me after deleting = %d, want 401", status)
+			}
+			var webhooks int64
+			DB.Model(&Webhook{}).Where("user_id = ?", user.ID).Count(&webhooks)
+			if webhooks != 0 {
+				t.Errorf("%d webhooks left", webhooks)
+			}
+			// The address can be used again
+			createTestUser(t, user.Email)
+		})
+	}
+}
+
+func TestDeleteAccountNeedsPasswordAndHeir(t *testing.T) {
+	setupTestDB(t)
+	user := createTestUser(t, "careless@example.com")
+	setTestPassword(t, user)
+	server := httptest.NewServer(setupRouter(NewServices(DB)))
+	t.Cleanup(server.Close)
+
+	tests := []struct {
+		name string
+		req  DeleteAccountRequest
+		want int
+	}{
+		{"wrong password", DeleteAccountRequest{Password: "wrong"}, http.StatusForbidden},
+		{"transfer without an heir", DeleteAccountRequest{Password: testPassword, Albums: AlbumsTransfer}, http.StatusBadRequest},
+		{"transfer to nobody", DeleteAccountRequest{Password: testPassword, Albums: AlbumsTransfer, TransferTo: "nobody@example.com"}, http.StatusBadRequest},
+		{"transfer to yourself", DeleteAccountRequest{Password: testPassword, Albums: AlbumsTransfer, TransferTo: user.Email}, http.StatusBadRequest},
+		{"unknown policy", DeleteAccountRequest{Password: testPassword, Albums: "sell"}, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if status := apiCall(t, server, user.APIKey, http.MethodDelete, "/me", tt.req, nil); status != tt.want {
+				t.Errorf("DELETE /me = %d, want %d", status, tt.want)
+			}
+		})
+	}
+
+	if status := apiCall(t, server, user.APIKey, http.MethodGet, "/me", nil, nil); status != http.StatusOK {
+		t.Errorf("account is gone after refused deletions: GET /me = %d", status)
+	}
+}
+
+func TestDeleteAccountKeepsAnOwner(t *testing.T) {
+	setupTestDB(t)
+	owner := createTestUser(t, "founder@example.com")
+	member := createTestUser(t, "member@example.com")
+	setTestPassword(t, owner)
+	server := httptest.NewServer(setupRouter(NewServices(DB)))
+	t.Cleanup(server.Close)
+	organization := createTestOrganization(t, server, owner, "vinyl-vault", map[string]string{member.Email: RoleEditor})
+
+	req := DeleteAccountRequest{Password: testPassword}
+	if status, code := apiErrorCode(t, server, owner.APIKey, http.MethodDelete, "/me", req); status != http.StatusConflict || code != CodeLastOwner {
+		t.Fatalf("DELETE /me as the only owner = %d %s, want 409 %s", status, code, CodeLastOwner)
+	}
+
+	if status := apiCall(t, server, owner.APIKey, http.MethodPut, "/orgs/vinyl-vault/members", MemberRequest{Email: member.Email, Role: RoleOwner}, nil); status != http.StatusOK {
+		t.Fatalf("PUT /orgs/vinyl-vault/members = %d", status)
+	}
+	if status := apiCall(t, server, owner.APIKey, http.MethodDelete, "/me", req, nil); status != http.StatusOK {
+		t.Fatalf("DELETE /me with another owner = %d, want 200", status)
+	}
+	var owners []OrganizationMember
+	DB.Where("organization_id = ? AND role = ?", organization.ID, RoleOwner).Find(&owners)
+	if len(owners) != 1 || owners[0].UserID != member.ID {
+		t.Errorf("owners after deleting = %+v, want only the member", owners)
+	}
+}
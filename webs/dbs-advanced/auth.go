@@ -0,0 +1,227 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// POST /login - Public: exchange email and password for the user's API key.
+// Users with 2FA on also send otp_code; the password is checked first.
+func (h *AccountHandlers) postLogin(c *gin.Context) {
+	var credentials LoginRequest
+	if err := BindBody(c, &credentials); err != nil {
+		RespondError(c, CodeValidationFailed, err.Error())
+		return
+	}
+
+	user, err := authenticate(h.db, credentials.Email, credentials.Password)
+	if err != nil {
+		RespondDBError(c, err, CodeInvalidCredentials)
+		return
+	}
+	if err := checkSecondFactor(h.db, user, credentials.OTPCode); err != nil {
+		RespondDBError(c, err, CodeInvalidCredentials)
+		return
+	}
+
+	Respond(c, http.StatusOK, SuccessResponse{
+		Success: true,
+		Data:    UserCredentials{User: user, APIKey: user.APIKey},
+	})
+}
+
+// POST /keys/rotate - Protected: replace the caller's API key, the old one stops working
+func (h *AccountHandlers) rotateAPIKey(c *gin.Context) {
+	user, _ := GetCurrentUser(c)
+
+	// The user is told by email, in case it wasn't them
+	user.APIKey = uuid.NewString()
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&user).Update("api_key", user.APIKey).Error; err != nil {
+			return err
+		}
+		return QueueEmail(tx, user.Email, "key_rotated", gin.H{"User": user, "Time": time.Now().UTC()})
+	})
+	if err != nil {
+		RespondDBError(c, err, CodeUserNotFound)
+		return
+	}
+
+	Respond(c, http.StatusOK, SuccessResponse{
+		Success: true,
+		Data:    UserCredentials{User: user, APIKey: user.APIKey},
+	})
+}
+
+// authenticate checks an email, in any case, and password against the users
+// in db. Unknown emails and wrong passwords both come back as
+// gorm.ErrRecordNotFound so callers can't tell them apart; other errors are
+// database failures.
+func authenticate(db *gorm.DB, email, password string) (User, error) {
+	var user User
+	if err := db.Where("LOWER(email) = ?", strings.ToLower(email)).First(&user).Error; err != nil {
+		return User{}, err
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)) != nil {
+		return User{}, gorm.ErrRecordNotFound
+	}
+	return user, nil
+}
+
+// POST /password/forgot - Public: email a password reset token. The answer is
+// the same whether or not the email has an account, so it can't be used to
+// find out who is registered.
+func (h *AccountHandlers) postForgotPassword(c *gin.Context) {
+	var req ForgotPasswordRequest
+	if err := BindBody(c, &req); err != nil {
+		RespondError(c, CodeValidationFailed, err.Error())
+		return
+	}
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		var user User
+		if err := tx.Where("LOWER(email) = ?", strings.ToLower(req.Email)).First(&user).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil
+			}
+			return err
+		}
+
+		token, err := issueToken(tx, user.ID, TokenPasswordReset, passwordResetTTL)
+		if err != nil {
+			return err
+		}
+		return QueueEmail(tx, user.Email, "password_reset", gin.H{
+			"User": user, "Token": token, "Minutes": int(passwordResetTTL.Minutes()),
+		})
+	})
+	if err != nil {
+		RespondInternalError(c, err)
+		return
+	}
+
+	Respond(c, http.StatusAccepted, SuccessResponse{
+		Success: true,
+		Data:    gin.H{"message": "If the email belongs to an account, a reset token has been sent to it"},
+	})
+}
+
+// POST /password/reset - Public: set a new password with an emailed token.
+// With sign_out_everywhere the API key is replaced and web sessions end too.
+func (h *AccountHandlers) postResetPassword(c *gin.Context) {
+	var req ResetPasswordRequest
+	if err := BindBody(c, &req); err != nil {
+		RespondError(c, CodeValidationFailed, err.Error())
+		return
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	if err != nil {
+		RespondValidationError(c, "", []ValidationIssue{{Location: "body", Field: "password", Message: "must be at most 72 bytes"}})
+		return
+	}
+
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		userID, err := consumeToken(tx, req.Token, TokenPasswordReset)
+		if err != nil {
+			return err
+		}
+
+		var user User
+		if err := tx.First(&user, userID).Error; err != nil {
+			return err
+		}
+
+		now := time.Now()
+		changes := map[string]interface{}{"password": string(hash)}
+		if user.EmailVerifiedAt == nil {
+			changes["email_verified_at"] = now // the token reached them, so the address works
+		}
+		if req.SignOutEverywhere {
+			changes["api_key"] = uuid.NewString()
+			changes["sessions_revoked_at"] = now
+		}
+		return tx.Model(&user).Updates(changes).Error
+	})
+	if err != nil {
+		RespondDBError(c, err, CodeTokenInvalid)
+		return
+	}
+
+	Respond(c, http.StatusOK, SuccessResponse{
+		Success: true,
+		Data:    gin.H{"message": "Password changed, log in with the new one"},
+	})
+}
+
+// POST /email/verify/send - Protected: email a token confirming the caller's address
+func (h *AccountHandlers) postSendVerificationEmail(c *gin.Context) {
+	user, _ := GetCurrentUser(c)
+
+	if user.EmailVerifiedAt != nil {
+		Respond(c, http.StatusOK, SuccessResponse{
+			Success: true,
+			Data:    gin.H{"message": "Email address is already verified"},
+		})
+		return
+	}
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		return sendVerificationEmail(tx, user)
+	})
+	if err != nil {
+		RespondInternalError(c, err)
+		return
+	}
+
+	Respond(c, http.StatusAccepted, SuccessResponse{
+		Success: true,
+		Data:    gin.H{"message": "A verification token has been sent to " + user.Email},
+	})
+}
+
+// POST /email/verify - Public: confirm an email address with the emailed token
+func (h *AccountHandlers) postVerifyEmail(c *gin.Context) {
+	var req VerifyEmailRequest
+	if err := BindBody(c, &req); err != nil {
+		RespondError(c, CodeValidationFailed, err.Error())
+		return
+	}
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		userID, err := consumeToken(tx, req.Token, TokenEmailVerification)
+		if err != nil {
+			return err
+		}
+		return tx.Model(&User{}).Where("id = ? AND email_verified_at IS NULL", userID).
+			Update("email_verified_at", time.Now()).Error
+	})
+	if err != nil {
+		RespondDBError(c, err, CodeTokenInvalid)
+		return
+	}
+
+	Respond(c, http.StatusOK, SuccessResponse{
+		Success: true,
+		Data:    gin.H{"message": "Email address verified"},
+	})
+}
+
+// sendVerificationEmail issues a verification token and queues the email carrying it
+func sendVerificationEmail(tx *gorm.DB, user User) error {
+	token, err := issueToken(tx, user.ID, TokenEmailVerification, emailVerificationTTL)
+	if err != nil {
+		return err
+	}
+	return QueueEmail(tx, user.Email, "verify_email", gin.H{
+		"User": user, "Token": token, "Hours": int(emailVerificationTTL.Hours()),
+	})
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var emailedToken = regexp.MustCompile(`[0-9a-f]{64}`)
+
+// tokenFromEmail returns the token in the last email queued from template
+func tokenFromEmail(t *testing.T, template string) string {
+	t.Helper()
+
+	var email QueuedEmail
+	if err := DB.Where("template = ?", template).Last(&email).Error; err != nil {
+		t.Fatalf("no %s email queued: %v", template, err)
+	}
+	token := emailedToken.FindString(email.TextBody)
+	if token == "" {
+		t.Fatalf("no token in %s email: %q", template, email.TextBody)
+	}
+	return token
+}
+
+func TestEmailsIgnoreCase(t *testing.T) {
+	setupTestDB(t)
+	user := createTestUser(t, "mixed@example.com")
+	setTestPassword(t, user)
+	server := httptest.NewServer(setupRouter(NewServices(DB)))
+	t.Cleanup(server.Close)
+
+	if status := apiCall(t, server, "", http.MethodPost, "/login", LoginRequest{Email: "Mixed@Example.COM", Password: testPassword}, nil); status != http.StatusOK {
+		t.Errorf("login with the email in capitals = %d, want 200", status)
+	}
+	apiCall(t, server, "", http.MethodPost, "/password/forgot", ForgotPasswordRequest{Email: "MIXED@example.com"}, nil)
+	var reset QueuedEmail
+	if err := DB.Where("template = ? AND \"to\" = ?", "password_reset", user.Email).First(&reset).Error; err != nil {
+		t.Errorf("forgot with the email in capitals sent nothing: %v", err)
+	}
+}
+
+func TestPasswordReset(t *testing.T) {
+	setupTestDB(t)
+	user := createTestUser(t, "forgetful@example.com")
+	server := httptest.NewServer(setupRouter(NewServices(DB)))
+	t.Cleanup(server.Close)
+
+	// Unknown addresses get the same answer and no email
+	if status := apiCall(t, server, "", http.MethodPost, "/password/forgot", ForgotPasswordRequest{Email: "nobody@example.com"}, nil); status != http.StatusAccepted {
+		t.Errorf("forgot for an unknown email = %d, want 202", status)
+	}
+	var queued int64
+	DB.Model(&QueuedEmail{}).Count(&queued)
+	if queued != 0 {
+		t.Errorf("%d emails queued for an unknown address, want none", queued)
+	}
+
+	apiCall(t, server, "", http.MethodPost, "/password/forgot", ForgotPasswordRequest{Email: user.Email}, nil)
+	first := tokenFromEmail(t, "password_reset")
+	apiCall(t, server, "", http.MethodPost, "/password/forgot", ForgotPasswordRequest{Email: user.Email}, nil)
+	token := tokenFromEmail(t, "password_reset")
+
+	var stored UserToken
+	DB.Where("user_id = ? AND used_at IS NULL", user.ID).First(&stored)
+	if stored.TokenHash == token || stored.TokenHash != hashToken(token) {
+		t.Errorf("stored token hash = %q, want the SHA-256 of the token, not the token", stored.TokenHash)
+	}
+
+	tests := []struct {
+		name string
+		req  ResetPasswordRequest
+		want int
+	}{
+		{"replaced token", ResetPasswordRequest{Token: first, Password: "new-password"}, http.StatusBadRequest},
+		{"short password", ResetPasswordRequest{Token: token, Password: "short"}, http.StatusBadRequest},
+		{"valid token", ResetPasswordRequest{Token: token, Password: "new-password"}, http.StatusOK},
+		{"used token", ResetPasswordRequest{Token: token, Password: "another-password"}, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if status := apiCall(t, server, "", http.MethodPost, "/password/reset", tt.req, nil); status != tt.want {
+				t.Errorf("POST /password/reset = %d, want %d", status, tt.want)
+			}
+		})
+	}
+
+	var updated User
+	DB.First(&updated, user.ID)
+	if bcrypt.CompareHashAndPassword([]byte(updated.Password), []byte("new-password")) != nil {
+		t.Error("password was not changed to new-password")
+	}
+	if updated.APIKey != user.APIKey || updated.EmailVerifiedAt == nil {
+		t.Errorf("after reset: API key changed = %v, verified = %v; want the same key and a verified address",
+			updated.APIKey != user.APIKey, updated.EmailVerifiedAt != nil)
+	}
+}
+
+func TestPasswordResetSignsOutEverywhere(t *testing.T) {
+	setupTestDB(t)
+	user := createTestUser(t, "worried@example.com")
+	server := httptest.NewServer(setupRouter(NewServices(DB)))
+	t.Cleanup(server.Close)
+
+	apiCall(t, server, "", http.MethodPost, "/password/forgot", ForgotPasswordRequest{Email: user.Email}, nil)
+	req := ResetPasswordRequest{Token: tokenFromEmail(t, "password_reset"), Password: "new-password", SignOutEverywhere: true}
+	if status := apiCall(t, server, "", http.MethodPost, "/password/reset", req, nil); status != http.StatusOK {
+		t.Fatalf("POST /password/reset = %d", status)
+	}
+
+	if status := apiCall(t, server, user.APIKey, http.MethodGet, "/me", nil, nil); status != http.StatusUnauthorized {
+		t.Errorf("GET /me with the old key = %d, want 401", status)
+	}
+	var updated User
+	DB.First(&updated, user.ID)
+	if updated.SessionsRevokedAt == nil {
+		t.Error("web sessions were not revoked")
+	}
+}
+
+func TestExpiredTokenIsRejected(t *testing.T) {
+	setupTestDB(t)
+	user := createTestUser(t, "slow@example.com")
+
+	token, err := issueToken(DB, user.ID, TokenPasswordReset, -time.Minute)
+	if err != nil {
+		t.Fatalf("issueToken: %v", err)
+	}
+	if _, err := consumeToken(DB, token, TokenPasswordReset); err == nil {
+		t.Error("consumeToken accepted an expired token")
+	}
+
+	token, _ = issueToken(DB, user.ID, TokenPasswordReset, time.Hour)
+	if _, err := consumeToken(DB, token, TokenEmailVerification); err == nil {
+		t.Error("consumeToken accepted a token issued for another purpose")
+	}
+}
+
+func TestEmailVerificationGatesAlbums(t *testing.T) {
+	setupTestDB(t)
+	RequireVerifiedEmail = true
+	t.Cleanup(func() { RequireVerifiedEmail = false })
+
+	user := createTestUser(t, "new@example.com")
+	server := httptest.NewServer(setupRouter(NewServices(DB)))
+	t.Cleanup(server.Close)
+	album := AlbumInput{Title: strPtr("Somethin' Else"), Artist: strPtr("Cannonball Adderley"), Price: floatPtr(21.99)}
+
+	if status := apiCall(t, server, user.APIKey, http.MethodPost, "/albums", album, nil); status != http.StatusForbidden {
+		t.Errorf("POST /albums before verifying = %d, want 403", status)
+	}
+
+	if status := apiCall(t, server, user.APIKey, http.MethodPost, "/email/verify/send", nil, nil); status != http.StatusAccepted {
+		t.Fatalf("POST /email/verify/send = %d, want 202", status)
+	}
+	verify := VerifyEmailRequest{Token: tokenFromEmail(t, "verify_email")}
+	if status := apiCall(t, server, "", http.MethodPost, "/email/verify", verify, nil); status != http.StatusOK {
+		t.Fatalf("POST /email/verify = %d, want 200", status)
+	}
+	if status := apiCall(t, server, "", http.MethodPost, "/email/verify", verify, nil); status != http.StatusBadRequest {
+		t.Errorf("verifying twice with one token = %d, want 400", status)
+	}
+
+	if status := apiCall(t, server, user.APIKey, http.MethodPost, "/albums", album, nil); status != http.StatusCreated {
+		t.Errorf("POST /albums after verifying = %d, want 201", status)
+	}
+	if status := apiCall(t, server, user.APIKey, http.MethodPost, "/email/verify/send", nil, nil); status != http.StatusOK {
+		t.Errorf("POST /email/verify/send when verified = %d, want 200", status)
+	}
+}
+
+func strPtr(s string) *string     { return &s }
+func floatPtr(f float64) *float64 { return &f }
+
+// Only the endpoints that hand out an API key answer with one, and album
+// owners' email addresses are only shown to the owners themselves
+func TestResponsesLeaveOutSecrets(t *testing.T) {
+	server := newTestServer(t)
+	owner := createTestUser(t, "owner@example.com")
+	viewer := createTestUser(t, "viewer@example.com")
+	setTestPassword(t, viewer)
+	album := createTestAlbum(t, "Blue Train", &owner.ID)
+
+	tests := []struct {
+		method, path string
+		apiKey       string
+		body         interface{}
+		wantKey      bool
+		wantEmail    bool // the owner's
+	}{
+		{http.MethodPost, "/login", "", LoginRequest{Email: viewer.Email, Password: testPassword}, true, false},
+		{http.MethodGet, "/me", viewer.APIKey, nil, false, false},
+		{http.MethodPatch, "/me", viewer.APIKey, map[string]string{"first_name": "Vi"}, false, false},
+		{http.MethodGet, "/albums", "", nil, false, false},
+		{http.MethodGet, "/albums", viewer.APIKey, nil, false, false},
+		{http.MethodGet, "/my-albums", owner.APIKey, nil, false, true},
+		{http.MethodGet, "/albums/" + itoa(album.ID), owner.APIKey, nil, false, true},
+		{http.MethodPost, "/keys/rotate", viewer.APIKey, nil, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			var data json.RawMessage
+			if status := apiCall(t, server, tt.apiKey, tt.method, tt.path, tt.body, &data); status != http.StatusOK {
+				t.Fatalf("%s %s = %d, want 200", tt.method, tt.path, status)
+			}
+			body := string(data)
+			if strings.Contains(body, `"api_key"`) != tt.wantKey {
+				t.Errorf("%s %s = %s, want api_key %v", tt.method, tt.path, body, tt.wantKey)
+			}
+			if strings.Contains(body, owner.Email) != tt.wantEmail {
+				t.Errorf("%s %s = %s, want the owner's email %v", tt.method, tt.path, body, tt.wantEmail)
+			}
+		})
+	}
+}
+
+// The key is answered in every format, not only JSON
+func TestLoginAnswersKeyInEveryFormat(t *testing.T) {
+	server := newTestServer(t)
+	user := createTestUser(t, "formats@example.com")
+	setTestPassword(t, user)
+
+	for _, accept := range []string{"application/json", "application/xml", "application/yaml", "application/x-msgpack"} {
+		t.Run(accept, func(t *testing.T) {
+			body := strings.NewReader(`{"email":"formats@example.com","password":"` + testPassword + `"}`)
+			req, _ := http.NewRequest(http.MethodPost, server.URL+"/login", body)
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Accept", accept)
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("POST /login: %v", err)
+			}
+			defer resp.Body.Close()
+			answer, _ := io.ReadAll(resp.Body)
+			if resp.StatusCode != http.StatusOK || !bytes.Contains(answer, []byte(user.APIKey)) || !bytes.Contains(answer, []byte(user.Email)) {
+				t.Errorf("POST /login as %s = %d %q, want the user and their key", accept, resp.StatusCode, answer)
+			}
+		})
+	}
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ErrAlbumHasOwner means a claim was made on, or approved for, an album that
+// already has an owner
+var ErrAlbumHasOwner = errors.New("album already has an owner")
+
+// ErrClaimPending means the user has already claimed the album and is
+// waiting for an answer
+var ErrClaimPending = errors.New("album already claimed by this user")
+
+// ErrClaimNotPending means the claim was already approved, rejected or
+// withdrawn
+var ErrClaimNotPending = errors.New("claim is no longer pending")
+
+// ClaimHandlers serve the routes for claiming albums without an owner
+type ClaimHandlers struct {
+	db *gorm.DB
+}
+
+// NewClaimHandlers keeps claims in db
+func NewClaimHandlers(db *gorm.DB) *ClaimHandlers {
+	return &ClaimHandlers{db: db}
+}
+
+// POST /albums/:id/claim - Protected: ask to become the owner of an album
+// without one. An administrator approves or rejects the claim.
+func (h *ClaimHandlers) postAlbumClaim(c *gin.Context) {
+	userID, _ := GetCurrentUserID(c)
+	id, ok := albumIDParam(c)
+	if !ok {
+		return
+	}
+
+	// The body is optional
+	var req ClaimAlbumRequest
+	if c.Request.ContentLength != 0 {
+		if err := BindBody(c, &req); err != nil {
+			RespondError(c, CodeValidationFailed, err.Error())
+			return
+		}
+	}
+
+	claim, err := ClaimAlbum(h.db, CurrentTenant(c), userID, id, strings.TrimSpace(req.Reason))
+	if err != nil {
+		RespondDBError(c, err, CodeAlbumNotFound)
+		return
+	}
+
+	Respond(c, http.StatusCreated, SuccessResponse{
+		Success: true,
+		Data:    claim,
+	})
+}
+
+// GET /claims - Protected: the caller's claims, newest first
+func (h *ClaimHandlers) getMyClaims(c *gin.Context) {
+	userID, _ := GetCurrentUserID(c)
+
+	var claims []AlbumClaim
+	err := h.db.Preload("Album").Where("user_id = ?", userID).Order("id DESC").Find(&claims).Error
+	if err == nil {
+		err = fillClaimEmails(h.db, claims)
+	}
+	if err != nil {
+		RespondInternalError(c, err)
+		return
+	}
+
+	Respond(c, http.StatusOK, SuccessResponse{
+		Success: true,
+		Data:    claims,
+	})
+}
+
+// GET /admin/claims - Admin: every claim, ?status= to filter and
+// ?limit=&offset= to page
+func (h *ClaimHandlers) getClaims(c *gin.Context) {
+	paginate, issues := Paginate(c)
+	status := c.Query("status")
+	switch status {
+	case "", ClaimPending, ClaimApproved, ClaimRejected, ClaimWithdrawn:
+	default:
+		issues = append(issues, ValidationIssue{Location: "query", Field: "status", Message: "must be pending, approved, rejected or withdrawn"})
+	}
+	if issues != nil {
+		RespondValidationError(c, "", issues)
+		return
+	}
+
+	query := h.db.Scopes(paginate).Preload("Album")
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	var claims []AlbumClaim
+	err := query.Find(&claims).Error
+	if err == nil {
+		err = fillClaimEmails(h.db, claims)
+	}
+	if err != nil {
+		RespondInternalError(c, err)
+		return
+	}
+
+	Respond(c, http.StatusOK, SuccessResponse{
+		Success: true,
+		Data:    claims,
+	})
+}
+
+// POST /admin/claims/:id/approve - Admin: make the claimant the album's owner
+func (h *ClaimHandlers) approveClaim(c *gin.Context) {
+	h.reviewClaim(c, ClaimApproved)
+}
+
+// POST /admin/claims/:id/reject - Admin: turn the claim down
+func (h *ClaimHandlers) rejectClaim(c *gin.Context) {
+	h.reviewClaim(c, ClaimRejected)
+}
+
+func (h *ClaimHandlers) reviewClaim(c *gin.Context, status string) {
+	adminID, _ := GetCurrentUserID(c)
+	id, ok := uintParam(c, "id")
+	if !ok {
+		return
+	}
+
+	// The body is optional
+	var req ReviewClaimRequest
+	if c.Request.ContentLength != 0 {
+		if err := BindBody(c, &req); err != nil {
+			RespondError(c, CodeValidationFailed, err.Error())
+			return
+		}
+	}
+
+	claim, err := ReviewClaim(h.db, adminID, id, status, strings.TrimSpace(req.Note))
+	if err != nil {
+		RespondDBError(c, err, CodeClaimNotFound)
+		return
+	}
+
+	Respond(c, http.StatusOK, SuccessResponse{
+		Success: true,
+		Data:    claim,
+	})
+}
+
+// ClaimAlbum records userID's pending claim on an album without an owner in
+// the tenant's catalogue
+func ClaimAlbum(db *gorm.DB, tenant Tenant, userID, albumID uint, reason string) (AlbumClaim, error) {
+	var claim AlbumClaim
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var album Album
+		if err := tx.Scopes(tenant.Albums).First(&album, albumID).Error; err != nil {
+			return err
+		}
+		if album.UserID != nil || album.OrganizationID != nil {
+			return ErrAlbumHasOwner
+		}
+
+		var pending int64
+		if err := tx.Model(&AlbumClaim{}).Where("album_id = ? AND user_id = ? AND status = ?", albumID, userID, ClaimPending).Count(&pending).Error; err != nil {
+			return err
+		}
+		if pending > 0 {
+			return ErrClaimPending
+		}
+
+		claim = AlbumClaim{AlbumID: albumID, UserID: userID, Reason: reason, Status: ClaimPending}
+		return tx.Create(&claim).Error
+	})
+	if err != nil {
+		return AlbumClaim{}, err
+	}
+	return loadClaim(db, claim.ID)
+}
+
+// ReviewClaim moves a pending claim to status, approved or rejected, on
+// behalf of the administrator adminID. Approving it makes the claimant the
+// album's owner and rejects everyone else's claims on it. Each claimant is
+// told the outcome by email.
+func ReviewClaim(db *gorm.DB, adminID, claimID uint, status, note string) (AlbumClaim, error) {
+	var owned Album // set when the album gets its owner
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var claim AlbumClaim
+		if err := tx.First(&claim, claimID).Error; err != nil {
+			return err
+		}
+
+		now := time.Now()
+		review := map[string]interface{}{"status": status, "reviewed_by_id": adminID, "review_note": note, "reviewed_at": now}
+		reviewed := tx.Model(&AlbumClaim{}).Where("id = ? AND status = ?", claim.ID, ClaimPending).Updates(review)
+		if reviewed.Error != nil {
+			return reviewed.Error
+		}
+		if reviewed.RowsAffected == 0 {
+			return ErrClaimNotPending
+		}
+		answered := []AlbumClaim{claim}
+
+		var album Album
+		if err := tx.First(&album, claim.AlbumID).Error; err != nil {
+			return err
+		}
+		if status == ClaimApproved {
+			// Someone may have become the owner since the claim was made
+			claimed := tx.Model(&Album{}).Where("id = ? AND user_id IS NULL AND organization_id IS NULL", album.ID).Update("user_id", claim.UserID)
+			if claimed.Error != nil {
+				return claimed.Error
+			}
+			if claimed.RowsAffected == 0 {
+				return ErrAlbumHasOwner
+			}
+			album.UserID = &claim.UserID
+			if err := tx.Where("album_id = ? AND user_id = ?", album.ID, claim.UserID).Delete(&AlbumCollaborator{}).Error; err != nil {
+				return err
+			}
+
+			var others []AlbumClaim
+			if err := tx.Where("album_id = ? AND status = ?", album.ID, ClaimPending).Find(&others).Error; err != nil {
+				return err
+			}
+			if len(others) > 0 {
+				rejected := map[string]interface{}{"status": ClaimRejected, "reviewed_by_id": adminID, "review_note": "Another claim was approved", "reviewed_at": now}
+				if err := tx.Model(&AlbumClaim{}).Where("album_id = ? AND status = ?", album.ID, ClaimPending).Updates(rejected).Error; err != nil {
+					return err
+				}
+				for _, other := range others {
+					other.Status, other.ReviewNote = ClaimRejected, "Another claim was approved"
+					answered = append(answered, other)
+				}
+			}
+			if err := addOutboxEvent(tx, EventAlbumUpdated, album); err != nil {
+				return err
+			}
+			owned = album
+		}
+		answered[0].Status, answered[0].ReviewNote = status, note
+
+		for _, claim := range answered {
+			var claimant User
+			if err := tx.First(&claimant, claim.UserID).Error; err != nil {
+				return err
+			}
+			err := QueueEmail(tx, claimant.Email, "album_claim_reviewed", gin.H{
+				"User": claimant, "Album": album, "Claim": claim, "Approved": claim.Status == ClaimApproved,
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return AlbumClaim{}, err
+	}
+	if owned.ID != 0 {
+		Events.Publish(EventAlbumUpdated, owned)
+	}
+	return loadClaim(db, claimID)
+}
+
+// rejectClaims rejects the album's pending claims when it is deleted
+func rejectClaims(tx *gorm.DB, albumID uint) error {
+	return tx.Model(&AlbumClaim{}).Where("album_id = ? AND status = ?", albumID, ClaimPending).
+		Updates(map[string]interface{}{"status": ClaimRejected, "review_note": "The album was deleted", "reviewed_at": time.Now()}).Error
+}
+
+func loadClaim(db *gorm.DB, id uint) (AlbumClaim, error) {
+	var claim AlbumClaim
+	if err := db.Preload("Album").First(&claim, id).Error; err != nil {
+		return AlbumClaim{}, err
+	}
+	claims := []AlbumClaim{claim}
+	err := fillClaimEmails(db, claims)
+	return claims[0], err
+}
+
+// fillClaimEmails sets each claimant's email address
+func fillClaimEmails(db *gorm.DB, claims []AlbumClaim) error {
+	var ids []uint
+	for _, claim := range claims {
+		ids = append(ids, claim.UserID)
+	}
+	emails, err := userEmails(db, ids)
+	if err != nil {
+		return err
+	}
+	for i := range claims {
+		claims[i].Email = emails[claims[i].UserID]
+	}
+	return nil
+}
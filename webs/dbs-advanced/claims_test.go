@@ -0,0 +1,171 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestOwnerlessAlbumIsReadOnly(t *testing.T) {
+	setupTestDB(t)
+	admin := createTestUser(t, "admin@example.com")
+	DB.Model(&admin).Update("is_admin", true)
+	user := createTestUser(t, "user@example.com")
+	album := createTestAlbum(t, "Kind of Blue", nil)
+	server := httptest.NewServer(setupRouter(NewServices(DB)))
+	t.Cleanup(server.Close)
+
+	path := "/albums/" + itoa(album.ID)
+	update := AlbumInput{Title: strPtr("Kind of Green"), Artist: strPtr(album.Artist), Price: floatPtr(album.Price)}
+	tests := []struct {
+		name             string
+		apiKey           string
+		wantGet, wantPut int
+	}{
+		{"user", user.APIKey, http.StatusOK, http.StatusForbidden},
+		{"admin", admin.APIKey, http.StatusOK, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if status := apiCall(t, server, tt.apiKey, http.MethodGet, path, nil, nil); status != tt.wantGet {
+				t.Errorf("GET = %d, want %d", status, tt.wantGet)
+			}
+			if status := apiCall(t, server, tt.apiKey, http.MethodPut, path, update, nil); status != tt.wantPut {
+				t.Errorf("PUT = %d, want %d", status, tt.wantPut)
+			}
+		})
+	}
+
+	// Not even the administrator's change makes the album theirs
+	var edited Album
+	DB.First(&edited, album.ID)
+	if edited.UserID != nil {
+		t.Errorf("album owner = %d after an update, want none", *edited.UserID)
+	}
+	if status := apiCall(t, server, user.APIKey, http.MethodDelete, path, nil, nil); status != http.StatusForbidden {
+		t.Errorf("user deleting the album = %d, want 403", status)
+	}
+}
+
+func TestAlbumClaim(t *testing.T) {
+	setupTestDB(t)
+	admin := createTestUser(t, "admin@example.com")
+	DB.Model(&admin).Update("is_admin", true)
+	claimant := createTestUser(t, "claimant@example.com")
+	rival := createTestUser(t, "rival@example.com")
+	owner := createTestUser(t, "owner@example.com")
+	album := createTestAlbum(t, "Moanin'", nil)
+	owned := createTestAlbum(t, "Owned", &owner.ID)
+	server := httptest.NewServer(setupRouter(NewServices(DB)))
+	t.Cleanup(server.Close)
+
+	path := "/albums/" + itoa(album.ID) + "/claim"
+	var claim, rivalClaim AlbumClaim
+	if status := apiCall(t, server, claimant.APIKey, http.MethodPost, path, ClaimAlbumRequest{Reason: "I uploaded it"}, &claim); status != http.StatusCreated {
+		t.Fatalf("POST %s = %d, want 201", path, status)
+	}
+	if claim.Status != ClaimPending || claim.Email != claimant.Email || claim.Reason != "I uploaded it" {
+		t.Errorf("claim = %+v, want pending with the reason", claim)
+	}
+	if status := apiCall(t, server, rival.APIKey, http.MethodPost, path, nil, &rivalClaim); status != http.StatusCreated {
+		t.Fatalf("POST %s without a body = %d, want 201", path, status)
+	}
+
+	refused := []struct {
+		name   string
+		apiKey string
+		method string
+		path   string
+		want   int
+	}{
+		{"claiming twice", claimant.APIKey, http.MethodPost, path, http.StatusConflict},
+		{"claiming an owned album", claimant.APIKey, http.MethodPost, "/albums/" + itoa(owned.ID) + "/claim", http.StatusConflict},
+		{"listing as a user", claimant.APIKey, http.MethodGet, "/admin/claims", http.StatusForbidden},
+		{"approving as a user", claimant.APIKey, http.MethodPost, "/admin/claims/" + itoa(claim.ID) + "/approve", http.StatusForbidden},
+		{"unknown status", admin.APIKey, http.MethodGet, "/admin/claims?status=lost", http.StatusBadRequest},
+	}
+	for _, tt := range refused {
+		t.Run(tt.name, func(t *testing.T) {
+			if status := apiCall(t, server, tt.apiKey, tt.method, tt.path, nil, nil); status != tt.want {
+				t.Errorf("%s %s = %d, want %d", tt.method, tt.path, status, tt.want)
+			}
+		})
+	}
+
+	var pending []AlbumClaim
+	apiCall(t, server, admin.APIKey, http.MethodGet, "/admin/claims?status=pending", nil, &pending)
+	if len(pending) != 2 || pending[0].Album == nil || pending[0].Album.Title != album.Title {
+		t.Errorf("pending claims = %+v, want both with their album", pending)
+	}
+
+	approve := "/admin/claims/" + itoa(claim.ID) + "/approve"
+	if status := apiCall(t, server, admin.APIKey, http.MethodPost, approve, ReviewClaimRequest{Note: "Welcome back"}, &claim); status != http.StatusOK {
+		t.Fatalf("POST %s = %d", approve, status)
+	}
+	if claim.Status != ClaimApproved || claim.ReviewedByID == nil || *claim.ReviewedByID != admin.ID || claim.ReviewedAt == nil {
+		t.Errorf("approved claim = %+v", claim)
+	}
+	if status := apiCall(t, server, admin.APIKey, http.MethodPost, approve, nil, nil); status != http.StatusConflict {
+		t.Errorf("approving twice = %d, want 409", status)
+	}
+
+	var claimed Album
+	DB.First(&claimed, album.ID)
+	if claimed.UserID == nil || *claimed.UserID != claimant.ID {
+		t.Errorf("album owner = %v, want %d", claimed.UserID, claimant.ID)
+	}
+	DB.First(&rivalClaim, rivalClaim.ID)
+	if rivalClaim.Status != ClaimRejected {
+		t.Errorf("rival's claim is %s, want rejected", rivalClaim.Status)
+	}
+	for _, to := range []string{claimant.Email, rival.Email} {
+		var email QueuedEmail
+		if err := DB.Where("template = ? AND \"to\" = ?", "album_claim_reviewed", to).First(&email).Error; err != nil {
+			t.Errorf("%s was not emailed: %v", to, err)
+		}
+	}
+
+	// Now the album is the claimant's, and nobody else's to read
+	if status := apiCall(t, server, rival.APIKey, http.MethodGet, "/albums/"+itoa(album.ID), nil, nil); status != http.StatusForbidden {
+		t.Errorf("rival reading the claimed album = %d, want 403", status)
+	}
+	var mine []AlbumClaim
+	apiCall(t, server, rival.APIKey, http.MethodGet, "/claims", nil, &mine)
+	if len(mine) != 1 || mine[0].ID != rivalClaim.ID {
+		t.Errorf("GET /claims = %+v, want only the rival's claim", mine)
+	}
+}
+
+func TestRejectAlbumClaim(t *testing.T) {
+	setupTestDB(t)
+	admin := createTestUser(t, "admin@example.com")
+	DB.Model(&admin).Update("is_admin", true)
+	claimant := createTestUser(t, "claimant@example.com")
+	album := createTestAlbum(t, "Speak No Evil", nil)
+	server := httptest.NewServer(setupRouter(NewServices(DB)))
+	t.Cleanup(server.Close)
+
+	var claim AlbumClaim
+	apiCall(t, server, claimant.APIKey, http.MethodPost, "/albums/"+itoa(album.ID)+"/claim", nil, &claim)
+
+	reject := "/admin/claims/" + itoa(claim.ID) + "/reject"
+	if status := apiCall(t, server, admin.APIKey, http.MethodPost, reject, nil, &claim); status != http.StatusOK {
+		t.Fatalf("POST %s = %d", reject, status)
+	}
+	if claim.Status != ClaimRejected {
+		t.Errorf("status = %s, want rejected", claim.Status)
+	}
+	var kept Album
+	DB.First(&kept, album.ID)
+	if kept.UserID != nil {
+		t.Errorf("album owner = %d after a rejection, want none", *kept.UserID)
+	}
+
+	// A rejected claimant may try again
+	if status := apiCall(t, server, claimant.APIKey, http.MethodPost, "/albums/"+itoa(album.ID)+"/claim", nil, nil); status != http.StatusCreated {
+		t.Errorf("claiming again = %d, want 201", status)
+	}
+	if status := apiCall(t, server, admin.APIKey, http.MethodPost, "/admin/claims/999/reject", nil, nil); status != http.StatusNotFound {
+		t.Errorf("rejecting an unknown claim = %d, want 404", status)
+	}
+}
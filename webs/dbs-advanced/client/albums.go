@@ -0,0 +1,137 @@
+package client
+
+import (
+	"context"
+	"iter"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Album as returned by the server. UserID is nil for ownerless albums.
+type Album struct {
+	ID        uint      `json:"id"`
+	Title     string    `json:"title"`
+	Artist    string    `json:"artist"`
+	Price     float64   `json:"price"`
+	UserID    *uint     `json:"user_id,omitempty"`
+	User      *User     `json:"user,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// AlbumInput is the body for CreateAlbum and UpdateAlbum
+type AlbumInput struct {
+	Title  string  `json:"title"`
+	Artist string  `json:"artist"`
+	Price  float64 `json:"price"`
+}
+
+// ListOptions selects one page of a list. A zero Limit returns everything.
+type ListOptions struct {
+	Limit  int
+	Offset int
+}
+
+func (o *ListOptions) query() url.Values {
+	if o == nil || o.Limit == 0 {
+		return nil
+	}
+	q := url.Values{}
+	q.Set("limit", strconv.Itoa(o.Limit))
+	q.Set("offset", strconv.Itoa(o.Offset))
+	return q
+}
+
+// Default page size for the AllAlbums and AllMyAlbums iterators
+const DefaultPageSize = 50
+
+// ListAlbums returns public albums (GET /albums). No API key needed.
+func (c *Client) ListAlbums(ctx context.Context, opts *ListOptions) ([]Album, error) {
+	var albums []Album
+	err := c.do(ctx, http.MethodGet, "/albums", opts.query(), nil, &albums)
+	return albums, err
+}
+
+// ListMyAlbums returns the caller's albums (GET /my-albums)
+func (c *Client) ListMyAlbums(ctx context.Context, opts *ListOptions) ([]Album, error) {
+	var albums []Album
+	err := c.do(ctx, http.MethodGet, "/my-albums", opts.query(), nil, &albums)
+	return albums, err
+}
+
+// AllAlbums iterates over every public album, fetching pageSize at a time
+// (DefaultPageSize if 0). Iteration stops at the first error.
+//
+//	for album, err := range c.AllAlbums(ctx, 0) { ... }
+func (c *Client) AllAlbums(ctx context.Context, pageSize int) iter.Seq2[Album, error] {
+	return c.paginate(ctx, pageSize, c.ListAlbums)
+}
+
+// AllMyAlbums is AllAlbums for the caller's own albums
+func (c *Client) AllMyAlbums(ctx context.Context, pageSize int) iter.Seq2[Album, error] {
+	return c.paginate(ctx, pageSize, c.ListMyAlbums)
+}
+
+func (c *Client) paginate(ctx context.Context, pageSize int, list func(context.Context, *ListOptions) ([]Album, error)) iter.Seq2[Album, error] {
+	if pageSize <= 0 {
+		pageSize = DefaultPageSize
+	}
+
+	return func(yield func(Album, error) bool) {
+		opts := &ListOptions{Limit: pageSize}
+		for {
+			page, err := list(ctx, opts)
+			if err != nil {
+				yield(Album{}, err)
+				return
+			}
+			for _, album := range page {
+				if !yield(album, nil) {
+					return
+				}
+			}
+			if len(page) < pageSize {
+				return
+			}
+			opts.Offset += len(page)
+		}
+	}
+}
+
+// GetAlbum returns one of the caller's albums (GET /albums/:id)
+func (c *Client) GetAlbum(ctx context.Context, id uint) (*Album, error) {
+	var album Album
+	if err := c.do(ctx, http.MethodGet, albumPath(id), nil, nil, &album); err != nil {
+		return nil, err
+	}
+	return &album, nil
+}
+
+// CreateAlbum creates an album owned by the caller (POST /albums)
+func (c *Client) CreateAlbum(ctx context.Context, input AlbumInput) (*Album, error) {
+	var album Album
+	if err := c.do(ctx, http.MethodPost, "/albums", nil, input, &album); err != nil {
+		return nil, err
+	}
+	return &album, nil
+}
+
+// UpdateAlbum replaces an album's fields (PUT /albums/:id)
+func (c *Client) UpdateAlbum(ctx context.Context, id uint, input AlbumInput) (*Album, error) {
+	var album Album
+	if err := c.do(ctx, http.MethodPut, albumPath(id), nil, input, &album); err != nil {
+		return nil, err
+	}
+	return &album, nil
+}
+
+// DeleteAlbum deletes one of the caller's albums (DELETE /albums/:id)
+func (c *Client) DeleteAlbum(ctx context.Context, id uint) error {
+	return c.do(ctx, http.MethodDelete, albumPath(id), nil, nil, nil)
+}
+
+func albumPath(id uint) string {
+	return "/albums/" + strconv.FormatUint(uint64(id), 10)
+}
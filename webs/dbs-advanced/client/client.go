@@ -0,0 +1,200 @@
+// Package client is a typed Go client for the dbs-advanced album API.
+//
+//	c := client.New("http://localhost:8080", client.WithAPIKey(key))
+//	album, err := c.CreateAlbum(ctx, client.AlbumInput{Title: "Jeru", Artist: "Gerry Mulligan", Price: 17.99})
+//	if client.IsCode(err, client.CodeValidationFailed) { ... }
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Client talks to one dbs-advanced server. It is safe for concurrent use.
+type Client struct {
+	baseURL     string
+	apiKey      string
+	httpClient  *http.Client
+	maxRetries  int
+	baseBackoff time.Duration
+	maxBackoff  time.Duration
+}
+
+// Option configures a Client
+type Option func(*Client)
+
+// WithAPIKey authenticates every request with the X-API-Key header
+func WithAPIKey(apiKey string) Option {
+	return func(c *Client) { c.apiKey = apiKey }
+}
+
+// WithHTTPClient replaces http.DefaultClient, e.g. to set timeouts or a transport
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) { c.httpClient = httpClient }
+}
+
+// WithRetries sets how many times a failed request is retried and the first
+// backoff delay, which doubles (with jitter) on every attempt.
+func WithRetries(maxRetries int, baseBackoff time.Duration) Option {
+	return func(c *Client) {
+		c.maxRetries = maxRetries
+		c.baseBackoff = baseBackoff
+	}
+}
+
+// New creates a client for the server at baseURL (e.g. "http://localhost:8080")
+func New(baseURL string, opts ...Option) *Client {
+	c := &Client{
+		baseURL:     strings.TrimRight(baseURL, "/"),
+		httpClient:  http.DefaultClient,
+		maxRetries:  3,
+		baseBackoff: 200 * time.Millisecond,
+		maxBackoff:  5 * time.Second,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// envelope is the server's SuccessResponse/ErrorResponse wrapper
+type envelope struct {
+	Success bool            `json:"success"`
+	Data    json.RawMessage `json:"data"`
+}
+
+// do sends a request and decodes the "data" of a successful response into
+// out (if not nil). Error responses come back as *APIError.
+//
+// 429 is retried for every method since the server did no work. Network
+// errors and 5xx are only retried for idempotent methods, so a POST is never
+// applied twice.
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, body, out interface{}) error {
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return fmt.Errorf("encode request: %w", err)
+		}
+	}
+
+	endpoint := c.baseURL + path
+	if len(query) > 0 {
+		endpoint += "?" + query.Encode()
+	}
+
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, method, endpoint, bytes.NewReader(payload))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Accept", "application/json")
+		if body != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		if c.apiKey != "" {
+			req.Header.Set("X-API-Key", c.apiKey)
+		}
+
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			if ctx.Err() != nil || attempt >= c.maxRetries || method == http.MethodPost {
+				return err
+			}
+			if err := c.wait(ctx, attempt, ""); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if c.shouldRetry(method, resp.StatusCode) && attempt < c.maxRetries {
+			retryAfter := resp.Header.Get("Retry-After")
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			if err := c.wait(ctx, attempt, retryAfter); err != nil {
+				return err
+			}
+			continue
+		}
+
+		return decodeResponse(resp, out)
+	}
+}
+
+// shouldRetry reports whether a response status is worth another attempt
+func (c *Client) shouldRetry(method string, status int) bool {
+	if status == http.StatusTooManyRequests {
+		return true
+	}
+	return status >= 500 && method != http.MethodPost
+}
+
+// wait sleeps before the next attempt, honouring Retry-After (in seconds)
+// when the server sent one, and returns early if ctx is cancelled.
+func (c *Client) wait(ctx context.Context, attempt int, retryAfter string) error {
+	delay := c.baseBackoff << attempt
+	if delay > c.maxBackoff || delay <= 0 {
+		delay = c.maxBackoff
+	}
+	delay = delay/2 + rand.N(delay/2+1) // jitter so clients don't retry in lockstep
+
+	if seconds, err := strconv.Atoi(retryAfter); err == nil && seconds >= 0 {
+		delay = time.Duration(seconds) * time.Second
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// decodeResponse closes resp and fills out, or returns an *APIError
+func decodeResponse(resp *http.Response, out interface{}) error {
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("read response: %w", err)
+	}
+
+	if resp.StatusCode >= 400 {
+		apiErr := &APIError{StatusCode: resp.StatusCode}
+		if err := json.Unmarshal(data, apiErr); err != nil || apiErr.Message == "" {
+			apiErr.Message = strings.TrimSpace(string(data))
+			if apiErr.Message == "" {
+				apiErr.Message = http.StatusText(resp.StatusCode)
+			}
+		}
+		if apiErr.RequestID == "" {
+			apiErr.RequestID = resp.Header.Get("X-Request-ID")
+		}
+		return apiErr
+	}
+
+	if out == nil {
+		return nil
+	}
+
+	var env envelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		return fmt.Errorf("decode response: %w", err)
+	}
+	if err := json.Unmarshal(env.Data, out); err != nil {
+		return fmt.Errorf("decode response data: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,280 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRetriesServerErrorsForIdempotentRequests(t *testing.T) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"success":true,"data":{"id":1,"email":"me@example.com"}}`))
+	}))
+	defer server.Close()
+
+	c := New(server.URL, WithAPIKey("key"), WithRetries(3, time.Millisecond))
+	user, err := c.Me(context.Background())
+	if err != nil {
+		t.Fatalf("Me: %v", err)
+	}
+	if attempts != 3 || user.Email != "me@example.com" {
+		t.Errorf("attempts = %d, user = %+v", attempts, user)
+	}
+}
+
+func TestDoesNotRetryServerErrorsForPost(t *testing.T) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"success":false,"error":"Internal server error","code":"INTERNAL_ERROR","request_id":"abc"}`))
+	}))
+	defer server.Close()
+
+	c := New(server.URL, WithRetries(3, time.Millisecond))
+	_, err := c.CreateAlbum(context.Background(), AlbumInput{Title: "Jeru"})
+	if !IsCode(err, CodeInternal) {
+		t.Fatalf("CreateAlbum: got %v, want INTERNAL_ERROR", err)
+	}
+	if attempts != 1 {
+		t.Errorf("POST was sent %d times, want 1", attempts)
+	}
+	if err.(*APIError).RequestID != "abc" {
+		t.Errorf("request ID not decoded: %+v", err)
+	}
+}
+
+func TestRetriesTooManyRequestsForPost(t *testing.T) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts == 1 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"success":true,"data":{"id":7,"title":"Jeru"}}`))
+	}))
+	defer server.Close()
+
+	c := New(server.URL, WithRetries(1, time.Millisecond))
+	album, err := c.CreateAlbum(context.Background(), AlbumInput{Title: "Jeru"})
+	if err != nil {
+		t.Fatalf("CreateAlbum: %v", err)
+	}
+	if attempts != 2 || album.ID != 7 {
+		t.Errorf("attempts = %d, album = %+v", attempts, album)
+	}
+}
+
+func TestWaitStopsWhenContextIsCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	c := New("http://unused", WithRetries(1, time.Hour))
+	if err := c.wait(ctx, 0, ""); err != context.Canceled {
+		t.Errorf("wait = %v, want context.Canceled", err)
+	}
+}
+
+// Each call sends the method, path, query, headers and body the server
+// expects, and decodes what comes back
+func TestRequests(t *testing.T) {
+	tests := []struct {
+		name      string
+		call      func(c *Client) (interface{}, error)
+		wantReq   string // method, path and query
+		wantBody  string
+		status    int
+		response  string
+		wantValue string // part of the result printed with %+v
+	}{
+		{
+			name: "list page",
+			call: func(c *Client) (interface{}, error) {
+				return c.ListAlbums(context.Background(), &ListOptions{Limit: 2, Offset: 4})
+			},
+			wantReq:   "GET /albums?limit=2&offset=4",
+			status:    http.StatusOK,
+			response:  `{"success":true,"data":[{"id":5,"title":"Jeru"}]}`,
+			wantValue: "Jeru",
+		},
+		{
+			name: "create",
+			call: func(c *Client) (interface{}, error) {
+				return c.CreateAlbum(context.Background(), AlbumInput{Title: "Jeru", Artist: "Gerry Mulligan", Price: 17.99})
+			},
+			wantReq:   "POST /albums",
+			wantBody:  `{"title":"Jeru","artist":"Gerry Mulligan","price":17.99}`,
+			status:    http.StatusCreated,
+			response:  `{"success":true,"data":{"id":7,"title":"Jeru"}}`,
+			wantValue: "ID:7",
+		},
+		{
+			name: "update",
+			call: func(c *Client) (interface{}, error) {
+				return c.UpdateAlbum(context.Background(), 7, AlbumInput{Title: "Jeru", Artist: "Gerry Mulligan", Price: 9})
+			},
+			wantReq:   "PUT /albums/7",
+			wantBody:  `{"title":"Jeru","artist":"Gerry Mulligan","price":9}`,
+			status:    http.StatusOK,
+			response:  `{"success":true,"data":{"id":7,"price":9}}`,
+			wantValue: "Price:9",
+		},
+		{
+			name:     "delete",
+			call:     func(c *Client) (interface{}, error) { return nil, c.DeleteAlbum(context.Background(), 7) },
+			wantReq:  "DELETE /albums/7",
+			status:   http.StatusOK,
+			response: `{"success":true,"message":"Album deleted successfully"}`,
+		},
+		{
+			name:      "login",
+			call:      func(c *Client) (interface{}, error) { return c.Login(context.Background(), "me@example.com", "secret") },
+			wantReq:   "POST /login",
+			wantBody:  `{"email":"me@example.com","password":"secret"}`,
+			status:    http.StatusOK,
+			response:  `{"success":true,"data":{"id":1,"api_key":"new-key"}}`,
+			wantValue: "APIKey:new-key",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				if got := r.Method + " " + r.URL.RequestURI(); got != tt.wantReq {
+					t.Errorf("request = %s, want %s", got, tt.wantReq)
+				}
+				if string(body) != tt.wantBody {
+					t.Errorf("body = %s, want %s", body, tt.wantBody)
+				}
+				if r.Header.Get("X-API-Key") != "key" || r.Header.Get("Accept") != "application/json" {
+					t.Errorf("headers = %v, want the API key and Accept: application/json", r.Header)
+				}
+				if len(body) > 0 && r.Header.Get("Content-Type") != "application/json" {
+					t.Errorf("Content-Type = %q, want application/json", r.Header.Get("Content-Type"))
+				}
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.response))
+			}))
+			defer server.Close()
+
+			value, err := tt.call(New(server.URL, WithAPIKey("key")))
+			if err != nil {
+				t.Fatalf("call: %v", err)
+			}
+			if got := fmt.Sprintf("%+v", value); !strings.Contains(got, tt.wantValue) {
+				t.Errorf("result = %s, want it to contain %s", got, tt.wantValue)
+			}
+		})
+	}
+}
+
+func TestDecodesErrors(t *testing.T) {
+	tests := []struct {
+		name        string
+		status      int
+		contentType string
+		body        string
+		want        APIError
+	}{
+		{
+			name:   "validation envelope",
+			status: http.StatusBadRequest,
+			body:   `{"success":false,"error":"Validation failed","code":"VALIDATION_FAILED","request_id":"abc","details":[{"location":"body","field":"price","message":"must be >= 0"}]}`,
+			want: APIError{StatusCode: http.StatusBadRequest, Message: "Validation failed", Code: CodeValidationFailed, RequestID: "abc",
+				Details: []ValidationIssue{{Location: "body", Field: "price", Message: "must be >= 0"}}},
+		},
+		{
+			name:   "not found",
+			status: http.StatusNotFound,
+			body:   `{"success":false,"error":"Album not found","code":"ALBUM_NOT_FOUND"}`,
+			want:   APIError{StatusCode: http.StatusNotFound, Message: "Album not found", Code: CodeAlbumNotFound, RequestID: "from-header"},
+		},
+		{
+			name:        "plain text from a proxy",
+			status:      http.StatusBadGateway,
+			contentType: "text/plain",
+			body:        "upstream unavailable\n",
+			want:        APIError{StatusCode: http.StatusBadGateway, Message: "upstream unavailable", RequestID: "from-header"},
+		},
+		{
+			name:   "empty body",
+			status: http.StatusForbidden,
+			want:   APIError{StatusCode: http.StatusForbidden, Message: "Forbidden", RequestID: "from-header"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("X-Request-ID", "from-header")
+				if tt.contentType != "" {
+					w.Header().Set("Content-Type", tt.contentType)
+				}
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			defer server.Close()
+
+			_, err := New(server.URL, WithRetries(0, time.Millisecond)).GetAlbum(context.Background(), 1)
+			var apiErr *APIError
+			if !errors.As(err, &apiErr) {
+				t.Fatalf("GetAlbum: got %v, want an *APIError", err)
+			}
+			if fmt.Sprintf("%+v", *apiErr) != fmt.Sprintf("%+v", tt.want) {
+				t.Errorf("error = %+v, want %+v", *apiErr, tt.want)
+			}
+		})
+	}
+}
+
+// Pages are requested until one comes back short, and breaking out of the
+// loop stops fetching
+func TestPaginate(t *testing.T) {
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.URL.RawQuery)
+		switch r.URL.Query().Get("offset") {
+		case "0":
+			w.Write([]byte(`{"success":true,"data":[{"id":1},{"id":2}]}`))
+		case "2":
+			w.Write([]byte(`{"success":true,"data":[{"id":3}]}`))
+		default:
+			t.Errorf("unexpected page %s", r.URL.RawQuery)
+		}
+	}))
+	defer server.Close()
+	c := New(server.URL)
+
+	var ids []uint
+	for album, err := range c.AllAlbums(context.Background(), 2) {
+		if err != nil {
+			t.Fatalf("AllAlbums: %v", err)
+		}
+		ids = append(ids, album.ID)
+	}
+	if fmt.Sprint(ids) != "[1 2 3]" || len(requests) != 2 {
+		t.Errorf("ids = %v after requests %v, want [1 2 3] from two pages", ids, requests)
+	}
+
+	requests = nil
+	for range c.AllAlbums(context.Background(), 2) {
+		break
+	}
+	if len(requests) != 1 {
+		t.Errorf("breaking after the first album sent %v, want one request", requests)
+	}
+}
@@ -0,0 +1,100 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrorCode mirrors the machine-readable codes the server sends
+type ErrorCode string
+
+const (
+	CodeValidationFailed     ErrorCode = "VALIDATION_FAILED"
+	CodeQueryTooComplex      ErrorCode = "QUERY_TOO_COMPLEX"
+	CodeAPIKeyRequired       ErrorCode = "API_KEY_REQUIRED"
+	CodeAPIKeyInvalid        ErrorCode = "API_KEY_INVALID"
+	CodeInvalidCredentials   ErrorCode = "INVALID_CREDENTIALS"
+	CodeWrongPassword        ErrorCode = "WRONG_PASSWORD"
+	CodeTokenInvalid         ErrorCode = "TOKEN_INVALID"
+	CodeOTPRequired          ErrorCode = "OTP_REQUIRED"
+	CodeOTPInvalid           ErrorCode = "OTP_INVALID"
+	CodeOTPLocked            ErrorCode = "OTP_LOCKED"
+	CodeTwoFactorEnabled     ErrorCode = "TWO_FACTOR_ALREADY_ENABLED"
+	CodeTwoFactorNotEnabled  ErrorCode = "TWO_FACTOR_NOT_ENABLED"
+	CodeUserNotFound         ErrorCode = "USER_NOT_FOUND"
+	CodeForbiddenNotOwner    ErrorCode = "FORBIDDEN_NOT_OWNER"
+	CodeAlbumReadOnly        ErrorCode = "ALBUM_READ_ONLY"
+	CodeAdminRequired        ErrorCode = "ADMIN_REQUIRED"
+	CodeAccountNotFound      ErrorCode = "ACCOUNT_NOT_FOUND"
+	CodeEmailNotVerified     ErrorCode = "EMAIL_NOT_VERIFIED"
+	CodeAlbumNotFound        ErrorCode = "ALBUM_NOT_FOUND"
+	CodeWebhookNotFound      ErrorCode = "WEBHOOK_NOT_FOUND"
+	CodeDeliveryNotFound     ErrorCode = "DELIVERY_NOT_FOUND"
+	CodeCollaboratorNotFound ErrorCode = "COLLABORATOR_NOT_FOUND"
+	CodeTransferNotFound     ErrorCode = "TRANSFER_NOT_FOUND"
+	CodeTransferPending      ErrorCode = "TRANSFER_PENDING"
+	CodeTransferNotPending   ErrorCode = "TRANSFER_NOT_PENDING"
+	CodeAlbumHasOwner        ErrorCode = "ALBUM_HAS_OWNER"
+	CodeClaimNotFound        ErrorCode = "CLAIM_NOT_FOUND"
+	CodeClaimPending         ErrorCode = "CLAIM_PENDING"
+	CodeClaimNotPending      ErrorCode = "CLAIM_NOT_PENDING"
+	CodeOrganizationNotFound ErrorCode = "ORGANIZATION_NOT_FOUND"
+	CodeNotMember            ErrorCode = "NOT_A_MEMBER"
+	CodeOrganizationReadOnly ErrorCode = "ORGANIZATION_READ_ONLY"
+	CodeOwnerRequired        ErrorCode = "ORGANIZATION_OWNER_REQUIRED"
+	CodeMemberNotFound       ErrorCode = "MEMBER_NOT_FOUND"
+	CodeLastOwner            ErrorCode = "LAST_OWNER"
+	CodeExportNotFound       ErrorCode = "EXPORT_NOT_FOUND"
+	CodeExportNotReady       ErrorCode = "EXPORT_NOT_READY"
+	CodeDownloadLinkInvalid  ErrorCode = "DOWNLOAD_LINK_INVALID"
+	CodeDuplicateResource    ErrorCode = "DUPLICATE_RESOURCE"
+	CodeInvalidReference     ErrorCode = "INVALID_REFERENCE"
+	CodeNotAcceptable        ErrorCode = "NOT_ACCEPTABLE"
+	CodeUnsupportedMediaType ErrorCode = "UNSUPPORTED_MEDIA_TYPE"
+	CodeInternal             ErrorCode = "INTERNAL_ERROR"
+)
+
+// ValidationIssue is one invalid part of a rejected request
+type ValidationIssue struct {
+	Location string `json:"location"`
+	Field    string `json:"field,omitempty"`
+	Message  string `json:"message"`
+}
+
+// APIError is the server's ErrorResponse plus the HTTP status it came with
+type APIError struct {
+	StatusCode int               `json:"-"`
+	Message    string            `json:"error"`
+	Code       ErrorCode         `json:"code"`
+	RequestID  string            `json:"request_id,omitempty"`
+	Details    []ValidationIssue `json:"details,omitempty"`
+}
+
+func (e *APIError) Error() string {
+	msg := fmt.Sprintf("albums api: %d %s: %s", e.StatusCode, e.Code, e.Message)
+	if e.RequestID != "" {
+		msg += " (request " + e.RequestID + ")"
+	}
+	return msg
+}
+
+// IsCode reports whether err is an *APIError with the given code
+func IsCode(err error, code ErrorCode) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.Code == code
+}
+
+// IsNotFound reports whether err means the album doesn't exist
+func IsNotFound(err error) bool {
+	return IsCode(err, CodeAlbumNotFound)
+}
+
+// IsForbidden reports whether err means the caller doesn't own the album
+func IsForbidden(err error) bool {
+	return IsCode(err, CodeForbiddenNotOwner)
+}
+
+// IsUnauthorized reports whether err means the API key is missing or wrong
+func IsUnauthorized(err error) bool {
+	return IsCode(err, CodeAPIKeyRequired) || IsCode(err, CodeAPIKeyInvalid)
+}
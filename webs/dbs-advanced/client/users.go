@@ -0,0 +1,49 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// User is an album owner as returned by the server
+type User struct {
+	ID        uint      `json:"id"`
+	FirstName string    `json:"first_name"`
+	LastName  string    `json:"last_name"`
+	Email     string    `json:"email"`   // blank on album owners other than the caller
+	APIKey    string    `json:"api_key"` // only set by Login and RotateAPIKey
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Me returns the user the API key belongs to (GET /me)
+func (c *Client) Me(ctx context.Context) (*User, error) {
+	var user User
+	if err := c.do(ctx, http.MethodGet, "/me", nil, nil, &user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// Login exchanges email and password for the user, whose APIKey can then be
+// passed to WithAPIKey (POST /login)
+func (c *Client) Login(ctx context.Context, email, password string) (*User, error) {
+	var user User
+	body := map[string]string{"email": email, "password": password}
+	if err := c.do(ctx, http.MethodPost, "/login", nil, body, &user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// RotateAPIKey replaces the caller's API key and returns the user with the
+// new one (POST /keys/rotate). This client keeps using the old, now invalid
+// key, so create a new client with the returned key.
+func (c *Client) RotateAPIKey(ctx context.Context) (*User, error) {
+	var user User
+	if err := c.do(ctx, http.MethodPost, "/keys/rotate", nil, nil, &user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
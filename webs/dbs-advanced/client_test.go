@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"example.com/dbs/client"
+)
+
+// newTestClient serves the real router and returns a client authenticated as user
+func newTestClient(t *testing.T, apiKey string) *client.Client {
+	t.Helper()
+
+	server := httptest.NewServer(setupRouter(NewServices(DB)))
+	t.Cleanup(server.Close)
+
+	return client.New(server.URL, client.WithAPIKey(apiKey), client.WithRetries(0, 0))
+}
+
+func TestClientAlbumLifecycle(t *testing.T) {
+	setupTestDB(t)
+	ctx := context.Background()
+	user := createTestUser(t, "owner@example.com")
+	c := newTestClient(t, user.APIKey)
+
+	me, err := c.Me(ctx)
+	if err != nil {
+		t.Fatalf("Me: %v", err)
+	}
+	if me.Email != user.Email {
+		t.Errorf("Me returned %q, want %q", me.Email, user.Email)
+	}
+
+	created, err := c.CreateAlbum(ctx, client.AlbumInput{Title: "Blue Train", Artist: "John Coltrane", Price: 56.99})
+	if err != nil {
+		t.Fatalf("CreateAlbum: %v", err)
+	}
+	if created.UserID == nil || *created.UserID != user.ID {
+		t.Errorf("created album owner = %v, want %d", created.UserID, user.ID)
+	}
+
+	updated, err := c.UpdateAlbum(ctx, created.ID, client.AlbumInput{Title: "Blue Train", Artist: "John Coltrane", Price: 19.99})
+	if err != nil {
+		t.Fatalf("UpdateAlbum: %v", err)
+	}
+	if updated.Price != 19.99 {
+		t.Errorf("updated price = %v, want 19.99", updated.Price)
+	}
+
+	got, err := c.GetAlbum(ctx, created.ID)
+	if err != nil {
+		t.Fatalf("GetAlbum: %v", err)
+	}
+	if got.Title != "Blue Train" {
+		t.Errorf("GetAlbum title = %q", got.Title)
+	}
+
+	if err := c.DeleteAlbum(ctx, created.ID); err != nil {
+		t.Fatalf("DeleteAlbum: %v", err)
+	}
+	if _, err := c.GetAlbum(ctx, created.ID); !client.IsNotFound(err) {
+		t.Errorf("GetAlbum after delete: got %v, want ALBUM_NOT_FOUND", err)
+	}
+}
+
+func TestClientErrors(t *testing.T) {
+	setupTestDB(t)
+	ctx := context.Background()
+	owner := createTestUser(t, "owner@example.com")
+	other := createTestUser(t, "other@example.com")
+	album := createTestAlbum(t, "Jeru", &owner.ID)
+
+	if _, err := newTestClient(t, "not-a-key").Me(ctx); !client.IsUnauthorized(err) {
+		t.Errorf("Me with bad key: got %v, want unauthorized", err)
+	}
+
+	_, err := newTestClient(t, other.APIKey).GetAlbum(ctx, album.ID)
+	if !client.IsForbidden(err) {
+		t.Fatalf("GetAlbum as non-owner: got %v, want FORBIDDEN_NOT_OWNER", err)
+	}
+	if apiErr := err.(*client.APIError); apiErr.StatusCode != 403 || apiErr.RequestID == "" {
+		t.Errorf("APIError = %+v, want status 403 and a request ID", apiErr)
+	}
+}
+
+func TestClientPagination(t *testing.T) {
+	setupTestDB(t)
+	ctx := context.Background()
+	user := createTestUser(t, "owner@example.com")
+	for _, title := range []string{"One", "Two", "Three", "Four", "Five"} {
+		createTestAlbum(t, title, &user.ID)
+	}
+	c := newTestClient(t, user.APIKey)
+
+	page, err := c.ListAlbums(ctx, &client.ListOptions{Limit: 2, Offset: 2})
+	if err != nil {
+		t.Fatalf("ListAlbums: %v", err)
+	}
+	if len(page) != 2 || page[0].Title != "Three" {
+		t.Errorf("second page = %+v, want Three and Four", page)
+	}
+
+	var titles []string
+	for album, err := range c.AllMyAlbums(ctx, 2) {
+		if err != nil {
+			t.Fatalf("AllMyAlbums: %v", err)
+		}
+		titles = append(titles, album.Title)
+	}
+	if len(titles) != 5 || titles[4] != "Five" {
+		t.Errorf("AllMyAlbums = %v, want all five in order", titles)
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Config is what `albumctl login` saves for later commands
+type Config struct {
+	Server string `json:"server"`
+	Email  string `json:"email"`
+	APIKey string `json:"api_key"`
+}
+
+// configPath is $ALBUMCTL_CONFIG, or albumctl/config.json in the user config dir
+func configPath() (string, error) {
+	if path := os.Getenv("ALBUMCTL_CONFIG"); path != "" {
+		return path, nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "albumctl", "config.json"), nil
+}
+
+// loadConfig reads the saved config, or fails with a hint to log in first
+func loadConfig() (Config, error) {
+	var cfg Config
+
+	path, err := configPath()
+	if err != nil {
+		return cfg, err
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return cfg, fmt.Errorf("not logged in, run `albumctl login` first")
+	}
+	if err != nil {
+		return cfg, err
+	}
+
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("read %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// saveConfig writes the config readable by the current user only, since it holds the API key
+func saveConfig(cfg Config) (string, error) {
+	path, err := configPath()
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return "", err
+	}
+
+	data, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return path, os.WriteFile(path, data, 0o600)
+}
@@ -0,0 +1,484 @@
+// Command albumctl manages albums on a dbs-advanced server from the shell.
+//
+//	albumctl login -server http://localhost:8080 -email me@example.com
+//	albumctl albums list -mine -o json
+//	albumctl albums update 3 -price 12.50
+//	albumctl export -o yaml -f albums.yaml
+//	albumctl import albums.yaml
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"example.com/dbs/client"
+	"github.com/goccy/go-yaml"
+	"golang.org/x/term"
+)
+
+const usage = `Usage: albumctl <command> [flags]
+
+Commands:
+  login                       save an API key for later commands (prompts for the password)
+  albums list [-mine]         list public albums, or only yours
+  albums get ID               show one of your albums
+  albums create -title -artist -price
+  albums update ID [-title] [-artist] [-price]
+  albums delete ID
+  import FILE                 create albums from a JSON or YAML file
+  export [-all] [-f FILE]     write your albums (or all with -all) as JSON or YAML
+  keys rotate                 replace your API key and save the new one
+
+Most commands take -o table|json|yaml. Credentials are stored in
+$ALBUMCTL_CONFIG or the user config dir (albumctl/config.json).
+`
+
+// errUsage means the arguments were wrong and usage has been printed
+var errUsage = errors.New("invalid arguments")
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if err := run(ctx, os.Args[1:]); err != nil {
+		if !errors.Is(err, errUsage) {
+			fmt.Fprintln(os.Stderr, "albumctl:", err)
+		}
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, usage)
+		return errUsage
+	}
+
+	switch args[0] {
+	case "login":
+		return cmdLogin(ctx, args[1:])
+	case "albums":
+		if len(args) < 2 {
+			fmt.Fprint(os.Stderr, usage)
+			return errUsage
+		}
+		switch args[1] {
+		case "list":
+			return cmdAlbumsList(ctx, args[2:])
+		case "get":
+			return cmdAlbumsGet(ctx, args[2:])
+		case "create":
+			return cmdAlbumsCreate(ctx, args[2:])
+		case "update":
+			return cmdAlbumsUpdate(ctx, args[2:])
+		case "delete":
+			return cmdAlbumsDelete(ctx, args[2:])
+		}
+	case "import":
+		return cmdImport(ctx, args[1:])
+	case "export":
+		return cmdExport(ctx, args[1:])
+	case "keys":
+		if len(args) >= 2 && args[1] == "rotate" {
+			return cmdKeysRotate(ctx, args[2:])
+		}
+	case "help", "-h", "-help", "--help":
+		fmt.Print(usage)
+		return nil
+	}
+
+	fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", strings.Join(args, " "), usage)
+	return errUsage
+}
+
+// newClient builds an authenticated client from the saved config
+func newClient() (*client.Client, Config, error) {
+	cfg, err := loadConfig()
+	if err != nil {
+		return nil, cfg, err
+	}
+	return client.New(cfg.Server, client.WithAPIKey(cfg.APIKey)), cfg, nil
+}
+
+// parseFlags parses flags that may come before or after positional
+// arguments (`albums get 3 -o json`) and checks the positional count.
+func parseFlags(fs *flag.FlagSet, args []string, positional ...string) ([]string, error) {
+	var values []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, errUsage
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			break
+		}
+		values = append(values, args[0])
+		args = args[1:]
+	}
+
+	if len(values) != len(positional) {
+		fmt.Fprintf(os.Stderr, "usage: albumctl %s %s [flags]\n", fs.Name(), strings.Join(positional, " "))
+		fs.PrintDefaults()
+		return nil, errUsage
+	}
+	return values, nil
+}
+
+func outputFlag(fs *flag.FlagSet, def string) *string {
+	return fs.String("o", def, "output format: table, json or yaml")
+}
+
+func parseID(value string) (uint, error) {
+	id, err := strconv.ParseUint(value, 10, 0)
+	if err != nil || id == 0 {
+		return 0, fmt.Errorf("invalid album ID %q", value)
+	}
+	return uint(id), nil
+}
+
+func cmdLogin(ctx context.Context, args []string) error {
+	saved, _ := loadConfig()
+	if saved.Server == "" {
+		saved.Server = "http://localhost:8080"
+	}
+
+	fs := flag.NewFlagSet("login", flag.ContinueOnError)
+	server := fs.String("server", saved.Server, "server base URL")
+	email := fs.String("email", saved.Email, "account email")
+	if _, err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	stdin := bufio.NewReader(os.Stdin)
+	if *email == "" {
+		fmt.Fprint(os.Stderr, "Email: ")
+		line, err := stdin.ReadString('\n')
+		if err != nil {
+			return fmt.Errorf("read email: %w", err)
+		}
+		*email = strings.TrimSpace(line)
+	}
+
+	password, err := readPassword(stdin)
+	if err != nil {
+		return err
+	}
+
+	user, err := client.New(*server).Login(ctx, *email, password)
+	if err != nil {
+		return err
+	}
+
+	path, err := saveConfig(Config{Server: *server, Email: user.Email, APIKey: user.APIKey})
+	if err != nil {
+		return fmt.Errorf("save credentials: %w", err)
+	}
+
+	fmt.Printf("Logged in as %s %s <%s>, credentials saved to %s\n", user.FirstName, user.LastName, user.Email, path)
+	return nil
+}
+
+// readPassword prompts without echo on a terminal and reads a plain line
+// otherwise, so `echo "$PASSWORD" | albumctl login` works in scripts.
+func readPassword(stdin *bufio.Reader) (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		line, err := stdin.ReadString('\n')
+		if err != nil && line == "" {
+			return "", fmt.Errorf("read password: %w", err)
+		}
+		return strings.TrimRight(line, "\r\n"), nil
+	}
+
+	fmt.Fprint(os.Stderr, "Password: ")
+	password, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", fmt.Errorf("read password: %w", err)
+	}
+	return string(password), nil
+}
+
+func cmdAlbumsList(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("albums list", flag.ContinueOnError)
+	mine := fs.Bool("mine", false, "only albums you own")
+	output := outputFlag(fs, "table")
+	if _, err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	c, _, err := newClient()
+	if err != nil {
+		return err
+	}
+
+	albums, err := collectAlbums(ctx, c, *mine)
+	if err != nil {
+		return err
+	}
+	return printAlbums(*output, albums)
+}
+
+// collectAlbums pages through all public albums, or the caller's
+func collectAlbums(ctx context.Context, c *client.Client, mine bool) ([]client.Album, error) {
+	all := c.AllAlbums
+	if mine {
+		all = c.AllMyAlbums
+	}
+
+	var albums []client.Album
+	for album, err := range all(ctx, 0) {
+		if err != nil {
+			return nil, err
+		}
+		albums = append(albums, album)
+	}
+	return albums, nil
+}
+
+func cmdAlbumsGet(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("albums get", flag.ContinueOnError)
+	output := outputFlag(fs, "table")
+	values, err := parseFlags(fs, args, "ID")
+	if err != nil {
+		return err
+	}
+	id, err := parseID(values[0])
+	if err != nil {
+		return err
+	}
+
+	c, _, err := newClient()
+	if err != nil {
+		return err
+	}
+
+	album, err := c.GetAlbum(ctx, id)
+	if err != nil {
+		return err
+	}
+	return printAlbum(*output, album)
+}
+
+func cmdAlbumsCreate(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("albums create", flag.ContinueOnError)
+	title := fs.String("title", "", "album title (required)")
+	artist := fs.String("artist", "", "artist (required)")
+	price := fs.Float64("price", 0, "price")
+	output := outputFlag(fs, "table")
+	if _, err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *title == "" || *artist == "" {
+		fmt.Fprintln(os.Stderr, "albums create needs -title and -artist")
+		return errUsage
+	}
+
+	c, _, err := newClient()
+	if err != nil {
+		return err
+	}
+
+	album, err := c.CreateAlbum(ctx, client.AlbumInput{Title: *title, Artist: *artist, Price: *price})
+	if err != nil {
+		return err
+	}
+	return printAlbum(*output, album)
+}
+
+func cmdAlbumsUpdate(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("albums update", flag.ContinueOnError)
+	title := fs.String("title", "", "new title")
+	artist := fs.String("artist", "", "new artist")
+	price := fs.Float64("price", 0, "new price")
+	output := outputFlag(fs, "table")
+	values, err := parseFlags(fs, args, "ID")
+	if err != nil {
+		return err
+	}
+	id, err := parseID(values[0])
+	if err != nil {
+		return err
+	}
+
+	c, _, err := newClient()
+	if err != nil {
+		return err
+	}
+
+	// PUT replaces every field, so start from the current album
+	album, err := c.GetAlbum(ctx, id)
+	if err != nil {
+		return err
+	}
+	input := client.AlbumInput{Title: album.Title, Artist: album.Artist, Price: album.Price}
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "title":
+			input.Title = *title
+		case "artist":
+			input.Artist = *artist
+		case "price":
+			input.Price = *price
+		}
+	})
+
+	album, err = c.UpdateAlbum(ctx, id, input)
+	if err != nil {
+		return err
+	}
+	return printAlbum(*output, album)
+}
+
+func cmdAlbumsDelete(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("albums delete", flag.ContinueOnError)
+	values, err := parseFlags(fs, args, "ID")
+	if err != nil {
+		return err
+	}
+	id, err := parseID(values[0])
+	if err != nil {
+		return err
+	}
+
+	c, _, err := newClient()
+	if err != nil {
+		return err
+	}
+
+	if err := c.DeleteAlbum(ctx, id); err != nil {
+		return err
+	}
+	fmt.Printf("Deleted album %d\n", id)
+	return nil
+}
+
+// cmdImport creates one album per entry of a JSON or YAML array. Extra fields
+// (id, user, timestamps) are ignored, so `export` output can be re-imported.
+func cmdImport(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	values, err := parseFlags(fs, args, "FILE")
+	if err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile(values[0])
+	if err != nil {
+		return err
+	}
+
+	var inputs []client.AlbumInput
+	switch strings.ToLower(filepath.Ext(values[0])) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &inputs)
+	default:
+		err = json.Unmarshal(data, &inputs)
+	}
+	if err != nil {
+		return fmt.Errorf("parse %s: %w", values[0], err)
+	}
+
+	c, _, err := newClient()
+	if err != nil {
+		return err
+	}
+
+	for i, input := range inputs {
+		album, err := c.CreateAlbum(ctx, input)
+		if err != nil {
+			return fmt.Errorf("album %d of %d (%q): %w (%d imported)", i+1, len(inputs), input.Title, err, i)
+		}
+		fmt.Printf("Created album %d: %s - %s\n", album.ID, album.Title, album.Artist)
+	}
+	fmt.Printf("Imported %d albums\n", len(inputs))
+	return nil
+}
+
+// exportedAlbum is one entry of `export` output, readable by `import`
+type exportedAlbum struct {
+	ID     uint    `json:"id"`
+	Title  string  `json:"title"`
+	Artist string  `json:"artist"`
+	Price  float64 `json:"price"`
+}
+
+func cmdExport(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	all := fs.Bool("all", false, "export every public album instead of only yours")
+	output := fs.String("o", "json", "output format: json or yaml")
+	file := fs.String("f", "", "write to this file instead of stdout")
+	if _, err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *output != "json" && *output != "yaml" {
+		return fmt.Errorf("export supports json or yaml, not %q", *output)
+	}
+
+	c, _, err := newClient()
+	if err != nil {
+		return err
+	}
+
+	albums, err := collectAlbums(ctx, c, !*all)
+	if err != nil {
+		return err
+	}
+
+	// Only catalogue fields: owners (and their API keys) stay on the server
+	records := make([]exportedAlbum, 0, len(albums))
+	for _, album := range albums {
+		records = append(records, exportedAlbum{ID: album.ID, Title: album.Title, Artist: album.Artist, Price: album.Price})
+	}
+
+	w := os.Stdout
+	if *file != "" {
+		f, err := os.Create(*file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	if err := printValue(w, *output, records, nil); err != nil {
+		return err
+	}
+	if *file != "" {
+		fmt.Fprintf(os.Stderr, "Exported %d albums to %s\n", len(records), *file)
+	}
+	return nil
+}
+
+func cmdKeysRotate(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("keys rotate", flag.ContinueOnError)
+	output := outputFlag(fs, "table")
+	if _, err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	c, cfg, err := newClient()
+	if err != nil {
+		return err
+	}
+
+	user, err := c.RotateAPIKey(ctx)
+	if err != nil {
+		return err
+	}
+
+	// The old key is already invalid, so save the new one before anything else can fail
+	cfg.APIKey = user.APIKey
+	if _, err := saveConfig(cfg); err != nil {
+		return fmt.Errorf("key rotated to %s but saving it failed: %w", user.APIKey, err)
+	}
+	return printUser(*output, user)
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"example.com/dbs/client"
+)
+
+// fakeServer is just enough of the album API for albumctl: one user whose
+// key is rotated by /keys/rotate, and their albums
+type fakeServer struct {
+	mu     sync.Mutex
+	apiKey string
+	albums []client.Album
+	puts   []string // bodies of PUT requests
+}
+
+func (s *fakeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	reply := func(status int, data interface{}) {
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "data": data})
+	}
+	if r.URL.Path != "/login" && r.Header.Get("X-API-Key") != s.apiKey {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"success":false,"error":"Invalid API key","code":"API_KEY_INVALID"}`))
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/login":
+		reply(http.StatusOK, client.User{ID: 1, Email: "me@example.com", APIKey: s.apiKey})
+	case r.Method == http.MethodPost && r.URL.Path == "/keys/rotate":
+		s.apiKey = "rotated-key"
+		reply(http.StatusOK, client.User{ID: 1, Email: "me@example.com", APIKey: s.apiKey})
+	case r.Method == http.MethodGet && (r.URL.Path == "/albums" || r.URL.Path == "/my-albums"):
+		offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		page := s.albums[min(offset, len(s.albums)):min(offset+limit, len(s.albums))]
+		reply(http.StatusOK, page)
+	case r.Method == http.MethodPost && r.URL.Path == "/albums":
+		var input client.AlbumInput
+		json.NewDecoder(r.Body).Decode(&input)
+		album := client.Album{ID: uint(len(s.albums) + 1), Title: input.Title, Artist: input.Artist, Price: input.Price}
+		s.albums = append(s.albums, album)
+		reply(http.StatusCreated, album)
+	case strings.HasPrefix(r.URL.Path, "/albums/"):
+		id, _ := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/albums/"))
+		if id < 1 || id > len(s.albums) {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"success":false,"error":"Album not found","code":"ALBUM_NOT_FOUND"}`))
+			return
+		}
+		album := &s.albums[id-1]
+		if r.Method == http.MethodPut {
+			var input client.AlbumInput
+			json.NewDecoder(r.Body).Decode(&input)
+			s.puts = append(s.puts, fmt.Sprintf("%+v", input))
+			album.Title, album.Artist, album.Price = input.Title, input.Artist, input.Price
+		}
+		reply(http.StatusOK, album)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// newFakeServer starts a fakeServer with two albums and logs albumctl in to it
+func newFakeServer(t *testing.T) (*fakeServer, string) {
+	t.Helper()
+	fake := &fakeServer{apiKey: "key", albums: []client.Album{
+		{ID: 1, Title: "Blue Train", Artist: "John Coltrane", Price: 56.99},
+		{ID: 2, Title: "Jeru", Artist: "Gerry Mulligan", Price: 17.99},
+	}}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	configFile := filepath.Join(t.TempDir(), "config.json")
+	t.Setenv("ALBUMCTL_CONFIG", configFile)
+	if _, err := saveConfig(Config{Server: server.URL, Email: "me@example.com", APIKey: "key"}); err != nil {
+		t.Fatalf("saveConfig: %v", err)
+	}
+	return fake, configFile
+}
+
+// runCommand runs albumctl with args and returns what it printed to stdout
+func runCommand(t *testing.T, args ...string) (string, error) {
+	t.Helper()
+	out, err := os.CreateTemp(t.TempDir(), "stdout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+
+	stdout := os.Stdout
+	os.Stdout = out
+	runErr := run(context.Background(), args)
+	os.Stdout = stdout
+
+	printed, err := os.ReadFile(out.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(printed), runErr
+}
+
+func TestCommands(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		want    []string // substrings of the output
+		wantErr string
+	}{
+		{"list as table", []string{"albums", "list"}, []string{"TITLE", "Blue Train", "17.99"}, ""},
+		{"list mine as json", []string{"albums", "list", "-mine", "-o", "json"}, []string{`"title": "Jeru"`}, ""},
+		{"get with flag after ID", []string{"albums", "get", "2", "-o", "yaml"}, []string{"title: Jeru"}, ""},
+		{"create", []string{"albums", "create", "-title", "Kind of Blue", "-artist", "Miles Davis", "-price", "9.5"}, []string{"ID", "3", "Kind of Blue"}, ""},
+		{"delete", []string{"albums", "delete", "1"}, []string{"Deleted album 1"}, ""},
+		{"get missing album", []string{"albums", "get", "9"}, nil, "ALBUM_NOT_FOUND"},
+		{"bad ID", []string{"albums", "get", "abc"}, nil, `invalid album ID "abc"`},
+		{"bad output format", []string{"albums", "list", "-o", "csv"}, nil, `unknown output format "csv"`},
+		{"create without artist", []string{"albums", "create", "-title", "Jeru"}, nil, errUsage.Error()},
+		{"missing ID", []string{"albums", "get"}, nil, errUsage.Error()},
+		{"unknown command", []string{"albums", "burn"}, nil, errUsage.Error()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newFakeServer(t)
+			out, err := runCommand(t, tt.args...)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("albumctl %v = %v, want an error containing %q", tt.args, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("albumctl %v: %v", tt.args, err)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(out, want) {
+					t.Errorf("albumctl %v printed %q, want %q in it", tt.args, out, want)
+				}
+			}
+		})
+	}
+}
+
+// update only changes the flags that were given, even to a zero value
+func TestUpdateKeepsUnsetFields(t *testing.T) {
+	fake, _ := newFakeServer(t)
+	if _, err := runCommand(t, "albums", "update", "2", "-price", "0"); err != nil {
+		t.Fatalf("albums update: %v", err)
+	}
+	want := "{Title:Jeru Artist:Gerry Mulligan Price:0}"
+	if len(fake.puts) != 1 || fake.puts[0] != want {
+		t.Errorf("PUT bodies = %v, want [%s]", fake.puts, want)
+	}
+}
+
+// export output can be imported again, and carries no owner details
+func TestExportImportRoundTrip(t *testing.T) {
+	for _, ext := range []string{"json", "yaml"} {
+		t.Run(ext, func(t *testing.T) {
+			fake, _ := newFakeServer(t)
+			file := filepath.Join(t.TempDir(), "albums."+ext)
+			if _, err := runCommand(t, "export", "-all", "-o", ext, "-f", file); err != nil {
+				t.Fatalf("export: %v", err)
+			}
+			data, _ := os.ReadFile(file)
+			if !strings.Contains(string(data), "Blue Train") || strings.Contains(string(data), "user") {
+				t.Errorf("export wrote %s, want the albums and no owners", data)
+			}
+
+			out, err := runCommand(t, "import", file)
+			if err != nil {
+				t.Fatalf("import: %v", err)
+			}
+			if !strings.Contains(out, "Imported 2 albums") || len(fake.albums) != 4 || fake.albums[3].Title != "Jeru" {
+				t.Errorf("import printed %q and left %+v", out, fake.albums)
+			}
+		})
+	}
+}
+
+func TestKeysRotateSavesNewKey(t *testing.T) {
+	newFakeServer(t)
+	if _, err := runCommand(t, "keys", "rotate"); err != nil {
+		t.Fatalf("keys rotate: %v", err)
+	}
+	cfg, err := loadConfig()
+	if err != nil || cfg.APIKey != "rotated-key" {
+		t.Fatalf("config after rotate = %+v, %v; want the new key", cfg, err)
+	}
+	if _, err := runCommand(t, "albums", "list", "-mine"); err != nil {
+		t.Errorf("list with the rotated key: %v", err)
+	}
+}
+
+func TestLoginSavesCredentials(t *testing.T) {
+	_, configFile := newFakeServer(t)
+	cfg, _ := loadConfig()
+	os.Remove(configFile)
+
+	// A pipe is not a terminal, so the password is read as a plain line
+	stdin := os.Stdin
+	r, w, _ := os.Pipe()
+	w.WriteString("secret\n")
+	w.Close()
+	os.Stdin = r
+	t.Cleanup(func() { os.Stdin = stdin })
+
+	if _, err := runCommand(t, "login", "-server", cfg.Server, "-email", "me@example.com"); err != nil {
+		t.Fatalf("login: %v", err)
+	}
+	saved, err := loadConfig()
+	if err != nil || saved != cfg {
+		t.Errorf("saved config = %+v, %v; want %+v", saved, err, cfg)
+	}
+	if info, err := os.Stat(configFile); err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("config file mode = %v, %v; want 0600", info.Mode().Perm(), err)
+	}
+}
+
+func TestLoadConfigBeforeLogin(t *testing.T) {
+	t.Setenv("ALBUMCTL_CONFIG", filepath.Join(t.TempDir(), "missing.json"))
+	if _, err := loadConfig(); err == nil || !strings.Contains(err.Error(), "albumctl login") {
+		t.Errorf("loadConfig = %v, want a hint to log in", err)
+	}
+}
+
+func TestParseFlags(t *testing.T) {
+	tests := []struct {
+		args       []string
+		positional []string
+		want       string // values and the -o flag, or "usage"
+	}{
+		{[]string{"3", "-o", "json"}, []string{"ID"}, "[3] json"},
+		{[]string{"-o", "json", "3"}, []string{"ID"}, "[3] json"},
+		{[]string{"3"}, []string{"ID"}, "[3] table"},
+		{nil, []string{"ID"}, "usage"},
+		{[]string{"3", "4"}, []string{"ID"}, "usage"},
+		{[]string{"-x"}, nil, "usage"},
+	}
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.args, " "), func(t *testing.T) {
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+			fs.SetOutput(new(strings.Builder))
+			output := outputFlag(fs, "table")
+			values, err := parseFlags(fs, tt.args, tt.positional...)
+			got := fmt.Sprintf("%v %s", values, *output)
+			if errors.Is(err, errUsage) {
+				got = "usage"
+			}
+			if got != tt.want {
+				t.Errorf("parseFlags(%v) = %s, want %s", tt.args, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"example.com/dbs/client"
+	"github.com/goccy/go-yaml"
+)
+
+// printValue writes v as JSON or YAML, or calls table for the table format
+func printValue(w io.Writer, format string, v interface{}, table func(*tabwriter.Writer)) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	case "yaml":
+		data, err := yaml.Marshal(v)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(data)
+		return err
+	case "table":
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		table(tw)
+		return tw.Flush()
+	default:
+		return fmt.Errorf("unknown output format %q, use table, json or yaml", format)
+	}
+}
+
+func printAlbums(format string, albums []client.Album) error {
+	if albums == nil {
+		albums = []client.Album{} // [] rather than null in JSON
+	}
+	return printValue(os.Stdout, format, albums, func(tw *tabwriter.Writer) {
+		fmt.Fprintln(tw, "ID\tTITLE\tARTIST\tPRICE\tOWNER")
+		for _, album := range albums {
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%.2f\t%s\n", album.ID, album.Title, album.Artist, album.Price, owner(album))
+		}
+	})
+}
+
+func printAlbum(format string, album *client.Album) error {
+	return printValue(os.Stdout, format, album, func(tw *tabwriter.Writer) {
+		fmt.Fprintf(tw, "ID\t%d\n", album.ID)
+		fmt.Fprintf(tw, "Title\t%s\n", album.Title)
+		fmt.Fprintf(tw, "Artist\t%s\n", album.Artist)
+		fmt.Fprintf(tw, "Price\t%.2f\n", album.Price)
+		fmt.Fprintf(tw, "Owner\t%s\n", owner(*album))
+		fmt.Fprintf(tw, "Updated\t%s\n", album.UpdatedAt.Format("2006-01-02 15:04"))
+	})
+}
+
+func printUser(format string, user *client.User) error {
+	return printValue(os.Stdout, format, user, func(tw *tabwriter.Writer) {
+		fmt.Fprintf(tw, "ID\t%d\n", user.ID)
+		fmt.Fprintf(tw, "Name\t%s %s\n", user.FirstName, user.LastName)
+		fmt.Fprintf(tw, "Email\t%s\n", user.Email)
+		fmt.Fprintf(tw, "API key\t%s\n", user.APIKey)
+	})
+}
+
+func owner(album client.Album) string {
+	switch {
+	case album.User != nil:
+		return album.User.Email
+	case album.UserID != nil:
+		return strconv.FormatUint(uint64(*album.UserID), 10)
+	default:
+		return "-"
+	}
+}
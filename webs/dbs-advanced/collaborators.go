@@ -0,0 +1,172 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// CollaboratorHandlers serve the routes sharing albums with other users
+type CollaboratorHandlers struct {
+	db     *gorm.DB
+	albums *AlbumService
+}
+
+// NewCollaboratorHandlers keeps collaborators in db, checking who may see
+// an album with albums
+func NewCollaboratorHandlers(db *gorm.DB, albums *AlbumService) *CollaboratorHandlers {
+	return &CollaboratorHandlers{db: db, albums: albums}
+}
+
+// GET /albums/:id/collaborators - Protected: who else may read or edit the
+// album. Its owner and its collaborators can see the list; only the owner
+// sees their email addresses, as with hideOwnerEmail.
+func (h *CollaboratorHandlers) getCollaborators(c *gin.Context) {
+	userID, _ := GetCurrentUserID(c)
+	id, ok := albumIDParam(c)
+	if !ok {
+		return
+	}
+
+	_, role, err := h.albums.getAs(CurrentTenant(c), userID, id)
+	if err != nil {
+		RespondDBError(c, err, CodeAlbumNotFound)
+		return
+	}
+
+	var collaborators []AlbumCollaborator
+	err = h.db.Where("album_id = ?", id).Order("id").Find(&collaborators).Error
+	if err == nil && role == RoleOwner {
+		err = fillCollaboratorEmails(h.db, collaborators)
+	}
+	if err != nil {
+		RespondInternalError(c, err)
+		return
+	}
+
+	Respond(c, http.StatusOK, SuccessResponse{
+		Success: true,
+		Data:    collaborators,
+	})
+}
+
+// PUT /albums/:id/collaborators - Protected: the owner gives another user a
+// role on the album (editor or viewer), or changes the one they have
+func (h *CollaboratorHandlers) putCollaborator(c *gin.Context) {
+	userID, _ := GetCurrentUserID(c)
+	id, ok := albumIDParam(c)
+	if !ok {
+		return
+	}
+
+	var req CollaboratorRequest
+	if err := BindBody(c, &req); err != nil {
+		RespondError(c, CodeValidationFailed, err.Error())
+		return
+	}
+
+	if _, err := getOwnedAlbum(h.db, CurrentTenant(c), userID, id); err != nil {
+		RespondDBError(c, err, CodeAlbumNotFound)
+		return
+	}
+	user, ok := findRecipient(c, h.db, "email", req.Email, userID)
+	if !ok {
+		return
+	}
+
+	collaborator := AlbumCollaborator{AlbumID: id, UserID: user.ID, Role: req.Role}
+	err := h.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "album_id"}, {Name: "user_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"role", "updated_at"}),
+	}).Create(&collaborator).Error
+	if err == nil {
+		err = h.db.Where("album_id = ? AND user_id = ?", id, user.ID).First(&collaborator).Error
+	}
+	if err != nil {
+		RespondDBError(c, err, CodeAlbumNotFound)
+		return
+	}
+	collaborator.Email = user.Email
+
+	Respond(c, http.StatusOK, SuccessResponse{
+		Success: true,
+		Data:    collaborator,
+	})
+}
+
+// DELETE /albums/:id/collaborators/:user_id - Protected: the owner takes a
+// collaborator's role away, or a collaborator leaves the album
+func (h *CollaboratorHandlers) deleteCollaborator(c *gin.Context) {
+	userID, _ := GetCurrentUserID(c)
+	id, ok := albumIDParam(c)
+	if !ok {
+		return
+	}
+	collaboratorID, ok := uintParam(c, "user_id")
+	if !ok {
+		return
+	}
+
+	if collaboratorID != userID {
+		if _, err := getOwnedAlbum(h.db, CurrentTenant(c), userID, id); err != nil {
+			RespondDBError(c, err, CodeAlbumNotFound)
+			return
+		}
+	}
+
+	removed := h.db.Where("album_id = ? AND user_id = ?", id, collaboratorID).Delete(&AlbumCollaborator{})
+	if removed.Error != nil {
+		RespondInternalError(c, removed.Error)
+		return
+	}
+	if removed.RowsAffected == 0 {
+		RespondError(c, CodeCollaboratorNotFound, "")
+		return
+	}
+
+	Respond(c, http.StatusOK, SuccessResponse{
+		Success: true,
+		Data:    gin.H{"message": "Collaborator removed"},
+	})
+}
+
+// getOwnedAlbum loads an album userID owns in the tenant's catalogue. Unlike
+// AlbumService.Get an album without an owner doesn't count: nobody can share
+// or give it away.
+func getOwnedAlbum(db *gorm.DB, tenant Tenant, userID, albumID uint) (Album, error) {
+	var album Album
+	if err := db.Scopes(tenant.Albums).First(&album, albumID).Error; err != nil {
+		return Album{}, err
+	}
+	if album.UserID == nil || *album.UserID != userID {
+		return Album{}, ErrNotOwner
+	}
+	return album, nil
+}
+
+// unshareAlbum removes an album's collaborators and cancels its pending
+// transfer, for when it is deleted or left without an owner
+func unshareAlbum(tx *gorm.DB, albumID uint) error {
+	if err := tx.Where("album_id = ?", albumID).Delete(&AlbumCollaborator{}).Error; err != nil {
+		return err
+	}
+	return cancelTransfers(tx, albumID)
+}
+
+// fillCollaboratorEmails sets each collaborator's email address
+func fillCollaboratorEmails(db *gorm.DB, collaborators []AlbumCollaborator) error {
+	var ids []uint
+	for _, collaborator := range collaborators {
+		ids = append(ids, collaborator.UserID)
+	}
+	emails, err := userEmails(db, ids)
+	if err != nil {
+		return err
+	}
+	for i := range collaborators {
+		collaborators[i].Email = emails[collaborators[i].UserID]
+	}
+	return nil
+}
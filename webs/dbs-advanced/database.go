@@ -0,0 +1,17 @@
+package main
+
+import (
+	"log"
+
+	"example.com/albums/database"
+)
+
+// Connect to the database DB_DRIVER names: Postgres, or SQLite in DB_PATH
+// (dbs-advanced.db by default), as example.com/albums/database explains
+func connectDB() {
+	var err error
+	DB, err = database.Open("dbs-advanced")
+	if err != nil {
+		log.Fatal("Failed to connect to database:", err)
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"fmt"
+	"html/template"
+	"log"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/gin-gonic/gin"
+)
+
+// The OpenAPI document and the page describing it are compiled into the
+// binary. The page is rendered here from the document, once, rather than by
+// a script from a CDN, so /docs works offline and always matches the build.
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+//go:embed docs.html
+var docsTemplate string
+
+// GET /openapi.json - Public: OpenAPI 3 description of every route
+func getOpenAPISpec(c *gin.Context) {
+	c.Data(http.StatusOK, "application/json; charset=utf-8", openAPISpec)
+}
+
+// GET /docs - Public: HTML reference rendered from /openapi.json
+func getAPIDocs(c *gin.Context) {
+	c.Data(http.StatusOK, "text/html; charset=utf-8", docsPage())
+}
+
+// docsPage renders docs.html the first time it is asked for. The spec and
+// the template are compiled in, so a failure is a programming error.
+var docsPage = sync.OnceValue(func() []byte {
+	doc, err := openapi3.NewLoader().LoadFromData(openAPISpec)
+	if err != nil {
+		log.Fatal("Failed to load openapi.json:", err)
+	}
+
+	var page bytes.Buffer
+	tmpl := template.Must(template.New("docs").Parse(docsTemplate))
+	if err := tmpl.Execute(&page, newDocsView(doc)); err != nil {
+		log.Fatal("Failed to render docs.html:", err)
+	}
+	return page.Bytes()
+})
+
+// docsView is what docs.html shows: the operations under their tags, then
+// the schemas they use
+type docsView struct {
+	Title, Version, Description string
+	Sections                    []docsSection
+	Schemas                     []docsSchema
+}
+
+type docsSection struct {
+	Tag        string
+	Operations []docsOperation
+}
+
+type docsOperation struct {
+	ID, Method, Path, Summary, Description string
+	Auth                                   string
+	Parameters                             []docsField
+	BodyTypes                              []string
+	Body                                   string
+	Responses                              []docsField
+}
+
+// docsField is a parameter, a response (Name is the status) or a property
+type docsField struct {
+	Name, In, Type, Description string
+	Required                    bool
+}
+
+type docsSchema struct {
+	Name, Type, Description string
+	Enum                    []string
+	Properties              []docsField
+}
+
+var docsMethodOrder = []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete}
+
+func newDocsView(doc *openapi3.T) docsView {
+	view := docsView{Title: doc.Info.Title, Version: doc.Info.Version, Description: doc.Info.Description}
+
+	sections := map[string]int{} // tag -> index in view.Sections
+	for _, tag := range doc.Tags {
+		sections[tag.Name] = len(view.Sections)
+		view.Sections = append(view.Sections, docsSection{Tag: tag.Name})
+	}
+
+	paths := doc.Paths.Map()
+	for _, path := range slices.Sorted(maps.Keys(paths)) {
+		item := paths[path]
+		for _, method := range docsMethodOrder {
+			op := item.GetOperation(method)
+			if op == nil {
+				continue
+			}
+			tag := "other"
+			if len(op.Tags) > 0 {
+				tag = op.Tags[0]
+			}
+			i, ok := sections[tag]
+			if !ok {
+				i = len(view.Sections)
+				sections[tag] = i
+				view.Sections = append(view.Sections, docsSection{Tag: tag})
+			}
+			view.Sections[i].Operations = append(view.Sections[i].Operations, newDocsOperation(method, path, item, op))
+		}
+	}
+
+	schemas := doc.Components.Schemas
+	for _, name := range slices.Sorted(maps.Keys(schemas)) {
+		view.Schemas = append(view.Schemas, newDocsSchema(name, schemas[name].Value))
+	}
+	return view
+}
+
+func newDocsOperation(method, path string, item *openapi3.PathItem, op *openapi3.Operation) docsOperation {
+	operation := docsOperation{
+		ID:          op.OperationID,
+		Method:      method,
+		Path:        path,
+		Summary:     op.Summary,
+		Description: op.Description,
+		Auth:        "API key",
+	}
+	if op.Security != nil {
+		switch {
+		case len(*op.Security) == 0:
+			operation.Auth = "public"
+		case slices.ContainsFunc(*op.Security, func(r openapi3.SecurityRequirement) bool { return len(r) == 0 }):
+			operation.Auth = "API key optional"
+		}
+	}
+
+	for _, param := range append(append(openapi3.Parameters{}, item.Parameters...), op.Parameters...) {
+		p := param.Value
+		operation.Parameters = append(operation.Parameters, docsField{
+			Name: p.Name, In: p.In, Type: schemaType(p.Schema), Description: p.Description, Required: p.Required,
+		})
+	}
+
+	if op.RequestBody != nil && op.RequestBody.Value != nil {
+		content := op.RequestBody.Value.Content
+		operation.BodyTypes = slices.Sorted(maps.Keys(content))
+		if media := content.Get("application/json"); media != nil {
+			operation.Body = schemaType(media.Schema)
+		}
+	}
+
+	responses := op.Responses.Map()
+	for _, status := range slices.Sorted(maps.Keys(responses)) {
+		response := responses[status].Value
+		field := docsField{Name: status}
+		if response.Description != nil {
+			field.Description = *response.Description
+		}
+		for _, contentType := range slices.Sorted(maps.Keys(response.Content)) {
+			field.Type = contentType
+			if contentType == "application/json" {
+				field.Type = schemaType(response.Content[contentType].Schema)
+				break
+			}
+		}
+		operation.Responses = append(operation.Responses, field)
+	}
+	return operation
+}
+
+func newDocsSchema(name string, schema *openapi3.Schema) docsSchema {
+	doc := docsSchema{Name: name, Type: "object", Description: schema.Description}
+	if len(schema.Properties) == 0 {
+		doc.Type = schemaType(&openapi3.SchemaRef{Value: schema})
+	}
+	for _, value := range schema.Enum {
+		doc.Enum = append(doc.Enum, fmt.Sprint(value))
+	}
+	for _, property := range slices.Sorted(maps.Keys(schema.Properties)) {
+		ref := schema.Properties[property]
+		doc.Properties = append(doc.Properties, docsField{
+			Name:        property,
+			Type:        schemaType(ref),
+			Description: ref.Value.Description,
+			Required:    slices.Contains(schema.Required, property),
+		})
+	}
+	return doc
+}
+
+// schemaType names a schema for the page: its component name, or a short
+// description of an inline one
+func schemaType(ref *openapi3.SchemaRef) string {
+	if ref == nil || ref.Value == nil {
+		return ""
+	}
+	if ref.Ref != "" {
+		return ref.Ref[strings.LastIndex(ref.Ref, "/")+1:]
+	}
+
+	schema := ref.Value
+	switch {
+	case len(schema.AllOf) > 0:
+		var parts []string
+		for _, part := range schema.AllOf {
+			parts = append(parts, schemaType(part))
+		}
+		return strings.Join(parts, " + ")
+	case schema.Type.Is(openapi3.TypeArray):
+		return "array of " + schemaType(schema.Items)
+	case len(schema.Properties) > 0:
+		var fields []string
+		for _, property := range slices.Sorted(maps.Keys(schema.Properties)) {
+			fields = append(fields, property+": "+schemaType(schema.Properties[property]))
+		}
+		return "{" + strings.Join(fields, ", ") + "}"
+	}
+
+	types := strings.Join(schema.Type.Slice(), " or ")
+	if schema.Format != "" {
+		types += " (" + schema.Format + ")"
+	}
+	return types
+}
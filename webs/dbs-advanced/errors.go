@@ -0,0 +1,211 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"example.com/albums/apierror"
+	"example.com/albums/catalog"
+	"github.com/gin-gonic/gin"
+)
+
+// The envelope, problem+json and the codes every album service sends are
+// shared in example.com/albums/apierror; the codes below are ours.
+
+// ErrorCode is the machine-readable reason sent with every error response
+type ErrorCode = apierror.Code
+
+// ValidationIssue pinpoints one invalid part of a request
+type ValidationIssue = apierror.ValidationIssue
+
+// ProblemDetails is the RFC 7807 body sent when the client accepts problem+json
+type ProblemDetails = apierror.ProblemDetails
+
+// MIMEProblemJSON is the RFC 7807 media type clients can ask for in Accept
+const MIMEProblemJSON = apierror.MIMEProblemJSON
+
+const (
+	CodeValidationFailed               = apierror.CodeValidationFailed
+	CodeQueryTooComplex      ErrorCode = "QUERY_TOO_COMPLEX"
+	CodeAPIKeyRequired       ErrorCode = "API_KEY_REQUIRED"
+	CodeAPIKeyInvalid        ErrorCode = "API_KEY_INVALID"
+	CodeInvalidCredentials   ErrorCode = "INVALID_CREDENTIALS"
+	CodeWrongPassword        ErrorCode = "WRONG_PASSWORD"
+	CodeTokenInvalid         ErrorCode = "TOKEN_INVALID"
+	CodeOTPRequired          ErrorCode = "OTP_REQUIRED"
+	CodeOTPInvalid           ErrorCode = "OTP_INVALID"
+	CodeOTPLocked            ErrorCode = "OTP_LOCKED"
+	CodeTwoFactorEnabled     ErrorCode = "TWO_FACTOR_ALREADY_ENABLED"
+	CodeTwoFactorNotEnabled  ErrorCode = "TWO_FACTOR_NOT_ENABLED"
+	CodeUserNotFound         ErrorCode = "USER_NOT_FOUND"
+	CodeForbiddenNotOwner    ErrorCode = "FORBIDDEN_NOT_OWNER"
+	CodeAlbumReadOnly        ErrorCode = "ALBUM_READ_ONLY"
+	CodeAdminRequired        ErrorCode = "ADMIN_REQUIRED"
+	CodeAccountNotFound      ErrorCode = "ACCOUNT_NOT_FOUND"
+	CodeEmailNotVerified     ErrorCode = "EMAIL_NOT_VERIFIED"
+	CodeAlbumNotFound        ErrorCode = "ALBUM_NOT_FOUND"
+	CodeWebhookNotFound      ErrorCode = "WEBHOOK_NOT_FOUND"
+	CodeDeliveryNotFound     ErrorCode = "DELIVERY_NOT_FOUND"
+	CodeCollaboratorNotFound ErrorCode = "COLLABORATOR_NOT_FOUND"
+	CodeTransferNotFound     ErrorCode = "TRANSFER_NOT_FOUND"
+	CodeTransferPending      ErrorCode = "TRANSFER_PENDING"
+	CodeTransferNotPending   ErrorCode = "TRANSFER_NOT_PENDING"
+	CodeAlbumHasOwner        ErrorCode = "ALBUM_HAS_OWNER"
+	CodeClaimNotFound        ErrorCode = "CLAIM_NOT_FOUND"
+	CodeClaimPending         ErrorCode = "CLAIM_PENDING"
+	CodeClaimNotPending      ErrorCode = "CLAIM_NOT_PENDING"
+	CodeOrganizationNotFound ErrorCode = "ORGANIZATION_NOT_FOUND"
+	CodeNotMember            ErrorCode = "NOT_A_MEMBER"
+	CodeOrganizationReadOnly ErrorCode = "ORGANIZATION_READ_ONLY"
+	CodeOwnerRequired        ErrorCode = "ORGANIZATION_OWNER_REQUIRED"
+	CodeMemberNotFound       ErrorCode = "MEMBER_NOT_FOUND"
+	CodeLastOwner            ErrorCode = "LAST_OWNER"
+	CodeExportNotFound       ErrorCode = "EXPORT_NOT_FOUND"
+	CodeExportNotReady       ErrorCode = "EXPORT_NOT_READY"
+	CodeDownloadLinkInvalid  ErrorCode = "DOWNLOAD_LINK_INVALID"
+	CodeDuplicateResource              = apierror.CodeDuplicateResource
+	CodeInvalidReference               = apierror.CodeInvalidReference
+	CodeNotAcceptable                  = apierror.CodeNotAcceptable
+	CodeUnsupportedMediaType           = apierror.CodeUnsupportedMediaType
+	CodeInternal                       = apierror.CodeInternal
+)
+
+// apiErrors writes our errors, with problem types named urn:dbs:error:...
+var apiErrors = apierror.New("dbs", apierror.Catalogue{
+	CodeQueryTooComplex:      {http.StatusBadRequest, "GraphQL query is too complex"},
+	CodeAPIKeyRequired:       {http.StatusUnauthorized, "API key is required. Provide it in X-API-Key header"},
+	CodeAPIKeyInvalid:        {http.StatusUnauthorized, "Invalid API key"},
+	CodeInvalidCredentials:   {http.StatusUnauthorized, "Invalid email or password"},
+	CodeWrongPassword:        {http.StatusForbidden, "The password is wrong"},
+	CodeTokenInvalid:         {http.StatusBadRequest, "The token is invalid, expired or already used"},
+	CodeOTPRequired:          {http.StatusUnauthorized, "Two-factor authentication is on. Send otp_code with an authenticator or recovery code"},
+	CodeOTPInvalid:           {http.StatusUnauthorized, "The two-factor code is wrong or already used"},
+	CodeOTPLocked:            {http.StatusTooManyRequests, "Too many wrong two-factor codes, try again later"},
+	CodeTwoFactorEnabled:     {http.StatusConflict, "Two-factor authentication is already on"},
+	CodeTwoFactorNotEnabled:  {http.StatusConflict, "Two-factor authentication is not on"},
+	CodeUserNotFound:         {http.StatusUnauthorized, "User not found"},
+	CodeForbiddenNotOwner:    {http.StatusForbidden, "You don't have permission to access this album"},
+	CodeAlbumReadOnly:        {http.StatusForbidden, "You can read this album but not change it"},
+	CodeAdminRequired:        {http.StatusForbidden, "Only administrators can do this"},
+	CodeAccountNotFound:      {http.StatusNotFound, "No account has that ID"},
+	CodeEmailNotVerified:     {http.StatusForbidden, "Verify your email address first. POST /email/verify/send sends a token"},
+	CodeAlbumNotFound:        {http.StatusNotFound, "Album not found"},
+	CodeWebhookNotFound:      {http.StatusNotFound, "Webhook not found"},
+	CodeDeliveryNotFound:     {http.StatusNotFound, "Webhook delivery not found"},
+	CodeCollaboratorNotFound: {http.StatusNotFound, "That user is not a collaborator on this album"},
+	CodeTransferNotFound:     {http.StatusNotFound, "Transfer not found"},
+	CodeTransferPending:      {http.StatusConflict, "The album has already been offered to someone. Cancel that transfer first"},
+	CodeTransferNotPending:   {http.StatusConflict, "The transfer has already been accepted, rejected or cancelled"},
+	CodeAlbumHasOwner:        {http.StatusConflict, "The album already has an owner"},
+	CodeClaimNotFound:        {http.StatusNotFound, "Claim not found"},
+	CodeClaimPending:         {http.StatusConflict, "You have already claimed this album. Wait for an administrator to review it"},
+	CodeClaimNotPending:      {http.StatusConflict, "The claim has already been approved, rejected or withdrawn"},
+	CodeOrganizationNotFound: {http.StatusNotFound, "Organization not found"},
+	CodeNotMember:            {http.StatusForbidden, "You are not a member of this organization"},
+	CodeOrganizationReadOnly: {http.StatusForbidden, "You can read this organization's catalogue but not change it"},
+	CodeOwnerRequired:        {http.StatusForbidden, "Only the organization's owners can do this"},
+	CodeMemberNotFound:       {http.StatusNotFound, "That user is not a member of this organization"},
+	CodeLastOwner:            {http.StatusConflict, "An organization needs at least one owner. Make someone else an owner first"},
+	CodeExportNotFound:       {http.StatusNotFound, "Export not found"},
+	CodeExportNotReady:       {http.StatusConflict, "The export is not ready, or its archive has expired"},
+	CodeDownloadLinkInvalid:  {http.StatusForbidden, "The download link is invalid or has expired"},
+})
+
+// errorCatalogue maps each code, ours and the shared ones, to its HTTP status
+// and default message
+var errorCatalogue = apiErrors.Catalogue
+
+// NewErrorResponse builds the standard error envelope for a code.
+// An empty message falls back to the catalogue default.
+func NewErrorResponse(c *gin.Context, code ErrorCode, message string) ErrorResponse {
+	return apiErrors.NewResponse(c, code, message)
+}
+
+// RespondError writes an error for the given code, as problem+json when the
+// client explicitly asked for it and in the negotiated format otherwise.
+func RespondError(c *gin.Context, code ErrorCode, message string) {
+	apiErrors.Respond(c, code, message, nil)
+}
+
+// RespondValidationError is RespondError for VALIDATION_FAILED with the
+// individual issues attached.
+func RespondValidationError(c *gin.Context, message string, issues []ValidationIssue) {
+	apiErrors.Respond(c, CodeValidationFailed, message, issues)
+}
+
+// AbortWithError is RespondError for middleware that must stop the chain
+func AbortWithError(c *gin.Context, code ErrorCode, message string) {
+	apiErrors.Abort(c, code, message)
+}
+
+// RespondDBError maps a GORM/pgx (or service) error to a client error where one applies
+// (notFound is the code to use for a missing record). Anything else is logged
+// with the request ID and answered with a generic 500.
+func RespondDBError(c *gin.Context, err error, notFound ErrorCode) {
+	var invalid *catalog.ValidationError
+	if errors.As(err, &invalid) {
+		RespondValidationError(c, "", apierror.Issues(invalid))
+		return
+	}
+	if code, ok := clientErrorCode(err, notFound); ok {
+		RespondError(c, code, "")
+		return
+	}
+	RespondInternalError(c, err)
+}
+
+// clientErrorCode returns the client error a GORM/pgx or service error stands
+// for, or false when it is a server-side failure.
+func clientErrorCode(err error, notFound ErrorCode) (ErrorCode, bool) {
+	switch {
+	case errors.Is(err, ErrNotOwner):
+		return CodeForbiddenNotOwner, true
+	case errors.Is(err, ErrAlbumReadOnly):
+		return CodeAlbumReadOnly, true
+	case errors.Is(err, ErrTransferPending):
+		return CodeTransferPending, true
+	case errors.Is(err, ErrTransferNotPending):
+		return CodeTransferNotPending, true
+	case errors.Is(err, ErrAlbumHasOwner):
+		return CodeAlbumHasOwner, true
+	case errors.Is(err, ErrClaimPending):
+		return CodeClaimPending, true
+	case errors.Is(err, ErrClaimNotPending):
+		return CodeClaimNotPending, true
+	case errors.Is(err, ErrNotMember):
+		return CodeNotMember, true
+	case errors.Is(err, ErrOrganizationReadOnly):
+		return CodeOrganizationReadOnly, true
+	case errors.Is(err, ErrOwnerRequired):
+		return CodeOwnerRequired, true
+	case errors.Is(err, ErrLastOwner):
+		return CodeLastOwner, true
+	case errors.Is(err, ErrEmailNotVerified):
+		return CodeEmailNotVerified, true
+	case errors.Is(err, ErrWrongPassword):
+		return CodeWrongPassword, true
+	case errors.Is(err, ErrOTPRequired):
+		return CodeOTPRequired, true
+	case errors.Is(err, ErrOTPInvalid):
+		return CodeOTPInvalid, true
+	case errors.Is(err, ErrOTPLocked):
+		return CodeOTPLocked, true
+	default:
+		return apierror.ClientCode(err, notFound)
+	}
+}
+
+// clientErrorMessage is the message to send with clientErrorCode's code:
+// what is wrong with an album, or "" for the catalogue's default
+func clientErrorMessage(err error) string {
+	var invalid *catalog.ValidationError
+	if errors.As(err, &invalid) {
+		return invalid.Error()
+	}
+	return ""
+}
+
+// RespondInternalError logs err server-side and hides it from the client
+func RespondInternalError(c *gin.Context, err error) {
+	apiErrors.Internal(c, err)
+}
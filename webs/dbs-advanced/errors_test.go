@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"gorm.io/gorm"
+)
+
+func TestClientErrorCode(t *testing.T) {
+	tests := []struct {
+		err  error
+		want ErrorCode // "" for a server-side failure
+	}{
+		{gorm.ErrRecordNotFound, CodeAlbumNotFound},
+		{fmt.Errorf("load album: %w", gorm.ErrRecordNotFound), CodeAlbumNotFound},
+		{ErrNotOwner, CodeForbiddenNotOwner},
+		{ErrAlbumReadOnly, CodeAlbumReadOnly},
+		{ErrTransferPending, CodeTransferPending},
+		{ErrClaimNotPending, CodeClaimNotPending},
+		{ErrNotMember, CodeNotMember},
+		{ErrLastOwner, CodeLastOwner},
+		{ErrEmailNotVerified, CodeEmailNotVerified},
+		{ErrOTPLocked, CodeOTPLocked},
+		{gorm.ErrDuplicatedKey, CodeDuplicateResource},
+		{gorm.ErrForeignKeyViolated, CodeInvalidReference},
+		{&pgconn.PgError{Code: "23502"}, CodeValidationFailed},
+		{&pgconn.PgError{Code: "22P02"}, CodeValidationFailed},
+		{&pgconn.PgError{Code: "40001"}, ""},
+		{errors.New("connection refused"), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.err.Error(), func(t *testing.T) {
+			code, ok := clientErrorCode(tt.err, CodeAlbumNotFound)
+			if code != tt.want || ok != (tt.want != "") {
+				t.Errorf("clientErrorCode = %q, %v; want %q", code, ok, tt.want)
+			}
+		})
+	}
+}
+
+// Every code has a status and message, and openapi.json lists exactly those codes
+func TestErrorCatalogueMatchesSpec(t *testing.T) {
+	var spec struct {
+		Components struct {
+			Schemas struct {
+				ErrorCode struct {
+					Enum []ErrorCode `json:"enum"`
+				} `json:"ErrorCode"`
+			} `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(openAPISpec, &spec); err != nil {
+		t.Fatalf("openapi.json: %v", err)
+	}
+	documented := spec.Components.Schemas.ErrorCode.Enum
+
+	for code, entry := range errorCatalogue {
+		if entry.Status < 400 || entry.Message == "" {
+			t.Errorf("%s has status %d and message %q", code, entry.Status, entry.Message)
+		}
+		if !slices.Contains(documented, code) {
+			t.Errorf("%s is not in the ErrorCode enum of openapi.json", code)
+		}
+	}
+	for _, code := range documented {
+		if _, ok := errorCatalogue[code]; !ok {
+			t.Errorf("openapi.json documents %s, which has no catalogue entry", code)
+		}
+	}
+}
+
+func TestErrorResponseShape(t *testing.T) {
+	setupTestDB(t)
+	user := createTestUser(t, "errors@example.com")
+	server := httptest.NewServer(setupRouter(NewServices(DB)))
+	t.Cleanup(server.Close)
+
+	get := func(t *testing.T, path, accept string) (*http.Response, []byte) {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodGet, server.URL+path, nil)
+		req.Header.Set("X-API-Key", user.APIKey)
+		req.Header.Set("X-Request-ID", "req-123")
+		req.Header.Set("Accept", accept)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("GET %s: %v", path, err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp, body
+	}
+
+	t.Run("problem+json", func(t *testing.T) {
+		resp, body := get(t, "/albums/999", "application/problem+json")
+		var problem ProblemDetails
+		if err := json.Unmarshal(body, &problem); err != nil {
+			t.Fatalf("decode %s: %v", body, err)
+		}
+		want := ProblemDetails{
+			Type:      "urn:dbs:error:album-not-found",
+			Title:     errorCatalogue[CodeAlbumNotFound].Message,
+			Status:    http.StatusNotFound,
+			Detail:    errorCatalogue[CodeAlbumNotFound].Message,
+			Instance:  "/albums/999",
+			Code:      CodeAlbumNotFound,
+			RequestID: "req-123",
+		}
+		if resp.StatusCode != http.StatusNotFound || resp.Header.Get("Content-Type") != MIMEProblemJSON {
+			t.Errorf("status %d, Content-Type %q; want 404 as %s", resp.StatusCode, resp.Header.Get("Content-Type"), MIMEProblemJSON)
+		}
+		if fmt.Sprint(problem) != fmt.Sprint(want) {
+			t.Errorf("problem = %+v, want %+v", problem, want)
+		}
+	})
+
+	t.Run("problem+json with issues", func(t *testing.T) {
+		resp, body := get(t, "/albums?limit=0", "application/problem+json")
+		var problem ProblemDetails
+		json.Unmarshal(body, &problem)
+		if resp.StatusCode != http.StatusBadRequest || problem.Code != CodeValidationFailed || len(problem.Errors) == 0 || problem.Errors[0].Field != "limit" {
+			t.Errorf("GET /albums?limit=0 = %d with %+v, want VALIDATION_FAILED on limit", resp.StatusCode, problem)
+		}
+	})
+
+	t.Run("envelope", func(t *testing.T) {
+		resp, body := get(t, "/albums/999", "application/json")
+		var envelope map[string]interface{}
+		json.Unmarshal(body, &envelope)
+		want := map[string]interface{}{
+			"success":    false,
+			"error":      errorCatalogue[CodeAlbumNotFound].Message,
+			"code":       string(CodeAlbumNotFound),
+			"request_id": "req-123",
+		}
+		if resp.StatusCode != http.StatusNotFound || fmt.Sprint(envelope) != fmt.Sprint(want) {
+			t.Errorf("GET /albums/999 = %d with %v, want %v", resp.StatusCode, envelope, want)
+		}
+	})
+
+	t.Run("xml envelope", func(t *testing.T) {
+		resp, body := get(t, "/albums/999", "application/xml")
+		var envelope ErrorResponse
+		if err := xml.Unmarshal(body, &envelope); err != nil {
+			t.Fatalf("decode %s: %v", body, err)
+		}
+		if resp.StatusCode != http.StatusNotFound || envelope.Code != CodeAlbumNotFound || envelope.RequestID != "req-123" {
+			t.Errorf("GET /albums/999 as XML = %d with %+v", resp.StatusCode, envelope)
+		}
+	})
+
+	t.Run("not acceptable", func(t *testing.T) {
+		resp, body := get(t, "/albums", "text/html")
+		var envelope ErrorResponse
+		json.Unmarshal(body, &envelope)
+		if resp.StatusCode != http.StatusNotAcceptable || envelope.Code != CodeNotAcceptable {
+			t.Errorf("Accept: text/html = %d with %+v, want 406 NOT_ACCEPTABLE", resp.StatusCode, envelope)
+		}
+	})
+}
+
+// A server-side failure is logged, not sent to the client
+func TestRespondDBErrorHidesInternalErrors(t *testing.T) {
+	setupTestDB(t)
+	server := httptest.NewServer(setupRouter(NewServices(DB)))
+	t.Cleanup(server.Close)
+
+	sqlDB, _ := DB.DB()
+	sqlDB.Close() // every query now fails
+
+	resp, err := http.Get(server.URL + "/albums")
+	if err != nil {
+		t.Fatalf("GET /albums: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusInternalServerError || !strings.Contains(string(body), string(CodeInternal)) || strings.Contains(string(body), "closed") {
+		t.Errorf("GET /albums = %d with %s, want a bare INTERNAL_ERROR", resp.StatusCode, body)
+	}
+}
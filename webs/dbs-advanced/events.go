@@ -0,0 +1,123 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	eventReplaySize  = 1000 // events kept for Last-Event-ID resume
+	subscriberBuffer = 64   // events a client may fall behind before it is dropped
+)
+
+// Album event types
+const (
+	EventAlbumCreated = "album.created"
+	EventAlbumUpdated = "album.updated"
+	EventAlbumDeleted = "album.deleted"
+)
+
+// AlbumEvent is one change pushed to the /albums/events feeds
+type AlbumEvent struct {
+	ID    uint64    `json:"id"`
+	Type  string    `json:"type"`
+	Album Album     `json:"album"`
+	Time  time.Time `json:"time"`
+}
+
+// Events is the process-wide album change hub. Whatever changes albums
+// publishes to it once its transaction has committed, so a rolled back change
+// is never announced: the album repository, transfers, claims and account
+// deletion.
+var Events = NewEventHub(eventReplaySize)
+
+// EventHub fans album events out to subscribers and keeps the most recent
+// ones so a reconnecting client can catch up.
+type EventHub struct {
+	mu          sync.Mutex
+	nextID      uint64
+	replay      []AlbumEvent // oldest first, at most replaySize long
+	replaySize  int
+	subscribers map[*Subscription]struct{}
+}
+
+// Subscription receives events until it is closed. Events is closed by the
+// hub when the subscriber falls more than subscriberBuffer events behind, so
+// one slow client can't hold up the others; it should reconnect and resume.
+type Subscription struct {
+	Events <-chan AlbumEvent
+
+	events chan AlbumEvent
+	hub    *EventHub
+}
+
+// NewEventHub creates a hub that remembers the last replaySize events
+func NewEventHub(replaySize int) *EventHub {
+	return &EventHub{replaySize: replaySize, subscribers: map[*Subscription]struct{}{}}
+}
+
+// Publish numbers an event, remembers it and sends it to every subscriber.
+// Call it after the change has committed.
+func (h *EventHub) Publish(eventType string, album Album) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	album.User = nil // keep owners' details (and API keys) out of the feed
+
+	h.nextID++
+	event := AlbumEvent{ID: h.nextID, Type: eventType, Album: album, Time: time.Now().UTC()}
+
+	if len(h.replay) == h.replaySize {
+		h.replay = h.replay[1:]
+	}
+	h.replay = append(h.replay, event)
+
+	for sub := range h.subscribers {
+		select {
+		case sub.events <- event:
+		default:
+			h.drop(sub)
+		}
+	}
+}
+
+// Subscribe starts a subscription and returns the remembered events after
+// lastEventID (0 for none). complete is false when some of those events have
+// already left the replay buffer (or came from before a restart), so the
+// client should reload the full list instead.
+func (h *EventHub) Subscribe(lastEventID uint64) (replay []AlbumEvent, complete bool, sub *Subscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	complete = true
+	if lastEventID > 0 {
+		if lastEventID > h.nextID || (len(h.replay) > 0 && h.replay[0].ID > lastEventID+1) {
+			complete = false
+		}
+		for _, event := range h.replay {
+			if event.ID > lastEventID {
+				replay = append(replay, event)
+			}
+		}
+	}
+
+	events := make(chan AlbumEvent, subscriberBuffer)
+	sub = &Subscription{Events: events, events: events, hub: h}
+	h.subscribers[sub] = struct{}{}
+	return replay, complete, sub
+}
+
+// Close ends the subscription; it is safe to call after the hub dropped it
+func (s *Subscription) Close() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+	s.hub.drop(s)
+}
+
+// drop removes a subscriber; h.mu must be held
+func (h *EventHub) drop(sub *Subscription) {
+	if _, ok := h.subscribers[sub]; ok {
+		delete(h.subscribers, sub)
+		close(sub.events)
+	}
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// lastEventID is the ID of the newest event Events has published
+func lastEventID() uint64 {
+	Events.mu.Lock()
+	defer Events.mu.Unlock()
+	return Events.nextID
+}
+
+// nextEvent waits briefly for an event on sub; ok is false if none came
+func nextEvent(sub *Subscription) (event AlbumEvent, ok bool) {
+	select {
+	case event, ok = <-sub.Events:
+		return event, ok
+	case <-time.After(time.Second):
+		return AlbumEvent{}, false
+	}
+}
+
+func TestEventHubReplay(t *testing.T) {
+	hub := NewEventHub(3)
+	for _, title := range []string{"One", "Two", "Three", "Four", "Five"} {
+		hub.Publish(EventAlbumCreated, Album{Title: title})
+	}
+
+	tests := []struct {
+		lastEventID  uint64
+		wantTitles   string
+		wantComplete bool
+	}{
+		{0, "", true}, // a new client starts from now
+		{3, "Four Five", true},
+		{2, "Three Four Five", true},
+		{5, "", true},
+		{1, "Three Four Five", false}, // Two has left the buffer
+		{9, "", false},                // from before a restart
+	}
+	for _, tt := range tests {
+		t.Run(strconv.FormatUint(tt.lastEventID, 10), func(t *testing.T) {
+			replay, complete, sub := hub.Subscribe(tt.lastEventID)
+			defer sub.Close()
+
+			var titles []string
+			for _, event := range replay {
+				titles = append(titles, event.Album.Title)
+			}
+			if got := strings.Join(titles, " "); got != tt.wantTitles || complete != tt.wantComplete {
+				t.Errorf("Subscribe(%d) = %q, complete %v; want %q, %v", tt.lastEventID, got, complete, tt.wantTitles, tt.wantComplete)
+			}
+		})
+	}
+}
+
+// A subscriber that stops reading is dropped once its buffer is full, and
+// the others keep receiving
+func TestEventHubDropsSlowSubscribers(t *testing.T) {
+	hub := NewEventHub(10)
+	_, _, slow := hub.Subscribe(0)
+	_, _, fast := hub.Subscribe(0)
+	defer fast.Close()
+
+	received := 0
+	for range subscriberBuffer + 1 {
+		hub.Publish(EventAlbumUpdated, Album{ID: 1})
+		if _, ok := nextEvent(fast); ok {
+			received++
+		}
+	}
+
+	buffered := 0
+	for range slow.Events {
+		buffered++
+	}
+	if buffered != subscriberBuffer || received != subscriberBuffer+1 {
+		t.Errorf("slow subscriber got %d before being dropped, fast one %d; want %d and %d", buffered, received, subscriberBuffer, subscriberBuffer+1)
+	}
+	slow.Close() // already dropped, must not panic
+}
+
+// Changes made by transfers, claims and account deletion are announced once
+// they commit, and ones that roll back are not
+func TestAlbumChangesAreAnnounced(t *testing.T) {
+	setupTestDB(t)
+	owner := createTestUser(t, "owner@example.com")
+	recipient := createTestUser(t, "recipient@example.com")
+	admin := createTestAdmin(t, "admin@example.com")
+	transferred := createTestAlbum(t, "Blue Train", &owner.ID)
+	ownerless := createTestAlbum(t, "Jeru", nil)
+	taken := createTestAlbum(t, "Kind of Blue", nil)
+
+	_, _, sub := Events.Subscribe(0)
+	defer sub.Close()
+
+	expect := func(t *testing.T, wantType string, wantAlbum uint, wantOwner *uint) {
+		t.Helper()
+		event, ok := nextEvent(sub)
+		if !ok || event.Type != wantType || event.Album.ID != wantAlbum || (event.Album.UserID == nil) != (wantOwner == nil) ||
+			(wantOwner != nil && *event.Album.UserID != *wantOwner) {
+			t.Fatalf("event = %+v (received %v), want %s of album %d", event, ok, wantType, wantAlbum)
+		}
+		if event.Album.User != nil {
+			t.Errorf("event carries the owner %+v", event.Album.User)
+		}
+	}
+	expectNone := func(t *testing.T) {
+		t.Helper()
+		select {
+		case event := <-sub.Events:
+			t.Fatalf("unexpected event %+v", event)
+		default:
+		}
+	}
+
+	t.Run("transfer", func(t *testing.T) {
+		transfer, err := OfferAlbum(DB, Tenant{}, owner.ID, transferred.ID, recipient, "")
+		if err != nil {
+			t.Fatalf("OfferAlbum: %v", err)
+		}
+		expectNone(t)
+		if _, err := AnswerTransfer(DB, recipient.ID, transfer.ID, TransferAccepted); err != nil {
+			t.Fatalf("AnswerTransfer: %v", err)
+		}
+		expect(t, EventAlbumUpdated, transferred.ID, &recipient.ID)
+	})
+
+	t.Run("claim", func(t *testing.T) {
+		first, err := ClaimAlbum(DB, Tenant{}, owner.ID, ownerless.ID, "mine")
+		if err != nil {
+			t.Fatalf("ClaimAlbum: %v", err)
+		}
+		if _, err := ReviewClaim(DB, admin.ID, first.ID, ClaimApproved, ""); err != nil {
+			t.Fatalf("ReviewClaim: %v", err)
+		}
+		expect(t, EventAlbumUpdated, ownerless.ID, &owner.ID)
+	})
+
+	t.Run("rolled back", func(t *testing.T) {
+		claim, err := ClaimAlbum(DB, Tenant{}, recipient.ID, taken.ID, "mine")
+		if err != nil {
+			t.Fatalf("ClaimAlbum: %v", err)
+		}
+		// Someone else becomes the owner before the claim is reviewed
+		DB.Model(&Album{}).Where("id = ?", taken.ID).Update("user_id", admin.ID)
+		if _, err := ReviewClaim(DB, admin.ID, claim.ID, ClaimApproved, ""); !errors.Is(err, ErrAlbumHasOwner) {
+			t.Fatalf("ReviewClaim = %v, want ErrAlbumHasOwner", err)
+		}
+		expectNone(t)
+	})
+
+	t.Run("account deleted with its albums orphaned", func(t *testing.T) {
+		if _, err := DeleteAccount(DB, recipient, AlbumsOrphan, nil); err != nil {
+			t.Fatalf("DeleteAccount: %v", err)
+		}
+		expect(t, EventAlbumUpdated, transferred.ID, nil)
+	})
+
+	t.Run("account deleted with its albums", func(t *testing.T) {
+		if _, err := DeleteAccount(DB, owner, AlbumsDelete, nil); err != nil {
+			t.Fatalf("DeleteAccount: %v", err)
+		}
+		expect(t, EventAlbumDeleted, ownerless.ID, &owner.ID)
+	})
+}
+
+// readSSE reads n events from an event stream as "id event" strings
+func readSSE(t *testing.T, stream *bufio.Reader, n int) []string {
+	t.Helper()
+
+	var events []string
+	var id, eventType string
+	for len(events) < n {
+		line, err := stream.ReadString('\n')
+		if err != nil {
+			t.Fatalf("read event stream after %v: %v", events, err)
+		}
+		switch line = strings.TrimRight(line, "\n"); {
+		case strings.HasPrefix(line, "id:"):
+			id = strings.TrimPrefix(line, "id:")
+		case strings.HasPrefix(line, "event:"):
+			eventType = strings.TrimPrefix(line, "event:")
+		case line == "" && eventType != "":
+			events = append(events, strings.TrimSpace(id+" "+eventType))
+			id, eventType = "", ""
+		}
+	}
+	return events
+}
+
+func TestAlbumEventsResumeWithLastEventID(t *testing.T) {
+	server := newTestServer(t)
+	base := lastEventID()
+	for _, eventType := range []string{EventAlbumCreated, EventAlbumUpdated, EventAlbumDeleted} {
+		Events.Publish(eventType, Album{ID: 1})
+	}
+	id := func(offset uint64) string { return strconv.FormatUint(base+offset, 10) }
+
+	tests := []struct {
+		name        string
+		lastEventID string
+		header      bool
+		want        []string
+	}{
+		{"header", id(1), true, []string{id(2) + " " + EventAlbumUpdated, id(3) + " " + EventAlbumDeleted, id(4) + " " + EventAlbumCreated}},
+		{"query", id(2), false, []string{id(3) + " " + EventAlbumDeleted, id(4) + " " + EventAlbumCreated}},
+		{"from before a restart", id(100), true, []string{"reset"}},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			path := "/albums/events"
+			if !tt.header {
+				path += "?last_event_id=" + tt.lastEventID
+			}
+			req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+path, nil)
+			if tt.header {
+				req.Header.Set("Last-Event-ID", tt.lastEventID)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("GET %s: %v", path, err)
+			}
+			defer resp.Body.Close()
+			if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+				t.Fatalf("Content-Type = %q", resp.Header.Get("Content-Type"))
+			}
+
+			// The replay is followed by a live event, published once
+			if i == 0 {
+				Events.Publish(EventAlbumCreated, Album{ID: 1})
+			}
+			stream := bufio.NewReader(resp.Body)
+			if got := readSSE(t, stream, len(tt.want)); strings.Join(got, ", ") != strings.Join(tt.want, ", ") {
+				t.Errorf("events = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAlbumEventsWebSocket(t *testing.T) {
+	server := newTestServer(t)
+	owner := createTestUser(t, "owner@example.com")
+	other := createTestUser(t, "other@example.com")
+	base := lastEventID()
+	Events.Publish(EventAlbumCreated, Album{ID: 1, UserID: &owner.ID})
+	Events.Publish(EventAlbumCreated, Album{ID: 2, UserID: &other.ID})
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/albums/events/ws?scope=mine&last_event_id=" + strconv.FormatUint(base, 10)
+	conn, resp, err := websocket.DefaultDialer.Dial(url, http.Header{"X-API-Key": {owner.APIKey}})
+	if err != nil {
+		t.Fatalf("dial %s: %v (%v)", url, err, resp)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	Events.Publish(EventAlbumDeleted, Album{ID: 2, UserID: &other.ID})
+	Events.Publish(EventAlbumUpdated, Album{ID: 1, UserID: &owner.ID})
+
+	var got []string
+	for range 2 {
+		var event AlbumEvent
+		if err := conn.ReadJSON(&event); err != nil {
+			t.Fatalf("read after %v: %v", got, err)
+		}
+		got = append(got, event.Type+" "+strconv.FormatUint(uint64(event.Album.ID), 10))
+	}
+	if want := EventAlbumCreated + " 1, " + EventAlbumUpdated + " 1"; strings.Join(got, ", ") != want {
+		t.Errorf("events = %v, want only the owner's: %s", got, want)
+	}
+
+	if _, resp, err := websocket.DefaultDialer.Dial(url, nil); err == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("scope=mine without an API key: %v, want 401", err)
+	}
+}
+
+// Feeds only carry the albums of one catalogue, and an organization's only
+// to its members
+func TestAlbumEventsStayInTheirCatalogue(t *testing.T) {
+	server := newTestServer(t)
+	member := createTestUser(t, "member@example.com")
+	stranger := createTestUser(t, "stranger@example.com")
+	organization := createTestOrganization(t, server, member, "vinyl-vault", nil)
+	Events.Publish(EventAlbumDeleted, Album{ID: 100}) // so there is an event to resume after
+	base := lastEventID()
+	Events.Publish(EventAlbumCreated, Album{ID: 1, UserID: &member.ID})
+	Events.Publish(EventAlbumCreated, Album{ID: 2, OrganizationID: &organization.ID})
+	Events.Publish(EventAlbumUpdated, Album{ID: 1, UserID: &member.ID})
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/albums/events/ws?last_event_id=" + strconv.FormatUint(base, 10)
+	tests := []struct {
+		name, apiKey, organization string
+		wantStatus                 int
+		want                       string
+	}{
+		{"anonymous", "", "", http.StatusSwitchingProtocols, EventAlbumCreated + " 1, " + EventAlbumUpdated + " 1"},
+		{"member", member.APIKey, "", http.StatusSwitchingProtocols, EventAlbumCreated + " 1, " + EventAlbumUpdated + " 1"},
+		{"member of the organization", member.APIKey, "vinyl-vault", http.StatusSwitchingProtocols, EventAlbumCreated + " 2"},
+		{"anonymous in the organization", "", "vinyl-vault", http.StatusUnauthorized, ""},
+		{"stranger in the organization", stranger.APIKey, "vinyl-vault", http.StatusForbidden, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			if tt.apiKey != "" {
+				header.Set("X-API-Key", tt.apiKey)
+			}
+			if tt.organization != "" {
+				header.Set("X-Organization", tt.organization)
+			}
+			conn, resp, err := websocket.DefaultDialer.Dial(url, header)
+			if resp == nil || resp.StatusCode != tt.wantStatus {
+				t.Fatalf("dial: %v (%v), want %d", err, resp, tt.wantStatus)
+			}
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+			// A marker event in the same catalogue ends the replay
+			marker := Album{ID: 99}
+			if tt.organization != "" {
+				marker.OrganizationID = &organization.ID
+			}
+			Events.Publish(EventAlbumDeleted, marker)
+			var got []string
+			for {
+				var event AlbumEvent
+				if err := conn.ReadJSON(&event); err != nil {
+					t.Fatalf("read after %v: %v", got, err)
+				}
+				if event.Album.ID == 99 {
+					break
+				}
+				got = append(got, event.Type+" "+strconv.FormatUint(uint64(event.Album.ID), 10))
+			}
+			if strings.Join(got, ", ") != tt.want {
+				t.Errorf("events = %v, want %s", got, tt.want)
+			}
+		})
+	}
+}
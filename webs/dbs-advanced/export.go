@@ -0,0 +1,584 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Data exports answer data-subject requests: everything stored about a user,
+// as JSON files in a ZIP archive. Small accounts get the archive straight
+// away; large ones are built by RunExporter and fetched through a signed link
+// that stops working when the export expires.
+
+const (
+	exportSyncAlbums   = 1000 // accounts with more albums are exported in the background
+	exportPollInterval = 5 * time.Second
+	exportLease        = 5 * time.Minute // how long one instance may spend building an export
+	exportMaxAttempts  = 3
+	exportRetryBackoff = time.Minute
+	exportTTL          = 24 * time.Hour
+)
+
+// Export statuses
+const (
+	ExportPending = "pending"
+	ExportReady   = "ready"
+	ExportFailed  = "failed"
+	ExportExpired = "expired" // the archive was deleted after ExpiresAt
+)
+
+// PublicURL is where browsers and API clients reach the server (PUBLIC_URL),
+// used for links in emails
+var PublicURL string
+
+// DataExport is an archive of a user's data being built in the background,
+// or ready to download until ExpiresAt
+type DataExport struct {
+	ID            uint       `gorm:"primaryKey" json:"id" xml:"id"`
+	UserID        uint       `gorm:"index;not null" json:"user_id" xml:"user_id"`                 // whose data it is
+	RequestedByID uint       `gorm:"index;not null" json:"requested_by_id" xml:"requested_by_id"` // the user or the admin who asked
+	Status        string     `gorm:"index;not null" json:"status" xml:"status"`
+	Archive       []byte     `json:"-" xml:"-"`
+	Size          int        `gorm:"not null;default:0" json:"size" xml:"size"` // of the archive, in bytes
+	Attempts      int        `gorm:"not null;default:0" json:"-" xml:"-"`
+	LeaseUntil    *time.Time `json:"-" xml:"-"` // another instance may take it over after this
+	LastError     string     `json:"error,omitempty" xml:"error,omitempty"`
+	ExpiresAt     *time.Time `json:"expires_at" xml:"expires_at,omitempty"`
+	CompletedAt   *time.Time `json:"completed_at" xml:"completed_at,omitempty"`
+	CreatedAt     time.Time  `json:"created_at" xml:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at" xml:"updated_at"`
+
+	DownloadURL string `gorm:"-" json:"download_url,omitempty" xml:"download_url,omitempty"` // set once it is ready
+}
+
+// ExportHandlers serve the routes asking for, checking on and downloading
+// data exports
+type ExportHandlers struct {
+	db *gorm.DB
+}
+
+// NewExportHandlers exports the users in db and keeps the exports there
+func NewExportHandlers(db *gorm.DB) *ExportHandlers {
+	return &ExportHandlers{db: db}
+}
+
+// GET /me/export - Protected: everything stored about the caller as a ZIP of
+// JSON files. Large accounts, or ?async=true, get 202 and an export built in
+// the background instead; poll GET /me/exports/:id or wait for the email.
+func (h *ExportHandlers) getMyExport(c *gin.Context) {
+	user, _ := GetCurrentUser(c)
+	h.respondExport(c, user, user.ID)
+}
+
+// GET /me/exports/:id - Protected: the status of one of the caller's
+// background exports, with a download link once it is ready
+func (h *ExportHandlers) getMyExportStatus(c *gin.Context) {
+	userID, _ := GetCurrentUserID(c)
+	id, ok := uintParam(c, "id")
+	if !ok {
+		return
+	}
+
+	var export DataExport
+	err := h.db.Omit("archive").Where("user_id = ? AND requested_by_id = ?", userID, userID).First(&export, id).Error
+	if err != nil {
+		RespondDBError(c, err, CodeExportNotFound)
+		return
+	}
+	export.setDownloadURL()
+
+	Respond(c, http.StatusOK, SuccessResponse{
+		Success: true,
+		Data:    export,
+	})
+}
+
+// GET /admin/users/:id/export - Admin: GET /me/export for any user. The
+// download link of a background export goes to the admin, not the user.
+func (h *ExportHandlers) getUserExport(c *gin.Context) {
+	adminID, _ := GetCurrentUserID(c)
+	id, ok := uintParam(c, "id")
+	if !ok {
+		return
+	}
+
+	var user User
+	if err := h.db.First(&user, id).Error; err != nil {
+		RespondDBError(c, err, CodeAccountNotFound)
+		return
+	}
+	h.respondExport(c, user, adminID)
+}
+
+// GET /admin/exports/:id - Admin: the status of any export
+func (h *ExportHandlers) getExportStatus(c *gin.Context) {
+	id, ok := uintParam(c, "id")
+	if !ok {
+		return
+	}
+
+	var export DataExport
+	if err := h.db.Omit("archive").First(&export, id).Error; err != nil {
+		RespondDBError(c, err, CodeExportNotFound)
+		return
+	}
+	export.setDownloadURL()
+
+	Respond(c, http.StatusOK, SuccessResponse{
+		Success: true,
+		Data:    export,
+	})
+}
+
+// GET /exports/:id/download - Public: the archive of a ready export. The link
+// in download_url is signed, so it works without an API key (from an email,
+// say) until the export expires.
+func (h *ExportHandlers) getExportDownload(c *gin.Context) {
+	id, ok := uintParam(c, "id")
+	if !ok {
+		return
+	}
+	expires, err := strconv.ParseInt(c.Query("expires"), 10, 64)
+	if err != nil || time.Now().Unix() >= expires ||
+		!hmac.Equal([]byte(c.Query("signature")), []byte(exportSignature(id, expires))) {
+		RespondError(c, CodeDownloadLinkInvalid, "")
+		return
+	}
+
+	var export DataExport
+	if err := h.db.First(&export, id).Error; err != nil {
+		RespondDBError(c, err, CodeExportNotFound)
+		return
+	}
+	if export.Status != ExportReady {
+		RespondError(c, CodeExportNotReady, "")
+		return
+	}
+
+	sendArchive(c, export.UserID, *export.CompletedAt, export.Archive)
+}
+
+// respondExport answers an export request for user: the archive itself for a
+// small account, or 202 and a queued DataExport
+func (h *ExportHandlers) respondExport(c *gin.Context, user User, requestedBy uint) {
+	async := c.Query("async") == "true"
+	if !async {
+		var albums int64
+		if err := h.db.Model(&Album{}).Where("user_id = ?", user.ID).Count(&albums).Error; err != nil {
+			RespondInternalError(c, err)
+			return
+		}
+		async = albums > exportSyncAlbums
+	}
+
+	if !async {
+		now := time.Now()
+		archive, err := BuildExport(h.db, user, now)
+		if err != nil {
+			RespondInternalError(c, err)
+			return
+		}
+		sendArchive(c, user.ID, now, archive)
+		return
+	}
+
+	export := DataExport{UserID: user.ID, RequestedByID: requestedBy, Status: ExportPending}
+	if err := h.db.Create(&export).Error; err != nil {
+		RespondInternalError(c, err)
+		return
+	}
+
+	Respond(c, http.StatusAccepted, SuccessResponse{
+		Success: true,
+		Data:    export,
+	})
+}
+
+func sendArchive(c *gin.Context, userID uint, at time.Time, archive []byte) {
+	filename := fmt.Sprintf("albums-export-%d-%s.zip", userID, at.UTC().Format("20060102-150405"))
+	c.Header("Content-Disposition", `attachment; filename="`+filename+`"`)
+	c.Data(http.StatusOK, "application/zip", archive)
+}
+
+// setDownloadURL fills in DownloadURL for a ready export
+func (e *DataExport) setDownloadURL() {
+	if e.Status == ExportReady && e.ExpiresAt != nil {
+		e.DownloadURL = exportDownloadURL(e.ID, *e.ExpiresAt)
+	}
+}
+
+func exportDownloadURL(id uint, expiresAt time.Time) string {
+	expires := expiresAt.Unix()
+	return fmt.Sprintf("%s/exports/%d/download?expires=%d&signature=%s", PublicURL, id, expires, exportSignature(id, expires))
+}
+
+func exportSignature(id uint, expires int64) string {
+	return sign(fmt.Sprintf("export:%d:%d", id, expires))
+}
+
+// RunExporter builds the exports queued in db and deletes expired archives
+// until ctx is cancelled
+func RunExporter(ctx context.Context, db *gorm.DB) {
+	ticker := time.NewTicker(exportPollInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := BuildQueuedExports(ctx, db); err != nil {
+			log.Printf("exporter: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// BuildQueuedExports expires old archives and builds every pending export
+// that is due. Exports are claimed with a lease, like queued emails, so
+// several instances can share the queue and an export left half-built by a
+// crash is picked up again.
+func BuildQueuedExports(ctx context.Context, db *gorm.DB) error {
+	now := time.Now()
+	err := db.Model(&DataExport{}).Where("status = ? AND expires_at <= ?", ExportReady, now).
+		Updates(map[string]interface{}{"status": ExportExpired, "archive": nil}).Error
+	if err != nil {
+		return err
+	}
+
+	var due []DataExport
+	err = db.Omit("archive").Where("status = ? AND (lease_until IS NULL OR lease_until <= ?)", ExportPending, now).
+		Order("id").Find(&due).Error
+	if err != nil {
+		return err
+	}
+
+	for _, export := range due {
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		claim := db.Model(&DataExport{}).
+			Where("id = ? AND status = ? AND attempts = ?", export.ID, ExportPending, export.Attempts).
+			Updates(map[string]interface{}{"attempts": export.Attempts + 1, "lease_until": time.Now().Add(exportLease)})
+		if claim.Error != nil {
+			return claim.Error
+		}
+		if claim.RowsAffected == 0 {
+			continue
+		}
+		export.Attempts++
+
+		buildErr := buildQueuedExport(db, export)
+		if buildErr == nil {
+			continue
+		}
+
+		changes := map[string]interface{}{"last_error": truncate(buildErr.Error(), 500)}
+		if export.Attempts >= exportMaxAttempts {
+			log.Printf("exporter: giving up on export %d of user %d: %v", export.ID, export.UserID, buildErr)
+			changes["status"] = ExportFailed
+			changes["lease_until"] = nil
+		} else {
+			changes["lease_until"] = time.Now().Add(backoff(export.Attempts, exportRetryBackoff, exportLease))
+		}
+		if err := db.Model(&export).Updates(changes).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// buildQueuedExport stores the archive and emails the download link to
+// whoever asked for it
+func buildQueuedExport(db *gorm.DB, export DataExport) error {
+	var user, requester User
+	if err := db.Unscoped().First(&user, export.UserID).Error; err != nil {
+		return err
+	}
+	if err := db.First(&requester, export.RequestedByID).Error; err != nil {
+		return err
+	}
+
+	now := time.Now()
+	archive, err := BuildExport(db, user, now)
+	if err != nil {
+		return err
+	}
+
+	expiresAt := now.Add(exportTTL).Truncate(time.Second)
+	return db.Transaction(func(tx *gorm.DB) error {
+		done := tx.Model(&DataExport{}).Where("id = ? AND status = ?", export.ID, ExportPending).Updates(map[string]interface{}{
+			"status":       ExportReady,
+			"archive":      archive,
+			"size":         len(archive),
+			"expires_at":   expiresAt,
+			"completed_at": now,
+			"lease_until":  nil,
+			"last_error":   "",
+		})
+		if done.Error != nil || done.RowsAffected == 0 {
+			return done.Error // built by another instance after our lease ran out
+		}
+		return QueueEmail(tx, requester.Email, "export_ready", gin.H{
+			"User": requester, "Subject": user, "URL": exportDownloadURL(export.ID, expiresAt),
+			"Size": len(archive), "Hours": int(exportTTL.Hours()),
+		})
+	})
+}
+
+// exportREADME is README.txt in every archive
+const exportREADME = `This archive holds everything the Albums service stores about one account,
+as it was at generated_at in profile.json.
+
+profile.json   the account: names, email address and when things were set up
+albums.json    the albums the account owns
+keys.json      the API key (only its last characters), two-factor
+               authentication and single sign-on links
+webhooks.json  registered webhooks (without their secrets) and the log of
+               every delivery made to them
+sharing.json   who collaborates on the account's albums, the albums shared
+               with it, albums offered to or by it, its claims on albums
+               without an owner, and the organizations it belongs to
+activity.json  what happened to the account, oldest first: emails sent,
+               tokens issued and used, transfers, claims, exports made
+
+Albums has no reviews and keeps no separate audit log, so there are no files
+for them; activity.json is built from the records listed above. Passwords,
+two-factor secrets and recovery codes are only stored as hashes and are not
+included.
+`
+
+// Files in an export archive
+type (
+	exportedProfile struct {
+		GeneratedAt     time.Time  `json:"generated_at"`
+		ID              uint       `json:"id"`
+		FirstName       string     `json:"first_name"`
+		LastName        string     `json:"last_name"`
+		Email           string     `json:"email"`
+		EmailVerifiedAt *time.Time `json:"email_verified_at"`
+		IsAdmin         bool       `json:"is_admin"`
+		CreatedAt       time.Time  `json:"created_at"`
+		UpdatedAt       time.Time  `json:"updated_at"`
+		DeletedAt       *time.Time `json:"deleted_at,omitempty"`
+	}
+
+	exportedKeys struct {
+		APIKeyEndsWith     string             `json:"api_key_ends_with"`
+		TwoFactorEnabledAt *time.Time         `json:"two_factor_enabled_at"`
+		RecoveryCodesLeft  int                `json:"recovery_codes_left"`
+		SSOIdentities      []exportedIdentity `json:"sso_identities"`
+	}
+
+	exportedIdentity struct {
+		Issuer   string    `json:"issuer"`
+		Subject  string    `json:"subject"`
+		Email    string    `json:"email"`
+		LinkedAt time.Time `json:"linked_at"`
+	}
+
+	exportedWebhooks struct {
+		Webhooks   []Webhook         `json:"webhooks"`
+		Deliveries []WebhookDelivery `json:"deliveries"`
+	}
+
+	exportedSharing struct {
+		Collaborators  []AlbumCollaborator  `json:"collaborators"`   // on the account's albums
+		SharedWithYou  []AlbumCollaborator  `json:"shared_with_you"` // roles on other users' albums
+		AlbumTransfers []AlbumTransfer      `json:"transfers"`
+		AlbumClaims    []AlbumClaim         `json:"claims"`
+		Organizations  []OrganizationMember `json:"organizations"` // the account's memberships
+	}
+
+	exportedEvent struct {
+		Time   time.Time `json:"time"`
+		Event  string    `json:"event"`
+		Detail string    `json:"detail,omitempty"`
+	}
+)
+
+// BuildExport collects everything stored about user in db into a ZIP archive.
+// It takes in the albums user owns in every catalogue.
+func BuildExport(db *gorm.DB, user User, now time.Time) ([]byte, error) {
+	var (
+		albums     []Album
+		webhooks   []Webhook
+		deliveries []WebhookDelivery
+		identities []UserIdentity
+		tokens     []UserToken
+		emails     []QueuedEmail
+		exports    []DataExport
+		sharing    exportedSharing
+		codesLeft  int64
+	)
+	queries := []*gorm.DB{
+		db.Where("user_id = ?", user.ID).Order("id").Find(&albums),
+		db.Where("user_id = ?", user.ID).Order("id").Find(&webhooks),
+		db.Where("webhook_id IN (?)", db.Model(&Webhook{}).Select("id").Where("user_id = ?", user.ID)).Order("id").Find(&deliveries),
+		db.Where("user_id = ?", user.ID).Order("id").Find(&identities),
+		db.Where("user_id = ?", user.ID).Order("id").Find(&tokens),
+		db.Omit("text_body", "html_body").Where(`"to" = ?`, user.Email).Order("id").Find(&emails),
+		db.Omit("archive").Where("user_id = ?", user.ID).Order("id").Find(&exports),
+		db.Where("album_id IN (?)", db.Model(&Album{}).Select("id").Where("user_id = ?", user.ID)).Order("id").Find(&sharing.Collaborators),
+		db.Where("user_id = ?", user.ID).Order("id").Find(&sharing.SharedWithYou),
+		db.Where("from_user_id = ? OR to_user_id = ?", user.ID, user.ID).Order("id").Find(&sharing.AlbumTransfers),
+		db.Where("user_id = ?", user.ID).Order("id").Find(&sharing.AlbumClaims),
+		db.Where("user_id = ?", user.ID).Order("id").Find(&sharing.Organizations),
+		db.Model(&RecoveryCode{}).Where("user_id = ? AND used_at IS NULL", user.ID).Count(&codesLeft),
+	}
+	for _, query := range queries {
+		if query.Error != nil {
+			return nil, query.Error
+		}
+	}
+	if err := fillCollaboratorEmails(db, sharing.Collaborators); err != nil {
+		return nil, err
+	}
+	if err := fillTransferEmails(db, sharing.AlbumTransfers); err != nil {
+		return nil, err
+	}
+	sharing.SharedWithYou = nonNil(sharing.SharedWithYou)
+	sharing.AlbumClaims = nonNil(sharing.AlbumClaims)
+	for i := range sharing.AlbumClaims {
+		sharing.AlbumClaims[i].Email = user.Email
+	}
+	sharing.Organizations = nonNil(sharing.Organizations)
+	for i := range sharing.Organizations {
+		sharing.Organizations[i].Email = user.Email
+	}
+	for i := range sharing.SharedWithYou {
+		sharing.SharedWithYou[i].Email = user.Email
+	}
+
+	profile := exportedProfile{
+		GeneratedAt: now.UTC(), ID: user.ID, FirstName: user.FirstName, LastName: user.LastName,
+		Email: user.Email, EmailVerifiedAt: user.EmailVerifiedAt, IsAdmin: user.IsAdmin,
+		CreatedAt: user.CreatedAt, UpdatedAt: user.UpdatedAt,
+	}
+	if user.DeletedAt.Valid {
+		profile.DeletedAt = &user.DeletedAt.Time
+	}
+
+	keys := exportedKeys{
+		TwoFactorEnabledAt: user.TwoFactorEnabledAt,
+		RecoveryCodesLeft:  int(codesLeft),
+		SSOIdentities:      []exportedIdentity{},
+	}
+	if len(user.APIKey) > 4 {
+		keys.APIKeyEndsWith = user.APIKey[len(user.APIKey)-4:]
+	}
+	for _, identity := range identities {
+		keys.SSOIdentities = append(keys.SSOIdentities, exportedIdentity{identity.Issuer, identity.Subject, identity.Email, identity.CreatedAt})
+	}
+
+	for i := range webhooks {
+		webhooks[i].Secret = ""
+	}
+
+	activity := []exportedEvent{{user.CreatedAt, "account.created", ""}}
+	if user.EmailVerifiedAt != nil {
+		activity = append(activity, exportedEvent{*user.EmailVerifiedAt, "email.verified", user.Email})
+	}
+	if user.TwoFactorEnabledAt != nil {
+		activity = append(activity, exportedEvent{*user.TwoFactorEnabledAt, "two_factor.enabled", ""})
+	}
+	for _, identity := range identities {
+		activity = append(activity, exportedEvent{identity.CreatedAt, "sso.linked", identity.Issuer})
+	}
+	for _, token := range tokens {
+		activity = append(activity, exportedEvent{token.CreatedAt, "token.issued", token.Purpose})
+		if token.UsedAt != nil {
+			activity = append(activity, exportedEvent{*token.UsedAt, "token.used", token.Purpose})
+		}
+	}
+	for _, email := range emails {
+		activity = append(activity, exportedEvent{email.CreatedAt, "email.queued", email.Subject})
+		if email.SentAt != nil {
+			activity = append(activity, exportedEvent{*email.SentAt, "email.sent", email.Subject})
+		}
+	}
+	for _, transfer := range sharing.AlbumTransfers {
+		direction := "transfer.received"
+		if transfer.FromUserID == user.ID {
+			direction = "transfer.offered"
+		}
+		activity = append(activity, exportedEvent{transfer.CreatedAt, direction, fmt.Sprintf("album %d", transfer.AlbumID)})
+		if transfer.RespondedAt != nil {
+			activity = append(activity, exportedEvent{*transfer.RespondedAt, "transfer." + transfer.Status, fmt.Sprintf("album %d", transfer.AlbumID)})
+		}
+	}
+	for _, claim := range sharing.AlbumClaims {
+		activity = append(activity, exportedEvent{claim.CreatedAt, "claim.made", fmt.Sprintf("album %d", claim.AlbumID)})
+		if claim.ReviewedAt != nil {
+			activity = append(activity, exportedEvent{*claim.ReviewedAt, "claim." + claim.Status, fmt.Sprintf("album %d", claim.AlbumID)})
+		}
+	}
+	for _, export := range exports {
+		detail := ""
+		if export.RequestedByID != user.ID {
+			detail = "requested by an administrator"
+		}
+		activity = append(activity, exportedEvent{export.CreatedAt, "export.requested", detail})
+	}
+	sort.SliceStable(activity, func(i, j int) bool { return activity[i].Time.Before(activity[j].Time) })
+
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	readme, err := archive.CreateHeader(&zip.FileHeader{Name: "README.txt", Method: zip.Deflate, Modified: now})
+	if err == nil {
+		_, err = readme.Write([]byte(exportREADME))
+	}
+	if err != nil {
+		return nil, err
+	}
+	files := []struct {
+		name string
+		data interface{}
+	}{
+		{"profile.json", profile},
+		{"albums.json", nonNil(albums)},
+		{"keys.json", keys},
+		{"webhooks.json", exportedWebhooks{nonNil(webhooks), nonNil(deliveries)}},
+		{"sharing.json", exportedSharing{nonNil(sharing.Collaborators), sharing.SharedWithYou, nonNil(sharing.AlbumTransfers), sharing.AlbumClaims, sharing.Organizations}},
+		{"activity.json", activity},
+	}
+	for _, file := range files {
+		body, err := json.MarshalIndent(file.data, "", "  ")
+		if err != nil {
+			return nil, fmt.Errorf("export %s: %w", file.name, err)
+		}
+		w, err := archive.CreateHeader(&zip.FileHeader{Name: file.name, Method: zip.Deflate, Modified: now})
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write(body); err != nil {
+			return nil, err
+		}
+	}
+	if err := archive.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// nonNil makes an empty result encode as [] rather than null
+func nonNil[T any](s []T) []T {
+	if s == nil {
+		return []T{}
+	}
+	return s
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// downloadExport GETs path with apiKey and returns the status and, for a
+// ZIP, the files in it by name
+func downloadExport(t *testing.T, server *httptest.Server, apiKey, path string) (int, map[string]string) {
+	t.Helper()
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL+path, nil)
+	req.Header.Set("Accept", "application/zip")
+	if apiKey != "" {
+		req.Header.Set("X-API-Key", apiKey)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET %s: %v", path, err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.Header.Get("Content-Type") != "application/zip" {
+		return resp.StatusCode, nil
+	}
+
+	archive, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		t.Fatalf("GET %s: not a ZIP archive: %v", path, err)
+	}
+	files := map[string]string{}
+	for _, file := range archive.File {
+		r, _ := file.Open()
+		content, _ := io.ReadAll(r)
+		r.Close()
+		files[file.Name] = string(content)
+	}
+	return resp.StatusCode, files
+}
+
+func TestExport(t *testing.T) {
+	setupTestDB(t)
+	user := createTestUser(t, "subject@example.com")
+	createTestAlbum(t, "Blue Train", &user.ID)
+	createTestAlbum(t, "Someone Else's", nil)
+	server := httptest.NewServer(setupRouter(NewServices(DB)))
+	t.Cleanup(server.Close)
+
+	var webhook Webhook
+	apiCall(t, server, user.APIKey, http.MethodPost, "/webhooks", WebhookInput{URL: "https://example.com/hook", Events: []string{EventAlbumUpdated}}, &webhook)
+
+	status, files := downloadExport(t, server, user.APIKey, "/me/export")
+	if status != http.StatusOK {
+		t.Fatalf("GET /me/export = %d", status)
+	}
+	for _, name := range []string{"README.txt", "profile.json", "albums.json", "keys.json", "webhooks.json", "activity.json"} {
+		if _, ok := files[name]; !ok {
+			t.Errorf("archive has no %s", name)
+		}
+	}
+	if !strings.Contains(files["profile.json"], user.Email) {
+		t.Errorf("profile.json = %s, want the email address", files["profile.json"])
+	}
+	if !strings.Contains(files["albums.json"], "Blue Train") || strings.Contains(files["albums.json"], "Someone Else's") {
+		t.Errorf("albums.json = %s, want only the user's album", files["albums.json"])
+	}
+	if !strings.Contains(files["webhooks.json"], "https://example.com/hook") {
+		t.Errorf("webhooks.json = %s, want the webhook", files["webhooks.json"])
+	}
+	for name, content := range files {
+		if strings.Contains(content, user.APIKey) || strings.Contains(content, webhook.Secret) {
+			t.Errorf("%s gives away the API key or the webhook secret", name)
+		}
+	}
+}
+
+func TestBackgroundExport(t *testing.T) {
+	setupTestDB(t)
+	user := createTestUser(t, "patient@example.com")
+	other := createTestUser(t, "nosy@example.com")
+	createTestAlbum(t, "Giant Steps", &user.ID)
+	server := httptest.NewServer(setupRouter(NewServices(DB)))
+	t.Cleanup(server.Close)
+	PublicURL = server.URL
+	t.Cleanup(func() { PublicURL = "" })
+
+	var export DataExport
+	if status := apiCall(t, server, user.APIKey, http.MethodGet, "/me/export?async=true", nil, &export); status != http.StatusAccepted {
+		t.Fatalf("GET /me/export?async=true = %d, want 202", status)
+	}
+	if export.Status != ExportPending {
+		t.Fatalf("new export is %s, want pending", export.Status)
+	}
+
+	if err := BuildQueuedExports(context.Background(), DB); err != nil {
+		t.Fatalf("BuildQueuedExports: %v", err)
+	}
+	path := "/me/exports/" + itoa(export.ID)
+	if status := apiCall(t, server, user.APIKey, http.MethodGet, path, nil, &export); status != http.StatusOK {
+		t.Fatalf("GET %s = %d", path, status)
+	}
+	if export.Status != ExportReady || export.DownloadURL == "" {
+		t.Fatalf("after building: status %s, download_url %q; want ready with a link", export.Status, export.DownloadURL)
+	}
+	if status := apiCall(t, server, other.APIKey, http.MethodGet, path, nil, nil); status != http.StatusNotFound {
+		t.Errorf("GET %s by another user = %d, want 404", path, status)
+	}
+
+	var email QueuedEmail
+	if err := DB.Where("template = ? AND \"to\" = ?", "export_ready", user.Email).First(&email).Error; err != nil {
+		t.Fatalf("no export_ready email: %v", err)
+	}
+	if !strings.Contains(email.TextBody, export.DownloadURL) {
+		t.Errorf("email doesn't have the download link %s", export.DownloadURL)
+	}
+
+	link := strings.TrimPrefix(export.DownloadURL, server.URL)
+	status, files := downloadExport(t, server, "", link)
+	if status != http.StatusOK || !strings.Contains(files["albums.json"], "Giant Steps") {
+		t.Errorf("download without an API key = %d with albums %q", status, files["albums.json"])
+	}
+
+	tests := []struct {
+		name string
+		link string
+	}{
+		{"forged signature", link[:strings.Index(link, "signature=")] + "signature=forged"},
+		{"later expiry", strings.Replace(link, "expires=", "expires=9", 1)},
+		{"another export", strings.Replace(link, "/exports/"+itoa(export.ID)+"/", "/exports/"+itoa(export.ID+1)+"/", 1)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if status, _ := downloadExport(t, server, "", tt.link); status != http.StatusForbidden {
+				t.Errorf("download = %d, want 403", status)
+			}
+		})
+	}
+
+	// Once it expires the archive is deleted
+	DB.Model(&DataExport{}).Where("id = ?", export.ID).Update("expires_at", time.Now().Add(-time.Minute))
+	if err := BuildQueuedExports(context.Background(), DB); err != nil {
+		t.Fatalf("BuildQueuedExports: %v", err)
+	}
+	var expired DataExport
+	DB.First(&expired, export.ID)
+	if expired.Status != ExportExpired || expired.Archive != nil {
+		t.Errorf("after expiring: status %s with %d bytes, want expired and empty", expired.Status, len(expired.Archive))
+	}
+}
+
+func TestAdminExport(t *testing.T) {
+	setupTestDB(t)
+	admin := createTestUser(t, "admin@example.com")
+	DB.Model(&admin).Update("is_admin", true)
+	user := createTestUser(t, "subject@example.com")
+	server := httptest.NewServer(setupRouter(NewServices(DB)))
+	t.Cleanup(server.Close)
+
+	path := "/admin/users/" + itoa(user.ID) + "/export"
+	tests := []struct {
+		name   string
+		apiKey string
+		path   string
+		want   int
+	}{
+		{"not an admin", user.APIKey, path, http.StatusForbidden},
+		{"admin", admin.APIKey, path, http.StatusOK},
+		{"no such user", admin.APIKey, "/admin/users/999/export", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, files := downloadExport(t, server, tt.apiKey, tt.path)
+			if status != tt.want {
+				t.Fatalf("GET %s = %d, want %d", tt.path, status, tt.want)
+			}
+			if status == http.StatusOK && !strings.Contains(files["profile.json"], user.Email) {
+				t.Errorf("profile.json = %s, want the user's", files["profile.json"])
+			}
+		})
+	}
+
+	var export DataExport
+	if status := apiCall(t, server, admin.APIKey, http.MethodGet, path+"?async=true", nil, &export); status != http.StatusAccepted {
+		t.Fatalf("GET %s?async=true = %d, want 202", path, status)
+	}
+	if export.UserID != user.ID || export.RequestedByID != admin.ID {
+		t.Errorf("export of user %d requested by %d, want %d by %d", export.UserID, export.RequestedByID, user.ID, admin.ID)
+	}
+	if status := apiCall(t, server, admin.APIKey, http.MethodGet, "/admin/exports/"+itoa(export.ID), nil, nil); status != http.StatusOK {
+		t.Errorf("GET /admin/exports/%d = %d", export.ID, status)
+	}
+	// The user didn't ask for it, so it isn't theirs to see
+	if status := apiCall(t, server, user.APIKey, http.MethodGet, "/me/exports/"+itoa(export.ID), nil, nil); status != http.StatusNotFound {
+		t.Errorf("GET /me/exports/%d by the user = %d, want 404", export.ID, status)
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-contrib/sse"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	heartbeatInterval = 15 * time.Second
+	feedWriteTimeout  = 10 * time.Second
+)
+
+// resetMessage tells a resuming client it missed events and should reload GET /albums
+const resetMessage = "Some events are no longer available, reload the album list"
+
+var upgrader = websocket.Upgrader{} // same-origin only, the default
+
+// GET /albums/events - Album changes as Server-Sent Events. ?scope=mine (needs an
+// API key) limits them to the caller's albums, X-Organization picks the
+// catalogue; Last-Event-ID resumes the stream.
+func getAlbumEvents(c *gin.Context) {
+	filter, ok := eventFilter(c)
+	if !ok {
+		return
+	}
+
+	lastEventID := c.GetHeader("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = c.Query("last_event_id")
+	}
+
+	replay, complete, sub := Events.Subscribe(parseEventID(lastEventID))
+	defer sub.Close()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no") // stop nginx from holding events back
+	c.Status(http.StatusOK)
+
+	if !complete {
+		c.Render(-1, sse.Event{Event: "reset", Data: gin.H{"message": resetMessage}})
+	}
+	for _, event := range replay {
+		if filter(event) {
+			c.Render(-1, albumSSE(event))
+		}
+	}
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case event, ok := <-sub.Events:
+			if !ok {
+				return // too slow: the client reconnects with Last-Event-ID
+			}
+			if filter(event) {
+				c.Render(-1, albumSSE(event))
+				c.Writer.Flush()
+			}
+		case <-heartbeat.C:
+			c.Writer.WriteString(": heartbeat\n\n")
+			c.Writer.Flush()
+		}
+	}
+}
+
+// GET /albums/events/ws - The same feed over a WebSocket, one JSON event per
+// message. Resume with ?last_event_id=.
+func getAlbumEventsWS(c *gin.Context) {
+	filter, ok := eventFilter(c)
+	if !ok {
+		return
+	}
+
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		return // Upgrade has already answered with an HTTP error
+	}
+	defer conn.Close()
+
+	replay, complete, sub := Events.Subscribe(parseEventID(c.Query("last_event_id")))
+	defer sub.Close()
+
+	// The client only sends pongs and close frames; a missing pong or a read
+	// error ends the connection.
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	defer cancel()
+	conn.SetReadDeadline(time.Now().Add(2 * heartbeatInterval))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(2 * heartbeatInterval))
+	})
+	go func() {
+		defer cancel()
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	send := func(message interface{}) bool {
+		conn.SetWriteDeadline(time.Now().Add(feedWriteTimeout))
+		return conn.WriteJSON(message) == nil
+	}
+
+	if !complete && !send(gin.H{"type": "reset", "message": resetMessage}) {
+		return
+	}
+	for _, event := range replay {
+		if filter(event) && !send(event) {
+			return
+		}
+	}
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event, ok := <-sub.Events:
+			if !ok {
+				conn.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "too far behind, reconnect with last_event_id"),
+					time.Now().Add(feedWriteTimeout))
+				return
+			}
+			if filter(event) && !send(event) {
+				return
+			}
+		case <-heartbeat.C:
+			if conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(feedWriteTimeout)) != nil {
+				return
+			}
+		}
+	}
+}
+
+// eventFilter reads ?scope=: "all" (the default, like GET /albums) or "mine",
+// which needs an API key. Either way only the albums of the request's
+// catalogue are sent, the shared one unless X-Organization picks a member's
+// organization. It answers the request itself when scope is wrong.
+func eventFilter(c *gin.Context) (func(AlbumEvent) bool, bool) {
+	tenant := CurrentTenant(c)
+	switch c.DefaultQuery("scope", "all") {
+	case "all":
+		return func(event AlbumEvent) bool { return tenant.Contains(event.Album) }, true
+	case "mine":
+		userID, ok := GetCurrentUserID(c)
+		if !ok {
+			RespondError(c, CodeAPIKeyRequired, "")
+			return nil, false
+		}
+		return func(event AlbumEvent) bool {
+			return tenant.Contains(event.Album) && event.Album.UserID != nil && *event.Album.UserID == userID
+		}, true
+	default:
+		RespondValidationError(c, "", []ValidationIssue{{Location: "query", Field: "scope", Message: `must be "all" or "mine"`}})
+		return nil, false
+	}
+}
+
+func albumSSE(event AlbumEvent) sse.Event {
+	return sse.Event{Id: strconv.FormatUint(event.ID, 10), Event: event.Type, Data: event}
+}
+
+// parseEventID reads a Last-Event-ID; anything unreadable means "from now"
+func parseEventID(value string) uint64 {
+	id, _ := strconv.ParseUint(value, 10, 64)
+	return id
+}
@@ -0,0 +1,523 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+)
+
+// maxQueryComplexity caps the work one GraphQL request may ask for, as
+// counted by queryComplexity
+const maxQueryComplexity = 1000
+
+// graphQLSchema is built once at startup from the types below
+var graphQLSchema graphql.Schema
+
+func init() {
+	var err error
+	graphQLSchema, err = graphql.NewSchema(graphql.SchemaConfig{
+		Query:    queryType,
+		Mutation: mutationType,
+	})
+	if err != nil {
+		log.Fatal("Failed to build GraphQL schema:", err)
+	}
+}
+
+// Struct fields resolve by name (firstName -> FirstName), so only fields
+// that need a lookup or a privacy check have their own resolver.
+var userType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "User",
+	Fields: graphql.Fields{
+		"id":        &graphql.Field{Type: graphql.NewNonNull(graphql.ID)},
+		"firstName": &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+		"lastName":  &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+		"email": &graphql.Field{
+			Type:        graphql.String,
+			Description: "Only visible to the user themselves",
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				// Album owners come without it, so it is the viewer's own
+				viewer, ok := GetCurrentUser(graphQLState(p.Context).gin)
+				if !ok || viewer.ID != p.Source.(User).ID {
+					return nil, nil
+				}
+				return viewer.Email, nil
+			},
+		},
+		"createdAt": &graphql.Field{Type: graphql.NewNonNull(graphql.DateTime)},
+	},
+})
+
+var albumType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "Album",
+	Fields: graphql.Fields{
+		"id":     &graphql.Field{Type: graphql.NewNonNull(graphql.ID)},
+		"title":  &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+		"artist": &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+		"price":  &graphql.Field{Type: graphql.NewNonNull(graphql.Float)},
+		"userId": &graphql.Field{
+			Type: graphql.ID,
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				if album := p.Source.(Album); album.UserID != nil {
+					return *album.UserID, nil
+				}
+				return nil, nil
+			},
+		},
+		"user": &graphql.Field{
+			Type:        userType,
+			Description: "The owner, or null for albums nobody owns",
+			// AlbumRepository loads the owners of a page with one query, so
+			// a list costs no lookup per album
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				if album := p.Source.(Album); album.User != nil {
+					return *album.User, nil
+				}
+				return nil, nil
+			},
+		},
+		"createdAt": &graphql.Field{Type: graphql.NewNonNull(graphql.DateTime)},
+		"updatedAt": &graphql.Field{Type: graphql.NewNonNull(graphql.DateTime)},
+	},
+})
+
+var albumInputType = graphql.NewInputObject(graphql.InputObjectConfig{
+	Name: "AlbumInput",
+	Fields: graphql.InputObjectConfigFieldMap{
+		"title":  &graphql.InputObjectFieldConfig{Type: graphql.NewNonNull(graphql.String)},
+		"artist": &graphql.InputObjectFieldConfig{Type: graphql.NewNonNull(graphql.String)},
+		"price":  &graphql.InputObjectFieldConfig{Type: graphql.NewNonNull(graphql.Float)},
+	},
+})
+
+// AlbumUpdateInput fields are optional; only those sent are changed, like PUT /albums/:id
+var albumUpdateInputType = graphql.NewInputObject(graphql.InputObjectConfig{
+	Name: "AlbumUpdateInput",
+	Fields: graphql.InputObjectConfigFieldMap{
+		"title":  &graphql.InputObjectFieldConfig{Type: graphql.String},
+		"artist": &graphql.InputObjectFieldConfig{Type: graphql.String},
+		"price":  &graphql.InputObjectFieldConfig{Type: graphql.Float},
+	},
+})
+
+var queryType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "Query",
+	Fields: graphql.Fields{
+		"albums": &graphql.Field{
+			Type:        graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(albumType))),
+			Description: "Public: all albums ordered by ID, at most " + strconv.Itoa(maxPageSize) + " per request",
+			Args: graphql.FieldConfigArgument{
+				"limit":  &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: maxPageSize},
+				"offset": &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: 0},
+			},
+			Resolve: resolveAlbums,
+		},
+		"album": &graphql.Field{
+			Type:        albumType,
+			Description: "An album you own or one without an owner (requires an API key)",
+			Args: graphql.FieldConfigArgument{
+				"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+			},
+			Resolve: resolveAlbum,
+		},
+		"me": &graphql.Field{
+			Type:        userType,
+			Description: "The user the API key belongs to (requires an API key)",
+			Resolve:     resolveMe,
+		},
+	},
+})
+
+var mutationType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "Mutation",
+	Fields: graphql.Fields{
+		"createAlbum": &graphql.Field{
+			Type: albumType,
+			Args: graphql.FieldConfigArgument{
+				"input": &graphql.ArgumentConfig{Type: graphql.NewNonNull(albumInputType)},
+			},
+			Resolve: resolveCreateAlbum,
+		},
+		"updateAlbum": &graphql.Field{
+			Type:        albumType,
+			Description: "Update an album you own or edit. An album without an owner is read-only until a claim on it is approved.",
+			Args: graphql.FieldConfigArgument{
+				"id":    &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+				"input": &graphql.ArgumentConfig{Type: graphql.NewNonNull(albumUpdateInputType)},
+			},
+			Resolve: resolveUpdateAlbum,
+		},
+		"deleteAlbum": &graphql.Field{
+			Type:        graphql.ID,
+			Description: "Delete an album you own (an administrator, one without an owner) and return its ID",
+			Args: graphql.FieldConfigArgument{
+				"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+			},
+			Resolve: resolveDeleteAlbum,
+		},
+	},
+})
+
+// GraphQLRequest is the body of POST /graphql
+type GraphQLRequest struct {
+	Query         string                 `json:"query" binding:"required"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// POST /graphql - Run a GraphQL query or mutation. An API key is optional;
+// fields that need one fail with API_KEY_REQUIRED in "errors".
+func (h *AlbumHandlers) postGraphQL(c *gin.Context) {
+	var req GraphQLRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, graphQLFailure(newGraphQLError(CodeValidationFailed, err.Error())))
+		return
+	}
+
+	doc, err := parser.Parse(parser.ParseParams{Source: req.Query})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, graphQLFailure(err))
+		return
+	}
+
+	if result := graphql.ValidateDocument(&graphQLSchema, doc, nil); !result.IsValid {
+		c.JSON(http.StatusBadRequest, &graphql.Result{Errors: withErrorCodes(result.Errors, CodeValidationFailed)})
+		return
+	}
+
+	if cost := queryComplexity(doc, req.OperationName, req.Variables); cost > maxQueryComplexity {
+		message := fmt.Sprintf("Query complexity %d exceeds the limit of %d", cost, maxQueryComplexity)
+		c.JSON(http.StatusBadRequest, graphQLFailure(newGraphQLError(CodeQueryTooComplex, message)))
+		return
+	}
+
+	state := &graphQLRequestState{gin: c, albums: h.albums}
+	result := graphql.Execute(graphql.ExecuteParams{
+		Schema:        graphQLSchema,
+		AST:           doc,
+		OperationName: req.OperationName,
+		Args:          req.Variables,
+		Context:       context.WithValue(c.Request.Context(), graphQLStateKey{}, state),
+	})
+	result.Errors = withErrorCodes(result.Errors, "")
+
+	c.JSON(http.StatusOK, result)
+}
+
+// graphQLRequestState is what resolvers need from the HTTP request
+type graphQLRequestState struct {
+	gin    *gin.Context
+	albums *AlbumService
+}
+
+type graphQLStateKey struct{}
+
+func graphQLState(ctx context.Context) *graphQLRequestState {
+	return ctx.Value(graphQLStateKey{}).(*graphQLRequestState)
+}
+
+// graphQLViewer returns the ID of the user whose API key was sent, if any
+func graphQLViewer(ctx context.Context) (uint, bool) {
+	return GetCurrentUserID(graphQLState(ctx).gin)
+}
+
+// graphQLTenant returns the catalogue picked with X-Organization
+func graphQLTenant(ctx context.Context) Tenant {
+	return CurrentTenant(graphQLState(ctx).gin)
+}
+
+// requireViewer is the GraphQL side of AuthMiddleware
+func requireViewer(ctx context.Context) (uint, error) {
+	userID, ok := graphQLViewer(ctx)
+	if !ok {
+		return 0, newGraphQLError(CodeAPIKeyRequired, "")
+	}
+	return userID, nil
+}
+
+// graphQLError carries an ErrorCode into the "extensions" of a GraphQL error
+type graphQLError struct {
+	Code    ErrorCode
+	Message string
+}
+
+func newGraphQLError(code ErrorCode, message string) *graphQLError {
+	if message == "" {
+		message = errorCatalogue[code].Message
+	}
+	return &graphQLError{Code: code, Message: message}
+}
+
+func (e *graphQLError) Error() string { return e.Message }
+
+func (e *graphQLError) Extensions() map[string]interface{} {
+	return map[string]interface{}{"code": e.Code}
+}
+
+// graphQLDBError is RespondDBError for resolvers: client errors keep their
+// code and anything else is logged and hidden behind INTERNAL_ERROR.
+func graphQLDBError(ctx context.Context, err error, notFound ErrorCode) error {
+	if code, ok := clientErrorCode(err, notFound); ok {
+		return newGraphQLError(code, clientErrorMessage(err))
+	}
+	log.Printf("[%s] POST /graphql: %v", GetRequestID(graphQLState(ctx).gin), err)
+	return newGraphQLError(CodeInternal, "")
+}
+
+func graphQLFailure(err error) *graphql.Result {
+	return &graphql.Result{Errors: withErrorCodes(gqlerrors.FormatErrors(err), CodeValidationFailed)}
+}
+
+// withErrorCodes sets extensions.code on every error. graphql-go drops the
+// extensions of errors returned from thunks, so the code is recovered from
+// the original error; errors without one get fallback (if not empty).
+func withErrorCodes(errs []gqlerrors.FormattedError, fallback ErrorCode) []gqlerrors.FormattedError {
+	for i, formatted := range errs {
+		if formatted.Extensions != nil {
+			continue
+		}
+		code := fallback
+		for err := formatted.OriginalError(); err != nil; {
+			if gqlErr, ok := err.(*graphQLError); ok {
+				code = gqlErr.Code
+				break
+			}
+			switch wrapped := err.(type) {
+			case gqlerrors.FormattedError:
+				err = wrapped.OriginalError()
+			case *gqlerrors.Error:
+				err = wrapped.OriginalError
+			default:
+				err = nil
+			}
+		}
+		if code != "" {
+			errs[i].Extensions = map[string]interface{}{"code": code}
+		}
+	}
+	return errs
+}
+
+// query { albums(limit, offset) }
+func resolveAlbums(p graphql.ResolveParams) (interface{}, error) {
+	limit, offset := p.Args["limit"].(int), p.Args["offset"].(int)
+	if limit < 1 || limit > maxPageSize {
+		return nil, newGraphQLError(CodeValidationFailed, "limit must be an integer between 1 and "+strconv.Itoa(maxPageSize))
+	}
+	if offset < 0 {
+		return nil, newGraphQLError(CodeValidationFailed, "offset must be a non-negative integer")
+	}
+
+	albums, err := graphQLState(p.Context).albums.List(graphQLTenant(p.Context), nil, Page{Limit: limit, Offset: offset})
+	if err != nil {
+		return nil, graphQLDBError(p.Context, err, CodeAlbumNotFound)
+	}
+	return albums, nil
+}
+
+// query { album(id) }
+func resolveAlbum(p graphql.ResolveParams) (interface{}, error) {
+	userID, albumID, err := albumArgs(p)
+	if err != nil {
+		return nil, err
+	}
+
+	album, err := graphQLState(p.Context).albums.Get(graphQLTenant(p.Context), userID, albumID)
+	if err != nil {
+		return nil, graphQLDBError(p.Context, err, CodeAlbumNotFound)
+	}
+	return album, nil
+}
+
+// query { me }
+func resolveMe(p graphql.ResolveParams) (interface{}, error) {
+	if _, err := requireViewer(p.Context); err != nil {
+		return nil, err
+	}
+	user, _ := GetCurrentUser(graphQLState(p.Context).gin)
+	return user, nil
+}
+
+// mutation { createAlbum(input) }
+func resolveCreateAlbum(p graphql.ResolveParams) (interface{}, error) {
+	userID, err := requireViewer(p.Context)
+	if err != nil {
+		return nil, err
+	}
+
+	album, err := graphQLState(p.Context).albums.Create(graphQLTenant(p.Context), userID, albumInputArg(p))
+	if err != nil {
+		return nil, graphQLDBError(p.Context, err, CodeAlbumNotFound)
+	}
+	return album, nil
+}
+
+// mutation { updateAlbum(id, input) }
+func resolveUpdateAlbum(p graphql.ResolveParams) (interface{}, error) {
+	userID, albumID, err := albumArgs(p)
+	if err != nil {
+		return nil, err
+	}
+
+	album, err := graphQLState(p.Context).albums.Update(graphQLTenant(p.Context), userID, albumID, albumInputArg(p))
+	if err != nil {
+		return nil, graphQLDBError(p.Context, err, CodeAlbumNotFound)
+	}
+	return album, nil
+}
+
+// mutation { deleteAlbum(id) }
+func resolveDeleteAlbum(p graphql.ResolveParams) (interface{}, error) {
+	userID, albumID, err := albumArgs(p)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := graphQLState(p.Context).albums.Delete(graphQLTenant(p.Context), userID, albumID); err != nil {
+		return nil, graphQLDBError(p.Context, err, CodeAlbumNotFound)
+	}
+	return albumID, nil
+}
+
+// albumArgs returns the viewer and the "id" argument of fields that, like
+// /albums/:id, need an API key
+func albumArgs(p graphql.ResolveParams) (uint, uint, error) {
+	userID, err := requireViewer(p.Context)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	albumID, err := strconv.ParseUint(p.Args["id"].(string), 10, 0)
+	if err != nil || albumID == 0 {
+		return 0, 0, newGraphQLError(CodeAlbumNotFound, "")
+	}
+	return userID, uint(albumID), nil
+}
+
+// albumInputArg converts the "input" argument; fields not sent stay nil
+func albumInputArg(p graphql.ResolveParams) AlbumInput {
+	fields := p.Args["input"].(map[string]interface{})
+
+	var input AlbumInput
+	if title, ok := fields["title"].(string); ok {
+		input.Title = &title
+	}
+	if artist, ok := fields["artist"].(string); ok {
+		input.Artist = &artist
+	}
+	if price, ok := fields["price"].(float64); ok {
+		input.Price = &price
+	}
+	return input
+}
+
+// queryComplexity estimates the cost of the operation that will run: every
+// field costs 1, and the selections under a list field count once per item
+// it can return (its limit argument, or maxPageSize). Aliases and fragments
+// are counted where they are used, so repeating a field doesn't get it free.
+func queryComplexity(doc *ast.Document, operationName string, variables map[string]interface{}) int {
+	fragments := map[string]*ast.FragmentDefinition{}
+	var operation *ast.OperationDefinition
+	for _, def := range doc.Definitions {
+		switch def := def.(type) {
+		case *ast.FragmentDefinition:
+			fragments[def.Name.Value] = def
+		case *ast.OperationDefinition:
+			if operation == nil && (operationName == "" || (def.Name != nil && def.Name.Value == operationName)) {
+				operation = def
+			}
+		}
+	}
+	if operation == nil {
+		return 0
+	}
+
+	root := graphQLSchema.QueryType()
+	if operation.Operation == ast.OperationTypeMutation {
+		root = graphQLSchema.MutationType()
+	}
+
+	var cost func(set *ast.SelectionSet, parent *graphql.Object) int
+	cost = func(set *ast.SelectionSet, parent *graphql.Object) int {
+		if set == nil || parent == nil {
+			return 0
+		}
+
+		total := 0
+		for _, selection := range set.Selections {
+			switch selection := selection.(type) {
+			case *ast.Field:
+				total++
+				field, ok := parent.Fields()[selection.Name.Value]
+				if !ok {
+					continue // __typename and introspection
+				}
+
+				child, isList := unwrapGraphQLType(field.Type)
+				items := 1
+				if isList {
+					items = listSize(selection, variables)
+				}
+				total += items * cost(selection.SelectionSet, child)
+			case *ast.InlineFragment:
+				total += cost(selection.SelectionSet, parent)
+			case *ast.FragmentSpread:
+				if fragment, ok := fragments[selection.Name.Value]; ok {
+					total += cost(fragment.SelectionSet, parent)
+				}
+			}
+		}
+		return total
+	}
+
+	return cost(operation.SelectionSet, root)
+}
+
+// unwrapGraphQLType strips NonNull and List, returning the object type (nil
+// for scalars) and whether a list was found
+func unwrapGraphQLType(t graphql.Type) (*graphql.Object, bool) {
+	isList := false
+	for {
+		switch wrapped := t.(type) {
+		case *graphql.NonNull:
+			t = wrapped.OfType
+		case *graphql.List:
+			isList = true
+			t = wrapped.OfType
+		case *graphql.Object:
+			return wrapped, isList
+		default:
+			return nil, isList
+		}
+	}
+}
+
+// listSize is the limit argument of a list field, from a literal or a
+// variable, capped at maxPageSize like the resolver does
+func listSize(field *ast.Field, variables map[string]interface{}) int {
+	for _, arg := range field.Arguments {
+		if arg.Name.Value != "limit" {
+			continue
+		}
+
+		switch value := arg.Value.(type) {
+		case *ast.IntValue:
+			if n, err := strconv.Atoi(value.Value); err == nil && n > 0 {
+				return min(n, maxPageSize)
+			}
+		case *ast.Variable:
+			if n, ok := variables[value.Name.Value].(float64); ok && n > 0 {
+				return int(min(n, maxPageSize))
+			}
+		}
+	}
+	return maxPageSize
+}
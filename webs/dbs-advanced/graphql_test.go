@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+// graphQLResponse is the body of a POST /graphql response
+type graphQLResponse struct {
+	Data   map[string]json.RawMessage `json:"data"`
+	Errors []struct {
+		Message    string `json:"message"`
+		Extensions struct {
+			Code ErrorCode `json:"code"`
+		} `json:"extensions"`
+	} `json:"errors"`
+}
+
+// postGraphQL sends body (a GraphQLRequest, or anything else to send as is)
+// and returns the status and decoded response
+func postGraphQL(t *testing.T, server *httptest.Server, apiKey string, body interface{}) (int, graphQLResponse) {
+	t.Helper()
+
+	encoded, _ := json.Marshal(body)
+	req, _ := http.NewRequest(http.MethodPost, server.URL+"/graphql", bytes.NewReader(encoded))
+	req.Header.Set("Content-Type", "application/json")
+	if apiKey != "" {
+		req.Header.Set("X-API-Key", apiKey)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("POST /graphql: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var result graphQLResponse
+	json.NewDecoder(resp.Body).Decode(&result)
+	return resp.StatusCode, result
+}
+
+// countUserQueries counts the SELECTs on users the test database runs from
+// now on
+func countUserQueries(t *testing.T) *atomic.Int32 {
+	t.Helper()
+
+	var count atomic.Int32
+	err := DB.Callback().Query().After("gorm:query").Register("test:count_users", func(tx *gorm.DB) {
+		if tx.Statement.Table == "users" {
+			count.Add(1)
+		}
+	})
+	if err != nil {
+		t.Fatalf("register query counter: %v", err)
+	}
+	return &count
+}
+
+// The owners of a page of albums are loaded with one query, however many
+// albums and owners are on it
+func TestGraphQLLoadsOwnersInOneQuery(t *testing.T) {
+	server := newTestServer(t)
+	var owners []User
+	for i := range 5 {
+		owner := createTestUser(t, fmt.Sprintf("owner%d@example.com", i))
+		owners = append(owners, owner)
+		for j := range 4 {
+			createTestAlbum(t, fmt.Sprintf("Album %d-%d", i, j), &owner.ID)
+		}
+	}
+	createTestAlbum(t, "Nobody's", nil)
+
+	tests := []struct {
+		name        string
+		query       string
+		wantQueries int32 // on users, besides the API key lookup
+	}{
+		{"one list", "{ albums { id user { id firstName } } }", 1},
+		{"fragment", "{ albums { ...owner } } fragment owner on Album { user { lastName } }", 1},
+		{"one query per list", "{ first: albums(limit: 10) { user { id } } rest: albums(offset: 10) { user { id } } }", 2},
+	}
+	count := countUserQueries(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			count.Store(0)
+
+			status, result := postGraphQL(t, server, owners[0].APIKey, GraphQLRequest{Query: tt.query})
+			if status != http.StatusOK || len(result.Errors) > 0 {
+				t.Fatalf("query = %d with %+v", status, result.Errors)
+			}
+
+			albums, owned := 0, 0
+			for _, list := range result.Data {
+				var page []struct {
+					User *struct{} `json:"user"`
+				}
+				json.Unmarshal(list, &page)
+				for _, album := range page {
+					albums++
+					if album.User != nil {
+						owned++
+					}
+				}
+			}
+			if queries := count.Load() - 1; albums != 21 || owned != 20 || queries != tt.wantQueries {
+				t.Errorf("%d albums (%d with owners) with %d queries on users, want 21 (20) with %d", albums, owned, queries, tt.wantQueries)
+			}
+		})
+	}
+}
+
+func TestGraphQLRejectsExpensiveQueries(t *testing.T) {
+	server := newTestServer(t)
+	user := createTestUser(t, "graphql@example.com")
+
+	var aliases strings.Builder
+	for i := range 6 {
+		fmt.Fprintf(&aliases, "a%d: albums { id title user { id firstName } } ", i)
+	}
+
+	tests := []struct {
+		name     string
+		query    string
+		wantCode ErrorCode // "" when the query runs
+	}{
+		{"a full page", "{ albums { id title artist price user { id firstName lastName } } }", ""},
+		{"a page of every field", "{ albums { id title artist price userId createdAt updatedAt user { id firstName lastName email createdAt } } }", CodeQueryTooComplex},
+		{"the limit is what counts", "{ albums(limit: 5) { id title artist price userId createdAt updatedAt user { id firstName lastName email createdAt } } }", ""},
+		{"repeated with aliases", "{ " + aliases.String() + "}", CodeQueryTooComplex},
+		{"repeated with fragments", "{ albums { ...a ...b } } fragment a on Album { id title artist price userId createdAt } fragment b on Album { updatedAt user { id firstName lastName email } }", CodeQueryTooComplex},
+		{"nested past the schema", "{ albums { user { albums { id } } } }", CodeValidationFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, result := postGraphQL(t, server, user.APIKey, GraphQLRequest{Query: tt.query})
+			if tt.wantCode == "" {
+				if status != http.StatusOK || len(result.Errors) > 0 {
+					t.Errorf("query = %d with %+v, want it to run", status, result.Errors)
+				}
+				return
+			}
+			if status != http.StatusBadRequest || len(result.Errors) == 0 || result.Errors[0].Extensions.Code != tt.wantCode {
+				t.Errorf("query = %d with %+v, want 400 %s", status, result.Errors, tt.wantCode)
+			}
+		})
+	}
+}
+
+// A batch of operations in one JSON array is not supported: each request
+// carries one operation, so the complexity limit can't be split across many
+func TestGraphQLRejectsBatchedRequests(t *testing.T) {
+	server := newTestServer(t)
+	user := createTestUser(t, "graphql@example.com")
+
+	batch := []GraphQLRequest{{Query: "{ albums { id } }"}, {Query: "{ me { id } }"}}
+	if status, _ := postGraphQL(t, server, user.APIKey, batch); status != http.StatusBadRequest {
+		t.Errorf("batched request = %d, want 400", status)
+	}
+}
@@ -0,0 +1,336 @@
+package main
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative albumpb/album.proto
+
+import (
+	"context"
+	"log"
+	"net"
+	"strings"
+
+	"example.com/dbs/albumpb"
+	"github.com/google/uuid"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"gorm.io/gorm"
+)
+
+// grpcPublicMethods can be called without an API key, like the public REST group
+var grpcPublicMethods = map[string]bool{
+	albumpb.AlbumService_ListAlbums_FullMethodName: true,
+}
+
+// grpcCodes maps each ErrorCode to the gRPC status it is sent with. The
+// ErrorCode itself travels as the reason of an ErrorInfo detail.
+var grpcCodes = map[ErrorCode]codes.Code{
+	CodeValidationFailed:     codes.InvalidArgument,
+	CodeQueryTooComplex:      codes.InvalidArgument,
+	CodeAPIKeyRequired:       codes.Unauthenticated,
+	CodeAPIKeyInvalid:        codes.Unauthenticated,
+	CodeInvalidCredentials:   codes.Unauthenticated,
+	CodeWrongPassword:        codes.PermissionDenied,
+	CodeTokenInvalid:         codes.InvalidArgument,
+	CodeOTPRequired:          codes.Unauthenticated,
+	CodeOTPInvalid:           codes.Unauthenticated,
+	CodeOTPLocked:            codes.ResourceExhausted,
+	CodeTwoFactorEnabled:     codes.FailedPrecondition,
+	CodeTwoFactorNotEnabled:  codes.FailedPrecondition,
+	CodeUserNotFound:         codes.Unauthenticated,
+	CodeForbiddenNotOwner:    codes.PermissionDenied,
+	CodeAlbumReadOnly:        codes.PermissionDenied,
+	CodeAdminRequired:        codes.PermissionDenied,
+	CodeAccountNotFound:      codes.NotFound,
+	CodeEmailNotVerified:     codes.FailedPrecondition,
+	CodeAlbumNotFound:        codes.NotFound,
+	CodeWebhookNotFound:      codes.NotFound,
+	CodeDeliveryNotFound:     codes.NotFound,
+	CodeCollaboratorNotFound: codes.NotFound,
+	CodeTransferNotFound:     codes.NotFound,
+	CodeTransferPending:      codes.FailedPrecondition,
+	CodeTransferNotPending:   codes.FailedPrecondition,
+	CodeAlbumHasOwner:        codes.FailedPrecondition,
+	CodeClaimNotFound:        codes.NotFound,
+	CodeClaimPending:         codes.AlreadyExists,
+	CodeClaimNotPending:      codes.FailedPrecondition,
+	CodeOrganizationNotFound: codes.NotFound,
+	CodeNotMember:            codes.PermissionDenied,
+	CodeOrganizationReadOnly: codes.PermissionDenied,
+	CodeOwnerRequired:        codes.PermissionDenied,
+	CodeMemberNotFound:       codes.NotFound,
+	CodeLastOwner:            codes.FailedPrecondition,
+	CodeExportNotFound:       codes.NotFound,
+	CodeExportNotReady:       codes.FailedPrecondition,
+	CodeDownloadLinkInvalid:  codes.PermissionDenied,
+	CodeDuplicateResource:    codes.AlreadyExists,
+	CodeInvalidReference:     codes.FailedPrecondition,
+	CodeNotAcceptable:        codes.InvalidArgument,
+	CodeUnsupportedMediaType: codes.InvalidArgument,
+	CodeInternal:             codes.Internal,
+}
+
+// grpcCode is the gRPC status for code. A code missing from grpcCodes is
+// sent as Unknown rather than the zero value, which is OK.
+func grpcCode(code ErrorCode) codes.Code {
+	if c, ok := grpcCodes[code]; ok {
+		return c
+	}
+	return codes.Unknown
+}
+
+type grpcContextKey int
+
+const (
+	grpcUserKey grpcContextKey = iota
+	grpcRequestIDKey
+	grpcTenantKey
+)
+
+// NewGRPCServer builds the gRPC server with the request ID and API key
+// interceptors and the AlbumService registered
+func NewGRPCServer(services Services) *grpc.Server {
+	server := grpc.NewServer(grpc.ChainUnaryInterceptor(GRPCRequestID, GRPCAuth(services.Users), GRPCTenant(services.Organizations)))
+	albumpb.RegisterAlbumServiceServer(server, &albumServer{albums: services.Albums})
+	return server
+}
+
+// serveGRPC runs the gRPC server next to the Gin router
+func serveGRPC(port string, services Services) {
+	listener, err := net.Listen("tcp", ":"+port)
+	if err != nil {
+		log.Fatal("Failed to listen for gRPC:", err)
+	}
+
+	log.Printf("gRPC server starting on port %s", port)
+	if err := NewGRPCServer(services).Serve(listener); err != nil {
+		log.Fatal("gRPC server stopped:", err)
+	}
+}
+
+// GRPCRequestID is RequestID for gRPC: it reuses the x-request-id metadata
+// or makes one up, and sends it back in the response header.
+func GRPCRequestID(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	requestID := firstMetadata(ctx, "x-request-id")
+	if requestID == "" {
+		requestID = uuid.NewString()
+	}
+
+	grpc.SetHeader(ctx, metadata.Pairs("x-request-id", requestID))
+	return handler(context.WithValue(ctx, grpcRequestIDKey, requestID), req)
+}
+
+// GRPCAuth is AuthMiddleware for gRPC: the key comes from the x-api-key
+// metadata, or authorization: Bearer, and the user is put in the context.
+func GRPCAuth(users UserRepository) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		apiKey := firstMetadata(ctx, "x-api-key")
+		if apiKey == "" {
+			apiKey = strings.TrimPrefix(firstMetadata(ctx, "authorization"), "Bearer ")
+		}
+
+		if apiKey == "" {
+			if grpcPublicMethods[info.FullMethod] {
+				return handler(ctx, req)
+			}
+			return nil, grpcError(ctx, CodeAPIKeyRequired, "")
+		}
+
+		user, err := users.FindByAPIKey(apiKey)
+		if err != nil {
+			return nil, grpcDBError(ctx, err, CodeAPIKeyInvalid)
+		}
+		return handler(context.WithValue(ctx, grpcUserKey, user), req)
+	}
+}
+
+// GRPCTenant is TenantMiddleware for gRPC: the organization's slug comes
+// from the x-organization metadata, and only its members get past.
+func GRPCTenant(orgs OrganizationRepository) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		user, _ := grpcUser(ctx)
+		tenant, err := LoadTenant(orgs, firstMetadata(ctx, "x-organization"), user.ID)
+		if err != nil {
+			return nil, grpcDBError(ctx, err, CodeOrganizationNotFound)
+		}
+		if tenant.Organization != nil && tenant.Role == "" {
+			if user.ID == 0 {
+				return nil, grpcError(ctx, CodeAPIKeyRequired, "")
+			}
+			return nil, grpcError(ctx, CodeNotMember, "")
+		}
+		return handler(context.WithValue(ctx, grpcTenantKey, tenant), req)
+	}
+}
+
+func firstMetadata(ctx context.Context, key string) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if values := md.Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// grpcUser returns the user GRPCAuth found, if any
+func grpcUser(ctx context.Context) (User, bool) {
+	user, ok := ctx.Value(grpcUserKey).(User)
+	return user, ok
+}
+
+// grpcTenant returns the catalogue GRPCTenant picked
+func grpcTenant(ctx context.Context) Tenant {
+	tenant, _ := ctx.Value(grpcTenantKey).(Tenant)
+	return tenant
+}
+
+// grpcError builds the status for a code (message defaults to the catalogue)
+func grpcError(ctx context.Context, code ErrorCode, message string) error {
+	if message == "" {
+		message = errorCatalogue[code].Message
+	}
+
+	requestID, _ := ctx.Value(grpcRequestIDKey).(string)
+	st, err := status.New(grpcCode(code), message).WithDetails(&errdetails.ErrorInfo{
+		Reason:   string(code),
+		Domain:   "dbs",
+		Metadata: map[string]string{"request_id": requestID},
+	})
+	if err != nil {
+		return status.Error(grpcCode(code), message)
+	}
+	return st.Err()
+}
+
+// grpcDBError is RespondDBError for gRPC
+func grpcDBError(ctx context.Context, err error, notFound ErrorCode) error {
+	if code, ok := clientErrorCode(err, notFound); ok {
+		return grpcError(ctx, code, clientErrorMessage(err))
+	}
+
+	requestID, _ := ctx.Value(grpcRequestIDKey).(string)
+	log.Printf("[%s] gRPC: %v", requestID, err)
+	return grpcError(ctx, CodeInternal, "")
+}
+
+// albumServer implements albumpb.AlbumServiceServer with the rules in service.go
+type albumServer struct {
+	albumpb.UnimplementedAlbumServiceServer
+	albums *AlbumService
+}
+
+// ListAlbums - Public: every album, or the caller's with mine = true
+func (s *albumServer) ListAlbums(ctx context.Context, req *albumpb.ListAlbumsRequest) (*albumpb.ListAlbumsResponse, error) {
+	if req.Limit < 0 || req.Limit > maxPageSize {
+		return nil, grpcError(ctx, CodeValidationFailed, "limit must be between 1 and 100, or 0 for every album")
+	}
+	if req.Offset < 0 {
+		return nil, grpcError(ctx, CodeValidationFailed, "offset must not be negative")
+	}
+
+	var ownerID *uint
+	if req.Mine {
+		user, ok := grpcUser(ctx)
+		if !ok {
+			return nil, grpcError(ctx, CodeAPIKeyRequired, "")
+		}
+		ownerID = &user.ID
+	}
+
+	albums, err := s.albums.List(grpcTenant(ctx), ownerID, Page{Limit: int(req.Limit), Offset: int(req.Offset)})
+	if err != nil {
+		return nil, grpcDBError(ctx, err, CodeAlbumNotFound)
+	}
+
+	resp := &albumpb.ListAlbumsResponse{Albums: make([]*albumpb.Album, len(albums))}
+	for i, album := range albums {
+		resp.Albums[i] = albumToProto(album)
+	}
+	return resp, nil
+}
+
+// GetAlbum - An album the caller owns or one without an owner
+func (s *albumServer) GetAlbum(ctx context.Context, req *albumpb.GetAlbumRequest) (*albumpb.Album, error) {
+	user, _ := grpcUser(ctx)
+
+	album, err := s.albums.Get(grpcTenant(ctx), user.ID, uint(req.Id))
+	if err != nil {
+		return nil, grpcDBError(ctx, err, CodeAlbumNotFound)
+	}
+	return albumToProto(album), nil
+}
+
+// CreateAlbum - A new album owned by the caller
+func (s *albumServer) CreateAlbum(ctx context.Context, req *albumpb.CreateAlbumRequest) (*albumpb.Album, error) {
+	user, _ := grpcUser(ctx)
+
+	album, err := s.albums.Create(grpcTenant(ctx), user.ID, AlbumInput{Title: &req.Title, Artist: &req.Artist, Price: &req.Price})
+	if err != nil {
+		return nil, grpcDBError(ctx, err, CodeAlbumNotFound)
+	}
+	return albumToProto(album), nil
+}
+
+// UpdateAlbum - Change the fields that are set (an album without an owner is read-only)
+func (s *albumServer) UpdateAlbum(ctx context.Context, req *albumpb.UpdateAlbumRequest) (*albumpb.Album, error) {
+	user, _ := grpcUser(ctx)
+
+	album, err := s.albums.Update(grpcTenant(ctx), user.ID, uint(req.Id), AlbumInput{Title: req.Title, Artist: req.Artist, Price: req.Price})
+	if err != nil {
+		return nil, grpcDBError(ctx, err, CodeAlbumNotFound)
+	}
+	return albumToProto(album), nil
+}
+
+// DeleteAlbum - Delete an album the caller owns (an administrator, one without an owner)
+func (s *albumServer) DeleteAlbum(ctx context.Context, req *albumpb.DeleteAlbumRequest) (*emptypb.Empty, error) {
+	user, _ := grpcUser(ctx)
+
+	if err := s.albums.Delete(grpcTenant(ctx), user.ID, uint(req.Id)); err != nil {
+		return nil, grpcDBError(ctx, err, CodeAlbumNotFound)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+// Me - The user the API key belongs to
+func (s *albumServer) Me(ctx context.Context, _ *emptypb.Empty) (*albumpb.User, error) {
+	user, ok := grpcUser(ctx)
+	if !ok {
+		return nil, grpcDBError(ctx, gorm.ErrRecordNotFound, CodeUserNotFound)
+	}
+	return userToProto(user), nil
+}
+
+func albumToProto(album Album) *albumpb.Album {
+	msg := &albumpb.Album{
+		Id:        uint64(album.ID),
+		Title:     album.Title,
+		Artist:    album.Artist,
+		Price:     album.Price,
+		CreatedAt: timestamppb.New(album.CreatedAt),
+		UpdatedAt: timestamppb.New(album.UpdatedAt),
+	}
+	if album.UserID != nil {
+		userID := uint64(*album.UserID)
+		msg.UserId = &userID
+	}
+	if album.User != nil {
+		msg.User = userToProto(*album.User)
+	}
+	return msg
+}
+
+// userToProto leaves out the API key and password hash. AlbumService has
+// already blanked the email of album owners other than the caller.
+func userToProto(user User) *albumpb.User {
+	return &albumpb.User{
+		Id:        uint64(user.ID),
+		FirstName: user.FirstName,
+		LastName:  user.LastName,
+		Email:     user.Email,
+		CreatedAt: timestamppb.New(user.CreatedAt),
+		UpdatedAt: timestamppb.New(user.UpdatedAt),
+	}
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"example.com/dbs/albumpb"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// newTestGRPCClient serves NewGRPCServer over an in-memory listener
+func newTestGRPCClient(t *testing.T) albumpb.AlbumServiceClient {
+	t.Helper()
+
+	listener := bufconn.Listen(1 << 20)
+	server := NewGRPCServer(NewServices(DB))
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("dial bufconn: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return albumpb.NewAlbumServiceClient(conn)
+}
+
+// withKey authenticates a call the way AuthMiddleware expects
+func withKey(apiKey string) context.Context {
+	return metadata.AppendToOutgoingContext(context.Background(), "x-api-key", apiKey)
+}
+
+// errorReason returns the ErrorCode carried in a status' ErrorInfo
+func errorReason(err error) ErrorCode {
+	for _, detail := range status.Convert(err).Details() {
+		if info, ok := detail.(*errdetails.ErrorInfo); ok {
+			return ErrorCode(info.Reason)
+		}
+	}
+	return ""
+}
+
+func TestGRPCAlbumLifecycle(t *testing.T) {
+	setupTestDB(t)
+	user := createTestUser(t, "grpc@example.com")
+	c := newTestGRPCClient(t)
+	ctx := withKey(user.APIKey)
+
+	me, err := c.Me(ctx, &emptypb.Empty{})
+	if err != nil {
+		t.Fatalf("Me: %v", err)
+	}
+	if me.Email != user.Email {
+		t.Errorf("Me email = %q, want %q", me.Email, user.Email)
+	}
+
+	created, err := c.CreateAlbum(ctx, &albumpb.CreateAlbumRequest{Title: "Giant Steps", Artist: "John Coltrane", Price: 24.99})
+	if err != nil {
+		t.Fatalf("CreateAlbum: %v", err)
+	}
+	if created.GetUserId() != uint64(user.ID) || created.GetUser().GetEmail() != user.Email {
+		t.Errorf("created album owner = %d/%q, want %d", created.GetUserId(), created.GetUser().GetEmail(), user.ID)
+	}
+
+	updated, err := c.UpdateAlbum(ctx, &albumpb.UpdateAlbumRequest{Id: created.Id, Price: proto.Float64(9.99)})
+	if err != nil {
+		t.Fatalf("UpdateAlbum: %v", err)
+	}
+	if updated.Price != 9.99 || updated.Title != "Giant Steps" {
+		t.Errorf("UpdateAlbum = %q %v, want only the price changed", updated.Title, updated.Price)
+	}
+
+	got, err := c.GetAlbum(ctx, &albumpb.GetAlbumRequest{Id: created.Id})
+	if err != nil {
+		t.Fatalf("GetAlbum: %v", err)
+	}
+	if got.Price != 9.99 {
+		t.Errorf("GetAlbum price = %v, want 9.99", got.Price)
+	}
+
+	mine, err := c.ListAlbums(ctx, &albumpb.ListAlbumsRequest{Mine: true})
+	if err != nil {
+		t.Fatalf("ListAlbums mine: %v", err)
+	}
+	if len(mine.Albums) != 1 {
+		t.Errorf("ListAlbums mine returned %d albums, want 1", len(mine.Albums))
+	}
+
+	if _, err := c.DeleteAlbum(ctx, &albumpb.DeleteAlbumRequest{Id: created.Id}); err != nil {
+		t.Fatalf("DeleteAlbum: %v", err)
+	}
+	if _, err := c.GetAlbum(ctx, &albumpb.GetAlbumRequest{Id: created.Id}); status.Code(err) != codes.NotFound {
+		t.Errorf("GetAlbum after delete: %v, want NotFound", err)
+	}
+}
+
+func TestGRPCListAlbumsIsPublic(t *testing.T) {
+	setupTestDB(t)
+	owner := createTestUser(t, "owner@example.com")
+	createTestAlbum(t, "Owned", &owner.ID)
+	createTestAlbum(t, "Ownerless", nil)
+	c := newTestGRPCClient(t)
+
+	resp, err := c.ListAlbums(context.Background(), &albumpb.ListAlbumsRequest{Limit: 1, Offset: 1})
+	if err != nil {
+		t.Fatalf("ListAlbums: %v", err)
+	}
+	if len(resp.Albums) != 1 || resp.Albums[0].Title != "Ownerless" || resp.Albums[0].UserId != nil {
+		t.Errorf("ListAlbums page = %v, want only the ownerless album", resp.Albums)
+	}
+}
+
+// TestGRPCMatchesREST runs the same calls against both APIs and expects the
+// same ErrorCode (or success) from each
+func TestGRPCMatchesREST(t *testing.T) {
+	setupTestDB(t)
+	owner := createTestUser(t, "owner@example.com")
+	other := createTestUser(t, "other@example.com")
+	owned := createTestAlbum(t, "Owned", &owner.ID)
+	ownerless := createTestAlbum(t, "Ownerless", nil)
+
+	c := newTestGRPCClient(t)
+	server := httptest.NewServer(setupRouter(NewServices(DB)))
+	t.Cleanup(server.Close)
+
+	albumPath := func(id uint) string { return "/albums/" + strconv.FormatUint(uint64(id), 10) }
+
+	tests := []struct {
+		name   string
+		apiKey string
+		method string
+		path   string
+		call   func(ctx context.Context) error
+		want   ErrorCode // "" means success
+	}{
+		{
+			name: "get without key", method: http.MethodGet, path: albumPath(owned.ID),
+			call: func(ctx context.Context) error {
+				_, err := c.GetAlbum(ctx, &albumpb.GetAlbumRequest{Id: uint64(owned.ID)})
+				return err
+			},
+			want: CodeAPIKeyRequired,
+		},
+		{
+			name: "get with wrong key", apiKey: "not-a-key", method: http.MethodGet, path: albumPath(owned.ID),
+			call: func(ctx context.Context) error {
+				_, err := c.GetAlbum(ctx, &albumpb.GetAlbumRequest{Id: uint64(owned.ID)})
+				return err
+			},
+			want: CodeAPIKeyInvalid,
+		},
+		{
+			name: "get someone else's album", apiKey: other.APIKey, method: http.MethodGet, path: albumPath(owned.ID),
+			call: func(ctx context.Context) error {
+				_, err := c.GetAlbum(ctx, &albumpb.GetAlbumRequest{Id: uint64(owned.ID)})
+				return err
+			},
+			want: CodeForbiddenNotOwner,
+		},
+		{
+			name: "get missing album", apiKey: other.APIKey, method: http.MethodGet, path: albumPath(9999),
+			call: func(ctx context.Context) error {
+				_, err := c.GetAlbum(ctx, &albumpb.GetAlbumRequest{Id: 9999})
+				return err
+			},
+			want: CodeAlbumNotFound,
+		},
+		{
+			name: "get ownerless album", apiKey: other.APIKey, method: http.MethodGet, path: albumPath(ownerless.ID),
+			call: func(ctx context.Context) error {
+				_, err := c.GetAlbum(ctx, &albumpb.GetAlbumRequest{Id: uint64(ownerless.ID)})
+				return err
+			},
+		},
+		{
+			name: "delete someone else's album", apiKey: other.APIKey, method: http.MethodDelete, path: albumPath(owned.ID),
+			call: func(ctx context.Context) error {
+				_, err := c.DeleteAlbum(ctx, &albumpb.DeleteAlbumRequest{Id: uint64(owned.ID)})
+				return err
+			},
+			want: CodeForbiddenNotOwner,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.apiKey != "" {
+				ctx = withKey(tt.apiKey)
+			}
+			if got := errorReason(tt.call(ctx)); got != tt.want {
+				t.Errorf("gRPC error code = %q, want %q", got, tt.want)
+			}
+
+			req, _ := http.NewRequest(tt.method, server.URL+tt.path, nil)
+			if tt.apiKey != "" {
+				req.Header.Set("X-API-Key", tt.apiKey)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("REST request: %v", err)
+			}
+			resp.Body.Close()
+
+			wantStatus := http.StatusOK
+			if tt.want != "" {
+				wantStatus = errorCatalogue[tt.want].Status
+			}
+			if resp.StatusCode != wantStatus {
+				t.Errorf("REST status = %d, want %d", resp.StatusCode, wantStatus)
+			}
+		})
+	}
+}
+
+// Every error the REST API can send has a gRPC status, and none of them is OK
+func TestGRPCCodesCoverCatalogue(t *testing.T) {
+	for code, entry := range errorCatalogue {
+		got, ok := grpcCodes[code]
+		if !ok || got == codes.OK {
+			t.Errorf("%s has no gRPC status", code)
+		}
+		if entry.Status == http.StatusNotFound && got != codes.NotFound {
+			t.Errorf("%s is 404 over HTTP but %s over gRPC", code, got)
+		}
+	}
+	if got := grpcCode("NOT_IN_THE_CATALOGUE"); got != codes.Unknown {
+		t.Errorf("unmapped code = %s, want Unknown", got)
+	}
+}
+
+// An organization's catalogue is only for its members, over gRPC as over REST
+func TestGRPCOrganizationCatalogueIsForMembers(t *testing.T) {
+	setupTestDB(t)
+	member := createTestUser(t, "member@example.com")
+	outsider := createTestUser(t, "outsider@example.com")
+	organization := Organization{Name: "Crate Diggers", Slug: "crate-diggers"}
+	DB.Create(&organization)
+	DB.Create(&OrganizationMember{OrganizationID: organization.ID, UserID: member.ID, Role: RoleViewer})
+	DB.Create(&Album{Title: "Members only", Artist: "Crate Diggers", OrganizationID: &organization.ID})
+	c := newTestGRPCClient(t)
+
+	tests := []struct {
+		name       string
+		apiKey     string
+		wantCode   codes.Code
+		wantReason ErrorCode
+	}{
+		{"member", member.APIKey, codes.OK, ""},
+		{"outsider", outsider.APIKey, codes.PermissionDenied, CodeNotMember},
+		{"anonymous", "", codes.Unauthenticated, CodeAPIKeyRequired},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := metadata.AppendToOutgoingContext(context.Background(), "x-organization", organization.Slug)
+			if tt.apiKey != "" {
+				ctx = metadata.AppendToOutgoingContext(ctx, "x-api-key", tt.apiKey)
+			}
+			resp, err := c.ListAlbums(ctx, &albumpb.ListAlbumsRequest{})
+			if status.Code(err) != tt.wantCode || errorReason(err) != tt.wantReason {
+				t.Fatalf("ListAlbums = %v, want %v %s", err, tt.wantCode, tt.wantReason)
+			}
+			if err == nil && (len(resp.Albums) != 1 || resp.Albums[0].Title != "Members only") {
+				t.Errorf("ListAlbums = %v, want the organization's album", resp.Albums)
+			}
+		})
+	}
+}
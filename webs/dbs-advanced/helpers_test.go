@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/glebarez/sqlite"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// setupTestDB points the global DB at an empty database: the Postgres one at
+// TEST_DATABASE_DSN, its tables emptied, or else a SQLite one in memory of
+// the test's own.
+func setupTestDB(t *testing.T) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	dialector := postgres.Open(dsn)
+	if dsn == "" {
+		dialector = sqlite.Open("file:" + url.PathEscape(t.Name()) + "?mode=memory&cache=shared&_pragma=foreign_keys(1)")
+	}
+
+	db, err := gorm.Open(dialector, &gorm.Config{TranslateError: true})
+	if err != nil {
+		t.Fatalf("connect to test database: %v", err)
+	}
+	if _, err := migrator.Up(db, 0); err != nil {
+		t.Fatalf("migrate test database: %v", err)
+	}
+	if dsn == "" {
+		// The database goes when its last connection closes
+		sqlDB, _ := db.DB()
+		t.Cleanup(func() { sqlDB.Close() })
+	} else if err := db.Exec("TRUNCATE organization_members, organizations, album_claims, album_transfers, album_collaborators, data_exports, user_identities, recovery_codes, user_tokens, queued_emails, webhook_deliveries, outbox_events, webhooks, albums, users RESTART IDENTITY CASCADE").Error; err != nil {
+		t.Fatalf("reset test database: %v", err)
+	}
+
+	DB = db
+}
+
+// createTestUser inserts a user with a fresh API key
+func createTestUser(t *testing.T, email string) User {
+	t.Helper()
+
+	user := User{
+		FirstName: "Test",
+		LastName:  "User",
+		Email:     email,
+		Password:  "not-a-real-hash",
+		APIKey:    uuid.NewString(),
+	}
+	if err := DB.Create(&user).Error; err != nil {
+		t.Fatalf("create user %s: %v", email, err)
+	}
+	return user
+}
+
+// testPassword is the password setTestPassword gives users
+const testPassword = "password123"
+
+// setTestPassword lets user log in with testPassword
+func setTestPassword(t *testing.T, user User) {
+	t.Helper()
+
+	hash, _ := bcrypt.GenerateFromPassword([]byte(testPassword), bcrypt.MinCost)
+	if err := DB.Model(&user).Update("password", string(hash)).Error; err != nil {
+		t.Fatalf("set password of %s: %v", user.Email, err)
+	}
+}
+
+// createTestAlbum inserts an album owned by userID (nil for ownerless)
+func createTestAlbum(t *testing.T, title string, userID *uint) Album {
+	t.Helper()
+
+	album := Album{Title: title, Artist: "Test Artist", Price: 9.99, UserID: userID}
+	if err := DB.Create(&album).Error; err != nil {
+		t.Fatalf("create album %s: %v", title, err)
+	}
+	return album
+}
+
+// newTestServer serves the router over a fresh test database
+func newTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	setupTestDB(t)
+	server := httptest.NewServer(setupRouter(NewServices(DB)))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// createTestAdmin inserts an administrator with a fresh API key
+func createTestAdmin(t *testing.T, email string) User {
+	t.Helper()
+
+	admin := createTestUser(t, email)
+	if err := DB.Model(&admin).Update("is_admin", true).Error; err != nil {
+		t.Fatalf("make %s an administrator: %v", email, err)
+	}
+	return admin
+}
+
+// shareTestAlbum makes user a collaborator on an album with role
+func shareTestAlbum(t *testing.T, albumID uint, user User, role string) {
+	t.Helper()
+
+	if err := DB.Create(&AlbumCollaborator{AlbumID: albumID, UserID: user.ID, Role: role}).Error; err != nil {
+		t.Fatalf("share album %d with %s: %v", albumID, user.Email, err)
+	}
+}
+
+// apiErrorCode sends a JSON request like apiCall, without following
+// redirects, and returns the status and the error code of a failure
+func apiErrorCode(t *testing.T, server *httptest.Server, apiKey, method, path string, body interface{}) (int, ErrorCode) {
+	t.Helper()
+
+	var reqBody io.Reader
+	if body != nil {
+		encoded, _ := json.Marshal(body)
+		reqBody = bytes.NewReader(encoded)
+	}
+	req, _ := http.NewRequest(method, server.URL+path, reqBody)
+	if apiKey != "" {
+		req.Header.Set("X-API-Key", apiKey)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	noRedirects := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := noRedirects.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+
+	var failure struct {
+		Code ErrorCode `json:"code"`
+	}
+	if resp.StatusCode >= 400 {
+		json.NewDecoder(resp.Body).Decode(&failure)
+	}
+	return resp.StatusCode, failure.Code
+}
@@ -0,0 +1,429 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Mail is configured from the environment (.env):
+//
+//	MAIL_TRANSPORT  smtp, file or stdout (the default, except in release mode,
+//	                where emails and the tokens in them must not end up in logs)
+//	MAIL_FROM       the sender, default "Albums <no-reply@localhost>"
+//	MAIL_DIR        where the file transport writes .eml files, default "mail"
+//	SMTP_HOST, SMTP_PORT (587), SMTP_USERNAME, SMTP_PASSWORD
+//
+// Emails are queued in the database, usually in the same transaction as the
+// change they are about, and sent by RunMailer.
+
+const (
+	mailPollInterval = 5 * time.Second
+	mailTimeout      = 30 * time.Second
+	mailBatchSize    = 20
+	mailMaxAttempts  = 6
+	mailBaseBackoff  = time.Minute
+	mailMaxBackoff   = time.Hour
+)
+
+// Email statuses
+const (
+	EmailPending = "pending"
+	EmailSent    = "sent"
+	EmailFailed  = "failed"
+)
+
+// Each email is emails/<name>.txt, whose "subject" block is the subject, and
+// emails/<name>.html, rendered inside emails/layout.html
+//
+//go:embed emails
+var emailFiles embed.FS
+
+var (
+	emailTemplatesMu sync.Mutex
+	emailTemplates   = map[string]*emailTemplate{}
+)
+
+type emailTemplate struct {
+	text *texttemplate.Template
+	html *htmltemplate.Template
+}
+
+// QueuedEmail is an email in the outbox, and what happened when it was sent
+type QueuedEmail struct {
+	ID            uint       `gorm:"primaryKey"`
+	To            string     `gorm:"not null"`
+	Template      string     `gorm:"not null"`
+	Subject       string     `gorm:"not null"`
+	TextBody      string     `gorm:"type:text;not null"`
+	HTMLBody      string     `gorm:"type:text;not null"`
+	Status        string     `gorm:"index;not null"`
+	Attempts      int        `gorm:"not null"`
+	NextAttemptAt *time.Time `gorm:"index"`
+	LastError     string
+	SentAt        *time.Time
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+// MailTransport delivers one ready-made RFC 5322 message
+type MailTransport interface {
+	Send(ctx context.Context, from string, to []string, message []byte) error
+}
+
+// QueueEmail renders the named email for data and adds it to the outbox.
+// Pass the transaction making the change the email is about, so it is only
+// sent if the change is committed.
+func QueueEmail(tx *gorm.DB, to, name string, data interface{}) error {
+	subject, text, html, err := RenderEmail(name, data)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	return tx.Create(&QueuedEmail{
+		To:            to,
+		Template:      name,
+		Subject:       subject,
+		TextBody:      text,
+		HTMLBody:      html,
+		Status:        EmailPending,
+		NextAttemptAt: &now,
+	}).Error
+}
+
+// RenderEmail executes the text and HTML templates of an email
+func RenderEmail(name string, data interface{}) (subject, text, html string, err error) {
+	tmpl, err := loadEmailTemplate(name)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.text.ExecuteTemplate(&buf, "subject", data); err != nil {
+		return "", "", "", fmt.Errorf("email %s: subject: %w", name, err)
+	}
+	subject = strings.TrimSpace(buf.String())
+
+	buf.Reset()
+	if err := tmpl.text.Execute(&buf, data); err != nil {
+		return "", "", "", fmt.Errorf("email %s: text: %w", name, err)
+	}
+	text = buf.String()
+
+	buf.Reset()
+	if err := tmpl.html.ExecuteTemplate(&buf, "layout", data); err != nil {
+		return "", "", "", fmt.Errorf("email %s: html: %w", name, err)
+	}
+	return subject, text, buf.String(), nil
+}
+
+func loadEmailTemplate(name string) (*emailTemplate, error) {
+	emailTemplatesMu.Lock()
+	defer emailTemplatesMu.Unlock()
+
+	if tmpl, ok := emailTemplates[name]; ok {
+		return tmpl, nil
+	}
+
+	text, err := texttemplate.ParseFS(emailFiles, "emails/"+name+".txt")
+	if err != nil {
+		return nil, fmt.Errorf("email %s: %w", name, err)
+	}
+	html, err := htmltemplate.ParseFS(emailFiles, "emails/layout.html", "emails/"+name+".html")
+	if err != nil {
+		return nil, fmt.Errorf("email %s: %w", name, err)
+	}
+
+	tmpl := &emailTemplate{text: text, html: html}
+	emailTemplates[name] = tmpl
+	return tmpl, nil
+}
+
+// NewMailTransport picks the transport named by MAIL_TRANSPORT
+func NewMailTransport() (MailTransport, error) {
+	switch transport := os.Getenv("MAIL_TRANSPORT"); transport {
+	case "":
+		if gin.Mode() == gin.ReleaseMode {
+			return nil, fmt.Errorf("MAIL_TRANSPORT is required in release mode, use smtp or file (stdout logs every token)")
+		}
+		return WriterTransport{Writer: os.Stdout}, nil
+	case "stdout":
+		return WriterTransport{Writer: os.Stdout}, nil
+	case "file":
+		dir := os.Getenv("MAIL_DIR")
+		if dir == "" {
+			dir = "mail"
+		}
+		return FileTransport{Dir: dir}, nil
+	case "smtp":
+		host := os.Getenv("SMTP_HOST")
+		if host == "" {
+			return nil, fmt.Errorf("MAIL_TRANSPORT=smtp needs SMTP_HOST")
+		}
+		port := os.Getenv("SMTP_PORT")
+		if port == "" {
+			port = "587"
+		}
+		return SMTPTransport{
+			Addr:     net.JoinHostPort(host, port),
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown MAIL_TRANSPORT %q, use smtp, file or stdout", transport)
+	}
+}
+
+// mailFrom is the sender of every email
+func mailFrom() string {
+	if from := os.Getenv("MAIL_FROM"); from != "" {
+		return from
+	}
+	return "Albums <no-reply@localhost>"
+}
+
+// SMTPTransport sends through an SMTP server, upgrading to TLS with STARTTLS
+// when the server offers it. Credentials are only sent over TLS.
+type SMTPTransport struct {
+	Addr     string // host:port
+	Username string
+	Password string
+}
+
+func (t SMTPTransport) Send(ctx context.Context, from string, to []string, message []byte) error {
+	dialer := net.Dialer{Timeout: mailTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", t.Addr)
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(mailTimeout))
+
+	host, _, _ := net.SplitHostPort(t.Addr)
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if t.Username != "" {
+		// PlainAuth refuses to send the password over a connection without TLS
+		if err := client.Auth(smtp.PlainAuth("", t.Username, t.Password, host)); err != nil {
+			return err
+		}
+	}
+
+	if err := client.Mail(from); err != nil {
+		return err
+	}
+	for _, rcpt := range to {
+		if err := client.Rcpt(rcpt); err != nil {
+			return err
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(message); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// FileTransport writes each message to Dir as an .eml file any mail client can open
+type FileTransport struct {
+	Dir string
+}
+
+func (t FileTransport) Send(_ context.Context, _ string, _ []string, message []byte) error {
+	if err := os.MkdirAll(t.Dir, 0o755); err != nil {
+		return err
+	}
+	name := time.Now().UTC().Format("20060102T150405") + "-" + uuid.NewString() + ".eml"
+	return os.WriteFile(filepath.Join(t.Dir, name), message, 0o644)
+}
+
+// WriterTransport prints each message, for development
+type WriterTransport struct {
+	Writer io.Writer
+}
+
+func (t WriterTransport) Send(_ context.Context, _ string, _ []string, message []byte) error {
+	_, err := fmt.Fprintf(t.Writer, "----- email -----\n%s\n----- end of email -----\n", message)
+	return err
+}
+
+// RunMailer calls SendQueuedEmails every few seconds until ctx ends
+func RunMailer(ctx context.Context, transport MailTransport) {
+	ticker := time.NewTicker(mailPollInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := SendQueuedEmails(ctx, transport); err != nil {
+			log.Printf("mailer: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// SendQueuedEmails makes one attempt at every pending email that is due.
+// Emails are claimed the same way as webhook deliveries, so several
+// instances can share the outbox.
+func SendQueuedEmails(ctx context.Context, transport MailTransport) error {
+	var due []QueuedEmail
+	err := DB.Where("status = ? AND next_attempt_at <= ?", EmailPending, time.Now()).
+		Order("next_attempt_at").Limit(mailBatchSize).Find(&due).Error
+	if err != nil {
+		return err
+	}
+
+	from := mailFrom()
+	for _, email := range due {
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		lease := time.Now().Add(2 * mailTimeout)
+		claim := DB.Model(&QueuedEmail{}).
+			Where("id = ? AND status = ? AND attempts = ?", email.ID, EmailPending, email.Attempts).
+			Updates(map[string]interface{}{"attempts": email.Attempts + 1, "next_attempt_at": lease})
+		if claim.Error != nil {
+			return claim.Error
+		}
+		if claim.RowsAffected == 0 {
+			continue
+		}
+		email.Attempts++
+
+		sendErr := sendEmail(ctx, transport, from, email)
+
+		changes := map[string]interface{}{"last_error": ""}
+		switch {
+		case sendErr == nil:
+			changes["status"] = EmailSent
+			changes["sent_at"] = time.Now()
+			changes["next_attempt_at"] = nil
+		case email.Attempts >= mailMaxAttempts:
+			log.Printf("mailer: giving up on email %d to %s: %v", email.ID, email.To, sendErr)
+			changes["status"] = EmailFailed
+			changes["last_error"] = truncate(sendErr.Error(), 500)
+			changes["next_attempt_at"] = nil
+		default:
+			changes["last_error"] = truncate(sendErr.Error(), 500)
+			changes["next_attempt_at"] = time.Now().Add(backoff(email.Attempts, mailBaseBackoff, mailMaxBackoff))
+		}
+		if err := DB.Model(&QueuedEmail{ID: email.ID}).Updates(changes).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func sendEmail(ctx context.Context, transport MailTransport, from string, email QueuedEmail) error {
+	sender, err := mail.ParseAddress(from)
+	if err != nil {
+		return fmt.Errorf("MAIL_FROM: %w", err)
+	}
+	rcpt, err := mail.ParseAddress(email.To)
+	if err != nil {
+		return err
+	}
+
+	message, err := buildMessage(sender, rcpt, email)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, mailTimeout)
+	defer cancel()
+	return transport.Send(ctx, sender.Address, []string{rcpt.Address}, message)
+}
+
+// buildMessage writes a multipart/alternative message with the text and
+// HTML bodies, both quoted-printable
+func buildMessage(from, to *mail.Address, email QueuedEmail) ([]byte, error) {
+	var buf bytes.Buffer
+	parts := multipart.NewWriter(&buf)
+
+	domain := "localhost"
+	if at := strings.LastIndex(from.Address, "@"); at >= 0 {
+		domain = from.Address[at+1:]
+	}
+
+	headers := [][2]string{
+		{"From", from.String()},
+		{"To", to.String()},
+		{"Subject", mime.QEncoding.Encode("utf-8", email.Subject)},
+		{"Date", email.CreatedAt.Format(time.RFC1123Z)},
+		{"Message-ID", "<email-" + strconv.FormatUint(uint64(email.ID), 10) + "." + uuid.NewString() + "@" + domain + ">"},
+		{"MIME-Version", "1.0"},
+		{"Content-Type", "multipart/alternative; boundary=" + parts.Boundary()},
+	}
+	for _, header := range headers {
+		fmt.Fprintf(&buf, "%s: %s\r\n", header[0], header[1])
+	}
+	buf.WriteString("\r\n")
+
+	for _, body := range []struct{ contentType, content string }{
+		{"text/plain; charset=utf-8", email.TextBody},
+		{"text/html; charset=utf-8", email.HTMLBody},
+	} {
+		part, err := parts.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {body.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		qp := quotedprintable.NewWriter(part)
+		if _, err := qp.Write([]byte(body.content)); err != nil {
+			return nil, err
+		}
+		if err := qp.Close(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := parts.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// recordingTransport keeps what it is asked to send and fails while err is set
+type recordingTransport struct {
+	mu       sync.Mutex
+	err      error
+	messages [][]byte
+}
+
+func (t *recordingTransport) Send(_ context.Context, _ string, _ []string, message []byte) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.err != nil {
+		return t.err
+	}
+	t.messages = append(t.messages, message)
+	return nil
+}
+
+func TestRenderEmail(t *testing.T) {
+	data := map[string]interface{}{
+		"User": User{FirstName: "<Ada>", Email: "ada@example.com"},
+		"Time": time.Date(2024, 3, 1, 9, 30, 0, 0, time.UTC),
+	}
+
+	subject, text, html, err := RenderEmail("key_rotated", data)
+	if err != nil {
+		t.Fatalf("RenderEmail: %v", err)
+	}
+	if subject != "Your API key was replaced" {
+		t.Errorf("subject = %q", subject)
+	}
+	if !strings.HasPrefix(text, "Hi <Ada>,") || !strings.Contains(text, "1 March 2024 at 09:30 UTC") {
+		t.Errorf("text body = %q", text)
+	}
+	if !strings.Contains(html, "Hi &lt;Ada&gt;,") || !strings.Contains(html, "<!DOCTYPE html>") {
+		t.Errorf("html body is not escaped or not in the layout: %q", html)
+	}
+
+	if _, _, _, err := RenderEmail("no_such_email", data); err == nil {
+		t.Error("RenderEmail of a missing template: want an error")
+	}
+}
+
+func TestMailerRetriesThenSends(t *testing.T) {
+	setupTestDB(t)
+	ctx := context.Background()
+	transport := &recordingTransport{err: errors.New("connection refused")}
+
+	data := map[string]interface{}{"User": User{FirstName: "Ada", Email: "ada@example.com"}, "Time": time.Now()}
+	if err := QueueEmail(DB, "Ada <ada@example.com>", "key_rotated", data); err != nil {
+		t.Fatalf("QueueEmail: %v", err)
+	}
+
+	if err := SendQueuedEmails(ctx, transport); err != nil {
+		t.Fatalf("SendQueuedEmails: %v", err)
+	}
+	var email QueuedEmail
+	DB.First(&email)
+	if email.Status != EmailPending || email.Attempts != 1 || email.LastError != "connection refused" {
+		t.Errorf("after a failure: %+v, want pending with 1 attempt", email)
+	}
+	if email.NextAttemptAt == nil || time.Until(*email.NextAttemptAt) < mailBaseBackoff/2 {
+		t.Errorf("next attempt at %v, want about %v from now", email.NextAttemptAt, mailBaseBackoff)
+	}
+
+	// Pretend the backoff is over
+	transport.err = nil
+	DB.Model(&email).Update("next_attempt_at", time.Now())
+	if err := SendQueuedEmails(ctx, transport); err != nil {
+		t.Fatalf("SendQueuedEmails: %v", err)
+	}
+	DB.First(&email, email.ID)
+	if email.Status != EmailSent || email.SentAt == nil {
+		t.Errorf("after sending: %+v, want sent", email)
+	}
+
+	if len(transport.messages) != 1 {
+		t.Fatalf("transport got %d messages, want 1", len(transport.messages))
+	}
+	msg, err := mail.ReadMessage(strings.NewReader(string(transport.messages[0])))
+	if err != nil {
+		t.Fatalf("parse message: %v", err)
+	}
+	if msg.Header.Get("To") != `"Ada" <ada@example.com>` || msg.Header.Get("Subject") != "Your API key was replaced" {
+		t.Errorf("headers = %v", msg.Header)
+	}
+
+	_, params, _ := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	parts := multipart.NewReader(msg.Body, params["boundary"])
+	var types []string
+	for {
+		part, err := parts.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("read part: %v", err)
+		}
+		types = append(types, part.Header.Get("Content-Type"))
+	}
+	if strings.Join(types, ",") != "text/plain; charset=utf-8,text/html; charset=utf-8" {
+		t.Errorf("parts = %v, want text then HTML", types)
+	}
+}
+
+func TestRotateAPIKeyQueuesEmail(t *testing.T) {
+	setupTestDB(t)
+	user := createTestUser(t, "rotate@example.com")
+	server := httptest.NewServer(setupRouter(NewServices(DB)))
+	t.Cleanup(server.Close)
+
+	if status := apiCall(t, server, user.APIKey, http.MethodPost, "/keys/rotate", nil, nil); status != http.StatusOK {
+		t.Fatalf("POST /keys/rotate = %d", status)
+	}
+
+	var emails []QueuedEmail
+	DB.Find(&emails)
+	if len(emails) != 1 || emails[0].To != user.Email || emails[0].Template != "key_rotated" {
+		t.Errorf("queued emails = %+v, want one key_rotated email to %s", emails, user.Email)
+	}
+}
+
+func TestFileTransport(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "mail")
+	transport := FileTransport{Dir: dir}
+
+	if err := transport.Send(context.Background(), "a@example.com", []string{"b@example.com"}, []byte("Subject: hi\r\n\r\nhello")); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if len(files) != 1 {
+		t.Fatalf("found %d .eml files, want 1", len(files))
+	}
+	if content, _ := os.ReadFile(files[0]); string(content) != "Subject: hi\r\n\r\nhello" {
+		t.Errorf("file content = %q", content)
+	}
+}
+
+func TestNewMailTransport(t *testing.T) {
+	tests := []struct {
+		name      string
+		mode      string
+		transport string
+		smtpHost  string
+		want      MailTransport
+		wantErr   bool
+	}{
+		{"unset in development", gin.DebugMode, "", "", WriterTransport{Writer: os.Stdout}, false},
+		{"unset in release", gin.ReleaseMode, "", "", nil, true},
+		{"stdout in release", gin.ReleaseMode, "stdout", "", WriterTransport{Writer: os.Stdout}, false},
+		{"file in release", gin.ReleaseMode, "file", "", FileTransport{Dir: "mail"}, false},
+		{"smtp without a host", gin.ReleaseMode, "smtp", "", nil, true},
+		{"smtp", gin.ReleaseMode, "smtp", "mail.example.com", SMTPTransport{Addr: "mail.example.com:587"}, false},
+		{"unknown", gin.DebugMode, "pigeon", "", nil, true},
+	}
+
+	mode := gin.Mode()
+	t.Cleanup(func() { gin.SetMode(mode) })
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gin.SetMode(tt.mode)
+			t.Setenv("MAIL_TRANSPORT", tt.transport)
+			t.Setenv("MAIL_DIR", "")
+			t.Setenv("SMTP_HOST", tt.smtpHost)
+			t.Setenv("SMTP_PORT", "")
+			t.Setenv("SMTP_USERNAME", "")
+			t.Setenv("SMTP_PASSWORD", "")
+
+			got, err := NewMailTransport()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewMailTransport() error = %v, want error %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("NewMailTransport() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
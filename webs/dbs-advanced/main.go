@@ -1,14 +1,15 @@
 package main
 
 import (
-	"fmt"
+	"context"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
-	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 )
 
@@ -18,6 +19,21 @@ var DB *gorm.DB
 func main() {
 	loadEnv() // load credentials
 
+	// `migrate ...` manages the schema and `seed ...` loads fixtures, then
+	// they exit (see migrate.go and seed.go)
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrateCommand(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "seed" {
+		if err := runSeedCommand(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	initDB() // Initialize database, check connections if they are working perfectly.
 
 	// Set Gin mode from env
@@ -25,35 +41,239 @@ func main() {
 		gin.SetMode(mode) // set test, release or debug
 	}
 
+	// Only users with a confirmed email address may add albums
+	RequireVerifiedEmail = os.Getenv("REQUIRE_VERIFIED_EMAIL") == "true"
+
+	// Only for development: let webhooks call localhost and private networks
+	AllowPrivateWebhooks = os.Getenv("WEBHOOK_ALLOW_PRIVATE") == "true"
+
+	// What happens to a deleted account's albums unless DELETE /me says
+	switch policy := os.Getenv("ACCOUNT_DELETION_ALBUMS"); policy {
+	case "":
+	case AlbumsDelete, AlbumsOrphan, AlbumsTransfer:
+		DeletedAccountAlbums = policy
+	default:
+		log.Fatalf("ACCOUNT_DELETION_ALBUMS must be delete, orphan or transfer, not %q", policy)
+	}
+	DeletedAccountAlbumsTo = os.Getenv("ACCOUNT_DELETION_TRANSFER_TO")
+
+	// Get port from env or use default
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "8080"
+	}
+
+	// PUBLIC_URL is where browsers reach us, for links in emails and single
+	// sign-on for the web UI
+	PublicURL = strings.TrimSuffix(os.Getenv("PUBLIC_URL"), "/")
+	if PublicURL == "" {
+		PublicURL = "http://localhost:" + port
+	}
+	setupSSO(PublicURL)
+
+	services := NewServices(DB)
+	router := setupRouter(services)
+
+	// gRPC for internal services, sharing the album rules in service.go
+	grpcPort := os.Getenv("GRPC_PORT")
+	if grpcPort == "" {
+		grpcPort = "9090"
+	}
+	go serveGRPC(grpcPort, services)
+
+	// Send the webhook outbox written by album changes
+	go RunWebhookDispatcher(context.Background())
+
+	// Send queued emails through MAIL_TRANSPORT
+	transport, err := NewMailTransport()
+	if err != nil {
+		log.Fatal("Failed to set up mail:", err)
+	}
+	go RunMailer(context.Background(), transport)
+
+	// Build data exports too large to send straight away
+	go RunExporter(context.Background(), DB)
+
+	log.Printf("Server starting on port %s", port)
+	router.Run(":" + port)
+}
+
+// Services are what the handlers are built with. The account,
+// organization, sharing and export handlers work on their tables in DB
+// directly, inside their own transactions.
+type Services struct {
+	Users         UserRepository
+	Organizations OrganizationRepository
+	Webhooks      WebhookRepository
+	Albums        *AlbumService
+	DB            *gorm.DB
+}
+
+// NewServices keeps users, organizations, webhooks, albums and the rest in db
+func NewServices(db *gorm.DB) Services {
+	users := NewGormUserRepository(db)
+	return Services{
+		Users:         users,
+		Organizations: NewGormOrganizationRepository(db),
+		Webhooks:      NewGormWebhookRepository(db),
+		Albums:        NewAlbumService(NewGormAlbumRepository(db), users),
+		DB:            db,
+	}
+}
+
+// Build the Gin engine with all middleware and routes registered
+func setupRouter(services Services) *gin.Engine {
+	albums := NewAlbumHandlers(services.Albums)
+	webhooks := NewWebhookHandlers(services.Webhooks)
+	accounts := NewAccountHandlers(services.DB)
+	organizations := NewOrganizationHandlers(services.DB)
+	collaborators := NewCollaboratorHandlers(services.DB, services.Albums)
+	transfers := NewTransferHandlers(services.DB)
+	claims := NewClaimHandlers(services.DB)
+	exporter := NewExportHandlers(services.DB)
+	auth := AuthMiddleware(services.Users)
+	optionalAuth := OptionalAuth(services.Users)
+	tenant := TenantMiddleware(services.Organizations)
+
 	router := gin.Default()
+	router.Use(RequestID()) // X-Request-ID for matching client errors to logs
+
+	// API responses are JSON, XML, YAML or MessagePack based on headers, and
+	// requests are checked against openapi.json (after auth on protected routes)
+	negotiation := ContentNegotiation()
+	validator := OpenAPIValidator()
 
 	// Public routes (no authentication needed)
 	public := router.Group("/")
+	public.Use(negotiation, tenant, validator)
 	{
 		public.GET("/health", healthCheck)
-		public.GET("/albums", getAlbumsPublic) // Public endpoint to see all albums
+		public.POST("/login", accounts.postLogin) // Email + password -> API key
+		public.GET("/openapi.json", getOpenAPISpec)
+		public.GET("/docs", getAPIDocs) // HTML reference rendered from /openapi.json
+
+		// Account recovery and email verification with emailed tokens
+		public.POST("/password/forgot", accounts.postForgotPassword)
+		public.POST("/password/reset", accounts.postResetPassword)
+		public.POST("/email/verify", accounts.postVerifyEmail)
+		public.POST("/email/change/confirm", accounts.postConfirmEmailChange)
+	}
+
+	// The album list is public, but an organization's (X-Organization) is
+	// only for its members, so an API key is read when there is one
+	catalogue := router.Group("/")
+	catalogue.Use(negotiation, optionalAuth, tenant, validator)
+	{
+		catalogue.GET("/albums", albums.getAlbumsPublic) // Public endpoint to see all albums
 	}
 
 	// Protected routes (require API key)
 	protected := router.Group("/")
-	protected.Use(AuthMiddleware())
+	protected.Use(negotiation, auth, tenant, validator)
 	{
-		protected.GET("/my-albums", getMyAlbums)      // User's own albums
-		protected.POST("/albums", postAlbums)
-		protected.GET("/albums/:id", getAlbumByID)
-		protected.PUT("/albums/:id", updateAlbum)
-		protected.DELETE("/albums/:id", deleteAlbum)
+		protected.GET("/my-albums", albums.getMyAlbums) // User's own albums
+		protected.POST("/albums", albums.postAlbums)
+		protected.GET("/albums/:id", albums.getAlbumByID)
+		protected.PUT("/albums/:id", albums.updateAlbum)
+		protected.DELETE("/albums/:id", albums.deleteAlbum)
+		protected.POST("/albums/:id/transfer", transfers.postAlbumTransfer)
+		protected.GET("/albums/:id/collaborators", collaborators.getCollaborators)
+		protected.PUT("/albums/:id/collaborators", collaborators.putCollaborator)
+		protected.DELETE("/albums/:id/collaborators/:user_id", collaborators.deleteCollaborator)
+		protected.GET("/transfers", transfers.getTransfers)
+		protected.POST("/transfers/:id/accept", transfers.acceptTransfer)
+		protected.POST("/transfers/:id/reject", transfers.rejectTransfer)
+		protected.POST("/transfers/:id/cancel", transfers.cancelTransfer)
+		protected.POST("/albums/:id/claim", claims.postAlbumClaim)
+		protected.GET("/claims", claims.getMyClaims)
 		protected.GET("/me", getCurrentUser)
+		protected.PATCH("/me", accounts.patchCurrentUser)
+		protected.DELETE("/me", accounts.deleteCurrentUser)
+		protected.POST("/me/email", accounts.postChangeEmail)
+		protected.POST("/me/password", accounts.postChangePassword)
+		protected.POST("/keys/rotate", accounts.rotateAPIKey)
+		protected.POST("/email/verify/send", accounts.postSendVerificationEmail)
+		protected.POST("/2fa/enroll", accounts.postTwoFactorEnroll)
+		protected.POST("/2fa/confirm", accounts.postTwoFactorConfirm)
+		protected.POST("/2fa/disable", accounts.postTwoFactorDisable)
+		protected.GET("/me/exports/:id", exporter.getMyExportStatus)
+		protected.GET("/orgs", organizations.getOrganizations)
+		protected.POST("/orgs", organizations.postOrganization)
+
+		// Webhooks for changes to the user's albums, and their delivery logs
+		protected.GET("/webhooks", webhooks.getWebhooks)
+		protected.POST("/webhooks", webhooks.postWebhook)
+		protected.GET("/webhooks/:id", webhooks.getWebhook)
+		protected.DELETE("/webhooks/:id", webhooks.deleteWebhook)
+		protected.GET("/webhooks/:id/deliveries", webhooks.getWebhookDeliveries)
+		protected.POST("/webhooks/:id/deliveries/:delivery_id/redeliver", webhooks.redeliverWebhook)
 	}
 
-	// Get port from env or use default
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
+	// An organization's catalogue and members, chosen by the path instead of
+	// X-Organization; only its members get past TenantMiddleware
+	orgs := router.Group("/orgs/:org")
+	orgs.Use(negotiation, auth, tenant, validator)
+	{
+		orgs.GET("/albums", albums.getAlbumsPublic)
+		orgs.POST("/albums", albums.postAlbums)
+		orgs.GET("/albums/:id", albums.getAlbumByID)
+		orgs.PUT("/albums/:id", albums.updateAlbum)
+		orgs.DELETE("/albums/:id", albums.deleteAlbum)
+		orgs.GET("/members", organizations.getMembers)
+		orgs.PUT("/members", organizations.putMember)
+		orgs.DELETE("/members/:user_id", organizations.deleteMember)
 	}
 
-	log.Printf("Server starting on port %s", port)
-	router.Run(":" + port)
+	// Administration of other users' accounts
+	admin := router.Group("/admin")
+	admin.Use(negotiation, auth, RequireAdmin(), validator)
+	{
+		admin.GET("/exports/:id", exporter.getExportStatus)
+		admin.GET("/claims", claims.getClaims)
+		admin.POST("/claims/:id/approve", claims.approveClaim)
+		admin.POST("/claims/:id/reject", claims.rejectClaim)
+	}
+
+	// Data exports are ZIP archives, so they skip negotiation; the download
+	// link is signed rather than needing an API key
+	exports := router.Group("/")
+	exports.Use(auth, validator)
+	{
+		exports.GET("/me/export", exporter.getMyExport)
+		exports.GET("/admin/users/:id/export", RequireAdmin(), exporter.getUserExport)
+	}
+	download := router.Group("/exports")
+	download.Use(validator)
+	{
+		download.GET("/:id/download", exporter.getExportDownload)
+	}
+
+	// GraphQL always answers in JSON; an API key is optional here and checked per field
+	graph := router.Group("/")
+	graph.Use(optionalAuth, tenant, validator)
+	{
+		graph.POST("/graphql", albums.postGraphQL)
+	}
+
+	// Album change feeds stream text/event-stream or WebSocket frames, so they
+	// skip negotiation and response validation; the API key is optional, and
+	// only needed for scope=mine or an organization's catalogue
+	feed := router.Group("/albums/events")
+	feed.Use(optionalAuth, tenant)
+	{
+		feed.GET("", getAlbumEvents)
+		feed.GET("/ws", getAlbumEventsWS)
+	}
+
+	// HTML pages for people rather than programs
+	setupWeb(router, albums, accounts, services.Users)
+
+	// Development identity provider for single sign-on (OIDC_MOCK=true)
+	if mockIdP != nil {
+		mockIdP.Register(router.Group("/mock-idp"))
+	}
+
+	return router
 }
 
 // Load environment variables
@@ -66,47 +286,12 @@ func loadEnv() {
 	}
 }
 
-// Build database connection string from env variables
-func getDatabaseDSN() string {
-	host := os.Getenv("DB_HOST")
-	user := os.Getenv("DB_USER")
-	password := os.Getenv("DB_PASSWORD")
-	dbname := os.Getenv("DB_NAME")
-	port := os.Getenv("DB_PORT")
-	sslmode := os.Getenv("DB_SSLMODE")
-
-	if host == "" {
-		host = "localhost"
-	}
-	if port == "" {
-		port = "5432"
-	}
-	if sslmode == "" {
-		sslmode = "disable"
-	}
-
-	if user == "" || password == "" || dbname == "" {
-		log.Fatal("Missing required database credentials: DB_USER, DB_PASSWORD, or DB_NAME")
-	}
-
-	return fmt.Sprintf(
-		"host=%s user=%s password=%s dbname=%s port=%s sslmode=%s",
-		host, user, password, dbname, port, sslmode,
-	)
-}
-
-// Initialize PostgreSQL connection
+// Initialize the database connection
 func initDB() {
-	dsn := getDatabaseDSN()
+	connectDB()
 
-	var err error
-	DB, err = gorm.Open(postgres.Open(dsn), &gorm.Config{})
-	if err != nil {
-		log.Fatal("Failed to connect to database:", err)
-	}
-
-	// Auto migrate both tables
-	if err := DB.AutoMigrate(&User{}, &Album{}); err != nil {
+	// Apply the pending migrations in migrations/
+	if err := migrator.OnStart(DB); err != nil {
 		log.Fatal("Failed to migrate database:", err)
 	}
 
@@ -115,7 +300,7 @@ func initDB() {
 
 // Health check endpoint
 func healthCheck(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{
+	Respond(c, http.StatusOK, gin.H{
 		"status":  "ok",
 		"message": "Server is running",
 	})
@@ -125,210 +310,173 @@ func healthCheck(c *gin.Context) {
 func getCurrentUser(c *gin.Context) {
 	user, exists := GetCurrentUser(c)
 	if !exists {
-		c.JSON(http.StatusUnauthorized, ErrorResponse{
-			Success: false,
-			Error:   "User not found",
-		})
+		RespondError(c, CodeUserNotFound, "")
 		return
 	}
 
-	c.JSON(http.StatusOK, SuccessResponse{
+	Respond(c, http.StatusOK, SuccessResponse{
 		Success: true,
 		Data:    user,
 	})
 }
 
-// GET /albums - Public: Get all albums (including those without users)
-func getAlbumsPublic(c *gin.Context) {
-	var albums []Album
+// AlbumHandlers serve the album routes of the REST API, GraphQL and the web UI
+type AlbumHandlers struct {
+	albums *AlbumService
+}
+
+// NewAlbumHandlers serves albums with the rules in albums
+func NewAlbumHandlers(albums *AlbumService) *AlbumHandlers {
+	return &AlbumHandlers{albums: albums}
+}
+
+// GET /albums - Public: Get all albums (including those without users), ?limit=&offset= to page
+func (h *AlbumHandlers) getAlbumsPublic(c *gin.Context) {
+	page, issues := ParsePage(c)
+	if issues != nil {
+		RespondValidationError(c, "", issues)
+		return
+	}
 
 	// Get all albums and preload user if it exists
-	result := DB.Preload("User").Find(&albums)
-	if result.Error != nil {
-		c.JSON(http.StatusInternalServerError, ErrorResponse{
-			Success: false,
-			Error:   result.Error.Error(),
-		})
+	albums, err := h.albums.List(CurrentTenant(c), nil, page)
+	if err != nil {
+		RespondInternalError(c, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, SuccessResponse{
+	Respond(c, http.StatusOK, SuccessResponse{
 		Success: true,
 		Data:    albums,
 	})
 }
 
-// GET /my-albums - Protected: Get only authenticated user's albums
-func getMyAlbums(c *gin.Context) {
+// GET /my-albums - Protected: Get only authenticated user's albums, ?limit=&offset= to page
+func (h *AlbumHandlers) getMyAlbums(c *gin.Context) {
 	userID, _ := GetCurrentUserID(c)
-	var albums []Album
+
+	page, issues := ParsePage(c)
+	if issues != nil {
+		RespondValidationError(c, "", issues)
+		return
+	}
 
 	// Get only albums created by this user
-	result := DB.Where("user_id = ?", userID).Preload("User").Find(&albums)
-	if result.Error != nil {
-		c.JSON(http.StatusInternalServerError, ErrorResponse{
-			Success: false,
-			Error:   result.Error.Error(),
-		})
+	albums, err := h.albums.List(CurrentTenant(c), &userID, page)
+	if err != nil {
+		RespondInternalError(c, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, SuccessResponse{
+	Respond(c, http.StatusOK, SuccessResponse{
 		Success: true,
 		Data:    albums,
 	})
 }
 
 // POST /albums - Create new album (requires authentication)
-func postAlbums(c *gin.Context) {
+func (h *AlbumHandlers) postAlbums(c *gin.Context) {
 	userID, _ := GetCurrentUserID(c)
-	var newAlbum Album
+	var input AlbumInput
 
-	if err := c.BindJSON(&newAlbum); err != nil {
-		c.JSON(http.StatusBadRequest, ErrorResponse{
-			Success: false,
-			Error:   err.Error(),
-		})
+	if err := BindBody(c, &input); err != nil {
+		RespondError(c, CodeValidationFailed, err.Error())
 		return
 	}
 
-	// Set the user who created this album
-	newAlbum.UserID = &userID
-
-	result := DB.Create(&newAlbum)
-	if result.Error != nil {
-		c.JSON(http.StatusInternalServerError, ErrorResponse{
-			Success: false,
-			Error:   result.Error.Error(),
-		})
+	// The album belongs to the user who created it
+	newAlbum, err := h.albums.Create(CurrentTenant(c), userID, input)
+	if err != nil {
+		RespondDBError(c, err, CodeAlbumNotFound)
 		return
 	}
 
-	// Load user relationship
-	DB.Preload("User").First(&newAlbum, newAlbum.ID)
-
-	c.JSON(http.StatusCreated, SuccessResponse{
+	Respond(c, http.StatusCreated, SuccessResponse{
 		Success: true,
 		Data:    newAlbum,
 	})
 }
 
-// GET /albums/:id - Get album by ID (user can only access their own albums)
-func getAlbumByID(c *gin.Context) {
-	id := c.Param("id")
+// GET /albums/:id - Get album by ID (its owner and collaborators can read it)
+func (h *AlbumHandlers) getAlbumByID(c *gin.Context) {
 	userID, _ := GetCurrentUserID(c)
-	var album Album
-
-	// Find album and check ownership
-	result := DB.Preload("User").First(&album, id)
-	if result.Error != nil {
-		c.JSON(http.StatusNotFound, ErrorResponse{
-			Success: false,
-			Error:   "Album not found",
-		})
+	id, ok := albumIDParam(c)
+	if !ok {
 		return
 	}
 
-	// Check if user owns this album (or if album has no owner)
-	if album.UserID != nil && *album.UserID != userID {
-		c.JSON(http.StatusForbidden, ErrorResponse{
-			Success: false,
-			Error:   "You don't have permission to access this album",
-		})
+	// Find album and check the user's role on it (albums without an owner can be read by everyone)
+	album, err := h.albums.Get(CurrentTenant(c), userID, id)
+	if err != nil {
+		RespondDBError(c, err, CodeAlbumNotFound)
 		return
 	}
 
-	c.JSON(http.StatusOK, SuccessResponse{
+	Respond(c, http.StatusOK, SuccessResponse{
 		Success: true,
 		Data:    album,
 	})
 }
 
-// PUT /albums/:id - Update album (only if user owns it)
-func updateAlbum(c *gin.Context) {
-	id := c.Param("id")
+// PUT /albums/:id - Update album (its owner and editors can change it)
+func (h *AlbumHandlers) updateAlbum(c *gin.Context) {
 	userID, _ := GetCurrentUserID(c)
-	var album Album
-
-	// Find the album
-	if err := DB.First(&album, id).Error; err != nil {
-		c.JSON(http.StatusNotFound, ErrorResponse{
-			Success: false,
-			Error:   "Album not found",
-		})
+	id, ok := albumIDParam(c)
+	if !ok {
 		return
 	}
 
-	// Check ownership
-	if album.UserID != nil && *album.UserID != userID {
-		c.JSON(http.StatusForbidden, ErrorResponse{
-			Success: false,
-			Error:   "You don't have permission to update this album",
-		})
+	// Only the fields in the body change; id and user_id can't be set this way
+	var input AlbumInput
+	if err := BindBody(c, &input); err != nil {
+		RespondError(c, CodeValidationFailed, err.Error())
 		return
 	}
 
-	// If album has no owner, assign to current user
-	if album.UserID == nil {
-		album.UserID = &userID
-	}
-
-	// Bind and update
-	if err := c.BindJSON(&album); err != nil {
-		c.JSON(http.StatusBadRequest, ErrorResponse{
-			Success: false,
-			Error:   err.Error(),
-		})
+	// Albums without an owner are read-only until a claim is approved
+	album, err := h.albums.Update(CurrentTenant(c), userID, id, input)
+	if err != nil {
+		RespondDBError(c, err, CodeAlbumNotFound)
 		return
 	}
 
-	// Ensure user_id doesn't change
-	album.UserID = &userID
-	DB.Save(&album)
-
-	DB.Preload("User").First(&album, album.ID)
-
-	c.JSON(http.StatusOK, SuccessResponse{
+	Respond(c, http.StatusOK, SuccessResponse{
 		Success: true,
 		Data:    album,
 	})
 }
 
-// DELETE /albums/:id - Delete album (only if user owns it)
-func deleteAlbum(c *gin.Context) {
-	id := c.Param("id")
+// DELETE /albums/:id - Delete album (only if user owns it, or an admin for one without an owner)
+func (h *AlbumHandlers) deleteAlbum(c *gin.Context) {
 	userID, _ := GetCurrentUserID(c)
-	var album Album
-
-	// Find the album
-	if err := DB.First(&album, id).Error; err != nil {
-		c.JSON(http.StatusNotFound, ErrorResponse{
-			Success: false,
-			Error:   "Album not found",
-		})
-		return
-	}
-
-	// Check ownership
-	if album.UserID != nil && *album.UserID != userID {
-		c.JSON(http.StatusForbidden, ErrorResponse{
-			Success: false,
-			Error:   "You don't have permission to delete this album",
-		})
+	id, ok := albumIDParam(c)
+	if !ok {
 		return
 	}
 
-	result := DB.Delete(&album)
-	if result.Error != nil {
-		c.JSON(http.StatusInternalServerError, ErrorResponse{
-			Success: false,
-			Error:   result.Error.Error(),
-		})
+	if err := h.albums.Delete(CurrentTenant(c), userID, id); err != nil {
+		RespondDBError(c, err, CodeAlbumNotFound)
 		return
 	}
 
-	c.JSON(http.StatusOK, SuccessResponse{
+	Respond(c, http.StatusOK, SuccessResponse{
 		Success: true,
 		Data:    gin.H{"message": "Album deleted successfully"},
 	})
-}
\ No newline at end of file
+}
+
+// albumIDParam parses :id, answering 400 itself when it isn't a positive integer
+func albumIDParam(c *gin.Context) (uint, bool) {
+	return uintParam(c, "id")
+}
+
+// uintParam parses a path param that holds an ID, answering 400 itself when
+// it isn't a positive integer
+func uintParam(c *gin.Context, name string) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param(name), 10, 0)
+	if err != nil || id == 0 {
+		RespondValidationError(c, "", []ValidationIssue{{Location: "path", Field: name, Message: "must be a positive integer"}})
+		return 0, false
+	}
+	return uint(id), true
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// In-memory repositories, for tests and tools that want the album rules
+// without a database. They keep no webhook outbox, and sharing and
+// membership are set up with AddCollaborator and AddMember rather than
+// through the API.
+
+// MemoryUserRepository keeps users in a map
+type MemoryUserRepository struct {
+	mu     sync.RWMutex
+	users  map[uint]User
+	nextID uint
+}
+
+// NewMemoryUserRepository starts with no users
+func NewMemoryUserRepository() *MemoryUserRepository {
+	return &MemoryUserRepository{users: map[uint]User{}}
+}
+
+// Add stores a user, giving it the next ID when it has none
+func (r *MemoryUserRepository) Add(user User) User {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if user.ID == 0 {
+		r.nextID++
+		user.ID = r.nextID
+	} else if user.ID > r.nextID {
+		r.nextID = user.ID
+	}
+	r.users[user.ID] = user
+	return user
+}
+
+func (r *MemoryUserRepository) Get(id uint) (User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	user, ok := r.users[id]
+	if !ok {
+		return User{}, gorm.ErrRecordNotFound
+	}
+	return user, nil
+}
+
+func (r *MemoryUserRepository) FindByAPIKey(apiKey string) (User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, user := range r.users {
+		if apiKey != "" && user.APIKey == apiKey {
+			return user, nil
+		}
+	}
+	return User{}, gorm.ErrRecordNotFound
+}
+
+// MemoryAlbumRepository keeps albums in a map, with their owners from users
+type MemoryAlbumRepository struct {
+	mu            sync.RWMutex
+	users         *MemoryUserRepository
+	albums        map[uint]Album
+	nextID        uint
+	collaborators map[[2]uint]string // album ID, user ID -> role
+	members       map[[2]uint]string // organization ID, user ID -> role
+}
+
+// NewMemoryAlbumRepository starts with no albums
+func NewMemoryAlbumRepository(users *MemoryUserRepository) *MemoryAlbumRepository {
+	return &MemoryAlbumRepository{
+		users:         users,
+		albums:        map[uint]Album{},
+		collaborators: map[[2]uint]string{},
+		members:       map[[2]uint]string{},
+	}
+}
+
+// AddCollaborator shares an album with userID
+func (r *MemoryAlbumRepository) AddCollaborator(albumID, userID uint, role string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.collaborators[[2]uint{albumID, userID}] = role
+}
+
+// AddMember gives userID a role in an organization
+func (r *MemoryAlbumRepository) AddMember(organizationID, userID uint, role string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.members[[2]uint{organizationID, userID}] = role
+}
+
+// withOwner is album with its owner loaded, like Preload("User")
+func (r *MemoryAlbumRepository) withOwner(album Album) Album {
+	album.User = nil
+	if album.UserID != nil {
+		if owner, err := r.users.Get(*album.UserID); err == nil {
+			album.User = &owner
+		}
+	}
+	return album
+}
+
+func (r *MemoryAlbumRepository) List(tenant Tenant, ownerID *uint, page Page) ([]Album, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	albums := []Album{}
+	for _, album := range r.albums {
+		if !tenant.Contains(album) || (ownerID != nil && (album.UserID == nil || *album.UserID != *ownerID)) {
+			continue
+		}
+		albums = append(albums, r.withOwner(album))
+	}
+	sort.Slice(albums, func(i, j int) bool { return albums[i].ID < albums[j].ID })
+
+	if page.Limit > 0 {
+		albums = albums[min(page.Offset, len(albums)):min(page.Offset+page.Limit, len(albums))]
+	}
+	return albums, nil
+}
+
+func (r *MemoryAlbumRepository) Get(tenant Tenant, id uint) (Album, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	album, ok := r.albums[id]
+	if !ok || !tenant.Contains(album) {
+		return Album{}, gorm.ErrRecordNotFound
+	}
+	return r.withOwner(album), nil
+}
+
+func (r *MemoryAlbumRepository) CollaboratorRole(albumID, userID uint) (string, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.collaborators[[2]uint{albumID, userID}], nil
+}
+
+func (r *MemoryAlbumRepository) MemberRole(organizationID, userID uint) (string, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.members[[2]uint{organizationID, userID}], nil
+}
+
+func (r *MemoryAlbumRepository) Create(album *Album) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.nextID++
+	album.ID = r.nextID
+	album.CreatedAt = time.Now()
+	album.UpdatedAt = album.CreatedAt
+	album.User = nil
+	r.albums[album.ID] = *album
+	*album = r.withOwner(*album)
+	return nil
+}
+
+func (r *MemoryAlbumRepository) Update(album *Album) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.albums[album.ID]; !ok {
+		return gorm.ErrRecordNotFound
+	}
+	album.UpdatedAt = time.Now()
+	album.User = nil
+	r.albums[album.ID] = *album
+	*album = r.withOwner(*album)
+	return nil
+}
+
+func (r *MemoryAlbumRepository) Delete(album Album) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.albums[album.ID]; !ok {
+		return gorm.ErrRecordNotFound
+	}
+	delete(r.albums, album.ID)
+	for key := range r.collaborators {
+		if key[0] == album.ID {
+			delete(r.collaborators, key)
+		}
+	}
+	return nil
+}
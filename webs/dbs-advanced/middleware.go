@@ -1,44 +1,42 @@
 package main
 
 import (
-	"net/http"
 	"strings"
 
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 )
 
-// AuthMiddleware validates API key and attaches user to context
-func AuthMiddleware() gin.HandlerFunc {
+// RequestID tags every request with an ID (reusing X-Request-ID if the client
+// sent one) so errors seen by clients can be matched to server logs.
+func RequestID() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// Get API key from header
-		apiKey := c.GetHeader("X-API-Key")
-		
-		// Also check Authorization header as fallback
-		if apiKey == "" {
-			authHeader := c.GetHeader("Authorization")
-			if strings.HasPrefix(authHeader, "Bearer ") {
-				apiKey = strings.TrimPrefix(authHeader, "Bearer ")
-			}
+		requestID := c.GetHeader("X-Request-ID")
+		if requestID == "" {
+			requestID = uuid.NewString()
 		}
 
+		c.Set("request_id", requestID)
+		c.Header("X-Request-ID", requestID)
+
+		c.Next()
+	}
+}
+
+// AuthMiddleware validates API key against users and attaches user to context
+func AuthMiddleware(users UserRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		apiKey := apiKeyFromRequest(c)
 		if apiKey == "" {
-			c.JSON(http.StatusUnauthorized, ErrorResponse{
-				Success: false,
-				Error:   "API key is required. Provide it in X-API-Key header",
-			})
-			c.Abort()
+			AbortWithError(c, CodeAPIKeyRequired, "")
 			return
 		}
 
 		// Find user by API key
-		var user User
-		result := DB.Where("api_key = ?", apiKey).First(&user)
-		if result.Error != nil {
-			c.JSON(http.StatusUnauthorized, ErrorResponse{
-				Success: false,
-				Error:   "Invalid API key",
-			})
+		user, err := users.FindByAPIKey(apiKey)
+		if err != nil {
 			c.Abort()
+			RespondDBError(c, err, CodeAPIKeyInvalid)
 			return
 		}
 
@@ -50,6 +48,45 @@ func AuthMiddleware() gin.HandlerFunc {
 	}
 }
 
+// RequireAdmin lets only administrators through. It goes after AuthMiddleware.
+func RequireAdmin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if user, _ := GetCurrentUser(c); !user.IsAdmin {
+			AbortWithError(c, CodeAdminRequired, "")
+			return
+		}
+		c.Next()
+	}
+}
+
+// OptionalAuth is AuthMiddleware for routes that also serve anonymous
+// callers: without a key the request goes on with no user, but a wrong key
+// is still rejected rather than silently ignored.
+func OptionalAuth(users UserRepository) gin.HandlerFunc {
+	required := AuthMiddleware(users)
+	return func(c *gin.Context) {
+		if apiKeyFromRequest(c) == "" {
+			c.Next()
+			return
+		}
+		required(c)
+	}
+}
+
+// apiKeyFromRequest reads the API key from X-API-Key, falling back to
+// Authorization: Bearer
+func apiKeyFromRequest(c *gin.Context) string {
+	if apiKey := c.GetHeader("X-API-Key"); apiKey != "" {
+		return apiKey
+	}
+
+	authHeader := c.GetHeader("Authorization")
+	if strings.HasPrefix(authHeader, "Bearer ") {
+		return strings.TrimPrefix(authHeader, "Bearer ")
+	}
+	return ""
+}
+
 // GetCurrentUser retrieves authenticated user from context
 func GetCurrentUser(c *gin.Context) (User, bool) {
 	user, exists := c.Get("user")
@@ -66,4 +103,9 @@ func GetCurrentUserID(c *gin.Context) (uint, bool) {
 		return 0, false
 	}
 	return userID.(uint), true
-}
\ No newline at end of file
+}
+
+// GetRequestID retrieves the request ID set by RequestID
+func GetRequestID(c *gin.Context) string {
+	return c.GetString("request_id")
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"embed"
+
+	"example.com/albums/migrate"
+	"gorm.io/gorm"
+)
+
+// The schema is built by the versioned SQL files in migrations/, compiled
+// into the binary (see example.com/albums/migrate). dbs-advanced owns the
+// users and albums tables: gorm-queries may share the database and use
+// albums, but keeps its history in its own table and never drops albums.
+//
+//go:embed migrations
+var migrationFiles embed.FS
+
+// migrator applies dbs-advanced's migrations, recorded in schema_migrations
+var migrator = migrate.Migrator{Files: migrationFiles, Dir: "migrations", Table: "schema_migrations"}
+
+// runMigrateCommand runs `migrate up [N]`, `migrate down [N]`, `migrate status`
+// or `migrate create NAME`
+func runMigrateCommand(args []string) error {
+	return migrator.Command(args, func() *gorm.DB {
+		connectDB()
+		return DB
+	})
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+// The migrations compiled in are well formed, with the same versions for
+// every database
+func TestMigrationsMatchAcrossDatabases(t *testing.T) {
+	postgres, err := migrator.Migrations("postgres")
+	if err != nil {
+		t.Fatalf("postgres migrations: %v", err)
+	}
+	sqlite, err := migrator.Migrations("sqlite")
+	if err != nil {
+		t.Fatalf("sqlite migrations: %v", err)
+	}
+	if len(postgres) != len(sqlite) {
+		t.Fatalf("%d postgres migrations but %d for sqlite", len(postgres), len(sqlite))
+	}
+	for i := range postgres {
+		if postgres[i].Version != sqlite[i].Version || postgres[i].Name != sqlite[i].Name {
+			t.Errorf("postgres has %04d_%s where sqlite has %04d_%s", postgres[i].Version, postgres[i].Name, sqlite[i].Version, sqlite[i].Name)
+		}
+	}
+}
+
+func TestMigrateDownAndUp(t *testing.T) {
+	setupTestDB(t)
+
+	undone, err := migrator.Down(DB, 1)
+	if err != nil || len(undone) != 1 || undone[0].Name != "add_missing_user_columns" {
+		t.Fatalf("Down = %+v, %v; want add_missing_user_columns undone", undone, err)
+	}
+	undone, err = migrator.Down(DB, 1)
+	if err != nil || len(undone) != 1 || undone[0].Name != "organizations" {
+		t.Fatalf("Down = %+v, %v; want organizations undone", undone, err)
+	}
+	if DB.Migrator().HasTable(&Organization{}) || DB.Migrator().HasColumn(&Album{}, "organization_id") {
+		t.Error("organizations are still in the schema")
+	}
+	statuses, err := migrator.Statuses(DB)
+	if err != nil || statuses[len(statuses)-1].AppliedAt != nil {
+		t.Errorf("statuses = %+v, %v; want the last one pending", statuses, err)
+	}
+
+	applied, err := migrator.Up(DB, 0)
+	if err != nil || len(applied) != 2 || applied[0].Name != "organizations" {
+		t.Fatalf("Up = %+v, %v; want organizations applied", applied, err)
+	}
+	if !DB.Migrator().HasTable(&Organization{}) {
+		t.Error("organizations are missing from the schema")
+	}
+}
+
+// Every model column is in the schema the migrations build, so a column
+// added to a model without a migration is caught
+func TestMigrationsBuildEveryModelColumn(t *testing.T) {
+	setupTestDB(t)
+
+	models := []interface{}{&User{}, &Album{}, &AlbumCollaborator{}, &Organization{}, &OrganizationMember{}, &AlbumTransfer{}, &AlbumClaim{},
+		&UserToken{}, &RecoveryCode{}, &UserIdentity{}, &Webhook{}, &OutboxEvent{}, &WebhookDelivery{}, &QueuedEmail{}, &DataExport{}}
+	for _, model := range models {
+		stmt := &gorm.Statement{DB: DB}
+		if err := stmt.Parse(model); err != nil {
+			t.Fatalf("parse %T: %v", model, err)
+		}
+		for _, field := range stmt.Schema.Fields {
+			if field.DBName != "" && !DB.Migrator().HasColumn(model, field.DBName) {
+				t.Errorf("%s.%s has no migration", stmt.Schema.Table, field.DBName)
+			}
+		}
+	}
+}
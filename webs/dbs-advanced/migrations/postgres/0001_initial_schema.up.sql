@@ -0,0 +1,226 @@
+-- The schema as the last release built it with AutoMigrate. Everything is
+-- IF NOT EXISTS so a database that release already set up is taken over as
+-- it is.
+
+CREATE TABLE IF NOT EXISTS "users" (
+  "id" bigserial,
+  "first_name" text NOT NULL,
+  "last_name" text NOT NULL,
+  "email" text NOT NULL,
+  "password" text NOT NULL,
+  "api_key" text NOT NULL,
+  "created_at" timestamptz,
+  "updated_at" timestamptz,
+  "deleted_at" timestamptz,
+  "is_admin" boolean NOT NULL DEFAULT false,
+  "email_verified_at" timestamptz,
+  "sessions_revoked_at" timestamptz,
+  "totp_secret" text,
+  "two_factor_enabled_at" timestamptz,
+  "totp_last_used_step" bigint NOT NULL DEFAULT 0,
+  "failed_otp_attempts" bigint NOT NULL DEFAULT 0,
+  "otp_locked_until" timestamptz,
+  PRIMARY KEY ("id")
+);
+CREATE INDEX IF NOT EXISTS "idx_users_deleted_at" ON "users" ("deleted_at");
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_users_api_key" ON "users" ("api_key");
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_users_email" ON "users" ("email");
+
+CREATE TABLE IF NOT EXISTS "albums" (
+  "id" bigserial,
+  "title" text NOT NULL,
+  "artist" text NOT NULL,
+  "price" decimal NOT NULL,
+  "user_id" bigint,
+  "created_at" timestamptz,
+  "updated_at" timestamptz,
+  "deleted_at" timestamptz,
+  PRIMARY KEY ("id"),
+  CONSTRAINT "fk_albums_user" FOREIGN KEY ("user_id") REFERENCES "users"("id")
+);
+-- gorm-queries keeps its albums in the same table when the two share a
+-- database, and may have created it with only the columns it needs
+ALTER TABLE "albums"
+  ADD COLUMN IF NOT EXISTS "user_id" bigint,
+  ADD COLUMN IF NOT EXISTS "created_at" timestamptz,
+  ADD COLUMN IF NOT EXISTS "updated_at" timestamptz,
+  ADD COLUMN IF NOT EXISTS "deleted_at" timestamptz;
+DO $$
+BEGIN
+  ALTER TABLE "albums" ADD CONSTRAINT "fk_albums_user" FOREIGN KEY ("user_id") REFERENCES "users"("id");
+EXCEPTION WHEN duplicate_object THEN
+  NULL;
+END $$;
+CREATE INDEX IF NOT EXISTS "idx_albums_deleted_at" ON "albums" ("deleted_at");
+CREATE INDEX IF NOT EXISTS "idx_albums_user_id" ON "albums" ("user_id");
+
+CREATE TABLE IF NOT EXISTS "webhooks" (
+  "id" bigserial,
+  "user_id" bigint NOT NULL,
+  "url" text NOT NULL,
+  "secret" text NOT NULL,
+  "events" text NOT NULL,
+  "created_at" timestamptz,
+  "updated_at" timestamptz,
+  "deleted_at" timestamptz,
+  PRIMARY KEY ("id")
+);
+CREATE INDEX IF NOT EXISTS "idx_webhooks_deleted_at" ON "webhooks" ("deleted_at");
+CREATE INDEX IF NOT EXISTS "idx_webhooks_user_id" ON "webhooks" ("user_id");
+
+CREATE TABLE IF NOT EXISTS "outbox_events" (
+  "id" bigserial,
+  "user_id" bigint NOT NULL,
+  "type" text NOT NULL,
+  "payload" text NOT NULL,
+  "created_at" timestamptz,
+  "processed_at" timestamptz,
+  PRIMARY KEY ("id")
+);
+CREATE INDEX IF NOT EXISTS "idx_outbox_events_processed_at" ON "outbox_events" ("processed_at");
+CREATE INDEX IF NOT EXISTS "idx_outbox_events_user_id" ON "outbox_events" ("user_id");
+
+CREATE TABLE IF NOT EXISTS "webhook_deliveries" (
+  "id" bigserial,
+  "webhook_id" bigint NOT NULL,
+  "outbox_event_id" bigint NOT NULL,
+  "event_type" text NOT NULL,
+  "status" text NOT NULL,
+  "attempts" bigint NOT NULL,
+  "next_attempt_at" timestamptz,
+  "last_status_code" bigint,
+  "last_error" text,
+  "delivered_at" timestamptz,
+  "created_at" timestamptz,
+  "updated_at" timestamptz,
+  PRIMARY KEY ("id")
+);
+CREATE INDEX IF NOT EXISTS "idx_webhook_deliveries_next_attempt_at" ON "webhook_deliveries" ("next_attempt_at");
+CREATE INDEX IF NOT EXISTS "idx_webhook_deliveries_status" ON "webhook_deliveries" ("status");
+CREATE INDEX IF NOT EXISTS "idx_webhook_deliveries_outbox_event_id" ON "webhook_deliveries" ("outbox_event_id");
+CREATE INDEX IF NOT EXISTS "idx_webhook_deliveries_webhook_id" ON "webhook_deliveries" ("webhook_id");
+
+CREATE TABLE IF NOT EXISTS "queued_emails" (
+  "id" bigserial,
+  "to" text NOT NULL,
+  "template" text NOT NULL,
+  "subject" text NOT NULL,
+  "text_body" text NOT NULL,
+  "html_body" text NOT NULL,
+  "status" text NOT NULL,
+  "attempts" bigint NOT NULL,
+  "next_attempt_at" timestamptz,
+  "last_error" text,
+  "sent_at" timestamptz,
+  "created_at" timestamptz,
+  "updated_at" timestamptz,
+  PRIMARY KEY ("id")
+);
+CREATE INDEX IF NOT EXISTS "idx_queued_emails_next_attempt_at" ON "queued_emails" ("next_attempt_at");
+CREATE INDEX IF NOT EXISTS "idx_queued_emails_status" ON "queued_emails" ("status");
+
+CREATE TABLE IF NOT EXISTS "user_tokens" (
+  "id" bigserial,
+  "user_id" bigint NOT NULL,
+  "purpose" text NOT NULL,
+  "token_hash" text NOT NULL,
+  "email" text,
+  "expires_at" timestamptz,
+  "used_at" timestamptz,
+  "created_at" timestamptz,
+  PRIMARY KEY ("id")
+);
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_user_tokens_token_hash" ON "user_tokens" ("token_hash");
+CREATE INDEX IF NOT EXISTS "idx_user_tokens_user_id" ON "user_tokens" ("user_id");
+
+CREATE TABLE IF NOT EXISTS "recovery_codes" (
+  "id" bigserial,
+  "user_id" bigint NOT NULL,
+  "code_hash" text NOT NULL,
+  "used_at" timestamptz,
+  "created_at" timestamptz,
+  PRIMARY KEY ("id")
+);
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_recovery_codes_code_hash" ON "recovery_codes" ("code_hash");
+CREATE INDEX IF NOT EXISTS "idx_recovery_codes_user_id" ON "recovery_codes" ("user_id");
+
+CREATE TABLE IF NOT EXISTS "user_identities" (
+  "id" bigserial,
+  "user_id" bigint NOT NULL,
+  "issuer" text NOT NULL,
+  "subject" text NOT NULL,
+  "email" text NOT NULL,
+  "created_at" timestamptz,
+  PRIMARY KEY ("id")
+);
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_user_identities_issuer_subject" ON "user_identities" ("issuer", "subject");
+CREATE INDEX IF NOT EXISTS "idx_user_identities_user_id" ON "user_identities" ("user_id");
+
+CREATE TABLE IF NOT EXISTS "data_exports" (
+  "id" bigserial,
+  "user_id" bigint NOT NULL,
+  "requested_by_id" bigint NOT NULL,
+  "status" text NOT NULL,
+  "archive" bytea,
+  "size" bigint NOT NULL DEFAULT 0,
+  "attempts" bigint NOT NULL DEFAULT 0,
+  "lease_until" timestamptz,
+  "last_error" text,
+  "expires_at" timestamptz,
+  "completed_at" timestamptz,
+  "created_at" timestamptz,
+  "updated_at" timestamptz,
+  PRIMARY KEY ("id")
+);
+CREATE INDEX IF NOT EXISTS "idx_data_exports_status" ON "data_exports" ("status");
+CREATE INDEX IF NOT EXISTS "idx_data_exports_requested_by_id" ON "data_exports" ("requested_by_id");
+CREATE INDEX IF NOT EXISTS "idx_data_exports_user_id" ON "data_exports" ("user_id");
+
+CREATE TABLE IF NOT EXISTS "album_collaborators" (
+  "id" bigserial,
+  "album_id" bigint NOT NULL,
+  "user_id" bigint NOT NULL,
+  "role" text NOT NULL,
+  "created_at" timestamptz,
+  "updated_at" timestamptz,
+  PRIMARY KEY ("id")
+);
+CREATE INDEX IF NOT EXISTS "idx_album_collaborators_user_id" ON "album_collaborators" ("user_id");
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_album_collaborators_album_user" ON "album_collaborators" ("album_id", "user_id");
+
+CREATE TABLE IF NOT EXISTS "album_transfers" (
+  "id" bigserial,
+  "album_id" bigint NOT NULL,
+  "from_user_id" bigint NOT NULL,
+  "to_user_id" bigint NOT NULL,
+  "message" text,
+  "status" text NOT NULL,
+  "responded_at" timestamptz,
+  "created_at" timestamptz,
+  "updated_at" timestamptz,
+  PRIMARY KEY ("id"),
+  CONSTRAINT "fk_album_transfers_album" FOREIGN KEY ("album_id") REFERENCES "albums"("id")
+);
+CREATE INDEX IF NOT EXISTS "idx_album_transfers_to_user_id" ON "album_transfers" ("to_user_id");
+CREATE INDEX IF NOT EXISTS "idx_album_transfers_from_user_id" ON "album_transfers" ("from_user_id");
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_album_transfers_pending" ON "album_transfers" ("album_id") WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS "idx_album_transfers_album_id" ON "album_transfers" ("album_id");
+
+CREATE TABLE IF NOT EXISTS "album_claims" (
+  "id" bigserial,
+  "album_id" bigint NOT NULL,
+  "user_id" bigint NOT NULL,
+  "reason" text,
+  "status" text NOT NULL,
+  "reviewed_by_id" bigint,
+  "review_note" text,
+  "reviewed_at" timestamptz,
+  "created_at" timestamptz,
+  "updated_at" timestamptz,
+  PRIMARY KEY ("id"),
+  CONSTRAINT "fk_album_claims_album" FOREIGN KEY ("album_id") REFERENCES "albums"("id")
+);
+CREATE INDEX IF NOT EXISTS "idx_album_claims_status" ON "album_claims" ("status");
+CREATE INDEX IF NOT EXISTS "idx_album_claims_user_id" ON "album_claims" ("user_id");
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_album_claims_pending" ON "album_claims" ("album_id", "user_id") WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS "idx_album_claims_album_id" ON "album_claims" ("album_id");
@@ -0,0 +1,6 @@
+-- Organizations' albums stay, in the shared catalogue and without an owner
+
+DROP INDEX IF EXISTS "idx_albums_organization_id";
+ALTER TABLE "albums" DROP COLUMN IF EXISTS "organization_id";
+DROP TABLE IF EXISTS "organization_members";
+DROP TABLE IF EXISTS "organizations";
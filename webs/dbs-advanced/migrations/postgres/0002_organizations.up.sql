@@ -0,0 +1,26 @@
+-- Organizations with their own album catalogues
+
+CREATE TABLE IF NOT EXISTS "organizations" (
+  "id" bigserial,
+  "name" text NOT NULL,
+  "slug" text NOT NULL,
+  "created_at" timestamptz,
+  "updated_at" timestamptz,
+  PRIMARY KEY ("id")
+);
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_organizations_slug" ON "organizations" ("slug");
+
+CREATE TABLE IF NOT EXISTS "organization_members" (
+  "id" bigserial,
+  "organization_id" bigint NOT NULL,
+  "user_id" bigint NOT NULL,
+  "role" text NOT NULL,
+  "created_at" timestamptz,
+  "updated_at" timestamptz,
+  PRIMARY KEY ("id")
+);
+CREATE INDEX IF NOT EXISTS "idx_organization_members_user_id" ON "organization_members" ("user_id");
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_organization_members_org_user" ON "organization_members" ("organization_id", "user_id");
+
+ALTER TABLE "albums" ADD COLUMN IF NOT EXISTS "organization_id" bigint;
+CREATE INDEX IF NOT EXISTS "idx_albums_organization_id" ON "albums" ("organization_id");
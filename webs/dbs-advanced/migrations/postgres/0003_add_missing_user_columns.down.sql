@@ -0,0 +1,2 @@
+-- Nothing to undo: the columns are part of the users table 0001 creates, and
+-- undoing 0001 drops them with it
@@ -0,0 +1,12 @@
+-- 0001 takes over a users table the last release built as it is, and that
+-- table predates these columns
+
+ALTER TABLE "users"
+  ADD COLUMN IF NOT EXISTS "is_admin" boolean NOT NULL DEFAULT false,
+  ADD COLUMN IF NOT EXISTS "email_verified_at" timestamptz,
+  ADD COLUMN IF NOT EXISTS "sessions_revoked_at" timestamptz,
+  ADD COLUMN IF NOT EXISTS "totp_secret" text,
+  ADD COLUMN IF NOT EXISTS "two_factor_enabled_at" timestamptz,
+  ADD COLUMN IF NOT EXISTS "totp_last_used_step" bigint NOT NULL DEFAULT 0,
+  ADD COLUMN IF NOT EXISTS "failed_otp_attempts" bigint NOT NULL DEFAULT 0,
+  ADD COLUMN IF NOT EXISTS "otp_locked_until" timestamptz;
@@ -0,0 +1,13 @@
+DROP TABLE "album_claims";
+DROP TABLE "album_transfers";
+DROP TABLE "album_collaborators";
+DROP TABLE "data_exports";
+DROP TABLE "user_identities";
+DROP TABLE "recovery_codes";
+DROP TABLE "user_tokens";
+DROP TABLE "queued_emails";
+DROP TABLE "webhook_deliveries";
+DROP TABLE "outbox_events";
+DROP TABLE "webhooks";
+DROP TABLE "albums";
+DROP TABLE "users";
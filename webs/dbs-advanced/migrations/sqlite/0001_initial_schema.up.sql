@@ -0,0 +1,198 @@
+-- The schema for SQLite, as migrations/postgres/0001 builds it for Postgres
+
+CREATE TABLE "users" (
+  "id" integer PRIMARY KEY AUTOINCREMENT,
+  "first_name" text NOT NULL,
+  "last_name" text NOT NULL,
+  "email" text NOT NULL,
+  "password" text NOT NULL,
+  "api_key" text NOT NULL,
+  "created_at" datetime,
+  "updated_at" datetime,
+  "deleted_at" datetime,
+  "is_admin" numeric NOT NULL DEFAULT false,
+  "email_verified_at" datetime,
+  "sessions_revoked_at" datetime,
+  "totp_secret" text,
+  "two_factor_enabled_at" datetime,
+  "totp_last_used_step" integer NOT NULL DEFAULT 0,
+  "failed_otp_attempts" integer NOT NULL DEFAULT 0,
+  "otp_locked_until" datetime
+);
+CREATE INDEX "idx_users_deleted_at" ON "users" ("deleted_at");
+CREATE UNIQUE INDEX "idx_users_api_key" ON "users" ("api_key");
+CREATE UNIQUE INDEX "idx_users_email" ON "users" ("email");
+
+CREATE TABLE "albums" (
+  "id" integer PRIMARY KEY AUTOINCREMENT,
+  "title" text NOT NULL,
+  "artist" text NOT NULL,
+  "price" real NOT NULL,
+  "user_id" integer,
+  "created_at" datetime,
+  "updated_at" datetime,
+  "deleted_at" datetime,
+  CONSTRAINT "fk_albums_user" FOREIGN KEY ("user_id") REFERENCES "users"("id")
+);
+CREATE INDEX "idx_albums_deleted_at" ON "albums" ("deleted_at");
+CREATE INDEX "idx_albums_user_id" ON "albums" ("user_id");
+
+CREATE TABLE "webhooks" (
+  "id" integer PRIMARY KEY AUTOINCREMENT,
+  "user_id" integer NOT NULL,
+  "url" text NOT NULL,
+  "secret" text NOT NULL,
+  "events" text NOT NULL,
+  "created_at" datetime,
+  "updated_at" datetime,
+  "deleted_at" datetime
+);
+CREATE INDEX "idx_webhooks_deleted_at" ON "webhooks" ("deleted_at");
+CREATE INDEX "idx_webhooks_user_id" ON "webhooks" ("user_id");
+
+CREATE TABLE "outbox_events" (
+  "id" integer PRIMARY KEY AUTOINCREMENT,
+  "user_id" integer NOT NULL,
+  "type" text NOT NULL,
+  "payload" text NOT NULL,
+  "created_at" datetime,
+  "processed_at" datetime
+);
+CREATE INDEX "idx_outbox_events_processed_at" ON "outbox_events" ("processed_at");
+CREATE INDEX "idx_outbox_events_user_id" ON "outbox_events" ("user_id");
+
+CREATE TABLE "webhook_deliveries" (
+  "id" integer PRIMARY KEY AUTOINCREMENT,
+  "webhook_id" integer NOT NULL,
+  "outbox_event_id" integer NOT NULL,
+  "event_type" text NOT NULL,
+  "status" text NOT NULL,
+  "attempts" integer NOT NULL,
+  "next_attempt_at" datetime,
+  "last_status_code" integer,
+  "last_error" text,
+  "delivered_at" datetime,
+  "created_at" datetime,
+  "updated_at" datetime
+);
+CREATE INDEX "idx_webhook_deliveries_next_attempt_at" ON "webhook_deliveries" ("next_attempt_at");
+CREATE INDEX "idx_webhook_deliveries_status" ON "webhook_deliveries" ("status");
+CREATE INDEX "idx_webhook_deliveries_outbox_event_id" ON "webhook_deliveries" ("outbox_event_id");
+CREATE INDEX "idx_webhook_deliveries_webhook_id" ON "webhook_deliveries" ("webhook_id");
+
+CREATE TABLE "queued_emails" (
+  "id" integer PRIMARY KEY AUTOINCREMENT,
+  "to" text NOT NULL,
+  "template" text NOT NULL,
+  "subject" text NOT NULL,
+  "text_body" text NOT NULL,
+  "html_body" text NOT NULL,
+  "status" text NOT NULL,
+  "attempts" integer NOT NULL,
+  "next_attempt_at" datetime,
+  "last_error" text,
+  "sent_at" datetime,
+  "created_at" datetime,
+  "updated_at" datetime
+);
+CREATE INDEX "idx_queued_emails_next_attempt_at" ON "queued_emails" ("next_attempt_at");
+CREATE INDEX "idx_queued_emails_status" ON "queued_emails" ("status");
+
+CREATE TABLE "user_tokens" (
+  "id" integer PRIMARY KEY AUTOINCREMENT,
+  "user_id" integer NOT NULL,
+  "purpose" text NOT NULL,
+  "token_hash" text NOT NULL,
+  "email" text,
+  "expires_at" datetime,
+  "used_at" datetime,
+  "created_at" datetime
+);
+CREATE UNIQUE INDEX "idx_user_tokens_token_hash" ON "user_tokens" ("token_hash");
+CREATE INDEX "idx_user_tokens_user_id" ON "user_tokens" ("user_id");
+
+CREATE TABLE "recovery_codes" (
+  "id" integer PRIMARY KEY AUTOINCREMENT,
+  "user_id" integer NOT NULL,
+  "code_hash" text NOT NULL,
+  "used_at" datetime,
+  "created_at" datetime
+);
+CREATE UNIQUE INDEX "idx_recovery_codes_code_hash" ON "recovery_codes" ("code_hash");
+CREATE INDEX "idx_recovery_codes_user_id" ON "recovery_codes" ("user_id");
+
+CREATE TABLE "user_identities" (
+  "id" integer PRIMARY KEY AUTOINCREMENT,
+  "user_id" integer NOT NULL,
+  "issuer" text NOT NULL,
+  "subject" text NOT NULL,
+  "email" text NOT NULL,
+  "created_at" datetime
+);
+CREATE UNIQUE INDEX "idx_user_identities_issuer_subject" ON "user_identities" ("issuer", "subject");
+CREATE INDEX "idx_user_identities_user_id" ON "user_identities" ("user_id");
+
+CREATE TABLE "data_exports" (
+  "id" integer PRIMARY KEY AUTOINCREMENT,
+  "user_id" integer NOT NULL,
+  "requested_by_id" integer NOT NULL,
+  "status" text NOT NULL,
+  "archive" blob,
+  "size" integer NOT NULL DEFAULT 0,
+  "attempts" integer NOT NULL DEFAULT 0,
+  "lease_until" datetime,
+  "last_error" text,
+  "expires_at" datetime,
+  "completed_at" datetime,
+  "created_at" datetime,
+  "updated_at" datetime
+);
+CREATE INDEX "idx_data_exports_status" ON "data_exports" ("status");
+CREATE INDEX "idx_data_exports_requested_by_id" ON "data_exports" ("requested_by_id");
+CREATE INDEX "idx_data_exports_user_id" ON "data_exports" ("user_id");
+
+CREATE TABLE "album_collaborators" (
+  "id" integer PRIMARY KEY AUTOINCREMENT,
+  "album_id" integer NOT NULL,
+  "user_id" integer NOT NULL,
+  "role" text NOT NULL,
+  "created_at" datetime,
+  "updated_at" datetime
+);
+CREATE INDEX "idx_album_collaborators_user_id" ON "album_collaborators" ("user_id");
+CREATE UNIQUE INDEX "idx_album_collaborators_album_user" ON "album_collaborators" ("album_id", "user_id");
+
+CREATE TABLE "album_transfers" (
+  "id" integer PRIMARY KEY AUTOINCREMENT,
+  "album_id" integer NOT NULL,
+  "from_user_id" integer NOT NULL,
+  "to_user_id" integer NOT NULL,
+  "message" text,
+  "status" text NOT NULL,
+  "responded_at" datetime,
+  "created_at" datetime,
+  "updated_at" datetime,
+  CONSTRAINT "fk_album_transfers_album" FOREIGN KEY ("album_id") REFERENCES "albums"("id")
+);
+CREATE INDEX "idx_album_transfers_to_user_id" ON "album_transfers" ("to_user_id");
+CREATE INDEX "idx_album_transfers_from_user_id" ON "album_transfers" ("from_user_id");
+CREATE UNIQUE INDEX "idx_album_transfers_pending" ON "album_transfers" ("album_id") WHERE status = 'pending';
+CREATE INDEX "idx_album_transfers_album_id" ON "album_transfers" ("album_id");
+
+CREATE TABLE "album_claims" (
+  "id" integer PRIMARY KEY AUTOINCREMENT,
+  "album_id" integer NOT NULL,
+  "user_id" integer NOT NULL,
+  "reason" text,
+  "status" text NOT NULL,
+  "reviewed_by_id" integer,
+  "review_note" text,
+  "reviewed_at" datetime,
+  "created_at" datetime,
+  "updated_at" datetime,
+  CONSTRAINT "fk_album_claims_album" FOREIGN KEY ("album_id") REFERENCES "albums"("id")
+);
+CREATE INDEX "idx_album_claims_status" ON "album_claims" ("status");
+CREATE INDEX "idx_album_claims_user_id" ON "album_claims" ("user_id");
+CREATE UNIQUE INDEX "idx_album_claims_pending" ON "album_claims" ("album_id", "user_id") WHERE status = 'pending';
+CREATE INDEX "idx_album_claims_album_id" ON "album_claims" ("album_id");
@@ -0,0 +1,6 @@
+-- Organizations' albums stay, in the shared catalogue and without an owner
+
+DROP INDEX "idx_albums_organization_id";
+ALTER TABLE "albums" DROP COLUMN "organization_id";
+DROP TABLE "organization_members";
+DROP TABLE "organizations";
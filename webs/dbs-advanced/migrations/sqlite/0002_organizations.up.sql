@@ -0,0 +1,24 @@
+-- Organizations with their own album catalogues
+
+CREATE TABLE "organizations" (
+  "id" integer PRIMARY KEY AUTOINCREMENT,
+  "name" text NOT NULL,
+  "slug" text NOT NULL,
+  "created_at" datetime,
+  "updated_at" datetime
+);
+CREATE UNIQUE INDEX "idx_organizations_slug" ON "organizations" ("slug");
+
+CREATE TABLE "organization_members" (
+  "id" integer PRIMARY KEY AUTOINCREMENT,
+  "organization_id" integer NOT NULL,
+  "user_id" integer NOT NULL,
+  "role" text NOT NULL,
+  "created_at" datetime,
+  "updated_at" datetime
+);
+CREATE INDEX "idx_organization_members_user_id" ON "organization_members" ("user_id");
+CREATE UNIQUE INDEX "idx_organization_members_org_user" ON "organization_members" ("organization_id", "user_id");
+
+ALTER TABLE "albums" ADD COLUMN "organization_id" integer;
+CREATE INDEX "idx_albums_organization_id" ON "albums" ("organization_id");
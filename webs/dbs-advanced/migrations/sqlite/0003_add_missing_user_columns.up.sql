@@ -0,0 +1,2 @@
+-- Nothing to add: no release built a SQLite database, so 0001 always created
+-- the users table with these columns
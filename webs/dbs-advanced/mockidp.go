@@ -0,0 +1,278 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+)
+
+const mockCodeTTL = time.Minute
+
+// MockIdentityProvider is a small OpenID Connect provider for development
+// and tests. It has one client, signs ID tokens with a key made at startup
+// and logs in whoever is typed into its form, so it must never be switched on
+// in production.
+type MockIdentityProvider struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+
+	key    *rsa.PrivateKey
+	signer jose.Signer
+
+	mu    sync.Mutex
+	codes map[string]mockAuthCode
+}
+
+// mockAuthCode is an issued authorization code waiting to be exchanged
+type mockAuthCode struct {
+	Challenge string // PKCE S256 code_challenge
+	Nonce     string
+	Identity  mockLogin
+	Expires   time.Time
+}
+
+// mockLogin is what was typed into the mock login form
+type mockLogin struct {
+	Email         string
+	EmailVerified bool
+	FirstName     string
+	LastName      string
+}
+
+// NewMockIdentityProvider returns a mock IdP answering as issuer and sending
+// browsers back to redirectURL
+func NewMockIdentityProvider(issuer, redirectURL string) *MockIdentityProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+	signer, err := jose.NewSigner(
+		jose.SigningKey{Algorithm: jose.RS256, Key: jose.JSONWebKey{Key: key, KeyID: "mock", Algorithm: string(jose.RS256)}},
+		(&jose.SignerOptions{}).WithType("JWT"),
+	)
+	if err != nil {
+		panic(err)
+	}
+
+	return &MockIdentityProvider{
+		Issuer:       issuer,
+		ClientID:     "albums-dev",
+		ClientSecret: "albums-dev-secret",
+		RedirectURL:  redirectURL,
+		key:          key,
+		signer:       signer,
+		codes:        map[string]mockAuthCode{},
+	}
+}
+
+// Register adds the provider's endpoints to group, which must be mounted at
+// the issuer's path
+func (m *MockIdentityProvider) Register(group *gin.RouterGroup) {
+	group.GET("/.well-known/openid-configuration", m.discovery)
+	group.GET("/jwks", m.jwks)
+	group.GET("/authorize", m.authorizeForm)
+	group.POST("/authorize", m.authorize)
+	group.POST("/token", m.token)
+}
+
+// GET /mock-idp/.well-known/openid-configuration - Discovery document
+func (m *MockIdentityProvider) discovery(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"issuer":                                m.Issuer,
+		"authorization_endpoint":                m.Issuer + "/authorize",
+		"token_endpoint":                        m.Issuer + "/token",
+		"jwks_uri":                              m.Issuer + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+		"scopes_supported":                      []string{"openid", "email", "profile"},
+		"token_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post"},
+	})
+}
+
+// GET /mock-idp/jwks - The public key ID tokens are signed with
+func (m *MockIdentityProvider) jwks(c *gin.Context) {
+	c.JSON(http.StatusOK, jose.JSONWebKeySet{Keys: []jose.JSONWebKey{{
+		Key:       &m.key.PublicKey,
+		KeyID:     "mock",
+		Algorithm: string(jose.RS256),
+		Use:       "sig",
+	}}})
+}
+
+var mockLoginPage = template.Must(template.New("mock-login").Parse(`<!DOCTYPE html>
+<html lang="en">
+  <head><meta charset="utf-8"><title>Mock identity provider</title></head>
+  <body>
+    <h1>Mock identity provider</h1>
+    <p>Development only: you are logged in as whoever you type in.</p>
+    <form method="post">
+      {{range $name, $value := .Params}}<input type="hidden" name="{{$name}}" value="{{$value}}">
+      {{end}}
+      <p><label>Email <input type="email" name="email" required autofocus></label></p>
+      <p><label>First name <input type="text" name="given_name"></label></p>
+      <p><label>Last name <input type="text" name="family_name"></label></p>
+      <p><label><input type="checkbox" name="email_verified" value="true" checked> Email address is verified</label></p>
+      <button type="submit">Log in</button>
+    </form>
+  </body>
+</html>
+`))
+
+// GET /mock-idp/authorize - Login form for an authorization request
+func (m *MockIdentityProvider) authorizeForm(c *gin.Context) {
+	params, message := m.checkAuthorizeRequest(c.Request.URL.Query())
+	if message != "" {
+		c.String(http.StatusBadRequest, message)
+		return
+	}
+	c.Status(http.StatusOK)
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	mockLoginPage.Execute(c.Writer, gin.H{"Params": params})
+}
+
+// POST /mock-idp/authorize - Log in as the submitted user and send the
+// browser back to the client with a code
+func (m *MockIdentityProvider) authorize(c *gin.Context) {
+	c.Request.ParseForm()
+	params, message := m.checkAuthorizeRequest(c.Request.PostForm)
+	if message == "" && c.PostForm("email") == "" {
+		message = "email is required"
+	}
+	if message != "" {
+		c.String(http.StatusBadRequest, message)
+		return
+	}
+
+	code := randomToken()
+	m.mu.Lock()
+	m.codes[code] = mockAuthCode{
+		Challenge: params["code_challenge"],
+		Nonce:     params["nonce"],
+		Identity: mockLogin{
+			Email:         c.PostForm("email"),
+			EmailVerified: c.PostForm("email_verified") == "true",
+			FirstName:     c.PostForm("given_name"),
+			LastName:      c.PostForm("family_name"),
+		},
+		Expires: time.Now().Add(mockCodeTTL),
+	}
+	m.mu.Unlock()
+
+	query := url.Values{"code": {code}}
+	if state := params["state"]; state != "" {
+		query.Set("state", state)
+	}
+	c.Redirect(http.StatusFound, m.RedirectURL+"?"+query.Encode())
+}
+
+// checkAuthorizeRequest returns the parameters to carry through the login
+// form, or why the request is refused. PKCE is required.
+func (m *MockIdentityProvider) checkAuthorizeRequest(values url.Values) (map[string]string, string) {
+	switch {
+	case values.Get("client_id") != m.ClientID:
+		return nil, "unknown client_id"
+	case values.Get("redirect_uri") != m.RedirectURL:
+		return nil, "redirect_uri is not registered for this client"
+	case values.Get("response_type") != "code":
+		return nil, "response_type must be code"
+	case values.Get("code_challenge") == "" || values.Get("code_challenge_method") != "S256":
+		return nil, "PKCE with code_challenge_method S256 is required"
+	}
+
+	params := map[string]string{}
+	for _, name := range []string{"client_id", "redirect_uri", "response_type", "scope", "state", "nonce", "code_challenge", "code_challenge_method"} {
+		params[name] = values.Get(name)
+	}
+	return params, ""
+}
+
+// POST /mock-idp/token - Exchange a code and its PKCE verifier for an ID token
+func (m *MockIdentityProvider) token(c *gin.Context) {
+	clientID, clientSecret, ok := c.Request.BasicAuth()
+	if !ok {
+		clientID, clientSecret = c.PostForm("client_id"), c.PostForm("client_secret")
+	}
+	if clientID != m.ClientID || subtle.ConstantTimeCompare([]byte(clientSecret), []byte(m.ClientSecret)) != 1 {
+		tokenError(c, http.StatusUnauthorized, "invalid_client", "unknown client or wrong secret")
+		return
+	}
+	if c.PostForm("grant_type") != "authorization_code" {
+		tokenError(c, http.StatusBadRequest, "unsupported_grant_type", "only authorization_code is supported")
+		return
+	}
+	if c.PostForm("redirect_uri") != m.RedirectURL {
+		tokenError(c, http.StatusBadRequest, "invalid_grant", "redirect_uri does not match")
+		return
+	}
+
+	// Codes work once, even when the exchange fails
+	m.mu.Lock()
+	code, found := m.codes[c.PostForm("code")]
+	delete(m.codes, c.PostForm("code"))
+	m.mu.Unlock()
+	if !found || time.Now().After(code.Expires) {
+		tokenError(c, http.StatusBadRequest, "invalid_grant", "unknown or expired code")
+		return
+	}
+
+	sum := sha256.Sum256([]byte(c.PostForm("code_verifier")))
+	if base64.RawURLEncoding.EncodeToString(sum[:]) != code.Challenge {
+		tokenError(c, http.StatusBadRequest, "invalid_grant", "code_verifier does not match code_challenge")
+		return
+	}
+
+	now := time.Now()
+	identity := code.Identity
+	idToken, err := jwt.Signed(m.signer).Claims(jwt.Claims{
+		Issuer:   m.Issuer,
+		Subject:  mockSubject(identity.Email),
+		Audience: jwt.Audience{m.ClientID},
+		IssuedAt: jwt.NewNumericDate(now),
+		Expiry:   jwt.NewNumericDate(now.Add(time.Hour)),
+	}).Claims(map[string]interface{}{
+		"nonce":          code.Nonce,
+		"email":          identity.Email,
+		"email_verified": identity.EmailVerified,
+		"given_name":     identity.FirstName,
+		"family_name":    identity.LastName,
+	}).Serialize()
+	if err != nil {
+		tokenError(c, http.StatusInternalServerError, "server_error", err.Error())
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, gin.H{
+		"access_token": randomToken(),
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     idToken,
+	})
+}
+
+// mockSubject is the same for an email address every time, like a real
+// provider's account ID
+func mockSubject(email string) string {
+	return "mock-" + hashToken(strings.ToLower(email))[:16]
+}
+
+// tokenError answers the token endpoint the way RFC 6749 section 5.2 says
+func tokenError(c *gin.Context, status int, code, description string) {
+	c.JSON(status, gin.H{"error": code, "error_description": description})
+}
@@ -1,44 +1,345 @@
 package main
 
 import (
+	"slices"
 	"time"
 
+	"example.com/albums/apierror"
 	"gorm.io/gorm"
 )
 
 // User model
 type User struct {
-	ID        uint           `gorm:"primaryKey" json:"id"`
-	FirstName string         `gorm:"not null" json:"first_name"`
-	LastName  string         `gorm:"not null" json:"last_name"`
-	Email     string         `gorm:"uniqueIndex;not null" json:"email"`
-	Password  string         `gorm:"not null" json:"-"` // "-" means don't include in JSON
-	APIKey    string         `gorm:"uniqueIndex;not null" json:"api_key"`
-	CreatedAt time.Time      `json:"created_at"`
-	UpdatedAt time.Time      `json:"updated_at"`
-	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
+	ID        uint           `gorm:"primaryKey" json:"id" xml:"id"`
+	FirstName string         `gorm:"not null" json:"first_name" xml:"first_name"`
+	LastName  string         `gorm:"not null" json:"last_name" xml:"last_name"`
+	Email     string         `gorm:"uniqueIndex;not null" json:"email" xml:"email"`
+	Password  string         `gorm:"not null" json:"-" xml:"-"`             // "-" means don't include in JSON (YAML and MessagePack follow the json tag)
+	APIKey    string         `gorm:"uniqueIndex;not null" json:"-" xml:"-"` // only answered as UserCredentials
+	CreatedAt time.Time      `json:"created_at" xml:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at" xml:"updated_at"`
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"-" xml:"-"`
+
+	IsAdmin bool `gorm:"not null;default:false" json:"is_admin" xml:"is_admin"` // granted in the database; there is no API for it
+
+	EmailVerifiedAt   *time.Time `json:"email_verified_at" xml:"email_verified_at,omitempty"` // nil until the address is confirmed
+	SessionsRevokedAt *time.Time `json:"-" xml:"-"`                                           // web sessions started before this are logged out
+
+	// Two-factor authentication. TOTPSecret is set on enrollment and only
+	// asked for at login once TwoFactorEnabledAt is set by a confirmed code.
+	TOTPSecret         string     `json:"-" xml:"-"`
+	TwoFactorEnabledAt *time.Time `json:"two_factor_enabled_at" xml:"two_factor_enabled_at,omitempty"`
+	TOTPLastUsedStep   int64      `gorm:"not null;default:0" json:"-" xml:"-"` // codes from this step or earlier can't be used again
+	FailedOTPAttempts  int        `gorm:"not null;default:0" json:"-" xml:"-"`
+	OTPLockedUntil     *time.Time `json:"-" xml:"-"`
+}
+
+// TwoFactorEnabled reports whether logging in needs a code as well as the password
+func (u User) TwoFactorEnabled() bool {
+	return u.TwoFactorEnabledAt != nil
 }
 
 // Album model with optional user relationship
 type Album struct {
-	ID        uint           `gorm:"primaryKey" json:"id"`
-	Title     string         `gorm:"not null" json:"title"`
-	Artist    string         `gorm:"not null" json:"artist"`
-	Price     float64        `gorm:"not null" json:"price"`
-	UserID    *uint          `gorm:"index" json:"user_id,omitempty"` // Pointer = nullable
-	User      *User          `gorm:"foreignKey:UserID" json:"user,omitempty"` // Pointer = optional
-	CreatedAt time.Time      `json:"created_at"`
-	UpdatedAt time.Time      `json:"updated_at"`
-	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
+	ID             uint           `gorm:"primaryKey" json:"id" xml:"id"`
+	Title          string         `gorm:"not null" json:"title" xml:"title"`
+	Artist         string         `gorm:"not null" json:"artist" xml:"artist"`
+	Price          float64        `gorm:"not null" json:"price" xml:"price"`
+	UserID         *uint          `gorm:"index" json:"user_id,omitempty" xml:"user_id,omitempty"`                 // Pointer = nullable
+	User           *User          `gorm:"foreignKey:UserID" json:"user,omitempty" xml:"user,omitempty"`           // Pointer = optional
+	OrganizationID *uint          `gorm:"index" json:"organization_id,omitempty" xml:"organization_id,omitempty"` // set instead of UserID when an organization owns the album
+	CreatedAt      time.Time      `json:"created_at" xml:"created_at"`
+	UpdatedAt      time.Time      `json:"updated_at" xml:"updated_at"`
+	DeletedAt      gorm.DeletedAt `gorm:"index" json:"-" xml:"-"`
+}
+
+// What a user may do with an album. Owners (and administrators, for an album
+// without an owner) may do anything, editors may change it but not delete or
+// share it, and viewers (everyone else, for an album without an owner) may
+// only read it. Members of an organization have one of the same roles on
+// all of its albums, and owners also manage its members.
+const (
+	RoleOwner  = "owner"
+	RoleEditor = "editor"
+	RoleViewer = "viewer"
+)
+
+// AlbumCollaborator gives a user other than the owner a role on an album
+type AlbumCollaborator struct {
+	ID        uint      `gorm:"primaryKey" json:"-" xml:"-"`
+	AlbumID   uint      `gorm:"uniqueIndex:idx_album_collaborators_album_user;not null" json:"album_id" xml:"album_id"`
+	UserID    uint      `gorm:"uniqueIndex:idx_album_collaborators_album_user;index;not null" json:"user_id" xml:"user_id"`
+	Email     string    `gorm:"-" json:"email" xml:"email"`
+	Role      string    `gorm:"not null" json:"role" xml:"role"` // editor or viewer
+	CreatedAt time.Time `json:"created_at" xml:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" xml:"updated_at"`
+}
+
+// Organization is a record store with its own album catalogue. Its members
+// choose it with X-Organization or the /orgs/:org prefix.
+type Organization struct {
+	ID        uint      `gorm:"primaryKey" json:"id" xml:"id"`
+	Name      string    `gorm:"not null" json:"name" xml:"name"`
+	Slug      string    `gorm:"uniqueIndex;not null" json:"slug" xml:"slug"`
+	Role      string    `gorm:"-" json:"role,omitempty" xml:"role,omitempty"` // the caller's
+	CreatedAt time.Time `json:"created_at" xml:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" xml:"updated_at"`
+}
+
+// OrganizationMember gives a user a role on every album of an organization
+type OrganizationMember struct {
+	ID             uint      `gorm:"primaryKey" json:"-" xml:"-"`
+	OrganizationID uint      `gorm:"uniqueIndex:idx_organization_members_org_user;not null" json:"organization_id" xml:"organization_id"`
+	UserID         uint      `gorm:"uniqueIndex:idx_organization_members_org_user;index;not null" json:"user_id" xml:"user_id"`
+	Email          string    `gorm:"-" json:"email" xml:"email"`
+	Role           string    `gorm:"not null" json:"role" xml:"role"` // owner, editor or viewer
+	CreatedAt      time.Time `json:"created_at" xml:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at" xml:"updated_at"`
+}
+
+// Album transfer statuses
+const (
+	TransferPending   = "pending"
+	TransferAccepted  = "accepted"
+	TransferRejected  = "rejected"
+	TransferCancelled = "cancelled" // by the sender, or because the album changed hands another way
+)
+
+// AlbumTransfer offers an album to another user, who becomes its owner if
+// they accept. An album has at most one pending transfer.
+type AlbumTransfer struct {
+	ID          uint       `gorm:"primaryKey" json:"id" xml:"id"`
+	AlbumID     uint       `gorm:"index;uniqueIndex:idx_album_transfers_pending,where:status = 'pending';not null" json:"album_id" xml:"album_id"`
+	Album       *Album     `json:"album,omitempty" xml:"album,omitempty"`
+	FromUserID  uint       `gorm:"index;not null" json:"from_user_id" xml:"from_user_id"`
+	FromEmail   string     `gorm:"-" json:"from_email" xml:"from_email"`
+	ToUserID    uint       `gorm:"index;not null" json:"to_user_id" xml:"to_user_id"`
+	ToEmail     string     `gorm:"-" json:"to_email" xml:"to_email"`
+	Message     string     `json:"message,omitempty" xml:"message,omitempty"`
+	Status      string     `gorm:"not null" json:"status" xml:"status"`
+	RespondedAt *time.Time `json:"responded_at" xml:"responded_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at" xml:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at" xml:"updated_at"`
+}
+
+// Album claim statuses
+const (
+	ClaimPending   = "pending"
+	ClaimApproved  = "approved"
+	ClaimRejected  = "rejected"
+	ClaimWithdrawn = "withdrawn" // the claimant's account was deleted
+)
+
+// AlbumClaim is a user's request to become the owner of an album without
+// one. An administrator approves or rejects it; approving one claim rejects
+// the album's other pending claims.
+type AlbumClaim struct {
+	ID           uint       `gorm:"primaryKey" json:"id" xml:"id"`
+	AlbumID      uint       `gorm:"index;uniqueIndex:idx_album_claims_pending,where:status = 'pending';not null" json:"album_id" xml:"album_id"`
+	Album        *Album     `json:"album,omitempty" xml:"album,omitempty"`
+	UserID       uint       `gorm:"index;uniqueIndex:idx_album_claims_pending,where:status = 'pending';not null" json:"user_id" xml:"user_id"`
+	Email        string     `gorm:"-" json:"email" xml:"email"` // the claimant's
+	Reason       string     `json:"reason,omitempty" xml:"reason,omitempty"`
+	Status       string     `gorm:"index;not null" json:"status" xml:"status"`
+	ReviewedByID *uint      `json:"reviewed_by_id,omitempty" xml:"reviewed_by_id,omitempty"`
+	ReviewNote   string     `json:"review_note,omitempty" xml:"review_note,omitempty"`
+	ReviewedAt   *time.Time `json:"reviewed_at" xml:"reviewed_at,omitempty"`
+	CreatedAt    time.Time  `json:"created_at" xml:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at" xml:"updated_at"`
+}
+
+// Purposes of a UserToken
+const (
+	TokenPasswordReset     = "password_reset"
+	TokenEmailVerification = "email_verification"
+	TokenEmailChange       = "email_change"
+)
+
+// UserToken is a single-use, time-limited token sent to a user by email. Only
+// its SHA-256 is stored, so a database leak doesn't give working tokens away.
+type UserToken struct {
+	ID        uint   `gorm:"primaryKey"`
+	UserID    uint   `gorm:"index;not null"`
+	Purpose   string `gorm:"not null"`
+	TokenHash string `gorm:"uniqueIndex;not null"`
+	Email     string // the new address, for email change tokens
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+	CreatedAt time.Time
+}
+
+// RecoveryCode is a one-time code that stands in for the authenticator app
+// when it is lost. Like UserToken only its SHA-256 is stored.
+type RecoveryCode struct {
+	ID        uint   `gorm:"primaryKey"`
+	UserID    uint   `gorm:"index;not null"`
+	CodeHash  string `gorm:"uniqueIndex;not null"`
+	UsedAt    *time.Time
+	CreatedAt time.Time
+}
+
+// UserIdentity links a user to their account at a single sign-on provider.
+// Issuer and Subject together name the account; Email is what the provider
+// said when it was linked.
+type UserIdentity struct {
+	ID        uint   `gorm:"primaryKey"`
+	UserID    uint   `gorm:"index;not null"`
+	Issuer    string `gorm:"uniqueIndex:idx_user_identities_issuer_subject;not null"`
+	Subject   string `gorm:"uniqueIndex:idx_user_identities_issuer_subject;not null"`
+	Email     string `gorm:"not null"`
+	CreatedAt time.Time
+}
+
+// Webhook is an endpoint a user registered to hear about changes to their albums
+type Webhook struct {
+	ID        uint           `gorm:"primaryKey" json:"id" xml:"id"`
+	UserID    uint           `gorm:"index;not null" json:"user_id" xml:"user_id"`
+	URL       string         `gorm:"not null" json:"url" xml:"url"`
+	Secret    string         `gorm:"not null" json:"secret,omitempty" xml:"secret,omitempty"` // only sent back when the webhook is created
+	Events    []string       `gorm:"serializer:json;not null" json:"events" xml:"events>event"`
+	CreatedAt time.Time      `json:"created_at" xml:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at" xml:"updated_at"`
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"-" xml:"-"`
+}
+
+// Subscribed reports whether the webhook wants events of this type
+func (w Webhook) Subscribed(eventType string) bool {
+	return slices.Contains(w.Events, eventType)
+}
+
+// OutboxEvent is an album change recorded in the same transaction as the
+// change itself; the webhook dispatcher turns it into deliveries.
+type OutboxEvent struct {
+	ID          uint   `gorm:"primaryKey"`
+	UserID      uint   `gorm:"index;not null"` // whose webhooks hear about it
+	Type        string `gorm:"not null"`
+	Payload     string `gorm:"type:text;not null"` // the JSON body every webhook receives
+	CreatedAt   time.Time
+	ProcessedAt *time.Time `gorm:"index"`
+}
+
+// WebhookDelivery is one event sent (or to be sent) to one webhook, and the
+// log of how the last attempt went
+type WebhookDelivery struct {
+	ID             uint       `gorm:"primaryKey" json:"id" xml:"id"`
+	WebhookID      uint       `gorm:"index;not null" json:"webhook_id" xml:"webhook_id"`
+	OutboxEventID  uint       `gorm:"index;not null" json:"event_id" xml:"event_id"`
+	EventType      string     `gorm:"not null" json:"event_type" xml:"event_type"`
+	Status         string     `gorm:"index;not null" json:"status" xml:"status"` // pending, succeeded or failed
+	Attempts       int        `gorm:"not null" json:"attempts" xml:"attempts"`
+	NextAttemptAt  *time.Time `gorm:"index" json:"next_attempt_at" xml:"next_attempt_at,omitempty"`
+	LastStatusCode int        `json:"last_status_code,omitempty" xml:"last_status_code,omitempty"`
+	LastError      string     `json:"last_error,omitempty" xml:"last_error,omitempty"`
+	DeliveredAt    *time.Time `json:"delivered_at" xml:"delivered_at,omitempty"`
+	CreatedAt      time.Time  `json:"created_at" xml:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at" xml:"updated_at"`
+}
+
+// UserCredentials is a user with their API key. Only POST /login and
+// POST /keys/rotate answer with it; everywhere else the key is left out.
+type UserCredentials struct {
+	User   `yaml:",inline"`
+	APIKey string `json:"api_key" xml:"api_key"`
+}
+
+// LoginRequest is the body of POST /login
+type LoginRequest struct {
+	Email    string `json:"email" xml:"email" binding:"required"`
+	Password string `json:"password" xml:"password" binding:"required"`
+	OTPCode  string `json:"otp_code,omitempty" xml:"otp_code,omitempty"` // authenticator or recovery code, when 2FA is on
+}
+
+// TwoFactorCodeRequest is the body of POST /2fa/confirm and POST /2fa/disable
+type TwoFactorCodeRequest struct {
+	Code string `json:"code" xml:"code" binding:"required"`
+}
+
+// ForgotPasswordRequest is the body of POST /password/forgot
+type ForgotPasswordRequest struct {
+	Email string `json:"email" xml:"email" binding:"required"`
+}
+
+// ResetPasswordRequest is the body of POST /password/reset
+type ResetPasswordRequest struct {
+	Token             string `json:"token" xml:"token" binding:"required"`
+	Password          string `json:"password" xml:"password" binding:"required,min=8,max=72"` // bcrypt ignores anything past 72 bytes
+	SignOutEverywhere bool   `json:"sign_out_everywhere" xml:"sign_out_everywhere"`           // also replace the API key and end web sessions
+}
+
+// VerifyEmailRequest is the body of POST /email/verify and POST /email/change/confirm
+type VerifyEmailRequest struct {
+	Token string `json:"token" xml:"token" binding:"required"`
+}
+
+// UpdateProfileRequest is the body of PATCH /me. Fields left out keep their value.
+type UpdateProfileRequest struct {
+	FirstName *string `json:"first_name,omitempty" xml:"first_name,omitempty" binding:"omitempty,min=1,max=100"`
+	LastName  *string `json:"last_name,omitempty" xml:"last_name,omitempty" binding:"omitempty,min=1,max=100"`
+}
+
+// ChangeEmailRequest is the body of POST /me/email
+type ChangeEmailRequest struct {
+	Email    string `json:"email" xml:"email" binding:"required,email"`
+	Password string `json:"password" xml:"password" binding:"required"`
+	OTPCode  string `json:"otp_code,omitempty" xml:"otp_code,omitempty"`
+}
+
+// ChangePasswordRequest is the body of POST /me/password
+type ChangePasswordRequest struct {
+	CurrentPassword   string `json:"current_password" xml:"current_password" binding:"required"`
+	NewPassword       string `json:"new_password" xml:"new_password" binding:"required,min=8,max=72"`
+	OTPCode           string `json:"otp_code,omitempty" xml:"otp_code,omitempty"`
+	SignOutEverywhere bool   `json:"sign_out_everywhere" xml:"sign_out_everywhere"` // also replace the API key and end web sessions
+}
+
+// DeleteAccountRequest is the body of DELETE /me. Albums and TransferTo
+// default to ACCOUNT_DELETION_ALBUMS and ACCOUNT_DELETION_TRANSFER_TO.
+type DeleteAccountRequest struct {
+	Password   string `json:"password" xml:"password" binding:"required"`
+	OTPCode    string `json:"otp_code,omitempty" xml:"otp_code,omitempty"`
+	Albums     string `json:"albums,omitempty" xml:"albums,omitempty" binding:"omitempty,oneof=delete orphan transfer"`
+	TransferTo string `json:"transfer_to,omitempty" xml:"transfer_to,omitempty" binding:"omitempty,email"` // email of the user who gets the albums
+}
+
+// TransferAlbumRequest is the body of POST /albums/:id/transfer
+type TransferAlbumRequest struct {
+	To      string `json:"to" xml:"to" binding:"required,email"` // email of the new owner
+	Message string `json:"message,omitempty" xml:"message,omitempty" binding:"max=500"`
+}
+
+// CollaboratorRequest is the body of PUT /albums/:id/collaborators
+type CollaboratorRequest struct {
+	Email string `json:"email" xml:"email" binding:"required,email"`
+	Role  string `json:"role" xml:"role" binding:"required,oneof=editor viewer"`
+}
+
+// OrganizationRequest is the body of POST /orgs
+type OrganizationRequest struct {
+	Name string `json:"name" xml:"name" binding:"required,max=100"`
+	Slug string `json:"slug" xml:"slug" binding:"required,min=2,max=50"` // lowercase letters, digits and hyphens
+}
+
+// MemberRequest is the body of PUT /orgs/:org/members
+type MemberRequest struct {
+	Email string `json:"email" xml:"email" binding:"required,email"`
+	Role  string `json:"role" xml:"role" binding:"required,oneof=owner editor viewer"`
+}
+
+// ClaimAlbumRequest is the optional body of POST /albums/:id/claim
+type ClaimAlbumRequest struct {
+	Reason string `json:"reason,omitempty" xml:"reason,omitempty" binding:"max=500"` // shown to the administrators
+}
+
+// ReviewClaimRequest is the optional body of POST /admin/claims/:id/approve and /reject
+type ReviewClaimRequest struct {
+	Note string `json:"note,omitempty" xml:"note,omitempty" binding:"max=500"` // emailed to the claimant
 }
 
 // Response structs
 type SuccessResponse struct {
-	Success bool        `json:"success"`
-	Data    interface{} `json:"data"`
+	Success bool        `json:"success" xml:"success"`
+	Data    interface{} `json:"data" xml:"data"`
 }
 
-type ErrorResponse struct {
-	Success bool   `json:"success"`
-	Error   string `json:"error"`
-}
\ No newline at end of file
+// ErrorResponse is the error envelope shared with the other album services
+type ErrorResponse = apierror.Response
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"example.com/albums/negotiate"
+	"github.com/gin-gonic/gin"
+)
+
+// The formats, Accept handling and body decoding are shared with the other
+// album services in example.com/albums/negotiate, and the 406 and 415
+// answers in example.com/albums/apierror.
+
+// ContentNegotiation rejects requests whose Accept or Content-Type header
+// names a format we don't speak, before any handler does work.
+func ContentNegotiation() gin.HandlerFunc {
+	return apiErrors.ContentNegotiation()
+}
+
+// Respond writes obj in the format picked from the Accept header
+func Respond(c *gin.Context, code int, obj interface{}) {
+	negotiate.Respond(c, code, obj)
+}
+
+// BindBody decodes the request body according to its Content-Type.
+// A missing Content-Type is treated as JSON, like BindJSON always did.
+func BindBody(c *gin.Context, obj interface{}) error {
+	return negotiate.BindBody(c, obj)
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/oauth2"
+	"gorm.io/gorm"
+)
+
+// IdentityProvider is a single sign-on provider using the authorization code
+// flow. The web UI keeps state, nonce and the PKCE verifier in the session
+// between the two calls.
+type IdentityProvider interface {
+	// AuthCodeURL is where to send the browser to log in
+	AuthCodeURL(ctx context.Context, state, nonce, verifier string) (string, error)
+	// Exchange swaps the code from the callback for the identity it proves
+	Exchange(ctx context.Context, code, verifier, nonce string) (ExternalIdentity, error)
+}
+
+// ExternalIdentity is who the identity provider says logged in
+type ExternalIdentity struct {
+	Issuer        string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	FirstName     string
+	LastName      string
+}
+
+// SSO is the configured identity provider, nil when single sign-on is off
+var SSO IdentityProvider
+
+// mockIdP is served at /mock-idp when OIDC_MOCK=true
+var mockIdP *MockIdentityProvider
+
+// ErrIdentityEmailUnverified means the identity provider didn't vouch for
+// the email address, so it can't be matched to or create an account
+var ErrIdentityEmailUnverified = errors.New("identity provider did not verify the email address")
+
+// OIDCProvider is an IdentityProvider for any OpenID Connect issuer. The
+// discovery document is fetched on first use, so the app starts even while
+// the issuer is unreachable (or is the mock IdP on this same server).
+type OIDCProvider struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+
+	mu       sync.Mutex
+	provider *oidc.Provider
+}
+
+func (p *OIDCProvider) discover(ctx context.Context) (*oidc.Provider, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.provider == nil {
+		provider, err := oidc.NewProvider(ctx, p.Issuer)
+		if err != nil {
+			return nil, fmt.Errorf("discover %s: %w", p.Issuer, err)
+		}
+		p.provider = provider
+	}
+	return p.provider, nil
+}
+
+func (p *OIDCProvider) config(provider *oidc.Provider) oauth2.Config {
+	return oauth2.Config{
+		ClientID:     p.ClientID,
+		ClientSecret: p.ClientSecret,
+		RedirectURL:  p.RedirectURL,
+		Endpoint:     provider.Endpoint(),
+		Scopes:       []string{oidc.ScopeOpenID, "email", "profile"},
+	}
+}
+
+func (p *OIDCProvider) AuthCodeURL(ctx context.Context, state, nonce, verifier string) (string, error) {
+	provider, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	config := p.config(provider)
+	return config.AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier)), nil
+}
+
+func (p *OIDCProvider) Exchange(ctx context.Context, code, verifier, nonce string) (ExternalIdentity, error) {
+	provider, err := p.discover(ctx)
+	if err != nil {
+		return ExternalIdentity{}, err
+	}
+	config := p.config(provider)
+
+	token, err := config.Exchange(ctx, code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return ExternalIdentity{}, fmt.Errorf("exchange code: %w", err)
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return ExternalIdentity{}, errors.New("token response has no id_token")
+	}
+
+	// Signature, issuer, audience and expiry
+	idToken, err := provider.Verifier(&oidc.Config{ClientID: p.ClientID}).Verify(ctx, rawIDToken)
+	if err != nil {
+		return ExternalIdentity{}, fmt.Errorf("verify id_token: %w", err)
+	}
+	if idToken.Nonce != nonce {
+		return ExternalIdentity{}, errors.New("id_token nonce does not match")
+	}
+
+	var claims struct {
+		Email         string `json:"email"`
+		EmailVerified bool   `json:"email_verified"`
+		GivenName     string `json:"given_name"`
+		FamilyName    string `json:"family_name"`
+	}
+	if err := idToken.Claims(&claims); err != nil {
+		return ExternalIdentity{}, fmt.Errorf("read id_token claims: %w", err)
+	}
+
+	return ExternalIdentity{
+		Issuer:        idToken.Issuer,
+		Subject:       idToken.Subject,
+		Email:         claims.Email,
+		EmailVerified: claims.EmailVerified,
+		FirstName:     claims.GivenName,
+		LastName:      claims.FamilyName,
+	}, nil
+}
+
+// userForIdentity finds the user an external identity logs in as. An
+// identity seen before goes to the user it was linked to; a new one is
+// linked to the user with the same email address, or to a new user when
+// there is none. Linking and creating need the provider to have verified the
+// address, otherwise anyone could claim an account by typing its email into
+// their own IdP profile.
+func userForIdentity(tx *gorm.DB, identity ExternalIdentity) (User, error) {
+	var user User
+
+	var link UserIdentity
+	err := tx.Where("issuer = ? AND subject = ?", identity.Issuer, identity.Subject).First(&link).Error
+	if err == nil {
+		return user, tx.First(&user, link.UserID).Error
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return User{}, err
+	}
+
+	if identity.Email == "" || !identity.EmailVerified {
+		return User{}, ErrIdentityEmailUnverified
+	}
+
+	now := time.Now()
+	err = tx.Where("LOWER(email) = ?", strings.ToLower(identity.Email)).First(&user).Error
+	switch {
+	case err == nil:
+		if user.EmailVerifiedAt == nil {
+			user.EmailVerifiedAt = &now
+			if err := tx.Model(&user).Update("email_verified_at", now).Error; err != nil {
+				return User{}, err
+			}
+		}
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		if user, err = provisionUser(tx, identity, now); err != nil {
+			return User{}, err
+		}
+	default:
+		return User{}, err
+	}
+
+	err = tx.Create(&UserIdentity{
+		UserID:  user.ID,
+		Issuer:  identity.Issuer,
+		Subject: identity.Subject,
+		Email:   identity.Email,
+	}).Error
+	return user, err
+}
+
+// provisionUser creates the account for someone logging in with SSO for the
+// first time. Its password is random, so until they reset it they can only
+// log in through the identity provider.
+func provisionUser(tx *gorm.DB, identity ExternalIdentity, now time.Time) (User, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(randomToken()), bcrypt.DefaultCost)
+	if err != nil {
+		return User{}, err
+	}
+
+	firstName := identity.FirstName
+	if firstName == "" {
+		firstName, _, _ = strings.Cut(identity.Email, "@")
+	}
+	user := User{
+		FirstName:       firstName,
+		LastName:        identity.LastName,
+		Email:           identity.Email,
+		Password:        string(hash),
+		APIKey:          uuid.NewString(),
+		EmailVerifiedAt: &now,
+	}
+	return user, tx.Create(&user).Error
+}
+
+// setupSSO configures single sign-on from the environment. OIDC_ISSUER,
+// OIDC_CLIENT_ID and OIDC_CLIENT_SECRET point at a real provider, and
+// OIDC_MOCK=true serves the mock IdP at /mock-idp instead so the flow works
+// offline. The provider sends browsers back to OIDC_REDIRECT_URL, by default
+// publicURL + /ui/login/sso/callback.
+func setupSSO(publicURL string) {
+	redirectURL := os.Getenv("OIDC_REDIRECT_URL")
+	if redirectURL == "" {
+		redirectURL = publicURL + "/ui/login/sso/callback"
+	}
+
+	switch {
+	case os.Getenv("OIDC_MOCK") == "true":
+		log.Println("Warning: OIDC_MOCK is on, anyone can log in as anyone through /mock-idp")
+		mockIdP = NewMockIdentityProvider(publicURL+"/mock-idp", redirectURL)
+		SSO = &OIDCProvider{
+			Issuer:       mockIdP.Issuer,
+			ClientID:     mockIdP.ClientID,
+			ClientSecret: mockIdP.ClientSecret,
+			RedirectURL:  redirectURL,
+		}
+	case os.Getenv("OIDC_ISSUER") != "":
+		SSO = &OIDCProvider{
+			Issuer:       os.Getenv("OIDC_ISSUER"),
+			ClientID:     os.Getenv("OIDC_CLIENT_ID"),
+			ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+			RedirectURL:  redirectURL,
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newSSOServer serves the router with the mock IdP switched on, as
+// OIDC_MOCK=true does
+func newSSOServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewUnstartedServer(nil)
+	base := "http://" + server.Listener.Addr().String()
+	mockIdP = NewMockIdentityProvider(base+"/mock-idp", base+"/ui/login/sso/callback")
+	SSO = &OIDCProvider{
+		Issuer:       mockIdP.Issuer,
+		ClientID:     mockIdP.ClientID,
+		ClientSecret: mockIdP.ClientSecret,
+		RedirectURL:  mockIdP.RedirectURL,
+	}
+	t.Cleanup(func() { mockIdP, SSO = nil, nil })
+
+	server.Config.Handler = setupRouter(NewServices(DB))
+	server.Start()
+	t.Cleanup(server.Close)
+	return server
+}
+
+// ssoLogin goes through the whole browser flow and returns the path the
+// browser ends up on
+func ssoLogin(t *testing.T, server *httptest.Server, email string, verified bool) string {
+	t.Helper()
+
+	jar, _ := cookiejar.New(nil)
+	return ssoLoginWith(t, server, &http.Client{Jar: jar}, email, verified)
+}
+
+// ssoLoginWith is ssoLogin in a browser the test goes on using
+func ssoLoginWith(t *testing.T, server *httptest.Server, browser *http.Client, email string, verified bool) string {
+	t.Helper()
+
+	// /ui/login/sso redirects to the mock IdP's login form
+	resp, err := browser.Get(server.URL + "/ui/login/sso")
+	if err != nil {
+		t.Fatalf("GET /ui/login/sso: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Request.URL.Path != "/mock-idp/authorize" {
+		t.Fatalf("ended up at %s with %d, want the mock IdP's login form", resp.Request.URL, resp.StatusCode)
+	}
+
+	form := resp.Request.URL.Query()
+	form.Set("email", email)
+	form.Set("given_name", "Single")
+	form.Set("family_name", "Sign-On")
+	if verified {
+		form.Set("email_verified", "true")
+	}
+	resp, err = browser.PostForm(server.URL+"/mock-idp/authorize", form)
+	if err != nil {
+		t.Fatalf("POST /mock-idp/authorize: %v", err)
+	}
+	resp.Body.Close()
+	return resp.Request.URL.Path
+}
+
+func TestSSOLoginProvisionsAndLinksUsers(t *testing.T) {
+	setupTestDB(t)
+	server := newSSOServer(t)
+
+	if path := ssoLogin(t, server, "sso@example.com", true); path != "/ui/my-albums" {
+		t.Fatalf("first SSO login ended at %s, want /ui/my-albums", path)
+	}
+	var user User
+	if err := DB.Where("email = ?", "sso@example.com").First(&user).Error; err != nil {
+		t.Fatalf("user was not provisioned: %v", err)
+	}
+	if user.FirstName != "Single" || user.EmailVerifiedAt == nil || user.APIKey == "" {
+		t.Errorf("provisioned user = %+v, want names from the IdP, a verified email and an API key", user)
+	}
+
+	// The same identity logs in as the same user
+	ssoLogin(t, server, "sso@example.com", true)
+	var users, identities int64
+	DB.Model(&User{}).Count(&users)
+	DB.Model(&UserIdentity{}).Count(&identities)
+	if users != 1 || identities != 1 {
+		t.Errorf("after logging in twice: %d users and %d identities, want 1 and 1", users, identities)
+	}
+
+	// An existing account is linked by email address rather than duplicated
+	existing := createTestUser(t, "local@example.com")
+	if path := ssoLogin(t, server, "Local@Example.com", true); path != "/ui/my-albums" {
+		t.Fatalf("SSO login for an existing user ended at %s", path)
+	}
+	var link UserIdentity
+	if err := DB.Where("user_id = ?", existing.ID).First(&link).Error; err != nil {
+		t.Errorf("existing user was not linked: %v", err)
+	}
+	DB.Model(&User{}).Count(&users)
+	if users != 2 {
+		t.Errorf("%d users, want 2", users)
+	}
+}
+
+func TestSSOLoginNeedsVerifiedEmail(t *testing.T) {
+	setupTestDB(t)
+	server := newSSOServer(t)
+	existing := createTestUser(t, "victim@example.com")
+
+	if path := ssoLogin(t, server, existing.Email, false); path != "/ui/login" {
+		t.Errorf("login with an unverified email ended at %s, want /ui/login", path)
+	}
+	var identities int64
+	DB.Model(&UserIdentity{}).Count(&identities)
+	if identities != 0 {
+		t.Errorf("%d identities linked, want none", identities)
+	}
+}
+
+func TestSSOLoginAsksForTheSecondFactor(t *testing.T) {
+	setupTestDB(t)
+	server := newSSOServer(t)
+	user := createTestUser(t, "totp@example.com")
+	secret, _ := enableTwoFactor(t, server, user)
+	code, _ := totpCode(secret, totpStep(time.Now()))
+
+	b := newBrowser(t, server)
+	if path := ssoLoginWith(t, server, b.client, user.Email, true); path != "/ui/login/2fa" {
+		t.Fatalf("SSO login ended at %s, want /ui/login/2fa", path)
+	}
+	if _, page := b.get("/ui/my-albums"); strings.Contains(page, "My albums") {
+		t.Fatal("the session started before the second factor")
+	}
+
+	b.get("/ui/login/2fa")
+	tests := []struct {
+		name       string
+		code       string
+		wantStatus int
+	}{
+		{"without a code", "", http.StatusUnauthorized},
+		{"with a wrong code", "000000", http.StatusUnauthorized},
+		{"with the code", code, http.StatusOK},
+	}
+	for _, tt := range tests {
+		status, page := b.post("/ui/login/2fa", url.Values{"csrf_token": {b.csrf}, "otp_code": {tt.code}})
+		if status != tt.wantStatus || strings.Contains(page, "My albums") != (tt.wantStatus == http.StatusOK) {
+			t.Errorf("%s = %d, want %d", tt.name, status, tt.wantStatus)
+		}
+	}
+}
+
+func TestSSOCallbackChecksState(t *testing.T) {
+	setupTestDB(t)
+	server := newSSOServer(t)
+
+	jar, _ := cookiejar.New(nil)
+	browser := &http.Client{Jar: jar}
+	resp, err := browser.Get(server.URL + "/ui/login/sso/callback?" + url.Values{"code": {"stolen"}, "state": {"forged"}}.Encode())
+	if err != nil {
+		t.Fatalf("GET callback: %v", err)
+	}
+	resp.Body.Close()
+	if resp.Request.URL.Path != "/ui/login" {
+		t.Errorf("callback without a started login ended at %s, want /ui/login", resp.Request.URL.Path)
+	}
+}
+
+func TestMockIdPRequiresPKCE(t *testing.T) {
+	server := newSSOServer(t)
+
+	authorize := url.Values{
+		"client_id":             {mockIdP.ClientID},
+		"redirect_uri":          {mockIdP.RedirectURL},
+		"response_type":         {"code"},
+		"code_challenge":        {"E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"},
+		"code_challenge_method": {"S256"},
+		"email":                 {"pkce@example.com"},
+	}
+	noRedirects := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := noRedirects.PostForm(server.URL+"/mock-idp/authorize", authorize)
+	if err != nil {
+		t.Fatalf("POST /mock-idp/authorize: %v", err)
+	}
+	resp.Body.Close()
+	location, _ := url.Parse(resp.Header.Get("Location"))
+	code := location.Query().Get("code")
+	if code == "" {
+		t.Fatalf("no code in redirect %q", resp.Header.Get("Location"))
+	}
+
+	exchange := func(verifier string) int {
+		resp, err := http.PostForm(server.URL+"/mock-idp/token", url.Values{
+			"grant_type":    {"authorization_code"},
+			"code":          {code},
+			"redirect_uri":  {mockIdP.RedirectURL},
+			"client_id":     {mockIdP.ClientID},
+			"client_secret": {mockIdP.ClientSecret},
+			"code_verifier": {verifier},
+		})
+		if err != nil {
+			t.Fatalf("POST /mock-idp/token: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// The RFC 7636 appendix B verifier for that challenge would have worked,
+	// but the code is spent by the failed attempt
+	if status := exchange("wrong-verifier"); status != http.StatusBadRequest {
+		t.Errorf("exchange with the wrong verifier = %d, want 400", status)
+	}
+	if status := exchange("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"); status != http.StatusBadRequest {
+		t.Errorf("exchange of a used code = %d, want 400", status)
+	}
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrOwnerRequired means only an owner of the organization may do this
+var ErrOwnerRequired = errors.New("only owners of the organization may do this")
+
+// ErrLastOwner means the change would leave the organization without an owner
+var ErrLastOwner = errors.New("organization would have no owner left")
+
+// slugPattern is what an organization's slug may look like: it goes in URLs
+// and in X-Organization
+var slugPattern = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// OrganizationHandlers serve the routes creating organizations and managing
+// their members
+type OrganizationHandlers struct {
+	db *gorm.DB
+}
+
+// NewOrganizationHandlers keeps organizations and their members in db
+func NewOrganizationHandlers(db *gorm.DB) *OrganizationHandlers {
+	return &OrganizationHandlers{db: db}
+}
+
+// POST /orgs - Protected: create an organization; the caller becomes its owner
+func (h *OrganizationHandlers) postOrganization(c *gin.Context) {
+	userID, _ := GetCurrentUserID(c)
+
+	var req OrganizationRequest
+	if err := BindBody(c, &req); err != nil {
+		RespondError(c, CodeValidationFailed, err.Error())
+		return
+	}
+	if !slugPattern.MatchString(req.Slug) {
+		RespondValidationError(c, "", []ValidationIssue{{Location: "body", Field: "slug", Message: "must be lowercase letters, digits and single hyphens"}})
+		return
+	}
+
+	organization := Organization{Name: strings.TrimSpace(req.Name), Slug: req.Slug, Role: RoleOwner}
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&organization).Error; err != nil {
+			return err
+		}
+		return tx.Create(&OrganizationMember{OrganizationID: organization.ID, UserID: userID, Role: RoleOwner}).Error
+	})
+	if err != nil {
+		RespondDBError(c, err, CodeOrganizationNotFound)
+		return
+	}
+
+	Respond(c, http.StatusCreated, SuccessResponse{
+		Success: true,
+		Data:    organization,
+	})
+}
+
+// GET /orgs - Protected: the organizations the caller belongs to, with their role
+func (h *OrganizationHandlers) getOrganizations(c *gin.Context) {
+	userID, _ := GetCurrentUserID(c)
+
+	var members []OrganizationMember
+	if err := h.db.Where("user_id = ?", userID).Find(&members).Error; err != nil {
+		RespondInternalError(c, err)
+		return
+	}
+	roles := map[uint]string{}
+	ids := []uint{}
+	for _, member := range members {
+		roles[member.OrganizationID] = member.Role
+		ids = append(ids, member.OrganizationID)
+	}
+
+	organizations := []Organization{}
+	if err := h.db.Where("id IN ?", ids).Order("id").Find(&organizations).Error; err != nil {
+		RespondInternalError(c, err)
+		return
+	}
+	for i := range organizations {
+		organizations[i].Role = roles[organizations[i].ID]
+	}
+
+	Respond(c, http.StatusOK, SuccessResponse{
+		Success: true,
+		Data:    organizations,
+	})
+}
+
+// GET /orgs/:org/members - Protected: the organization's members, for any of them
+func (h *OrganizationHandlers) getMembers(c *gin.Context) {
+	tenant := CurrentTenant(c)
+	if tenant.Role == "" {
+		RespondError(c, CodeNotMember, "")
+		return
+	}
+
+	var members []OrganizationMember
+	err := h.db.Where("organization_id = ?", tenant.Organization.ID).Order("id").Find(&members).Error
+	if err == nil {
+		err = fillMemberEmails(h.db, members)
+	}
+	if err != nil {
+		RespondInternalError(c, err)
+		return
+	}
+
+	Respond(c, http.StatusOK, SuccessResponse{
+		Success: true,
+		Data:    members,
+	})
+}
+
+// PUT /orgs/:org/members - Protected: an owner gives a user a role in the
+// organization (owner, editor or viewer), or changes the one they have
+func (h *OrganizationHandlers) putMember(c *gin.Context) {
+	tenant := CurrentTenant(c)
+
+	var req MemberRequest
+	if err := BindBody(c, &req); err != nil {
+		RespondError(c, CodeValidationFailed, err.Error())
+		return
+	}
+
+	if err := requireOwner(tenant); err != nil {
+		RespondDBError(c, err, CodeOrganizationNotFound)
+		return
+	}
+	var user User
+	if err := h.db.Where("LOWER(email) = ?", strings.ToLower(req.Email)).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			RespondValidationError(c, "", []ValidationIssue{{Location: "body", Field: "email", Message: "does not belong to any account"}})
+			return
+		}
+		RespondInternalError(c, err)
+		return
+	}
+
+	member := OrganizationMember{OrganizationID: tenant.Organization.ID, UserID: user.ID, Role: req.Role}
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if req.Role != RoleOwner {
+			if err := keepAnOwner(tx, tenant.Organization.ID, user.ID); err != nil {
+				return err
+			}
+		}
+		err := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "organization_id"}, {Name: "user_id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"role", "updated_at"}),
+		}).Create(&member).Error
+		if err != nil {
+			return err
+		}
+		return tx.Where("organization_id = ? AND user_id = ?", tenant.Organization.ID, user.ID).First(&member).Error
+	})
+	if err != nil {
+		RespondDBError(c, err, CodeMemberNotFound)
+		return
+	}
+	member.Email = user.Email
+
+	Respond(c, http.StatusOK, SuccessResponse{
+		Success: true,
+		Data:    member,
+	})
+}
+
+// DELETE /orgs/:org/members/:user_id - Protected: an owner removes a member,
+// or a member leaves. The last owner can't go.
+func (h *OrganizationHandlers) deleteMember(c *gin.Context) {
+	userID, _ := GetCurrentUserID(c)
+	tenant := CurrentTenant(c)
+	memberID, ok := uintParam(c, "user_id")
+	if !ok {
+		return
+	}
+
+	if memberID != userID {
+		if err := requireOwner(tenant); err != nil {
+			RespondDBError(c, err, CodeOrganizationNotFound)
+			return
+		}
+	} else if tenant.Role == "" {
+		RespondError(c, CodeMemberNotFound, "")
+		return
+	}
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := keepAnOwner(tx, tenant.Organization.ID, memberID); err != nil {
+			return err
+		}
+		removed := tx.Where("organization_id = ? AND user_id = ?", tenant.Organization.ID, memberID).Delete(&OrganizationMember{})
+		if removed.Error != nil {
+			return removed.Error
+		}
+		if removed.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return nil
+	})
+	if err != nil {
+		RespondDBError(c, err, CodeMemberNotFound)
+		return
+	}
+
+	Respond(c, http.StatusOK, SuccessResponse{
+		Success: true,
+		Data:    gin.H{"message": "Member removed"},
+	})
+}
+
+// requireOwner checks the caller is an owner of the tenant's organization
+func requireOwner(tenant Tenant) error {
+	switch tenant.Role {
+	case RoleOwner:
+		return nil
+	case "":
+		return ErrNotMember
+	default:
+		return ErrOwnerRequired
+	}
+}
+
+// keepAnOwner fails with ErrLastOwner if userID is the organization's only
+// owner, before they stop being one
+func keepAnOwner(tx *gorm.DB, organizationID, userID uint) error {
+	var others int64
+	err := tx.Model(&OrganizationMember{}).
+		Where("organization_id = ? AND role = ? AND user_id <> ?", organizationID, RoleOwner, userID).Count(&others).Error
+	if err != nil {
+		return err
+	}
+	role, err := memberRole(tx, organizationID, userID)
+	if err != nil {
+		return err
+	}
+	if role == RoleOwner && others == 0 {
+		return ErrLastOwner
+	}
+	return nil
+}
+
+// fillMemberEmails sets each member's email address
+func fillMemberEmails(db *gorm.DB, members []OrganizationMember) error {
+	var ids []uint
+	for _, member := range members {
+		ids = append(ids, member.UserID)
+	}
+	emails, err := userEmails(db, ids)
+	if err != nil {
+		return err
+	}
+	for i := range members {
+		members[i].Email = emails[members[i].UserID]
+	}
+	return nil
+}
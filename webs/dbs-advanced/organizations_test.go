@@ -0,0 +1,206 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// createTestOrganization creates an organization owned by owner, with the
+// other members given their roles
+func createTestOrganization(t *testing.T, server *httptest.Server, owner User, slug string, members map[string]string) Organization {
+	t.Helper()
+
+	var organization Organization
+	if status := apiCall(t, server, owner.APIKey, http.MethodPost, "/orgs", OrganizationRequest{Name: slug, Slug: slug}, &organization); status != http.StatusCreated {
+		t.Fatalf("POST /orgs %s = %d", slug, status)
+	}
+	for email, role := range members {
+		if status := apiCall(t, server, owner.APIKey, http.MethodPut, "/orgs/"+slug+"/members", MemberRequest{Email: email, Role: role}, nil); status != http.StatusOK {
+			t.Fatalf("PUT /orgs/%s/members %s = %d", slug, email, status)
+		}
+	}
+	return organization
+}
+
+func TestOrganizationCatalogue(t *testing.T) {
+	setupTestDB(t)
+	owner := createTestUser(t, "owner@example.com")
+	editor := createTestUser(t, "editor@example.com")
+	viewer := createTestUser(t, "viewer@example.com")
+	rival := createTestUser(t, "rival@example.com")
+	shared := createTestAlbum(t, "Shared", &owner.ID)
+	server := httptest.NewServer(setupRouter(NewServices(DB)))
+	t.Cleanup(server.Close)
+
+	organization := createTestOrganization(t, server, owner, "vinyl-vault", map[string]string{editor.Email: RoleEditor, viewer.Email: RoleViewer})
+	createTestOrganization(t, server, rival, "crate-diggers", nil)
+
+	var album Album
+	input := AlbumInput{Title: strPtr("Blue Train"), Artist: strPtr("John Coltrane"), Price: floatPtr(56.99)}
+	if status := apiCall(t, server, editor.APIKey, http.MethodPost, "/orgs/vinyl-vault/albums", input, &album); status != http.StatusCreated {
+		t.Fatalf("POST /orgs/vinyl-vault/albums = %d", status)
+	}
+	if album.OrganizationID == nil || *album.OrganizationID != organization.ID || album.UserID != nil {
+		t.Errorf("album = %+v, want it owned by the organization", album)
+	}
+	if status := apiCall(t, server, viewer.APIKey, http.MethodPost, "/orgs/vinyl-vault/albums", input, nil); status != http.StatusForbidden {
+		t.Errorf("viewer adding an album = %d, want 403", status)
+	}
+	if status := apiCall(t, server, rival.APIKey, http.MethodPost, "/orgs/vinyl-vault/albums", input, nil); status != http.StatusForbidden {
+		t.Errorf("outsider adding an album = %d, want 403", status)
+	}
+
+	// Each catalogue lists only its own albums, and an organization's only
+	// to its members
+	lists := []struct {
+		path       string
+		header     string
+		apiKey     string
+		wantStatus int
+		want       uint
+	}{
+		{"/albums", "", "", http.StatusOK, shared.ID},
+		{"/albums", "", rival.APIKey, http.StatusOK, shared.ID},
+		{"/orgs/vinyl-vault/albums", "", viewer.APIKey, http.StatusOK, album.ID},
+		{"/albums", "vinyl-vault", viewer.APIKey, http.StatusOK, album.ID},
+		{"/orgs/vinyl-vault/albums", "", "", http.StatusUnauthorized, 0},
+		{"/albums", "vinyl-vault", "", http.StatusUnauthorized, 0},
+		{"/orgs/vinyl-vault/albums", "", rival.APIKey, http.StatusForbidden, 0},
+		{"/albums", "vinyl-vault", rival.APIKey, http.StatusForbidden, 0},
+		{"/orgs/nobody/albums", "", rival.APIKey, http.StatusNotFound, 0},
+	}
+	for _, tt := range lists {
+		t.Run("list "+tt.path+" "+tt.header, func(t *testing.T) {
+			req, _ := http.NewRequest(http.MethodGet, server.URL+tt.path, nil)
+			req.Header.Set("X-Organization", tt.header)
+			if tt.apiKey != "" {
+				req.Header.Set("X-API-Key", tt.apiKey)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("GET %s: %v", tt.path, err)
+			}
+			defer resp.Body.Close()
+			var body struct{ Data []Album }
+			json.NewDecoder(resp.Body).Decode(&body)
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("GET %s = %d, want %d", tt.path, resp.StatusCode, tt.wantStatus)
+			}
+			if tt.want != 0 && (len(body.Data) != 1 || body.Data[0].ID != tt.want) {
+				t.Errorf("GET %s = %+v, want only album %d", tt.path, body.Data, tt.want)
+			}
+		})
+	}
+
+	path := "/orgs/vinyl-vault/albums/" + itoa(album.ID)
+	update := AlbumInput{Title: strPtr("Blue Train (Mono)"), Artist: input.Artist, Price: input.Price}
+	tests := []struct {
+		name                       string
+		apiKey                     string
+		wantGet, wantPut, wantDrop int
+	}{
+		{"outsider", rival.APIKey, http.StatusForbidden, http.StatusForbidden, http.StatusForbidden},
+		{"viewer", viewer.APIKey, http.StatusOK, http.StatusForbidden, http.StatusForbidden},
+		{"editor", editor.APIKey, http.StatusOK, http.StatusOK, http.StatusForbidden},
+		{"owner", owner.APIKey, http.StatusOK, http.StatusOK, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if status := apiCall(t, server, tt.apiKey, http.MethodGet, path, nil, nil); status != tt.wantGet {
+				t.Errorf("GET = %d, want %d", status, tt.wantGet)
+			}
+			if status := apiCall(t, server, tt.apiKey, http.MethodPut, path, update, nil); status != tt.wantPut {
+				t.Errorf("PUT = %d, want %d", status, tt.wantPut)
+			}
+			// Outside its catalogue the album isn't found, whoever asks
+			if status := apiCall(t, server, tt.apiKey, http.MethodGet, "/albums/"+itoa(album.ID), nil, nil); status != http.StatusNotFound {
+				t.Errorf("GET from the shared catalogue = %d, want 404", status)
+			}
+			// rival's own organization doesn't have it; the others may not look
+			wantOther := http.StatusForbidden
+			if tt.apiKey == rival.APIKey {
+				wantOther = http.StatusNotFound
+			}
+			if status := apiCall(t, server, tt.apiKey, http.MethodGet, "/orgs/crate-diggers/albums/"+itoa(album.ID), nil, nil); status != wantOther {
+				t.Errorf("GET from another organization = %d, want %d", status, wantOther)
+			}
+			if status := apiCall(t, server, tt.apiKey, http.MethodDelete, path, nil, nil); status != tt.wantDrop {
+				t.Errorf("DELETE = %d, want %d", status, tt.wantDrop)
+			}
+		})
+	}
+
+	// Nobody can claim, share or give away an organization's album from
+	// the shared catalogue: it isn't found there
+	var claimable Album
+	apiCall(t, server, owner.APIKey, http.MethodPost, "/orgs/vinyl-vault/albums", input, &claimable)
+	outside := []struct {
+		name, apiKey, method, path string
+		body                       interface{}
+	}{
+		{"claim", rival.APIKey, http.MethodPost, "/albums/" + itoa(claimable.ID) + "/claim", nil},
+		{"share", owner.APIKey, http.MethodPut, "/albums/" + itoa(claimable.ID) + "/collaborators", CollaboratorRequest{Email: rival.Email, Role: RoleViewer}},
+		{"transfer", owner.APIKey, http.MethodPost, "/albums/" + itoa(claimable.ID) + "/transfer", TransferAlbumRequest{To: rival.Email}},
+	}
+	for _, tt := range outside {
+		if status := apiCall(t, server, tt.apiKey, tt.method, tt.path, tt.body, nil); status != http.StatusNotFound {
+			t.Errorf("%s an organization's album from the shared catalogue = %d, want 404", tt.name, status)
+		}
+	}
+}
+
+func TestOrganizationMembers(t *testing.T) {
+	setupTestDB(t)
+	owner := createTestUser(t, "owner@example.com")
+	editor := createTestUser(t, "editor@example.com")
+	server := httptest.NewServer(setupRouter(NewServices(DB)))
+	t.Cleanup(server.Close)
+
+	createTestOrganization(t, server, owner, "vinyl-vault", map[string]string{editor.Email: RoleEditor})
+
+	refused := []struct {
+		name   string
+		apiKey string
+		method string
+		path   string
+		body   interface{}
+		want   int
+	}{
+		{"taken slug", editor.APIKey, http.MethodPost, "/orgs", OrganizationRequest{Name: "Copycat", Slug: "vinyl-vault"}, http.StatusConflict},
+		{"bad slug", editor.APIKey, http.MethodPost, "/orgs", OrganizationRequest{Name: "Shouty", Slug: "Vinyl Vault"}, http.StatusBadRequest},
+		{"editor adding a member", editor.APIKey, http.MethodPut, "/orgs/vinyl-vault/members", MemberRequest{Email: editor.Email, Role: RoleOwner}, http.StatusForbidden},
+		{"unknown account", owner.APIKey, http.MethodPut, "/orgs/vinyl-vault/members", MemberRequest{Email: "nobody@example.com", Role: RoleViewer}, http.StatusBadRequest},
+		{"last owner stepping down", owner.APIKey, http.MethodPut, "/orgs/vinyl-vault/members", MemberRequest{Email: owner.Email, Role: RoleViewer}, http.StatusConflict},
+		{"last owner leaving", owner.APIKey, http.MethodDelete, "/orgs/vinyl-vault/members/" + itoa(owner.ID), nil, http.StatusConflict},
+		{"editor removing the owner", editor.APIKey, http.MethodDelete, "/orgs/vinyl-vault/members/" + itoa(owner.ID), nil, http.StatusForbidden},
+	}
+	for _, tt := range refused {
+		t.Run(tt.name, func(t *testing.T) {
+			if status := apiCall(t, server, tt.apiKey, tt.method, tt.path, tt.body, nil); status != tt.want {
+				t.Errorf("%s %s = %d, want %d", tt.method, tt.path, status, tt.want)
+			}
+		})
+	}
+
+	var organizations []Organization
+	apiCall(t, server, editor.APIKey, http.MethodGet, "/orgs", nil, &organizations)
+	if len(organizations) != 1 || organizations[0].Slug != "vinyl-vault" || organizations[0].Role != RoleEditor {
+		t.Errorf("GET /orgs = %+v, want vinyl-vault as an editor", organizations)
+	}
+	var members []OrganizationMember
+	apiCall(t, server, editor.APIKey, http.MethodGet, "/orgs/vinyl-vault/members", nil, &members)
+	if len(members) != 2 || members[0].Email != owner.Email || members[0].Role != RoleOwner {
+		t.Errorf("members = %+v, want the owner then the editor", members)
+	}
+
+	// With a second owner the first may go
+	apiCall(t, server, owner.APIKey, http.MethodPut, "/orgs/vinyl-vault/members", MemberRequest{Email: editor.Email, Role: RoleOwner}, nil)
+	if status := apiCall(t, server, owner.APIKey, http.MethodDelete, "/orgs/vinyl-vault/members/"+itoa(owner.ID), nil, nil); status != http.StatusOK {
+		t.Fatalf("owner leaving = %d", status)
+	}
+	if status := apiCall(t, server, owner.APIKey, http.MethodGet, "/orgs/vinyl-vault/members", nil, nil); status != http.StatusForbidden {
+		t.Errorf("GET members after leaving = %d, want 403", status)
+	}
+}
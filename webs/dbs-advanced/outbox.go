@@ -0,0 +1,325 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	webhookPollInterval = 2 * time.Second
+	webhookTimeout      = 10 * time.Second
+	webhookBatchSize    = 50
+	webhookMaxAttempts  = 8                // then the delivery is failed until redelivered by hand
+	webhookBaseBackoff  = 30 * time.Second // doubled after every failed attempt
+	webhookMaxBackoff   = time.Hour
+)
+
+// Webhook delivery statuses
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed"
+)
+
+// webhookEventTypes are the events a webhook can subscribe to
+var webhookEventTypes = []string{EventAlbumCreated, EventAlbumUpdated, EventAlbumDeleted}
+
+// AllowPrivateWebhooks lets webhooks deliver to loopback, private and
+// link-local addresses (WEBHOOK_ALLOW_PRIVATE=true), for development and
+// tests. Otherwise a webhook could make the server call its own admin
+// endpoints or cloud metadata.
+var AllowPrivateWebhooks bool
+
+// errWebhookAddress is why a delivery to a non-public address fails
+var errWebhookAddress = errors.New("webhook address is not public")
+
+// sharedAddressSpace is carrier-grade NAT, which netip doesn't count as
+// private but is as unreachable from outside
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// publicAddress reports whether a webhook may be delivered to addr
+func publicAddress(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsGlobalUnicast() && !addr.IsPrivate() && !sharedAddressSpace.Contains(addr)
+}
+
+// checkWebhookDial runs before every connection a delivery makes, after DNS
+// has been resolved, so a name that resolves (or later rebinds) to an
+// internal address is caught as well as a literal one
+func checkWebhookDial(network, address string, _ syscall.RawConn) error {
+	if AllowPrivateWebhooks {
+		return nil
+	}
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return err
+	}
+	if !publicAddress(addrPort.Addr()) {
+		return fmt.Errorf("%w: %s", errWebhookAddress, addrPort.Addr())
+	}
+	return nil
+}
+
+// webhookClient doesn't follow redirects, so a receiver can't bounce
+// deliveries (and their signatures) somewhere else, and only dials public
+// addresses. It ignores proxy settings: through a proxy the dial check
+// would see the proxy's address instead of the receiver's.
+var webhookClient = &http.Client{
+	Timeout: webhookTimeout,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: webhookTimeout,
+			Control: checkWebhookDial,
+		}).DialContext,
+		TLSHandshakeTimeout: webhookTimeout,
+		MaxIdleConnsPerHost: 2,
+		IdleConnTimeout:     90 * time.Second,
+	},
+	CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// WebhookPayload is the JSON body POSTed to webhooks
+type WebhookPayload struct {
+	Type  string    `json:"type"`
+	Album Album     `json:"album"`
+	Time  time.Time `json:"time"`
+}
+
+// addOutboxEvent records an album change for the owner's webhooks. Call it
+// inside the transaction making the change, so the event exists exactly when
+// the change does. Albums without an owner have nobody to notify.
+func addOutboxEvent(tx *gorm.DB, eventType string, album Album) error {
+	if album.UserID == nil {
+		return nil
+	}
+
+	album.User = nil // keep owners' details (and API keys) out of the payload
+	payload, err := json.Marshal(WebhookPayload{Type: eventType, Album: album, Time: time.Now().UTC()})
+	if err != nil {
+		return err
+	}
+	return tx.Create(&OutboxEvent{UserID: *album.UserID, Type: eventType, Payload: string(payload)}).Error
+}
+
+// SignWebhook is the hex HMAC-SHA256 of "timestamp.body" with the webhook's
+// secret, sent as X-Webhook-Signature: t=<timestamp>,v1=<signature>.
+// Receivers recompute it to check the delivery came from us, and reject old
+// timestamps to stop replays.
+func SignWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// RunWebhookDispatcher calls DispatchWebhooks every few seconds until ctx ends
+func RunWebhookDispatcher(ctx context.Context) {
+	ticker := time.NewTicker(webhookPollInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := DispatchWebhooks(ctx); err != nil {
+			log.Printf("webhooks: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// DispatchWebhooks turns new outbox events into deliveries and sends the
+// deliveries that are due. Rows are claimed with conditional updates, so
+// several instances can run it against the same database.
+func DispatchWebhooks(ctx context.Context) error {
+	if err := fanOutOutbox(); err != nil {
+		return err
+	}
+	return sendDueDeliveries(ctx)
+}
+
+// fanOutOutbox creates one pending delivery per subscribed webhook for each
+// unprocessed outbox event
+func fanOutOutbox() error {
+	var events []OutboxEvent
+	if err := DB.Where("processed_at IS NULL").Order("id").Limit(webhookBatchSize).Find(&events).Error; err != nil {
+		return err
+	}
+
+	for _, event := range events {
+		err := DB.Transaction(func(tx *gorm.DB) error {
+			now := time.Now()
+			claim := tx.Model(&OutboxEvent{}).Where("id = ? AND processed_at IS NULL", event.ID).Update("processed_at", now)
+			if claim.Error != nil || claim.RowsAffected == 0 {
+				return claim.Error // 0 rows: another dispatcher got there first
+			}
+
+			var webhooks []Webhook
+			if err := tx.Where("user_id = ?", event.UserID).Find(&webhooks).Error; err != nil {
+				return err
+			}
+			for _, webhook := range webhooks {
+				if !webhook.Subscribed(event.Type) {
+					continue
+				}
+				delivery := WebhookDelivery{
+					WebhookID:     webhook.ID,
+					OutboxEventID: event.ID,
+					EventType:     event.Type,
+					Status:        DeliveryPending,
+					NextAttemptAt: &now,
+				}
+				if err := tx.Create(&delivery).Error; err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("outbox event %d: %w", event.ID, err)
+		}
+	}
+	return nil
+}
+
+// sendDueDeliveries makes one attempt at every pending delivery whose time has come
+func sendDueDeliveries(ctx context.Context) error {
+	var due []WebhookDelivery
+	err := DB.Where("status = ? AND next_attempt_at <= ?", DeliveryPending, time.Now()).
+		Order("next_attempt_at").Limit(webhookBatchSize).Find(&due).Error
+	if err != nil {
+		return err
+	}
+
+	for _, delivery := range due {
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		// Count the attempt and hold the delivery while it is in flight. The
+		// attempts check makes sure only one dispatcher sends it.
+		lease := time.Now().Add(2 * webhookTimeout)
+		claim := DB.Model(&WebhookDelivery{}).
+			Where("id = ? AND status = ? AND attempts = ?", delivery.ID, DeliveryPending, delivery.Attempts).
+			Updates(map[string]interface{}{"attempts": delivery.Attempts + 1, "next_attempt_at": lease})
+		if claim.Error != nil {
+			return claim.Error
+		}
+		if claim.RowsAffected == 0 {
+			continue
+		}
+		delivery.Attempts++
+
+		statusCode, sendErr := deliverWebhook(ctx, delivery)
+		if err := recordAttempt(delivery, statusCode, sendErr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deliverWebhook POSTs the delivery's event to its webhook. It returns the
+// receiver's status code (0 when there was no response) and an error unless
+// the receiver answered 2xx.
+func deliverWebhook(ctx context.Context, delivery WebhookDelivery) (int, error) {
+	var webhook Webhook
+	if err := DB.First(&webhook, delivery.WebhookID).Error; err != nil {
+		return 0, fmt.Errorf("webhook %d: %w", delivery.WebhookID, err)
+	}
+	var event OutboxEvent
+	if err := DB.First(&event, delivery.OutboxEventID).Error; err != nil {
+		return 0, fmt.Errorf("outbox event %d: %w", delivery.OutboxEventID, err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, strings.NewReader(event.Payload))
+	if err != nil {
+		return 0, err
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "dbs-webhooks/1.0")
+	req.Header.Set("X-Webhook-Event", event.Type)
+	req.Header.Set("X-Webhook-ID", strconv.FormatUint(uint64(event.ID), 10)) // the same for every retry, for deduplication
+	req.Header.Set("X-Webhook-Delivery", strconv.FormatUint(uint64(delivery.ID), 10))
+	req.Header.Set("X-Webhook-Signature", "t="+timestamp+",v1="+SignWebhook(webhook.Secret, timestamp, []byte(event.Payload)))
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10)) // let the connection be reused
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("receiver answered %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// recordAttempt logs how an attempt went and schedules the next one
+func recordAttempt(delivery WebhookDelivery, statusCode int, sendErr error) error {
+	now := time.Now()
+	changes := map[string]interface{}{"last_status_code": statusCode, "last_error": ""}
+
+	switch {
+	case sendErr == nil:
+		changes["status"] = DeliverySucceeded
+		changes["delivered_at"] = now
+		changes["next_attempt_at"] = nil
+	case delivery.Attempts >= webhookMaxAttempts, errors.Is(sendErr, gorm.ErrRecordNotFound): // the webhook was deleted
+		changes["status"] = DeliveryFailed
+		changes["last_error"] = truncate(sendErr.Error(), 500)
+		changes["next_attempt_at"] = nil
+	default:
+		changes["last_error"] = truncate(sendErr.Error(), 500)
+		changes["next_attempt_at"] = now.Add(webhookBackoff(delivery.Attempts))
+	}
+
+	return DB.Model(&WebhookDelivery{ID: delivery.ID}).Updates(changes).Error
+}
+
+// webhookBackoff is the wait after the given number of failed attempts:
+// 30s, 1m, 2m, 4m ... up to an hour
+func webhookBackoff(attempts int) time.Duration {
+	return backoff(attempts, webhookBaseBackoff, webhookMaxBackoff)
+}
+
+// backoff doubles base for every failed attempt after the first, up to limit
+func backoff(attempts int, base, limit time.Duration) time.Duration {
+	wait := base
+	for i := 1; i < attempts && wait < limit; i++ {
+		wait *= 2
+	}
+	if wait > limit {
+		wait = limit
+	}
+	return wait
+}
+
+func truncate(s string, max int) string {
+	if len(s) > max {
+		return s[:max]
+	}
+	return s
+}
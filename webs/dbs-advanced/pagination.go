@@ -0,0 +1,65 @@
+package main
+
+import (
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Largest page a client can ask for with ?limit=
+const maxPageSize = 100
+
+// Page is a window on a list ordered by ID: Limit rows after the first
+// Offset, or every row when Limit is 0
+type Page struct {
+	Limit  int
+	Offset int
+}
+
+// Scope is the GORM scope applying the page to a query
+func (p Page) Scope(db *gorm.DB) *gorm.DB {
+	db = db.Order("id")
+	if p.Limit > 0 {
+		db = db.Limit(p.Limit).Offset(p.Offset)
+	}
+	return db
+}
+
+// ParsePage reads the optional ?limit= and ?offset= query params. Without
+// limit every row is returned, as before pagination existed.
+func ParsePage(c *gin.Context) (Page, []ValidationIssue) {
+	var issues []ValidationIssue
+	var page Page
+
+	if value := c.Query("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > maxPageSize {
+			issues = append(issues, ValidationIssue{Location: "query", Field: "limit", Message: "must be an integer between 1 and " + strconv.Itoa(maxPageSize)})
+		}
+		page.Limit = n
+	}
+
+	if value := c.Query("offset"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			issues = append(issues, ValidationIssue{Location: "query", Field: "offset", Message: "must be a non-negative integer"})
+		}
+		page.Offset = n
+	}
+
+	if issues != nil {
+		return Page{}, issues
+	}
+	return page, nil
+}
+
+// Paginate is ParsePage as a GORM scope. Rows are always ordered by ID so
+// pages are stable.
+func Paginate(c *gin.Context) (func(*gorm.DB) *gorm.DB, []ValidationIssue) {
+	page, issues := ParsePage(c)
+	if issues != nil {
+		return nil, issues
+	}
+	return page.Scope, nil
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Repositories are where AlbumService keeps albums and finds users, so the
+// album rules don't depend on the database: NewGormAlbumRepository and
+// NewGormUserRepository are the ones the server runs on, and memory.go has
+// in-memory ones for tests and tools. TenantMiddleware finds organizations
+// and the webhook handlers keep webhooks the same way, in the database only.
+// Like GORM they return gorm.ErrRecordNotFound for a row that isn't there.
+
+// AlbumRepository stores albums. Lookups only see the tenant's catalogue.
+type AlbumRepository interface {
+	// List returns one page of the tenant's albums with their owners, only
+	// ownerID's albums when it is set
+	List(tenant Tenant, ownerID *uint, page Page) ([]Album, error)
+	// Get returns one of the tenant's albums with its owner
+	Get(tenant Tenant, id uint) (Album, error)
+	// CollaboratorRole returns userID's role on an album shared with them,
+	// or "" if it isn't
+	CollaboratorRole(albumID, userID uint) (string, error)
+	// MemberRole returns userID's role in an organization, or "" if they
+	// have none
+	MemberRole(organizationID, userID uint) (string, error)
+	// Create saves a new album and loads its owner
+	Create(album *Album) error
+	// Update saves an album's fields and reloads its owner
+	Update(album *Album) error
+	// Delete removes an album with its sharing and open claims
+	Delete(album Album) error
+}
+
+// UserRepository finds users
+type UserRepository interface {
+	Get(id uint) (User, error)
+	FindByAPIKey(apiKey string) (User, error)
+}
+
+// OrganizationRepository finds the organization whose catalogue a request
+// works in
+type OrganizationRepository interface {
+	FindBySlug(slug string) (Organization, error)
+	// MemberRole returns userID's role in an organization, or "" if they
+	// have none
+	MemberRole(organizationID, userID uint) (string, error)
+}
+
+// WebhookRepository stores webhooks and their delivery logs. Lookups only
+// see userID's webhooks.
+type WebhookRepository interface {
+	List(userID uint) ([]Webhook, error)
+	Get(userID, id uint) (Webhook, error)
+	Create(webhook *Webhook) error
+	Delete(webhook Webhook) error
+	// Deliveries returns one page of a webhook's delivery log
+	Deliveries(webhookID uint, page Page) ([]WebhookDelivery, error)
+	// Redeliver marks one of a webhook's deliveries to be sent again as
+	// soon as the dispatcher next runs
+	Redeliver(webhookID, deliveryID uint) (WebhookDelivery, error)
+}
+
+// gormAlbumRepository keeps albums in the database and writes every change to
+// the webhook outbox in the same transaction as the change itself. Changes are
+// published to Events once that transaction has committed.
+type gormAlbumRepository struct {
+	db *gorm.DB
+}
+
+// NewGormAlbumRepository keeps albums in db
+func NewGormAlbumRepository(db *gorm.DB) AlbumRepository {
+	return &gormAlbumRepository{db: db}
+}
+
+func (r *gormAlbumRepository) List(tenant Tenant, ownerID *uint, page Page) ([]Album, error) {
+	query := r.db.Scopes(tenant.Albums, page.Scope).Preload("User")
+	if ownerID != nil {
+		query = query.Where("user_id = ?", *ownerID)
+	}
+
+	var albums []Album
+	err := query.Find(&albums).Error
+	return albums, err
+}
+
+func (r *gormAlbumRepository) Get(tenant Tenant, id uint) (Album, error) {
+	var album Album
+	err := r.db.Scopes(tenant.Albums).Preload("User").First(&album, id).Error
+	return album, err
+}
+
+func (r *gormAlbumRepository) CollaboratorRole(albumID, userID uint) (string, error) {
+	var collaborator AlbumCollaborator
+	err := r.db.Where("album_id = ? AND user_id = ?", albumID, userID).First(&collaborator).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", nil
+	}
+	return collaborator.Role, err
+}
+
+func (r *gormAlbumRepository) MemberRole(organizationID, userID uint) (string, error) {
+	return memberRole(r.db, organizationID, userID)
+}
+
+func (r *gormAlbumRepository) Create(album *Album) error {
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(album).Error; err != nil {
+			return err
+		}
+		return addOutboxEvent(tx, EventAlbumCreated, *album)
+	})
+	if err != nil {
+		return err
+	}
+	Events.Publish(EventAlbumCreated, *album)
+	return r.db.Preload("User").First(album, album.ID).Error
+}
+
+func (r *gormAlbumRepository) Update(album *Album) error {
+	album.User = nil // don't let Save write the old owner back
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(album).Error; err != nil {
+			return err
+		}
+		return addOutboxEvent(tx, EventAlbumUpdated, *album)
+	})
+	if err != nil {
+		return err
+	}
+	Events.Publish(EventAlbumUpdated, *album)
+	return r.db.Preload("User").First(album, album.ID).Error
+}
+
+func (r *gormAlbumRepository) Delete(album Album) error {
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&album).Error; err != nil {
+			return err
+		}
+		if err := unshareAlbum(tx, album.ID); err != nil {
+			return err
+		}
+		if err := rejectClaims(tx, album.ID); err != nil {
+			return err
+		}
+		album.User = nil
+		return addOutboxEvent(tx, EventAlbumDeleted, album)
+	})
+	if err != nil {
+		return err
+	}
+	Events.Publish(EventAlbumDeleted, album)
+	return nil
+}
+
+// gormUserRepository finds users in the database
+type gormUserRepository struct {
+	db *gorm.DB
+}
+
+// NewGormUserRepository finds users in db
+func NewGormUserRepository(db *gorm.DB) UserRepository {
+	return &gormUserRepository{db: db}
+}
+
+func (r *gormUserRepository) Get(id uint) (User, error) {
+	var user User
+	err := r.db.First(&user, id).Error
+	return user, err
+}
+
+func (r *gormUserRepository) FindByAPIKey(apiKey string) (User, error) {
+	var user User
+	err := r.db.Where("api_key = ?", apiKey).First(&user).Error
+	return user, err
+}
+
+// gormOrganizationRepository finds organizations in the database
+type gormOrganizationRepository struct {
+	db *gorm.DB
+}
+
+// NewGormOrganizationRepository finds organizations in db
+func NewGormOrganizationRepository(db *gorm.DB) OrganizationRepository {
+	return &gormOrganizationRepository{db: db}
+}
+
+func (r *gormOrganizationRepository) FindBySlug(slug string) (Organization, error) {
+	var organization Organization
+	err := r.db.Where("slug = ?", strings.ToLower(slug)).First(&organization).Error
+	return organization, err
+}
+
+func (r *gormOrganizationRepository) MemberRole(organizationID, userID uint) (string, error) {
+	return memberRole(r.db, organizationID, userID)
+}
+
+// gormWebhookRepository keeps webhooks in the database
+type gormWebhookRepository struct {
+	db *gorm.DB
+}
+
+// NewGormWebhookRepository keeps webhooks in db
+func NewGormWebhookRepository(db *gorm.DB) WebhookRepository {
+	return &gormWebhookRepository{db: db}
+}
+
+func (r *gormWebhookRepository) List(userID uint) ([]Webhook, error) {
+	var webhooks []Webhook
+	err := r.db.Where("user_id = ?", userID).Order("id").Find(&webhooks).Error
+	return webhooks, err
+}
+
+func (r *gormWebhookRepository) Get(userID, id uint) (Webhook, error) {
+	var webhook Webhook
+	err := r.db.Where("user_id = ?", userID).First(&webhook, id).Error
+	return webhook, err
+}
+
+func (r *gormWebhookRepository) Create(webhook *Webhook) error {
+	return r.db.Create(webhook).Error
+}
+
+func (r *gormWebhookRepository) Delete(webhook Webhook) error {
+	return r.db.Delete(&webhook).Error
+}
+
+func (r *gormWebhookRepository) Deliveries(webhookID uint, page Page) ([]WebhookDelivery, error) {
+	var deliveries []WebhookDelivery
+	err := r.db.Scopes(page.Scope).Where("webhook_id = ?", webhookID).Find(&deliveries).Error
+	return deliveries, err
+}
+
+func (r *gormWebhookRepository) Redeliver(webhookID, deliveryID uint) (WebhookDelivery, error) {
+	var delivery WebhookDelivery
+	if err := r.db.Where("webhook_id = ?", webhookID).First(&delivery, deliveryID).Error; err != nil {
+		return WebhookDelivery{}, err
+	}
+
+	now := time.Now()
+	delivery.Status = DeliveryPending
+	delivery.NextAttemptAt = &now
+	err := r.db.Model(&delivery).Updates(map[string]interface{}{"status": delivery.Status, "next_attempt_at": now}).Error
+	return delivery, err
+}
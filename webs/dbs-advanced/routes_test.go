@@ -0,0 +1,441 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// routeWorld is what every TestRoutes case starts from, in a database of its
+// own: an owner whose album "Blue Train" is shared with an editor, an album
+// "Gift" on its way to a stranger, an ownerless album the editor has
+// claimed, an organization the owner runs with the editor in it, and the
+// owner's webhook, failed delivery and ready export.
+type routeWorld struct {
+	server *httptest.Server
+	keys   map[string]string // API key of each user by name, "bad" for a wrong one
+	ids    *strings.Replacer // {album}, {gift}, {ownerless}, ... in paths
+}
+
+func newRouteWorld(t *testing.T) routeWorld {
+	t.Helper()
+
+	server := newTestServer(t)
+	owner := createTestUser(t, "owner@example.com")
+	editor := createTestUser(t, "editor@example.com")
+	stranger := createTestUser(t, "stranger@example.com")
+	admin := createTestAdmin(t, "admin@example.com")
+	setTestPassword(t, owner)
+	setTestPassword(t, editor)
+
+	album := createTestAlbum(t, "Blue Train", &owner.ID)
+	shareTestAlbum(t, album.ID, editor, RoleEditor)
+	gift := createTestAlbum(t, "Gift", &owner.ID)
+	ownerless := createTestAlbum(t, "Unclaimed", nil)
+
+	var transfer AlbumTransfer
+	if status := apiCall(t, server, owner.APIKey, http.MethodPost, "/albums/"+itoa(gift.ID)+"/transfer", TransferAlbumRequest{To: stranger.Email}, &transfer); status != http.StatusCreated {
+		t.Fatalf("transfer Gift = %d", status)
+	}
+	var claim AlbumClaim
+	if status := apiCall(t, server, editor.APIKey, http.MethodPost, "/albums/"+itoa(ownerless.ID)+"/claim", ClaimAlbumRequest{}, &claim); status != http.StatusCreated {
+		t.Fatalf("claim Unclaimed = %d", status)
+	}
+
+	createTestOrganization(t, server, owner, "vinyl-vault", map[string]string{editor.Email: RoleEditor})
+	var orgAlbum Album
+	if status := apiCall(t, server, owner.APIKey, http.MethodPost, "/orgs/vinyl-vault/albums", gin.H{"title": "Ah Um", "artist": "Charles Mingus", "price": 21.99}, &orgAlbum); status != http.StatusCreated {
+		t.Fatalf("add organization album = %d", status)
+	}
+
+	var webhook Webhook
+	if status := apiCall(t, server, owner.APIKey, http.MethodPost, "/webhooks", WebhookInput{URL: "https://example.com/hook", Events: []string{EventAlbumUpdated}}, &webhook); status != http.StatusCreated {
+		t.Fatalf("add webhook = %d", status)
+	}
+	event := OutboxEvent{UserID: owner.ID, Type: EventAlbumUpdated, Payload: "{}"}
+	DB.Create(&event)
+	delivery := WebhookDelivery{WebhookID: webhook.ID, OutboxEventID: event.ID, EventType: event.Type, Status: DeliveryFailed, Attempts: 1}
+	DB.Create(&delivery)
+
+	now := time.Now()
+	expires := now.Add(time.Hour)
+	export := DataExport{UserID: owner.ID, RequestedByID: owner.ID, Status: ExportReady, Archive: []byte("archive"), CompletedAt: &now, ExpiresAt: &expires}
+	DB.Create(&export)
+
+	return routeWorld{
+		server: server,
+		keys: map[string]string{
+			"owner": owner.APIKey, "editor": editor.APIKey, "stranger": stranger.APIKey, "admin": admin.APIKey,
+			"bad": "not-a-real-key",
+		},
+		ids: strings.NewReplacer(
+			"{owner}", itoa(owner.ID), "{editor}", itoa(editor.ID),
+			"{album}", itoa(album.ID), "{gift}", itoa(gift.ID), "{ownerless}", itoa(ownerless.ID), "{orgAlbum}", itoa(orgAlbum.ID),
+			"{transfer}", itoa(transfer.ID), "{claim}", itoa(claim.ID),
+			"{webhook}", itoa(webhook.ID), "{delivery}", itoa(delivery.ID),
+			"{export}", itoa(export.ID), "{download}", strings.TrimPrefix(exportDownloadURL(export.ID, expires), PublicURL),
+		),
+	}
+}
+
+// routeCase is one request to a route and the answer it should get
+type routeCase struct {
+	as   string // the user whose API key is sent, "" for none
+	path string // with {placeholders} from routeWorld
+	body interface{}
+	want int
+	code ErrorCode // checked when set
+}
+
+// Every API route, each with its failures and a happy path. The HTML pages
+// under /ui and the development identity provider aren't part of the API
+// and aren't listed.
+var routeCases = []struct {
+	route string // method and path as registered
+	cases []routeCase
+}{
+	{"GET /health", []routeCase{{path: "/health", want: 200}}},
+	{"GET /openapi.json", []routeCase{{path: "/openapi.json", want: 200}}},
+	{"GET /docs", []routeCase{{path: "/docs", want: 200}}},
+	{"GET /albums", []routeCase{
+		{path: "/albums", want: 200},
+		{path: "/albums?limit=2&offset=1", want: 200},
+		{path: "/albums?limit=0", want: 400, code: CodeValidationFailed},
+	}},
+	{"POST /login", []routeCase{
+		{path: "/login", body: LoginRequest{Email: "owner@example.com", Password: testPassword}, want: 200},
+		{path: "/login", body: LoginRequest{Email: "owner@example.com", Password: "wrong"}, want: 401, code: CodeInvalidCredentials},
+		{path: "/login", body: LoginRequest{Email: "owner@example.com"}, want: 400, code: CodeValidationFailed},
+	}},
+	{"POST /password/forgot", []routeCase{
+		{path: "/password/forgot", body: ForgotPasswordRequest{Email: "owner@example.com"}, want: 202},
+		{path: "/password/forgot", body: ForgotPasswordRequest{Email: "nobody@example.com"}, want: 202},
+		{path: "/password/forgot", body: ForgotPasswordRequest{}, want: 400, code: CodeValidationFailed},
+	}},
+	{"POST /password/reset", []routeCase{
+		{path: "/password/reset", body: ResetPasswordRequest{Token: "made-up", Password: "a-new-password"}, want: 400, code: CodeTokenInvalid},
+		{path: "/password/reset", body: ResetPasswordRequest{Token: "made-up", Password: "short"}, want: 400, code: CodeValidationFailed},
+	}},
+	{"POST /email/verify", []routeCase{
+		{path: "/email/verify", body: VerifyEmailRequest{Token: "made-up"}, want: 400, code: CodeTokenInvalid},
+		{path: "/email/verify", body: VerifyEmailRequest{}, want: 400, code: CodeValidationFailed},
+	}},
+	{"POST /email/change/confirm", []routeCase{
+		{path: "/email/change/confirm", body: VerifyEmailRequest{Token: "made-up"}, want: 400, code: CodeTokenInvalid},
+	}},
+
+	// Albums
+	{"GET /my-albums", []routeCase{
+		{as: "owner", path: "/my-albums", want: 200},
+		{as: "owner", path: "/my-albums?offset=-1", want: 400, code: CodeValidationFailed},
+	}},
+	{"POST /albums", []routeCase{
+		{as: "stranger", path: "/albums", body: gin.H{"title": "Giant Steps", "artist": "John Coltrane", "price": 24.99}, want: 201},
+		{as: "stranger", path: "/albums", body: gin.H{"price": "free"}, want: 400, code: CodeValidationFailed},
+		{as: "stranger", path: "/albums", body: gin.H{}, want: 400, code: CodeValidationFailed},
+		{as: "stranger", path: "/albums", body: gin.H{"title": "Giant Steps", "artist": "John Coltrane", "price": -5}, want: 400, code: CodeValidationFailed},
+	}},
+	{"GET /albums/:id", []routeCase{
+		{as: "owner", path: "/albums/{album}", want: 200},
+		{as: "editor", path: "/albums/{album}", want: 200},
+		{as: "stranger", path: "/albums/{ownerless}", want: 200},
+		{as: "stranger", path: "/albums/{album}", want: 403, code: CodeForbiddenNotOwner},
+		{as: "owner", path: "/albums/{orgAlbum}", want: 404, code: CodeAlbumNotFound},
+		{as: "owner", path: "/albums/9999", want: 404, code: CodeAlbumNotFound},
+		{as: "owner", path: "/albums/first", want: 400, code: CodeValidationFailed},
+	}},
+	{"PUT /albums/:id", []routeCase{
+		{as: "owner", path: "/albums/{album}", body: gin.H{"price": 9.5}, want: 200},
+		{as: "editor", path: "/albums/{album}", body: gin.H{"title": "Blue Train (Remastered)"}, want: 200},
+		{as: "stranger", path: "/albums/{album}", body: gin.H{"price": 0}, want: 403, code: CodeForbiddenNotOwner},
+		{as: "stranger", path: "/albums/{ownerless}", body: gin.H{"price": 0}, want: 403, code: CodeAlbumReadOnly},
+		{as: "admin", path: "/albums/{ownerless}", body: gin.H{"price": 0}, want: 200},
+		{as: "owner", path: "/albums/9999", body: gin.H{}, want: 404, code: CodeAlbumNotFound},
+		{as: "owner", path: "/albums/{album}", body: gin.H{"title": 7}, want: 400, code: CodeValidationFailed},
+	}},
+	{"DELETE /albums/:id", []routeCase{
+		{as: "owner", path: "/albums/{album}", want: 200},
+		{as: "editor", path: "/albums/{album}", want: 403, code: CodeForbiddenNotOwner},
+		{as: "stranger", path: "/albums/{ownerless}", want: 403, code: CodeForbiddenNotOwner},
+		{as: "admin", path: "/albums/{ownerless}", want: 200},
+		{as: "owner", path: "/albums/9999", want: 404, code: CodeAlbumNotFound},
+	}},
+	{"POST /albums/:id/transfer", []routeCase{
+		{as: "owner", path: "/albums/{album}/transfer", body: TransferAlbumRequest{To: "stranger@example.com"}, want: 201},
+		{as: "owner", path: "/albums/{gift}/transfer", body: TransferAlbumRequest{To: "editor@example.com"}, want: 409, code: CodeTransferPending},
+		{as: "editor", path: "/albums/{album}/transfer", body: TransferAlbumRequest{To: "stranger@example.com"}, want: 403, code: CodeForbiddenNotOwner},
+		{as: "owner", path: "/albums/9999/transfer", body: TransferAlbumRequest{To: "stranger@example.com"}, want: 404, code: CodeAlbumNotFound},
+		{as: "owner", path: "/albums/{album}/transfer", body: TransferAlbumRequest{To: "not an email"}, want: 400, code: CodeValidationFailed},
+	}},
+	{"POST /albums/:id/claim", []routeCase{
+		{as: "stranger", path: "/albums/{ownerless}/claim", body: ClaimAlbumRequest{Reason: "I bought it in 1961"}, want: 201},
+		{as: "editor", path: "/albums/{ownerless}/claim", body: ClaimAlbumRequest{}, want: 409, code: CodeClaimPending},
+		{as: "stranger", path: "/albums/9999/claim", body: ClaimAlbumRequest{}, want: 404, code: CodeAlbumNotFound},
+		{as: "stranger", path: "/albums/{ownerless}/claim", body: ClaimAlbumRequest{Reason: strings.Repeat("x", 501)}, want: 400, code: CodeValidationFailed},
+	}},
+	{"GET /albums/:id/collaborators", []routeCase{
+		{as: "owner", path: "/albums/{album}/collaborators", want: 200},
+		{as: "editor", path: "/albums/{album}/collaborators", want: 200},
+		{as: "stranger", path: "/albums/{album}/collaborators", want: 403, code: CodeForbiddenNotOwner},
+		{as: "owner", path: "/albums/9999/collaborators", want: 404, code: CodeAlbumNotFound},
+	}},
+	{"PUT /albums/:id/collaborators", []routeCase{
+		{as: "owner", path: "/albums/{album}/collaborators", body: CollaboratorRequest{Email: "stranger@example.com", Role: RoleViewer}, want: 200},
+		{as: "editor", path: "/albums/{album}/collaborators", body: CollaboratorRequest{Email: "stranger@example.com", Role: RoleViewer}, want: 403, code: CodeForbiddenNotOwner},
+		{as: "owner", path: "/albums/9999/collaborators", body: CollaboratorRequest{Email: "stranger@example.com", Role: RoleViewer}, want: 404, code: CodeAlbumNotFound},
+		{as: "owner", path: "/albums/{album}/collaborators", body: CollaboratorRequest{Email: "stranger@example.com", Role: "boss"}, want: 400, code: CodeValidationFailed},
+	}},
+	{"DELETE /albums/:id/collaborators/:user_id", []routeCase{
+		{as: "owner", path: "/albums/{album}/collaborators/{editor}", want: 200},
+		{as: "editor", path: "/albums/{album}/collaborators/{editor}", want: 200},
+		{as: "stranger", path: "/albums/{album}/collaborators/{editor}", want: 403, code: CodeForbiddenNotOwner},
+		{as: "owner", path: "/albums/{album}/collaborators/9999", want: 404, code: CodeCollaboratorNotFound},
+		{as: "owner", path: "/albums/{album}/collaborators/me", want: 400, code: CodeValidationFailed},
+	}},
+
+	// Transfers and claims
+	{"GET /transfers", []routeCase{{as: "stranger", path: "/transfers", want: 200}}},
+	{"POST /transfers/:id/accept", []routeCase{
+		{as: "stranger", path: "/transfers/{transfer}/accept", want: 200},
+		{as: "editor", path: "/transfers/{transfer}/accept", want: 404, code: CodeTransferNotFound},
+		{as: "stranger", path: "/transfers/9999/accept", want: 404, code: CodeTransferNotFound},
+	}},
+	{"POST /transfers/:id/reject", []routeCase{
+		{as: "stranger", path: "/transfers/{transfer}/reject", want: 200},
+		{as: "owner", path: "/transfers/{transfer}/reject", want: 404, code: CodeTransferNotFound},
+	}},
+	{"POST /transfers/:id/cancel", []routeCase{
+		{as: "owner", path: "/transfers/{transfer}/cancel", want: 200},
+		{as: "stranger", path: "/transfers/{transfer}/cancel", want: 404, code: CodeTransferNotFound},
+	}},
+	{"GET /claims", []routeCase{{as: "editor", path: "/claims", want: 200}}},
+
+	// The caller's account
+	{"GET /me", []routeCase{{as: "owner", path: "/me", want: 200}}},
+	{"PATCH /me", []routeCase{
+		{as: "owner", path: "/me", body: UpdateProfileRequest{FirstName: strPtr("Ada")}, want: 200},
+		{as: "owner", path: "/me", body: UpdateProfileRequest{FirstName: strPtr(" ")}, want: 400, code: CodeValidationFailed},
+	}},
+	{"DELETE /me", []routeCase{
+		{as: "editor", path: "/me", body: DeleteAccountRequest{Password: testPassword}, want: 200},
+		{as: "owner", path: "/me", body: DeleteAccountRequest{Password: testPassword}, want: 409, code: CodeLastOwner},
+		{as: "owner", path: "/me", body: DeleteAccountRequest{Password: "wrong"}, want: 403, code: CodeWrongPassword},
+		{as: "owner", path: "/me", body: DeleteAccountRequest{}, want: 400, code: CodeValidationFailed},
+	}},
+	{"POST /me/email", []routeCase{
+		{as: "owner", path: "/me/email", body: ChangeEmailRequest{Email: "new@example.com", Password: testPassword}, want: 202},
+		{as: "owner", path: "/me/email", body: ChangeEmailRequest{Email: "editor@example.com", Password: testPassword}, want: 409, code: CodeDuplicateResource},
+		{as: "owner", path: "/me/email", body: ChangeEmailRequest{Email: "new@example.com", Password: "wrong"}, want: 403, code: CodeWrongPassword},
+	}},
+	{"POST /me/password", []routeCase{
+		{as: "owner", path: "/me/password", body: ChangePasswordRequest{CurrentPassword: testPassword, NewPassword: "a-new-password"}, want: 200},
+		{as: "owner", path: "/me/password", body: ChangePasswordRequest{CurrentPassword: "wrong", NewPassword: "a-new-password"}, want: 403, code: CodeWrongPassword},
+		{as: "owner", path: "/me/password", body: ChangePasswordRequest{CurrentPassword: testPassword, NewPassword: "short"}, want: 400, code: CodeValidationFailed},
+	}},
+	{"POST /keys/rotate", []routeCase{{as: "owner", path: "/keys/rotate", want: 200}}},
+	{"POST /email/verify/send", []routeCase{{as: "owner", path: "/email/verify/send", want: 202}}},
+	{"POST /2fa/enroll", []routeCase{{as: "owner", path: "/2fa/enroll", want: 200}}},
+	{"POST /2fa/confirm", []routeCase{
+		{as: "owner", path: "/2fa/confirm", body: TwoFactorCodeRequest{Code: "000000"}, want: 409, code: CodeTwoFactorNotEnabled},
+		{as: "owner", path: "/2fa/confirm", body: TwoFactorCodeRequest{}, want: 400, code: CodeValidationFailed},
+	}},
+	{"POST /2fa/disable", []routeCase{
+		{as: "owner", path: "/2fa/disable", body: TwoFactorCodeRequest{Code: "000000"}, want: 409, code: CodeTwoFactorNotEnabled},
+	}},
+	{"GET /me/exports/:id", []routeCase{
+		{as: "owner", path: "/me/exports/{export}", want: 200},
+		{as: "stranger", path: "/me/exports/{export}", want: 404, code: CodeExportNotFound},
+		{as: "owner", path: "/me/exports/latest", want: 400, code: CodeValidationFailed},
+	}},
+	{"GET /me/export", []routeCase{
+		{as: "owner", path: "/me/export", want: 200},
+		{as: "owner", path: "/me/export?async=true", want: 202},
+	}},
+	{"GET /exports/:id/download", []routeCase{
+		{path: "{download}", want: 200},
+		{path: "/exports/{export}/download", want: 400, code: CodeValidationFailed},
+		{path: "/exports/{export}/download?expires=9999999999&signature=forged", want: 403, code: CodeDownloadLinkInvalid},
+	}},
+
+	// Organizations
+	{"GET /orgs", []routeCase{{as: "editor", path: "/orgs", want: 200}}},
+	{"POST /orgs", []routeCase{
+		{as: "stranger", path: "/orgs", body: OrganizationRequest{Name: "Crate Diggers", Slug: "crate-diggers"}, want: 201},
+		{as: "stranger", path: "/orgs", body: OrganizationRequest{Name: "Vinyl Vault", Slug: "vinyl-vault"}, want: 409, code: CodeDuplicateResource},
+		{as: "stranger", path: "/orgs", body: OrganizationRequest{Name: "Nameless"}, want: 400, code: CodeValidationFailed},
+	}},
+	{"GET /orgs/:org/albums", []routeCase{
+		{as: "editor", path: "/orgs/vinyl-vault/albums", want: 200},
+		{path: "/orgs/vinyl-vault/albums", want: 401, code: CodeAPIKeyRequired},
+		{as: "stranger", path: "/orgs/vinyl-vault/albums", want: 403, code: CodeNotMember},
+		{as: "owner", path: "/orgs/nowhere/albums", want: 404, code: CodeOrganizationNotFound},
+	}},
+	{"POST /orgs/:org/albums", []routeCase{
+		{as: "editor", path: "/orgs/vinyl-vault/albums", body: gin.H{"title": "Mingus Ah Um", "artist": "Charles Mingus", "price": 19.99}, want: 201},
+		{as: "stranger", path: "/orgs/vinyl-vault/albums", body: gin.H{"title": "Mingus Ah Um", "artist": "Charles Mingus", "price": 19.99}, want: 403, code: CodeNotMember},
+		{as: "owner", path: "/orgs/nowhere/albums", body: gin.H{"title": "Lost", "artist": "Nobody", "price": 1}, want: 404, code: CodeOrganizationNotFound},
+	}},
+	{"GET /orgs/:org/albums/:id", []routeCase{
+		{as: "editor", path: "/orgs/vinyl-vault/albums/{orgAlbum}", want: 200},
+		{as: "stranger", path: "/orgs/vinyl-vault/albums/{orgAlbum}", want: 403, code: CodeNotMember},
+		{as: "owner", path: "/orgs/vinyl-vault/albums/{album}", want: 404, code: CodeAlbumNotFound},
+	}},
+	{"PUT /orgs/:org/albums/:id", []routeCase{
+		{as: "editor", path: "/orgs/vinyl-vault/albums/{orgAlbum}", body: gin.H{"price": 12}, want: 200},
+		{as: "stranger", path: "/orgs/vinyl-vault/albums/{orgAlbum}", body: gin.H{"price": 12}, want: 403, code: CodeNotMember},
+		{as: "owner", path: "/orgs/vinyl-vault/albums/9999", body: gin.H{}, want: 404, code: CodeAlbumNotFound},
+	}},
+	{"DELETE /orgs/:org/albums/:id", []routeCase{
+		{as: "owner", path: "/orgs/vinyl-vault/albums/{orgAlbum}", want: 200},
+		{as: "editor", path: "/orgs/vinyl-vault/albums/{orgAlbum}", want: 403, code: CodeForbiddenNotOwner},
+		{as: "owner", path: "/orgs/vinyl-vault/albums/9999", want: 404, code: CodeAlbumNotFound},
+	}},
+	{"GET /orgs/:org/members", []routeCase{
+		{as: "editor", path: "/orgs/vinyl-vault/members", want: 200},
+		{as: "stranger", path: "/orgs/vinyl-vault/members", want: 403, code: CodeNotMember},
+		{as: "owner", path: "/orgs/nowhere/members", want: 404, code: CodeOrganizationNotFound},
+	}},
+	{"PUT /orgs/:org/members", []routeCase{
+		{as: "owner", path: "/orgs/vinyl-vault/members", body: MemberRequest{Email: "stranger@example.com", Role: RoleViewer}, want: 200},
+		{as: "editor", path: "/orgs/vinyl-vault/members", body: MemberRequest{Email: "stranger@example.com", Role: RoleViewer}, want: 403, code: CodeOwnerRequired},
+		{as: "owner", path: "/orgs/vinyl-vault/members", body: MemberRequest{Email: "stranger@example.com", Role: "boss"}, want: 400, code: CodeValidationFailed},
+	}},
+	{"DELETE /orgs/:org/members/:user_id", []routeCase{
+		{as: "owner", path: "/orgs/vinyl-vault/members/{editor}", want: 200},
+		{as: "stranger", path: "/orgs/vinyl-vault/members/{editor}", want: 403, code: CodeNotMember},
+		{as: "owner", path: "/orgs/vinyl-vault/members/{owner}", want: 409, code: CodeLastOwner},
+	}},
+
+	// Webhooks
+	{"GET /webhooks", []routeCase{{as: "owner", path: "/webhooks", want: 200}}},
+	{"POST /webhooks", []routeCase{
+		{as: "stranger", path: "/webhooks", body: WebhookInput{URL: "https://example.com/other", Events: []string{EventAlbumCreated}}, want: 201},
+		{as: "stranger", path: "/webhooks", body: WebhookInput{URL: "https://example.com/other", Events: []string{"album.played"}}, want: 400, code: CodeValidationFailed},
+	}},
+	{"GET /webhooks/:id", []routeCase{
+		{as: "owner", path: "/webhooks/{webhook}", want: 200},
+		{as: "stranger", path: "/webhooks/{webhook}", want: 404, code: CodeWebhookNotFound},
+	}},
+	{"DELETE /webhooks/:id", []routeCase{
+		{as: "owner", path: "/webhooks/{webhook}", want: 200},
+		{as: "stranger", path: "/webhooks/{webhook}", want: 404, code: CodeWebhookNotFound},
+	}},
+	{"GET /webhooks/:id/deliveries", []routeCase{
+		{as: "owner", path: "/webhooks/{webhook}/deliveries", want: 200},
+		{as: "stranger", path: "/webhooks/{webhook}/deliveries", want: 404, code: CodeWebhookNotFound},
+	}},
+	{"POST /webhooks/:id/deliveries/:delivery_id/redeliver", []routeCase{
+		{as: "owner", path: "/webhooks/{webhook}/deliveries/{delivery}/redeliver", want: 202},
+		{as: "owner", path: "/webhooks/{webhook}/deliveries/9999/redeliver", want: 404, code: CodeDeliveryNotFound},
+		{as: "stranger", path: "/webhooks/{webhook}/deliveries/{delivery}/redeliver", want: 404, code: CodeWebhookNotFound},
+	}},
+
+	// Administration
+	{"GET /admin/claims", []routeCase{
+		{as: "admin", path: "/admin/claims", want: 200},
+		{as: "owner", path: "/admin/claims", want: 403, code: CodeAdminRequired},
+	}},
+	{"POST /admin/claims/:id/approve", []routeCase{
+		{as: "admin", path: "/admin/claims/{claim}/approve", body: ReviewClaimRequest{}, want: 200},
+		{as: "editor", path: "/admin/claims/{claim}/approve", body: ReviewClaimRequest{}, want: 403, code: CodeAdminRequired},
+		{as: "admin", path: "/admin/claims/9999/approve", body: ReviewClaimRequest{}, want: 404, code: CodeClaimNotFound},
+	}},
+	{"POST /admin/claims/:id/reject", []routeCase{
+		{as: "admin", path: "/admin/claims/{claim}/reject", body: ReviewClaimRequest{Note: "Show us the receipt"}, want: 200},
+		{as: "admin", path: "/admin/claims/9999/reject", body: ReviewClaimRequest{}, want: 404, code: CodeClaimNotFound},
+	}},
+	{"GET /admin/exports/:id", []routeCase{
+		{as: "admin", path: "/admin/exports/{export}", want: 200},
+		{as: "owner", path: "/admin/exports/{export}", want: 403, code: CodeAdminRequired},
+		{as: "admin", path: "/admin/exports/9999", want: 404, code: CodeExportNotFound},
+	}},
+	{"GET /admin/users/:id/export", []routeCase{
+		{as: "admin", path: "/admin/users/{owner}/export", want: 200},
+		{as: "owner", path: "/admin/users/{owner}/export", want: 403, code: CodeAdminRequired},
+		{as: "admin", path: "/admin/users/9999/export", want: 404, code: CodeAccountNotFound},
+	}},
+
+	// GraphQL and the change feeds
+	{"POST /graphql", []routeCase{
+		{path: "/graphql", body: GraphQLRequest{Query: "{ albums(limit: 10) { id title } }"}, want: 200},
+		{as: "owner", path: "/graphql", body: GraphQLRequest{Query: "{ me { email } }"}, want: 200},
+		{path: "/graphql", body: GraphQLRequest{Query: "{ albums { nope } }"}, want: 400},
+	}},
+	{"GET /albums/events", []routeCase{
+		{path: "/albums/events", want: 200},
+		{as: "owner", path: "/albums/events?scope=mine", want: 200},
+		{path: "/albums/events?scope=mine", want: 401, code: CodeAPIKeyRequired},
+		{path: "/albums/events?scope=theirs", want: 400, code: CodeValidationFailed},
+	}},
+	{"GET /albums/events/ws", []routeCase{
+		{path: "/albums/events/ws", want: 400},
+	}},
+}
+
+func TestRoutes(t *testing.T) {
+	setupTestDB(t)
+	registered := map[string]bool{}
+	for _, route := range setupRouter(NewServices(DB)).Routes() {
+		if !strings.HasPrefix(route.Path, "/ui") && !strings.HasPrefix(route.Path, "/mock-idp") {
+			registered[route.Method+" "+route.Path] = true
+		}
+	}
+
+	for _, route := range routeCases {
+		if !registered[route.route] {
+			t.Errorf("%s has cases but isn't a route", route.route)
+		}
+		delete(registered, route.route)
+		method, _, _ := strings.Cut(route.route, " ")
+
+		for _, tt := range route.cases {
+			t.Run(route.route+" as "+tt.as, func(t *testing.T) {
+				world := newRouteWorld(t)
+				status, code := apiErrorCode(t, world.server, world.keys[tt.as], method, world.ids.Replace(tt.path), tt.body)
+				if status != tt.want || (tt.code != "" && code != tt.code) {
+					t.Errorf("%s %s as %q = %d %s, want %d %s", method, tt.path, tt.as, status, code, tt.want, tt.code)
+				}
+			})
+		}
+	}
+	for route := range registered {
+		t.Errorf("%s has no cases", route)
+	}
+}
+
+// Every route that needs an API key turns away requests without one, or with
+// a wrong one, before looking at anything else
+func TestRoutesNeedAPIKey(t *testing.T) {
+	world := newRouteWorld(t)
+
+	public := map[string]bool{}
+	for _, route := range routeCases {
+		for _, tt := range route.cases {
+			if tt.as == "" {
+				public[route.route] = true
+			}
+		}
+	}
+
+	for _, route := range routeCases {
+		if public[route.route] {
+			continue
+		}
+		method, path, _ := strings.Cut(route.route, " ")
+		path = strings.NewReplacer(":id", "1", ":user_id", "1", ":delivery_id", "1", ":org", "vinyl-vault").Replace(path)
+
+		for _, tt := range []struct {
+			as   string
+			code ErrorCode
+		}{{"", CodeAPIKeyRequired}, {"bad", CodeAPIKeyInvalid}} {
+			status, code := apiErrorCode(t, world.server, world.keys[tt.as], method, path, nil)
+			if status != http.StatusUnauthorized || code != tt.code {
+				t.Errorf("%s %s as %q = %d %s, want 401 %s", method, path, tt.as, status, code, tt.code)
+			}
+		}
+	}
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"embed"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"math/rand"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/goccy/go-yaml"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// `seed` fills the database with users and albums from the fixture files in
+// fixtures/<environment>/, YAML or JSON. Users are matched by email and
+// albums in the shared catalogue by title and artist, so seeding again
+// updates what is there rather than adding it twice.
+//
+//go:embed fixtures
+var fixtureFiles embed.FS
+
+// Fixtures is what a fixture file holds
+type Fixtures struct {
+	Users  []UserFixture  `json:"users"`
+	Albums []AlbumFixture `json:"albums"`
+}
+
+// UserFixture is a user to seed. The password is only set when the account
+// is created; without one it is random, and the user resets it to log in.
+type UserFixture struct {
+	Email     string `json:"email"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Password  string `json:"password"`
+	IsAdmin   bool   `json:"is_admin"`
+}
+
+// AlbumFixture is an album to seed, owned by the user with the email in
+// Owner, or by nobody
+type AlbumFixture struct {
+	Title  string  `json:"title"`
+	Artist string  `json:"artist"`
+	Price  float64 `json:"price"`
+	Owner  string  `json:"owner"`
+}
+
+// SeedResult counts what seeding created and updated
+type SeedResult struct {
+	UsersCreated, UsersUpdated   int
+	AlbumsCreated, AlbumsUpdated int
+}
+
+// LoadFixtures reads every .yaml, .yml and .json file in fsys's directory
+// for env, in name order
+func LoadFixtures(fsys fs.FS, env string) (Fixtures, error) {
+	entries, err := fs.ReadDir(fsys, env)
+	if errors.Is(err, fs.ErrNotExist) {
+		return Fixtures{}, fmt.Errorf("no fixtures for environment %q", env)
+	}
+	if err != nil {
+		return Fixtures{}, err
+	}
+
+	var all Fixtures
+	for _, entry := range entries {
+		switch path.Ext(entry.Name()) {
+		case ".yaml", ".yml", ".json":
+		default:
+			continue
+		}
+		data, err := fs.ReadFile(fsys, path.Join(env, entry.Name()))
+		if err != nil {
+			return Fixtures{}, err
+		}
+		var fixtures Fixtures
+		if err := yaml.UnmarshalWithOptions(data, &fixtures, yaml.DisallowUnknownField()); err != nil {
+			return Fixtures{}, fmt.Errorf("%s/%s: %w", env, entry.Name(), err)
+		}
+		all.Users = append(all.Users, fixtures.Users...)
+		all.Albums = append(all.Albums, fixtures.Albums...)
+	}
+	return all, nil
+}
+
+// SeedFixtures creates or updates the fixtures' users, then their albums, in
+// one transaction
+func SeedFixtures(db *gorm.DB, fixtures Fixtures) (SeedResult, error) {
+	var result SeedResult
+	err := db.Transaction(func(tx *gorm.DB) error {
+		for _, fixture := range fixtures.Users {
+			created, err := seedUser(tx, fixture)
+			if err != nil {
+				return fmt.Errorf("user %s: %w", fixture.Email, err)
+			}
+			if created {
+				result.UsersCreated++
+			} else {
+				result.UsersUpdated++
+			}
+		}
+		for _, fixture := range fixtures.Albums {
+			created, err := seedAlbum(tx, fixture)
+			if err != nil {
+				return fmt.Errorf("album %q by %s: %w", fixture.Title, fixture.Artist, err)
+			}
+			if created {
+				result.AlbumsCreated++
+			} else {
+				result.AlbumsUpdated++
+			}
+		}
+		return nil
+	})
+	return result, err
+}
+
+func seedUser(tx *gorm.DB, fixture UserFixture) (bool, error) {
+	email := strings.ToLower(strings.TrimSpace(fixture.Email))
+	if email == "" {
+		return false, errors.New("email is required")
+	}
+
+	var user User
+	err := tx.Where("LOWER(email) = ?", email).First(&user).Error
+	if err == nil {
+		changes := map[string]interface{}{"first_name": fixture.FirstName, "last_name": fixture.LastName, "is_admin": fixture.IsAdmin}
+		return false, tx.Model(&user).Updates(changes).Error
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, err
+	}
+
+	password := fixture.Password
+	if password == "" {
+		password = randomToken()
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return false, err
+	}
+	now := time.Now()
+	user = User{
+		FirstName:       fixture.FirstName,
+		LastName:        fixture.LastName,
+		Email:           email,
+		Password:        string(hash),
+		APIKey:          uuid.NewString(),
+		IsAdmin:         fixture.IsAdmin,
+		EmailVerifiedAt: &now,
+	}
+	return true, tx.Create(&user).Error
+}
+
+func seedAlbum(tx *gorm.DB, fixture AlbumFixture) (bool, error) {
+	if fixture.Title == "" || fixture.Artist == "" {
+		return false, errors.New("title and artist are required")
+	}
+	var ownerID *uint
+	if fixture.Owner != "" {
+		var owner User
+		if err := tx.Where("LOWER(email) = ?", strings.ToLower(fixture.Owner)).First(&owner).Error; err != nil {
+			return false, fmt.Errorf("owner %s: %w", fixture.Owner, err)
+		}
+		ownerID = &owner.ID
+	}
+
+	var album Album
+	err := tx.Scopes(Tenant{}.Albums).Where("title = ? AND artist = ?", fixture.Title, fixture.Artist).First(&album).Error
+	if err == nil {
+		return false, tx.Model(&album).Updates(map[string]interface{}{"price": fixture.Price, "user_id": ownerID}).Error
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, err
+	}
+	album = Album{Title: fixture.Title, Artist: fixture.Artist, Price: fixture.Price, UserID: ownerID}
+	return true, tx.Create(&album).Error
+}
+
+// Words fake albums are made of
+var (
+	fakeAdjectives = []string{"Blue", "Midnight", "Electric", "Silent", "Golden", "Broken", "Velvet", "Distant", "Neon", "Wild"}
+	fakeNouns      = []string{"Train", "Horizon", "Echoes", "Garden", "Machine", "River", "Skyline", "Dreams", "Fire", "Harbor"}
+	fakeFirstNames = []string{"Ada", "Miles", "Nina", "Chet", "Ella", "Thelonious", "Billie", "Dexter", "Sonny", "Alice"}
+	fakeLastNames  = []string{"Coltrane", "Mingus", "Simone", "Baker", "Fitzgerald", "Monk", "Holiday", "Gordon", "Rollins", "Shorter"}
+)
+
+// fakeAlbums makes n albums for load testing. They are the same every time,
+// numbered in the title, so asking for more later only adds the new ones.
+func fakeAlbums(n int) []Album {
+	rng := rand.New(rand.NewSource(1))
+	pick := func(words []string) string { return words[rng.Intn(len(words))] }
+
+	albums := make([]Album, n)
+	for i := range albums {
+		albums[i] = Album{
+			Title:  fmt.Sprintf("%s %s No. %d", pick(fakeAdjectives), pick(fakeNouns), i+1),
+			Artist: pick(fakeFirstNames) + " " + pick(fakeLastNames),
+			Price:  float64(rng.Intn(9000)+500) / 100,
+		}
+	}
+	return albums
+}
+
+// SeedFakeAlbums adds the first n fake albums to the shared catalogue, without
+// an owner, skipping those already there. It returns how many it added.
+func SeedFakeAlbums(db *gorm.DB, n int) (int, error) {
+	albums := fakeAlbums(n)
+	created := 0
+	err := db.Transaction(func(tx *gorm.DB) error {
+		for start := 0; start < len(albums); start += 1000 {
+			batch := albums[start:min(start+1000, len(albums))]
+			titles := make([]string, len(batch))
+			for i, album := range batch {
+				titles[i] = album.Title
+			}
+			var existing []string
+			if err := tx.Model(&Album{}).Scopes(Tenant{}.Albums).Where("title IN ?", titles).Pluck("title", &existing).Error; err != nil {
+				return err
+			}
+			seeded := map[string]bool{}
+			for _, title := range existing {
+				seeded[title] = true
+			}
+
+			var missing []Album
+			for _, album := range batch {
+				if !seeded[album.Title] {
+					missing = append(missing, album)
+				}
+			}
+			if len(missing) == 0 {
+				continue
+			}
+			if err := tx.CreateInBatches(missing, 500).Error; err != nil {
+				return err
+			}
+			created += len(missing)
+		}
+		return nil
+	})
+	return created, err
+}
+
+// runSeedCommand runs `seed [-env NAME] [-dir DIR] [-fake N]`. The
+// environment is SEED_ENV, or development, unless -env says; -dir reads the
+// fixtures from disk instead of those compiled in.
+func runSeedCommand(args []string) error {
+	flags := flag.NewFlagSet("seed", flag.ContinueOnError)
+	env := flags.String("env", os.Getenv("SEED_ENV"), "fixture set to load (default development)")
+	dir := flags.String("dir", "", "directory of fixture sets to use instead of the built-in ones")
+	fake := flags.Int("fake", 0, "also add this many fake albums")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() > 0 || *fake < 0 {
+		return errors.New("usage: seed [-env NAME] [-dir DIR] [-fake N]")
+	}
+	if *env == "" {
+		*env = "development"
+	}
+
+	fsys, err := fs.Sub(fixtureFiles, "fixtures")
+	if err != nil {
+		return err
+	}
+	if *dir != "" {
+		fsys = os.DirFS(*dir)
+	}
+	fixtures, err := LoadFixtures(fsys, *env)
+	if err != nil {
+		return err
+	}
+
+	connectDB()
+	if err := migrator.OnStart(DB); err != nil {
+		return err
+	}
+	result, err := SeedFixtures(DB, fixtures)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Seeded %s: %d users created, %d updated; %d albums created, %d updated\n",
+		*env, result.UsersCreated, result.UsersUpdated, result.AlbumsCreated, result.AlbumsUpdated)
+
+	if *fake > 0 {
+		created, err := SeedFakeAlbums(DB, *fake)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Added %d fake albums (%d were already there)\n", created, *fake-created)
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"io/fs"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestLoadFixtures(t *testing.T) {
+	files := fstest.MapFS{
+		"development/users.yaml":  {Data: []byte("users:\n  - email: ada@example.com\n    is_admin: true\n")},
+		"development/albums.json": {Data: []byte(`{"albums": [{"title": "Jeru", "artist": "Gerry Mulligan", "price": 17.99}]}`)},
+		"development/README.md":   {Data: []byte("not a fixture")},
+		"staging/albums.yaml":     {Data: []byte("albums:\n  - title: Jeru\n    artst: Gerry Mulligan\n")},
+	}
+
+	fixtures, err := LoadFixtures(files, "development")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fixtures.Users) != 1 || !fixtures.Users[0].IsAdmin || len(fixtures.Albums) != 1 || fixtures.Albums[0].Price != 17.99 {
+		t.Errorf("fixtures = %+v, want the admin and Jeru", fixtures)
+	}
+
+	errs := []struct {
+		env  string
+		want string
+	}{
+		{"staging", "artst"},
+		{"production", "no fixtures"},
+	}
+	for _, tt := range errs {
+		if _, err := LoadFixtures(files, tt.env); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("LoadFixtures(%s) = %v, want an error about %q", tt.env, err, tt.want)
+		}
+	}
+
+	// The ones compiled in load
+	builtIn, _ := fs.Sub(fixtureFiles, "fixtures")
+	for _, env := range []string{"development", "demo"} {
+		if _, err := LoadFixtures(builtIn, env); err != nil {
+			t.Errorf("built-in %s fixtures: %v", env, err)
+		}
+	}
+}
+
+func TestSeedFixtures(t *testing.T) {
+	setupTestDB(t)
+	existing := createTestUser(t, "bob@example.com")
+
+	fixtures := Fixtures{
+		Users: []UserFixture{
+			{Email: "Ada@example.com", FirstName: "Ada", IsAdmin: true},
+			{Email: "bob@example.com", FirstName: "Bob"},
+		},
+		Albums: []AlbumFixture{
+			{Title: "Jeru", Artist: "Gerry Mulligan", Price: 17.99, Owner: "ada@example.com"},
+			{Title: "Kind of Blue", Artist: "Miles Davis", Price: 24.99},
+		},
+	}
+	want := []SeedResult{
+		{UsersCreated: 1, UsersUpdated: 1, AlbumsCreated: 2},
+		{UsersUpdated: 2, AlbumsUpdated: 2},
+	}
+	for i, want := range want {
+		result, err := SeedFixtures(DB, fixtures)
+		if err != nil || result != want {
+			t.Errorf("seeding #%d = %+v, %v; want %+v", i+1, result, err, want)
+		}
+	}
+
+	var ada User
+	DB.Where("email = ?", "ada@example.com").First(&ada)
+	if !ada.IsAdmin || ada.EmailVerifiedAt == nil || ada.APIKey == "" {
+		t.Errorf("ada = %+v, want a verified administrator", ada)
+	}
+	var bob User
+	DB.First(&bob, existing.ID)
+	if bob.FirstName != "Bob" || bob.APIKey != existing.APIKey {
+		t.Errorf("bob = %+v, want his name updated and his API key kept", bob)
+	}
+	var albums []Album
+	DB.Order("id").Find(&albums)
+	if len(albums) != 2 || albums[0].UserID == nil || *albums[0].UserID != ada.ID || albums[1].UserID != nil {
+		t.Errorf("albums = %+v, want Jeru owned by ada and Kind of Blue by nobody", albums)
+	}
+
+	// One bad fixture and nothing is seeded
+	_, err := SeedFixtures(DB, Fixtures{
+		Albums: []AlbumFixture{{Title: "Time Out", Artist: "Dave Brubeck"}, {Title: "Ah Um", Artist: "Charles Mingus", Owner: "nobody@example.com"}},
+	})
+	var count int64
+	DB.Model(&Album{}).Count(&count)
+	if err == nil || count != 2 {
+		t.Errorf("seeding with an unknown owner = %v with %d albums, want an error and 2", err, count)
+	}
+}
+
+func TestSeedFakeAlbums(t *testing.T) {
+	setupTestDB(t)
+
+	steps := []struct{ n, wantCreated, wantTotal int }{
+		{5, 5, 5},
+		{5, 0, 5},
+		{8, 3, 8},
+	}
+	for _, tt := range steps {
+		created, err := SeedFakeAlbums(DB, tt.n)
+		var total int64
+		DB.Model(&Album{}).Count(&total)
+		if err != nil || created != tt.wantCreated || int(total) != tt.wantTotal {
+			t.Errorf("SeedFakeAlbums(%d) = %d, %v with %d albums; want %d with %d", tt.n, created, err, total, tt.wantCreated, tt.wantTotal)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"errors"
+
+	"example.com/albums/catalog"
+)
+
+// The album rules shared by the REST handlers, GraphQL resolvers, gRPC
+// server and web UI, so every API answers the same way. Like authenticate,
+// they return gorm errors (plus ErrNotOwner, ErrAlbumReadOnly,
+// ErrEmailNotVerified and *catalog.ValidationError) and leave the mapping to
+// error codes to the caller. They only see the albums in the tenant's
+// catalogue, and reach them through the repositories in repository.go
+// rather than DB.
+
+// ErrNotOwner means the album belongs to another user
+var ErrNotOwner = errors.New("album belongs to another user")
+
+// ErrAlbumReadOnly means the user may read the album but not change it
+var ErrAlbumReadOnly = errors.New("album is read-only for this user")
+
+// ErrEmailNotVerified means the user must confirm their email address first
+var ErrEmailNotVerified = errors.New("email address is not verified")
+
+// RequireVerifiedEmail stops users who haven't confirmed their email address
+// from creating albums (REQUIRE_VERIFIED_EMAIL=true)
+var RequireVerifiedEmail bool
+
+// AlbumInput is the writable part of an album. Fields left nil keep their
+// current value on update and are empty on create.
+type AlbumInput struct {
+	Title  *string  `json:"title" xml:"title"`
+	Artist *string  `json:"artist" xml:"artist"`
+	Price  *float64 `json:"price" xml:"price"`
+}
+
+// applyTo sets the fields in input on album and checks the result with the
+// rules every album service shares, whatever format the body came in
+func (in AlbumInput) applyTo(album *Album) error {
+	if in.Title != nil {
+		album.Title = *in.Title
+	}
+	if in.Artist != nil {
+		album.Artist = *in.Artist
+	}
+	if in.Price != nil {
+		album.Price = *in.Price
+	}
+	return catalog.Fields{Title: album.Title, Artist: album.Artist, Price: album.Price}.Validate()
+}
+
+// AlbumService holds the album rules shared by the REST handlers, GraphQL
+// resolvers, gRPC server and web UI
+type AlbumService struct {
+	albums AlbumRepository
+	users  UserRepository
+}
+
+// NewAlbumService applies the rules to the albums and users in the repositories
+func NewAlbumService(albums AlbumRepository, users UserRepository) *AlbumService {
+	return &AlbumService{albums: albums, users: users}
+}
+
+// List returns one page of the tenant's albums with their owners, only
+// ownerID's albums when it is set
+func (s *AlbumService) List(tenant Tenant, ownerID *uint, page Page) ([]Album, error) {
+	albums, err := s.albums.List(tenant, ownerID, page)
+	if err != nil {
+		return nil, err
+	}
+	var viewerID uint // nobody, unless these are the owner's own albums
+	if ownerID != nil {
+		viewerID = *ownerID
+	}
+	for i := range albums {
+		hideOwnerEmail(&albums[i], viewerID)
+	}
+	return albums, nil
+}
+
+// Role returns what userID may do with album: RoleOwner for its owner,
+// the role of a collaborator or of a member of the organization it belongs
+// to, or "" when they may not even see it. An album without an owner is
+// read-only, except for administrators, until a claim on it is approved.
+func (s *AlbumService) Role(userID uint, album Album) (string, error) {
+	if album.OrganizationID != nil {
+		return s.albums.MemberRole(*album.OrganizationID, userID)
+	}
+	if album.UserID == nil {
+		user, err := s.users.Get(userID)
+		if err != nil {
+			return "", err
+		}
+		if user.IsAdmin {
+			return RoleOwner, nil
+		}
+		return RoleViewer, nil
+	}
+	if *album.UserID == userID {
+		return RoleOwner, nil
+	}
+	return s.albums.CollaboratorRole(album.ID, userID)
+}
+
+// Get loads an album userID owns or collaborates on, one of their
+// organization's, or one without an owner
+func (s *AlbumService) Get(tenant Tenant, userID, albumID uint) (Album, error) {
+	album, _, err := s.getAs(tenant, userID, albumID)
+	return album, err
+}
+
+// getAs loads one of the tenant's albums with its owner and userID's role on it
+func (s *AlbumService) getAs(tenant Tenant, userID, albumID uint) (Album, string, error) {
+	album, err := s.albums.Get(tenant, albumID)
+	if err != nil {
+		return Album{}, "", err
+	}
+	role, err := s.Role(userID, album)
+	if err != nil {
+		return Album{}, "", err
+	}
+	if role == "" {
+		return Album{}, "", ErrNotOwner
+	}
+	hideOwnerEmail(&album, userID)
+	return album, role, nil
+}
+
+// hideOwnerEmail blanks the owner's email address for everyone but the
+// owner: collaborators and the public catalogue see their name only
+func hideOwnerEmail(album *Album, viewerID uint) {
+	if album.User != nil && album.User.ID != viewerID {
+		album.User.Email = ""
+	}
+}
+
+// Create saves a new album owned by userID, or in an organization's
+// catalogue by one of its owners or editors
+func (s *AlbumService) Create(tenant Tenant, userID uint, input AlbumInput) (Album, error) {
+	album := Album{UserID: &userID}
+	if tenant.Organization != nil {
+		switch tenant.Role {
+		case RoleOwner, RoleEditor:
+		case RoleViewer:
+			return Album{}, ErrOrganizationReadOnly
+		default:
+			return Album{}, ErrNotMember
+		}
+		album = Album{OrganizationID: &tenant.Organization.ID}
+	}
+
+	if RequireVerifiedEmail {
+		user, err := s.users.Get(userID)
+		if err != nil {
+			return Album{}, err
+		}
+		if user.EmailVerifiedAt == nil {
+			return Album{}, ErrEmailNotVerified
+		}
+	}
+
+	if err := input.applyTo(&album); err != nil {
+		return Album{}, err
+	}
+	if err := s.albums.Create(&album); err != nil {
+		return Album{}, err
+	}
+	return album, nil
+}
+
+// Update changes the fields set in input, for the owner or an editor.
+// Nobody becomes the owner this way; see ClaimAlbum.
+func (s *AlbumService) Update(tenant Tenant, userID, albumID uint, input AlbumInput) (Album, error) {
+	album, role, err := s.getAs(tenant, userID, albumID)
+	if err != nil {
+		return Album{}, err
+	}
+	if role == RoleViewer {
+		return Album{}, ErrAlbumReadOnly
+	}
+
+	if err := input.applyTo(&album); err != nil {
+		return Album{}, err
+	}
+	if err := s.albums.Update(&album); err != nil {
+		return Album{}, err
+	}
+	hideOwnerEmail(&album, userID)
+	return album, nil
+}
+
+// Delete deletes an album userID owns (an administrator, one without an
+// owner; an owner of the organization, one of its albums). Collaborators
+// can't delete it; its sharing and claims go with it.
+func (s *AlbumService) Delete(tenant Tenant, userID, albumID uint) error {
+	album, role, err := s.getAs(tenant, userID, albumID)
+	if err != nil {
+		return err
+	}
+	if role != RoleOwner {
+		return ErrNotOwner
+	}
+	return s.albums.Delete(album)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"example.com/albums/catalog"
+	"gorm.io/gorm"
+)
+
+// The album rules on the in-memory repositories, without a database
+func TestAlbumServiceInMemory(t *testing.T) {
+	users := NewMemoryUserRepository()
+	owner := users.Add(User{Email: "owner@example.com", APIKey: "owner-key"})
+	editor := users.Add(User{Email: "editor@example.com", APIKey: "editor-key"})
+	stranger := users.Add(User{Email: "stranger@example.com", APIKey: "stranger-key"})
+	admin := users.Add(User{Email: "admin@example.com", APIKey: "admin-key", IsAdmin: true})
+	albums := NewMemoryAlbumRepository(users)
+	service := NewAlbumService(albums, users)
+
+	album, err := service.Create(Tenant{}, owner.ID, AlbumInput{Title: strPtr("Blue Train"), Artist: strPtr("John Coltrane"), Price: floatPtr(56.99)})
+	if err != nil || album.ID == 0 || album.User == nil || album.User.ID != owner.ID {
+		t.Fatalf("Create = %+v, %v; want an album owned by owner", album, err)
+	}
+	albums.AddCollaborator(album.ID, editor.ID, RoleEditor)
+	ownerless := Album{Title: "Unclaimed", Artist: "Unknown"}
+	albums.Create(&ownerless)
+
+	renamed := "Giant Steps"
+	tests := []struct {
+		name    string
+		do      func() error
+		wantErr error
+	}{
+		{"owner reads", func() error { _, err := service.Get(Tenant{}, owner.ID, album.ID); return err }, nil},
+		{"stranger can't read", func() error { _, err := service.Get(Tenant{}, stranger.ID, album.ID); return err }, ErrNotOwner},
+		{"missing album", func() error { _, err := service.Get(Tenant{}, owner.ID, 999); return err }, gorm.ErrRecordNotFound},
+		{"editor updates", func() error {
+			_, err := service.Update(Tenant{}, editor.ID, album.ID, AlbumInput{Title: &renamed})
+			return err
+		}, nil},
+		{"editor can't delete", func() error { return service.Delete(Tenant{}, editor.ID, album.ID) }, ErrNotOwner},
+		{"ownerless is read-only", func() error {
+			_, err := service.Update(Tenant{}, stranger.ID, ownerless.ID, AlbumInput{Title: &renamed})
+			return err
+		}, ErrAlbumReadOnly},
+		{"admin deletes ownerless", func() error { return service.Delete(Tenant{}, admin.ID, ownerless.ID) }, nil},
+		{"in another catalogue", func() error {
+			_, err := service.Get(Tenant{Organization: &Organization{ID: 1}}, owner.ID, album.ID)
+			return err
+		}, gorm.ErrRecordNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.do(); !errors.Is(err, tt.wantErr) {
+				t.Errorf("err = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	got, err := service.Get(Tenant{}, editor.ID, album.ID)
+	if err != nil || got.Title != renamed || got.User.Email != "" {
+		t.Errorf("editor sees %+v, %v; want the new title and no owner email", got, err)
+	}
+	mine, err := service.List(Tenant{}, &owner.ID, Page{})
+	if err != nil || len(mine) != 1 || mine[0].User.Email != owner.Email {
+		t.Errorf("owner's albums = %+v, %v; want Giant Steps with their email", mine, err)
+	}
+	all, err := service.List(Tenant{}, nil, Page{})
+	if err != nil || len(all) != 1 || all[0].User.Email != "" {
+		t.Errorf("all albums = %+v, %v; want Giant Steps without the owner's email", all, err)
+	}
+}
+
+// Fields are checked whichever API the album came through: XML and
+// MessagePack bodies carry NaN and infinite prices the OpenAPI schema never
+// sees
+func TestAlbumServiceRejectsBadFields(t *testing.T) {
+	users := NewMemoryUserRepository()
+	owner := users.Add(User{Email: "owner@example.com", APIKey: "owner-key"})
+	service := NewAlbumService(NewMemoryAlbumRepository(users), users)
+	album, err := service.Create(Tenant{}, owner.ID, AlbumInput{Title: strPtr("Jeru"), Artist: strPtr("Gerry Mulligan"), Price: floatPtr(17.99)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		input     AlbumInput
+		wantField string
+	}{
+		{"NaN price", AlbumInput{Price: floatPtr(math.NaN())}, "price"},
+		{"infinite price", AlbumInput{Price: floatPtr(math.Inf(1))}, "price"},
+		{"negative price", AlbumInput{Price: floatPtr(-1)}, "price"},
+		{"empty title", AlbumInput{Title: strPtr("")}, "title"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var invalid *catalog.ValidationError
+			if _, err := service.Update(Tenant{}, owner.ID, album.ID, tt.input); !errors.As(err, &invalid) || invalid.Issues[0].Field != tt.wantField {
+				t.Errorf("Update = %v, want %s refused", err, tt.wantField)
+			}
+
+			create := AlbumInput{Title: strPtr("Jeru"), Artist: strPtr("Gerry Mulligan"), Price: floatPtr(17.99)}
+			if tt.input.Title != nil {
+				create.Title = tt.input.Title
+			}
+			if tt.input.Price != nil {
+				create.Price = tt.input.Price
+			}
+			if _, err := service.Create(Tenant{}, owner.ID, create); !errors.As(err, &invalid) {
+				t.Errorf("Create = %v, want %s refused", err, tt.wantField)
+			}
+		})
+	}
+
+	if got, _ := service.Get(Tenant{}, owner.ID, album.ID); got.Price != 17.99 || got.Title != "Jeru" {
+		t.Errorf("album = %+v, want it unchanged", got)
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	sessionCookie = "albums_session"
+	sessionMaxAge = 7 * 24 * time.Hour
+
+	// secondFactorTimeout is how long a single sign-on waits for the code
+	secondFactorTimeout = 5 * time.Minute
+)
+
+// Session is the web UI state kept in a signed cookie
+type Session struct {
+	UserID       uint          `json:"uid,omitempty"`
+	LoggedInAt   int64         `json:"iat,omitempty"` // Unix milliseconds, checked against User.SessionsRevokedAt
+	CSRF         string        `json:"csrf"`
+	Flashes      []Flash       `json:"flashes,omitempty"`
+	SSO          *SSOLogin     `json:"sso,omitempty"`
+	SecondFactor *PendingLogin `json:"2fa,omitempty"`
+	Expires      int64         `json:"exp"`
+}
+
+// SSOLogin is a single sign-on started at /ui/login/sso and waiting for the
+// callback. The cookie is signed, not encrypted, but the verifier only
+// proves the code to the provider, which the browser holding it may know.
+type SSOLogin struct {
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+}
+
+// PendingLogin is a single sign-on into an account with 2FA on, waiting at
+// /ui/login/2fa for the code until Expires (Unix seconds)
+type PendingLogin struct {
+	UserID  uint  `json:"uid"`
+	Expires int64 `json:"exp"`
+}
+
+// Flash is a one-time message shown on the next rendered page
+type Flash struct {
+	Kind    string `json:"kind"` // "success" or "error"
+	Message string `json:"message"`
+}
+
+// sessionKey signs session cookies. Set SESSION_SECRET so sessions survive
+// restarts and work across instances.
+var sessionKey []byte
+
+func loadSessionKey() {
+	if secret := os.Getenv("SESSION_SECRET"); secret != "" {
+		sessionKey = []byte(secret)
+		return
+	}
+
+	log.Println("Warning: SESSION_SECRET not set, web sessions will not survive a restart")
+	sessionKey = make([]byte, 32)
+	rand.Read(sessionKey)
+}
+
+// WebSession loads the session cookie (or starts a new session) for UI routes
+func WebSession() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		session, ok := readSession(c)
+		if !ok {
+			session = &Session{CSRF: randomToken()}
+		}
+		c.Set("session", session)
+		c.Next()
+	}
+}
+
+// VerifyCSRF rejects form posts whose csrf_token doesn't match the session
+func VerifyCSRF() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead {
+			c.Next()
+			return
+		}
+
+		token := c.PostForm("csrf_token")
+		if token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(GetSession(c).CSRF)) != 1 {
+			c.Abort()
+			renderPage(c, http.StatusForbidden, "error.html", gin.H{
+				"Message": "Your form expired or came from another site. Go back, reload the page and try again.",
+			})
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// GetSession retrieves the session set by WebSession
+func GetSession(c *gin.Context) *Session {
+	return c.MustGet("session").(*Session)
+}
+
+// AddFlash queues a message for the next rendered page
+func (s *Session) AddFlash(kind, message string) {
+	s.Flashes = append(s.Flashes, Flash{Kind: kind, Message: message})
+}
+
+// saveSession writes the session cookie. It must run before the response
+// body, which is why renderPage and redirectTo call it.
+func saveSession(c *gin.Context, session *Session) {
+	session.Expires = time.Now().Add(sessionMaxAge).Unix()
+
+	payload, _ := json.Marshal(session)
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	value := encoded + "." + sign(encoded)
+
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(sessionCookie, value, int(sessionMaxAge.Seconds()), "/", "", c.Request.TLS != nil, true)
+}
+
+// clearSession logs the user out and starts a fresh session
+func clearSession(c *gin.Context) *Session {
+	session := &Session{CSRF: randomToken()}
+	c.Set("session", session)
+	return session
+}
+
+// readSession returns the session from a valid, unexpired cookie
+func readSession(c *gin.Context) (*Session, bool) {
+	value, err := c.Cookie(sessionCookie)
+	if err != nil {
+		return nil, false
+	}
+
+	encoded, signature, found := strings.Cut(value, ".")
+	if !found || !hmac.Equal([]byte(signature), []byte(sign(encoded))) {
+		return nil, false
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, false
+	}
+
+	var session Session
+	if err := json.Unmarshal(payload, &session); err != nil || session.CSRF == "" {
+		return nil, false
+	}
+	if time.Now().Unix() > session.Expires {
+		return nil, false
+	}
+	return &session, true
+}
+
+func sign(value string) string {
+	mac := hmac.New(sha256.New, sessionKey)
+	mac.Write([]byte(value))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func randomToken() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
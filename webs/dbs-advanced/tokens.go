@@ -0,0 +1,89 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// How long emailed tokens work
+const (
+	passwordResetTTL     = time.Hour
+	emailVerificationTTL = 24 * time.Hour
+)
+
+// issueToken creates a token for purpose and returns it in plain text, to be
+// emailed. Earlier unused tokens for the same purpose stop working, so only
+// the latest email counts.
+func issueToken(tx *gorm.DB, userID uint, purpose string, ttl time.Duration) (string, error) {
+	return newToken(tx, UserToken{UserID: userID, Purpose: purpose}, ttl)
+}
+
+// issueEmailChangeToken is issueToken for moving the account to newEmail,
+// which is kept with the token until it is used
+func issueEmailChangeToken(tx *gorm.DB, userID uint, newEmail string) (string, error) {
+	return newToken(tx, UserToken{UserID: userID, Purpose: TokenEmailChange, Email: newEmail}, emailVerificationTTL)
+}
+
+func newToken(tx *gorm.DB, row UserToken, ttl time.Duration) (string, error) {
+	now := time.Now()
+	err := tx.Model(&UserToken{}).
+		Where("user_id = ? AND purpose = ? AND used_at IS NULL", row.UserID, row.Purpose).
+		Update("used_at", now).Error
+	if err != nil {
+		return "", err
+	}
+
+	token := randomToken()
+	row.TokenHash = hashToken(token)
+	row.ExpiresAt = now.Add(ttl)
+	return token, tx.Create(&row).Error
+}
+
+// consumeToken uses up a token for purpose and returns whose it was. A
+// token that is unknown, expired or already used is gorm.ErrRecordNotFound.
+// Marking it used is a single conditional update, so two requests racing
+// with the same token can't both succeed.
+func consumeToken(tx *gorm.DB, token, purpose string) (uint, error) {
+	row, err := consumeTokenRow(tx, token, purpose)
+	return row.UserID, err
+}
+
+// consumeTokenRow is consumeToken returning the whole row, for tokens that
+// carry more than whose they are
+func consumeTokenRow(tx *gorm.DB, token, purpose string) (UserToken, error) {
+	now := time.Now()
+	hash := hashToken(token)
+
+	claim := tx.Model(&UserToken{}).
+		Where("token_hash = ? AND purpose = ? AND used_at IS NULL AND expires_at > ?", hash, purpose, now).
+		Update("used_at", now)
+	if claim.Error != nil {
+		return UserToken{}, claim.Error
+	}
+	if claim.RowsAffected == 0 {
+		return UserToken{}, gorm.ErrRecordNotFound
+	}
+
+	var userToken UserToken
+	err := tx.Where("token_hash = ?", hash).First(&userToken).Error
+	return userToken, err
+}
+
+// revokeTokens stops userID's unused tokens for purposes (all of them when
+// none are given) working, for when the address they were sent to or the
+// password they reset is no longer current
+func revokeTokens(tx *gorm.DB, userID uint, purposes ...string) error {
+	query := tx.Model(&UserToken{}).Where("user_id = ? AND used_at IS NULL", userID)
+	if len(purposes) > 0 {
+		query = query.Where("purpose IN ?", purposes)
+	}
+	return query.Update("used_at", time.Now()).Error
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
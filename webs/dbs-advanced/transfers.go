@@ -0,0 +1,278 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ErrTransferPending means the album has already been offered to someone
+var ErrTransferPending = errors.New("album already has a pending transfer")
+
+// ErrTransferNotPending means the transfer was already accepted, rejected or
+// cancelled
+var ErrTransferNotPending = errors.New("transfer is no longer pending")
+
+// TransferHandlers serve the routes handing albums from one user to another
+type TransferHandlers struct {
+	db *gorm.DB
+}
+
+// NewTransferHandlers keeps transfers in db
+func NewTransferHandlers(db *gorm.DB) *TransferHandlers {
+	return &TransferHandlers{db: db}
+}
+
+// POST /albums/:id/transfer - Protected: offer one of the caller's albums to
+// another user, who becomes its owner if they accept. They are told by email.
+func (h *TransferHandlers) postAlbumTransfer(c *gin.Context) {
+	userID, _ := GetCurrentUserID(c)
+	id, ok := albumIDParam(c)
+	if !ok {
+		return
+	}
+
+	var req TransferAlbumRequest
+	if err := BindBody(c, &req); err != nil {
+		RespondError(c, CodeValidationFailed, err.Error())
+		return
+	}
+
+	recipient, ok := findRecipient(c, h.db, "to", req.To, userID)
+	if !ok {
+		return
+	}
+
+	transfer, err := OfferAlbum(h.db, CurrentTenant(c), userID, id, recipient, strings.TrimSpace(req.Message))
+	if err != nil {
+		RespondDBError(c, err, CodeAlbumNotFound)
+		return
+	}
+
+	Respond(c, http.StatusCreated, SuccessResponse{
+		Success: true,
+		Data:    transfer,
+	})
+}
+
+// GET /transfers - Protected: transfers offered to or by the caller, newest first
+func (h *TransferHandlers) getTransfers(c *gin.Context) {
+	userID, _ := GetCurrentUserID(c)
+
+	var transfers []AlbumTransfer
+	err := h.db.Preload("Album").Where("to_user_id = ? OR from_user_id = ?", userID, userID).
+		Order("id DESC").Find(&transfers).Error
+	if err == nil {
+		err = fillTransferEmails(h.db, transfers)
+	}
+	if err != nil {
+		RespondInternalError(c, err)
+		return
+	}
+
+	Respond(c, http.StatusOK, SuccessResponse{
+		Success: true,
+		Data:    transfers,
+	})
+}
+
+// POST /transfers/:id/accept - Protected: the recipient takes the album over
+func (h *TransferHandlers) acceptTransfer(c *gin.Context) {
+	h.answerTransfer(c, TransferAccepted)
+}
+
+// POST /transfers/:id/reject - Protected: the recipient turns the album down
+func (h *TransferHandlers) rejectTransfer(c *gin.Context) {
+	h.answerTransfer(c, TransferRejected)
+}
+
+// POST /transfers/:id/cancel - Protected: the sender withdraws the offer
+func (h *TransferHandlers) cancelTransfer(c *gin.Context) {
+	h.answerTransfer(c, TransferCancelled)
+}
+
+func (h *TransferHandlers) answerTransfer(c *gin.Context, status string) {
+	userID, _ := GetCurrentUserID(c)
+	id, ok := uintParam(c, "id")
+	if !ok {
+		return
+	}
+
+	transfer, err := AnswerTransfer(h.db, userID, id, status)
+	if err != nil {
+		RespondDBError(c, err, CodeTransferNotFound)
+		return
+	}
+
+	Respond(c, http.StatusOK, SuccessResponse{
+		Success: true,
+		Data:    transfer,
+	})
+}
+
+// findRecipient looks up the account in db an album is handed to by email
+// address, answering 400 itself when there is none or it is the caller's own
+func findRecipient(c *gin.Context, db *gorm.DB, field, email string, userID uint) (User, bool) {
+	var user User
+	err := db.Where("LOWER(email) = ?", strings.ToLower(email)).First(&user).Error
+	var issue string
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		issue = "does not belong to any account"
+	case err != nil:
+		RespondInternalError(c, err)
+		return User{}, false
+	case user.ID == userID:
+		issue = "must be another account"
+	}
+	if issue != "" {
+		RespondValidationError(c, "", []ValidationIssue{{Location: "body", Field: field, Message: issue}})
+		return User{}, false
+	}
+	return user, true
+}
+
+// OfferAlbum creates a pending transfer of an album fromID owns in the
+// tenant's catalogue to recipient and emails them about it
+func OfferAlbum(db *gorm.DB, tenant Tenant, fromID, albumID uint, recipient User, message string) (AlbumTransfer, error) {
+	var transfer AlbumTransfer
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var album Album
+		if err := tx.Scopes(tenant.Albums).Preload("User").First(&album, albumID).Error; err != nil {
+			return err
+		}
+		if album.UserID == nil || *album.UserID != fromID {
+			return ErrNotOwner
+		}
+
+		var pending int64
+		if err := tx.Model(&AlbumTransfer{}).Where("album_id = ? AND status = ?", albumID, TransferPending).Count(&pending).Error; err != nil {
+			return err
+		}
+		if pending > 0 {
+			return ErrTransferPending
+		}
+
+		transfer = AlbumTransfer{AlbumID: albumID, FromUserID: fromID, ToUserID: recipient.ID, Message: message, Status: TransferPending}
+		if err := tx.Create(&transfer).Error; err != nil {
+			return err
+		}
+		return QueueEmail(tx, recipient.Email, "album_transfer", gin.H{
+			"User": recipient, "From": album.User, "Album": album, "Transfer": transfer,
+		})
+	})
+	if err != nil {
+		return AlbumTransfer{}, err
+	}
+	return loadTransfer(db, transfer.ID)
+}
+
+// AnswerTransfer moves a pending transfer to status: accepted or rejected by
+// its recipient, or cancelled by its sender. Accepting makes the recipient
+// the album's owner; its collaborators stay.
+func AnswerTransfer(db *gorm.DB, userID, transferID uint, status string) (AlbumTransfer, error) {
+	var album Album // set when the album changes hands
+	err := db.Transaction(func(tx *gorm.DB) error {
+		party := "to_user_id"
+		if status == TransferCancelled {
+			party = "from_user_id"
+		}
+		var transfer AlbumTransfer
+		if err := tx.Where(party+" = ?", userID).First(&transfer, transferID).Error; err != nil {
+			return err
+		}
+
+		answered := tx.Model(&AlbumTransfer{}).Where("id = ? AND status = ?", transfer.ID, TransferPending).
+			Updates(map[string]interface{}{"status": status, "responded_at": time.Now()})
+		if answered.Error != nil {
+			return answered.Error
+		}
+		if answered.RowsAffected == 0 {
+			return ErrTransferNotPending
+		}
+		if status != TransferAccepted {
+			return nil
+		}
+
+		// The album may have been deleted, or left its owner some other way
+		err := tx.Where("user_id = ?", transfer.FromUserID).First(&album, transfer.AlbumID).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrTransferNotPending
+		}
+		if err != nil {
+			return err
+		}
+		album.UserID = &transfer.ToUserID
+		if err := tx.Model(&album).Update("user_id", transfer.ToUserID).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("album_id = ? AND user_id = ?", album.ID, transfer.ToUserID).Delete(&AlbumCollaborator{}).Error; err != nil {
+			return err
+		}
+		return addOutboxEvent(tx, EventAlbumUpdated, album)
+	})
+	if err != nil {
+		return AlbumTransfer{}, err
+	}
+	if album.ID != 0 {
+		Events.Publish(EventAlbumUpdated, album)
+	}
+	return loadTransfer(db, transferID)
+}
+
+// cancelTransfers cancels the album's pending transfer, if it has one, when
+// it changes hands some other way
+func cancelTransfers(tx *gorm.DB, albumID uint) error {
+	return tx.Model(&AlbumTransfer{}).Where("album_id = ? AND status = ?", albumID, TransferPending).
+		Updates(map[string]interface{}{"status": TransferCancelled, "responded_at": time.Now()}).Error
+}
+
+func loadTransfer(db *gorm.DB, id uint) (AlbumTransfer, error) {
+	var transfer AlbumTransfer
+	if err := db.Preload("Album").First(&transfer, id).Error; err != nil {
+		return AlbumTransfer{}, err
+	}
+	transfers := []AlbumTransfer{transfer}
+	err := fillTransferEmails(db, transfers)
+	return transfers[0], err
+}
+
+// fillTransferEmails sets the sender's and recipient's email addresses, which
+// are shown instead of their accounts so nobody sees another user's API key
+func fillTransferEmails(db *gorm.DB, transfers []AlbumTransfer) error {
+	var ids []uint
+	for _, transfer := range transfers {
+		ids = append(ids, transfer.FromUserID, transfer.ToUserID)
+	}
+	emails, err := userEmails(db, ids)
+	if err != nil {
+		return err
+	}
+	for i := range transfers {
+		transfers[i].FromEmail = emails[transfers[i].FromUserID]
+		transfers[i].ToEmail = emails[transfers[i].ToUserID]
+	}
+	return nil
+}
+
+// userEmails maps user IDs in db to their email addresses, deleted accounts
+// included
+func userEmails(db *gorm.DB, ids []uint) (map[uint]string, error) {
+	emails := map[uint]string{}
+	if len(ids) == 0 {
+		return emails, nil
+	}
+
+	var users []User
+	if err := db.Unscoped().Select("id", "email").Where("id IN ?", ids).Find(&users).Error; err != nil {
+		return nil, err
+	}
+	for _, user := range users {
+		emails[user.ID] = user.Email
+	}
+	return emails, nil
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAlbumTransfer(t *testing.T) {
+	setupTestDB(t)
+	owner := createTestUser(t, "giver@example.com")
+	recipient := createTestUser(t, "taker@example.com")
+	album := createTestAlbum(t, "Mingus Ah Um", &owner.ID)
+	server := httptest.NewServer(setupRouter(NewServices(DB)))
+	t.Cleanup(server.Close)
+
+	path := "/albums/" + itoa(album.ID) + "/transfer"
+	refused := []struct {
+		name   string
+		apiKey string
+		req    TransferAlbumRequest
+		want   int
+	}{
+		{"not the owner", recipient.APIKey, TransferAlbumRequest{To: owner.Email}, http.StatusForbidden},
+		{"to nobody", owner.APIKey, TransferAlbumRequest{To: "nobody@example.com"}, http.StatusBadRequest},
+		{"to yourself", owner.APIKey, TransferAlbumRequest{To: owner.Email}, http.StatusBadRequest},
+	}
+	for _, tt := range refused {
+		t.Run(tt.name, func(t *testing.T) {
+			if status := apiCall(t, server, tt.apiKey, http.MethodPost, path, tt.req, nil); status != tt.want {
+				t.Errorf("POST %s = %d, want %d", path, status, tt.want)
+			}
+		})
+	}
+
+	var transfer AlbumTransfer
+	offer := TransferAlbumRequest{To: "Taker@example.com", Message: "Yours now"}
+	if status := apiCall(t, server, owner.APIKey, http.MethodPost, path, offer, &transfer); status != http.StatusCreated {
+		t.Fatalf("POST %s = %d, want 201", path, status)
+	}
+	if transfer.Status != TransferPending || transfer.ToUserID != recipient.ID || transfer.ToEmail != recipient.Email {
+		t.Errorf("transfer = %+v, want pending to %s", transfer, recipient.Email)
+	}
+	if status := apiCall(t, server, owner.APIKey, http.MethodPost, path, offer, nil); status != http.StatusConflict {
+		t.Errorf("second offer = %d, want 409", status)
+	}
+	var email QueuedEmail
+	if err := DB.Where("template = ? AND \"to\" = ?", "album_transfer", recipient.Email).First(&email).Error; err != nil {
+		t.Errorf("recipient was not emailed: %v", err)
+	}
+
+	// Offering changes nothing until the recipient accepts
+	if status := apiCall(t, server, recipient.APIKey, http.MethodGet, "/albums/"+itoa(album.ID), nil, nil); status != http.StatusForbidden {
+		t.Errorf("recipient reading the album before accepting = %d, want 403", status)
+	}
+	var incoming []AlbumTransfer
+	apiCall(t, server, recipient.APIKey, http.MethodGet, "/transfers", nil, &incoming)
+	if len(incoming) != 1 || incoming[0].Album == nil || incoming[0].Album.Title != album.Title {
+		t.Errorf("GET /transfers = %+v, want the offer with its album", incoming)
+	}
+
+	accept := "/transfers/" + itoa(transfer.ID) + "/accept"
+	if status := apiCall(t, server, owner.APIKey, http.MethodPost, accept, nil, nil); status != http.StatusNotFound {
+		t.Errorf("sender accepting = %d, want 404", status)
+	}
+	if status := apiCall(t, server, recipient.APIKey, http.MethodPost, accept, nil, &transfer); status != http.StatusOK {
+		t.Fatalf("POST %s = %d", accept, status)
+	}
+	if transfer.Status != TransferAccepted || transfer.RespondedAt == nil {
+		t.Errorf("accepted transfer = %+v", transfer)
+	}
+	if status := apiCall(t, server, recipient.APIKey, http.MethodPost, accept, nil, nil); status != http.StatusConflict {
+		t.Errorf("accepting twice = %d, want 409", status)
+	}
+
+	var moved Album
+	DB.First(&moved, album.ID)
+	if moved.UserID == nil || *moved.UserID != recipient.ID {
+		t.Errorf("album owner = %v, want %d", moved.UserID, recipient.ID)
+	}
+	if status := apiCall(t, server, owner.APIKey, http.MethodGet, "/albums/"+itoa(album.ID), nil, nil); status != http.StatusForbidden {
+		t.Errorf("old owner reading the album = %d, want 403", status)
+	}
+}
+
+func TestAlbumTransferRejectAndCancel(t *testing.T) {
+	setupTestDB(t)
+	owner := createTestUser(t, "giver@example.com")
+	recipient := createTestUser(t, "taker@example.com")
+	album := createTestAlbum(t, "Ah Um", &owner.ID)
+	server := httptest.NewServer(setupRouter(NewServices(DB)))
+	t.Cleanup(server.Close)
+
+	tests := []struct {
+		action string
+		apiKey string // who answers
+		want   string
+	}{
+		{"reject", recipient.APIKey, TransferRejected},
+		{"cancel", owner.APIKey, TransferCancelled},
+	}
+	for _, tt := range tests {
+		t.Run(tt.action, func(t *testing.T) {
+			var transfer AlbumTransfer
+			apiCall(t, server, owner.APIKey, http.MethodPost, "/albums/"+itoa(album.ID)+"/transfer", TransferAlbumRequest{To: recipient.Email}, &transfer)
+
+			path := "/transfers/" + itoa(transfer.ID) + "/" + tt.action
+			if status := apiCall(t, server, tt.apiKey, http.MethodPost, path, nil, &transfer); status != http.StatusOK {
+				t.Fatalf("POST %s = %d", path, status)
+			}
+			if transfer.Status != tt.want {
+				t.Errorf("status = %s, want %s", transfer.Status, tt.want)
+			}
+			var kept Album
+			DB.First(&kept, album.ID)
+			if kept.UserID == nil || *kept.UserID != owner.ID {
+				t.Errorf("album owner = %v, want the sender", kept.UserID)
+			}
+		})
+	}
+}
+
+func TestCollaboratorRoles(t *testing.T) {
+	setupTestDB(t)
+	owner := createTestUser(t, "owner@example.com")
+	editor := createTestUser(t, "editor@example.com")
+	viewer := createTestUser(t, "viewer@example.com")
+	stranger := createTestUser(t, "stranger@example.com")
+	album := createTestAlbum(t, "Time Out", &owner.ID)
+	server := httptest.NewServer(setupRouter(NewServices(DB)))
+	t.Cleanup(server.Close)
+
+	collaborators := "/albums/" + itoa(album.ID) + "/collaborators"
+	for _, req := range []CollaboratorRequest{{editor.Email, RoleViewer}, {editor.Email, RoleEditor}, {viewer.Email, RoleViewer}} {
+		if status := apiCall(t, server, owner.APIKey, http.MethodPut, collaborators, req, nil); status != http.StatusOK {
+			t.Fatalf("PUT %s %+v = %d", collaborators, req, status)
+		}
+	}
+	if status := apiCall(t, server, editor.APIKey, http.MethodPut, collaborators, CollaboratorRequest{stranger.Email, RoleEditor}, nil); status != http.StatusForbidden {
+		t.Errorf("editor sharing the album = %d, want 403", status)
+	}
+	// Only the owner sees the collaborators' email addresses
+	for _, tt := range []struct {
+		name       string
+		apiKey     string
+		wantEmails []string
+	}{
+		{"owner", owner.APIKey, []string{editor.Email, viewer.Email}},
+		{"viewer", viewer.APIKey, []string{"", ""}},
+	} {
+		var list []AlbumCollaborator
+		apiCall(t, server, tt.apiKey, http.MethodGet, collaborators, nil, &list)
+		if len(list) != 2 || list[0].UserID != editor.ID || list[0].Role != RoleEditor || list[1].UserID != viewer.ID {
+			t.Errorf("collaborators for the %s = %+v, want the editor then the viewer", tt.name, list)
+			continue
+		}
+		if list[0].Email != tt.wantEmails[0] || list[1].Email != tt.wantEmails[1] {
+			t.Errorf("emails for the %s = %q, %q; want %q", tt.name, list[0].Email, list[1].Email, tt.wantEmails)
+		}
+	}
+
+	path := "/albums/" + itoa(album.ID)
+	title := "Time Further Out"
+	update := AlbumInput{Title: &title, Artist: strPtr(album.Artist), Price: floatPtr(album.Price)}
+	tests := []struct {
+		name                       string
+		apiKey                     string
+		wantGet, wantPut, wantDrop int
+	}{
+		{"stranger", stranger.APIKey, http.StatusForbidden, http.StatusForbidden, http.StatusForbidden},
+		{"viewer", viewer.APIKey, http.StatusOK, http.StatusForbidden, http.StatusForbidden},
+		{"editor", editor.APIKey, http.StatusOK, http.StatusOK, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got Album
+			if status := apiCall(t, server, tt.apiKey, http.MethodGet, path, nil, &got); status != tt.wantGet {
+				t.Errorf("GET = %d, want %d", status, tt.wantGet)
+			}
+			if got.User != nil && got.User.APIKey != "" {
+				t.Error("a collaborator can see the owner's API key")
+			}
+			if status := apiCall(t, server, tt.apiKey, http.MethodPut, path, update, nil); status != tt.wantPut {
+				t.Errorf("PUT = %d, want %d", status, tt.wantPut)
+			}
+			if status := apiCall(t, server, tt.apiKey, http.MethodDelete, path, nil, nil); status != tt.wantDrop {
+				t.Errorf("DELETE = %d, want %d", status, tt.wantDrop)
+			}
+		})
+	}
+
+	// An editor's change doesn't make the album theirs
+	var edited Album
+	DB.First(&edited, album.ID)
+	if edited.Title != title || edited.UserID == nil || *edited.UserID != owner.ID {
+		t.Errorf("after the editor's update: %q owned by %v, want %q owned by %d", edited.Title, edited.UserID, title, owner.ID)
+	}
+
+	// A collaborator can leave; then they lose access
+	leave := collaborators + "/" + itoa(viewer.ID)
+	if status := apiCall(t, server, viewer.APIKey, http.MethodDelete, leave, nil, nil); status != http.StatusOK {
+		t.Fatalf("viewer leaving = %d", status)
+	}
+	if status := apiCall(t, server, viewer.APIKey, http.MethodGet, path, nil, nil); status != http.StatusForbidden {
+		t.Errorf("GET after leaving = %d, want 403", status)
+	}
+	if status := apiCall(t, server, owner.APIKey, http.MethodDelete, leave, nil, nil); status != http.StatusNotFound {
+		t.Errorf("removing a former collaborator = %d, want 404", status)
+	}
+}
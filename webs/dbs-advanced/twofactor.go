@@ -0,0 +1,261 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/skip2/go-qrcode"
+	"gorm.io/gorm"
+)
+
+// Wrong codes allowed before the second factor is locked, and for how long
+const (
+	maxOTPAttempts     = 5
+	otpLockout         = 15 * time.Minute
+	recoveryCodeCount  = 10
+	recoveryCodeLength = 10 // base32 characters, 50 bits
+)
+
+// Second-factor errors, mapped to OTP_REQUIRED, OTP_INVALID and OTP_LOCKED
+var (
+	ErrOTPRequired = errors.New("two-factor code required")
+	ErrOTPInvalid  = errors.New("two-factor code is wrong")
+	ErrOTPLocked   = errors.New("too many wrong two-factor codes")
+)
+
+// TwoFactorEnrollment is returned by POST /2fa/enroll. QRCode is a PNG data
+// URI of OTPAuthURI for an <img> tag.
+type TwoFactorEnrollment struct {
+	Secret     string `json:"secret" xml:"secret"`
+	OTPAuthURI string `json:"otpauth_uri" xml:"otpauth_uri"`
+	QRCode     string `json:"qr_code" xml:"qr_code"`
+}
+
+// RecoveryCodes is returned once, when 2FA is switched on
+type RecoveryCodes struct {
+	EnabledAt time.Time `json:"two_factor_enabled_at" xml:"two_factor_enabled_at"`
+	Codes     []string  `json:"recovery_codes" xml:"recovery_codes>code"`
+}
+
+// POST /2fa/enroll - Protected: start 2FA with a new authenticator secret.
+// Nothing changes at login until the secret is confirmed with a code.
+func (h *AccountHandlers) postTwoFactorEnroll(c *gin.Context) {
+	user, _ := GetCurrentUser(c)
+	if user.TwoFactorEnabled() {
+		RespondError(c, CodeTwoFactorEnabled, "")
+		return
+	}
+
+	secret := newTOTPSecret()
+	uri := totpURI(secret, user.Email)
+	png, err := qrcode.Encode(uri, qrcode.Medium, 256)
+	if err != nil {
+		RespondInternalError(c, err)
+		return
+	}
+
+	if err := h.db.Model(&user).Update("totp_secret", secret).Error; err != nil {
+		RespondDBError(c, err, CodeUserNotFound)
+		return
+	}
+
+	Respond(c, http.StatusOK, SuccessResponse{
+		Success: true,
+		Data: TwoFactorEnrollment{
+			Secret:     secret,
+			OTPAuthURI: uri,
+			QRCode:     "data:image/png;base64," + base64.StdEncoding.EncodeToString(png),
+		},
+	})
+}
+
+// POST /2fa/confirm - Protected: switch 2FA on with a code from the enrolled
+// secret. The recovery codes are in the response and can't be fetched again.
+func (h *AccountHandlers) postTwoFactorConfirm(c *gin.Context) {
+	var req TwoFactorCodeRequest
+	if err := BindBody(c, &req); err != nil {
+		RespondError(c, CodeValidationFailed, err.Error())
+		return
+	}
+
+	user, _ := GetCurrentUser(c)
+	switch {
+	case user.TwoFactorEnabled():
+		RespondError(c, CodeTwoFactorEnabled, "")
+		return
+	case user.TOTPSecret == "":
+		RespondError(c, CodeTwoFactorNotEnabled, "Start with POST /2fa/enroll")
+		return
+	}
+
+	step, ok := matchTOTP(user.TOTPSecret, normalizeOTP(req.Code), time.Now(), 0)
+	if !ok {
+		RespondError(c, CodeOTPInvalid, "")
+		return
+	}
+
+	now := time.Now()
+	codes := make([]string, recoveryCodeCount)
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&user).Updates(map[string]interface{}{
+			"two_factor_enabled_at": now,
+			"totp_last_used_step":   step,
+			"failed_otp_attempts":   0,
+			"otp_locked_until":      nil,
+		}).Error
+		if err != nil {
+			return err
+		}
+
+		if err := tx.Where("user_id = ?", user.ID).Delete(&RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		rows := make([]RecoveryCode, recoveryCodeCount)
+		for i := range codes {
+			codes[i] = newRecoveryCode()
+			rows[i] = RecoveryCode{UserID: user.ID, CodeHash: hashToken(normalizeOTP(codes[i]))}
+		}
+		return tx.Create(&rows).Error
+	})
+	if err != nil {
+		RespondDBError(c, err, CodeUserNotFound)
+		return
+	}
+
+	Respond(c, http.StatusOK, SuccessResponse{
+		Success: true,
+		Data:    RecoveryCodes{EnabledAt: now, Codes: codes},
+	})
+}
+
+// POST /2fa/disable - Protected: switch 2FA off, confirmed with an
+// authenticator or recovery code
+func (h *AccountHandlers) postTwoFactorDisable(c *gin.Context) {
+	var req TwoFactorCodeRequest
+	if err := BindBody(c, &req); err != nil {
+		RespondError(c, CodeValidationFailed, err.Error())
+		return
+	}
+
+	user, _ := GetCurrentUser(c)
+	if !user.TwoFactorEnabled() {
+		RespondError(c, CodeTwoFactorNotEnabled, "")
+		return
+	}
+	if err := checkSecondFactor(h.db, user, req.Code); err != nil {
+		RespondDBError(c, err, CodeUserNotFound)
+		return
+	}
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&user).Updates(map[string]interface{}{
+			"totp_secret":           "",
+			"two_factor_enabled_at": nil,
+			"totp_last_used_step":   0,
+		}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", user.ID).Delete(&RecoveryCode{}).Error
+	})
+	if err != nil {
+		RespondDBError(c, err, CodeUserNotFound)
+		return
+	}
+
+	Respond(c, http.StatusOK, SuccessResponse{
+		Success: true,
+		Data:    gin.H{"message": "Two-factor authentication is off"},
+	})
+}
+
+// checkSecondFactor is the login step after the password: users with 2FA on
+// must also give an authenticator code or an unused recovery code. After
+// maxOTPAttempts wrong codes in a row every code is refused for otpLockout.
+// A missing code is not counted as a wrong one.
+func checkSecondFactor(db *gorm.DB, user User, code string) error {
+	if !user.TwoFactorEnabled() {
+		return nil
+	}
+	now := time.Now()
+	if user.OTPLockedUntil != nil && now.Before(*user.OTPLockedUntil) {
+		return ErrOTPLocked
+	}
+
+	code = normalizeOTP(code)
+	if code == "" {
+		return ErrOTPRequired
+	}
+
+	ok, err := useSecondFactor(db, user, code, now)
+	if err != nil {
+		return err
+	}
+	if ok {
+		return db.Model(&user).Updates(map[string]interface{}{"failed_otp_attempts": 0, "otp_locked_until": nil}).Error
+	}
+
+	// Counted in the database, so parallel guesses can't share one count.
+	// user may predate a lock a parallel guess just set: then this one is
+	// refused too.
+	miss := db.Model(&user).Where(otpUnlocked, now).Update("failed_otp_attempts", gorm.Expr("failed_otp_attempts + 1"))
+	if miss.Error != nil {
+		return miss.Error
+	}
+	if miss.RowsAffected == 0 {
+		return ErrOTPLocked
+	}
+	lock := db.Model(&user).Where("failed_otp_attempts >= ?", maxOTPAttempts).
+		Updates(map[string]interface{}{"failed_otp_attempts": 0, "otp_locked_until": now.Add(otpLockout)})
+	if lock.Error != nil {
+		return lock.Error
+	}
+	if lock.RowsAffected > 0 {
+		return ErrOTPLocked
+	}
+	return ErrOTPInvalid
+}
+
+// otpUnlocked is the condition on users that no lock on codes is running at
+// the time given as its argument
+const otpUnlocked = "(otp_locked_until IS NULL OR otp_locked_until <= ?)"
+
+// useSecondFactor uses up code as either an authenticator code, which moves
+// TOTPLastUsedStep forward, or a recovery code, which is marked used. Both are
+// conditional updates, so the same code can't log in twice, and neither
+// works while the user is locked out, however stale user is.
+func useSecondFactor(db *gorm.DB, user User, code string, now time.Time) (bool, error) {
+	if step, ok := matchTOTP(user.TOTPSecret, code, now, user.TOTPLastUsedStep); ok {
+		claim := db.Model(&User{}).Where("id = ? AND totp_last_used_step < ?", user.ID, step).
+			Where(otpUnlocked, now).
+			Update("totp_last_used_step", step)
+		return claim.RowsAffected > 0, claim.Error
+	}
+
+	unlocked := db.Model(&User{}).Select("id").Where("id = ?", user.ID).Where(otpUnlocked, now)
+	claim := db.Model(&RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", user.ID, hashToken(code)).
+		Where("user_id IN (?)", unlocked).
+		Update("used_at", now)
+	return claim.RowsAffected > 0, claim.Error
+}
+
+// newRecoveryCode returns a code like "k3v7q-9xm2d", easy to write down
+func newRecoveryCode() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	code := strings.ToLower(base32.StdEncoding.EncodeToString(b))[:recoveryCodeLength]
+	return code[:recoveryCodeLength/2] + "-" + code[recoveryCodeLength/2:]
+}
+
+// normalizeOTP drops the spaces and dashes people type into codes and
+// lower-cases recovery codes
+func normalizeOTP(code string) string {
+	return strings.ToLower(strings.NewReplacer(" ", "", "-", "").Replace(code))
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTOTPCode(t *testing.T) {
+	// RFC 6238 appendix B vectors for the SHA-1 key "12345678901234567890",
+	// cut to 6 digits
+	secret := totpEncoding.EncodeToString([]byte("12345678901234567890"))
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1234567890, "005924"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		if got, _ := totpCode(secret, totpStep(time.Unix(tt.unix, 0))); got != tt.want {
+			t.Errorf("code at %d = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+
+	now := time.Unix(1111111109, 0)
+	if step, ok := matchTOTP(secret, "081804", now.Add(totpPeriod*time.Second), 0); !ok || step != totpStep(now) {
+		t.Error("a code from the previous step was refused")
+	}
+	if _, ok := matchTOTP(secret, "081804", now, totpStep(now)); ok {
+		t.Error("a code from an already used step was accepted")
+	}
+	if _, ok := matchTOTP(secret, "081804", now.Add(3*totpPeriod*time.Second), 0); ok {
+		t.Error("a code three steps old was accepted")
+	}
+}
+
+// enableTwoFactor gives user testPassword, switches 2FA on through the API
+// and returns the secret and recovery codes
+func enableTwoFactor(t *testing.T, server *httptest.Server, user User) (string, []string) {
+	t.Helper()
+
+	setTestPassword(t, user)
+
+	var enrollment TwoFactorEnrollment
+	if status := apiCall(t, server, user.APIKey, http.MethodPost, "/2fa/enroll", nil, &enrollment); status != http.StatusOK {
+		t.Fatalf("POST /2fa/enroll = %d", status)
+	}
+	if !strings.HasPrefix(enrollment.OTPAuthURI, "otpauth://totp/Albums:") || !strings.HasPrefix(enrollment.QRCode, "data:image/png;base64,") {
+		t.Errorf("enrollment = %+v", enrollment)
+	}
+
+	// Log in still works without a code until the secret is confirmed
+	if status := apiCall(t, server, "", http.MethodPost, "/login", LoginRequest{Email: user.Email, Password: testPassword}, nil); status != http.StatusOK {
+		t.Errorf("login before confirming = %d, want 200", status)
+	}
+
+	// The code is for the previous step so the test can log in with the current one
+	code, _ := totpCode(enrollment.Secret, totpStep(time.Now())-1)
+	var recovery RecoveryCodes
+	if status := apiCall(t, server, user.APIKey, http.MethodPost, "/2fa/confirm", TwoFactorCodeRequest{Code: code}, &recovery); status != http.StatusOK {
+		t.Fatalf("POST /2fa/confirm = %d", status)
+	}
+	if len(recovery.Codes) != recoveryCodeCount {
+		t.Fatalf("got %d recovery codes, want %d", len(recovery.Codes), recoveryCodeCount)
+	}
+	return enrollment.Secret, recovery.Codes
+}
+
+func TestTwoFactorLogin(t *testing.T) {
+	setupTestDB(t)
+	user := createTestUser(t, "careful@example.com")
+	server := httptest.NewServer(setupRouter(NewServices(DB)))
+	t.Cleanup(server.Close)
+
+	secret, recovery := enableTwoFactor(t, server, user)
+	if status := apiCall(t, server, user.APIKey, http.MethodPost, "/2fa/enroll", nil, nil); status != http.StatusConflict {
+		t.Errorf("enrolling again = %d, want 409", status)
+	}
+
+	var stored []RecoveryCode
+	DB.Where("user_id = ?", user.ID).Find(&stored)
+	for _, code := range stored {
+		if code.CodeHash == recovery[0] {
+			t.Fatal("recovery codes are stored in plain text")
+		}
+	}
+
+	current, _ := totpCode(secret, totpStep(time.Now()))
+	login := func(code string) int {
+		return apiCall(t, server, "", http.MethodPost, "/login", LoginRequest{Email: user.Email, Password: testPassword, OTPCode: code}, nil)
+	}
+	tests := []struct {
+		name string
+		code string
+		want int
+	}{
+		{"no code", "", http.StatusUnauthorized},
+		{"authenticator code", current, http.StatusOK},
+		{"replayed authenticator code", current, http.StatusUnauthorized},
+		{"recovery code", strings.ToUpper(recovery[0]), http.StatusOK},
+		{"used recovery code", recovery[0], http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if status := login(tt.code); status != tt.want {
+				t.Errorf("POST /login = %d, want %d", status, tt.want)
+			}
+		})
+	}
+
+	var updated User
+	DB.First(&updated, user.ID)
+	if err := checkSecondFactor(DB, updated, ""); !errors.Is(err, ErrOTPRequired) {
+		t.Errorf("checkSecondFactor without a code = %v, want ErrOTPRequired", err)
+	}
+	if err := checkSecondFactor(DB, updated, "000000"); !errors.Is(err, ErrOTPInvalid) {
+		t.Errorf("checkSecondFactor with a wrong code = %v, want ErrOTPInvalid", err)
+	}
+}
+
+func TestTwoFactorLockout(t *testing.T) {
+	setupTestDB(t)
+	user := createTestUser(t, "guessed@example.com")
+	server := httptest.NewServer(setupRouter(NewServices(DB)))
+	t.Cleanup(server.Close)
+
+	_, recovery := enableTwoFactor(t, server, user)
+	login := func(code string) int {
+		return apiCall(t, server, "", http.MethodPost, "/login", LoginRequest{Email: user.Email, Password: testPassword, OTPCode: code}, nil)
+	}
+
+	// A wrong password isn't a wrong code
+	apiCall(t, server, "", http.MethodPost, "/login", LoginRequest{Email: user.Email, Password: "wrong", OTPCode: "000000"}, nil)
+
+	for i := 1; i < maxOTPAttempts; i++ {
+		if status := login("000000"); status != http.StatusUnauthorized {
+			t.Fatalf("wrong code %d = %d, want 401", i, status)
+		}
+	}
+	if status := login("000000"); status != http.StatusTooManyRequests {
+		t.Fatalf("wrong code %d = %d, want 429", maxOTPAttempts, status)
+	}
+	if status := login(recovery[0]); status != http.StatusTooManyRequests {
+		t.Errorf("right code while locked = %d, want 429", status)
+	}
+
+	// Once the lock runs out the right code works again
+	DB.Model(&User{}).Where("id = ?", user.ID).Update("otp_locked_until", time.Now().Add(-time.Second))
+	if status := login(recovery[0]); status != http.StatusOK {
+		t.Errorf("right code after the lock = %d, want 200", status)
+	}
+
+	if status := apiCall(t, server, user.APIKey, http.MethodPost, "/2fa/disable", TwoFactorCodeRequest{Code: recovery[1]}, nil); status != http.StatusOK {
+		t.Fatalf("POST /2fa/disable = %d", status)
+	}
+	if status := login(""); status != http.StatusOK {
+		t.Errorf("login without a code after disabling = %d, want 200", status)
+	}
+}
+
+// A guess made with the user loaded before a parallel guess set the lock
+// must be refused like any other
+func TestTwoFactorLockoutWithStaleUser(t *testing.T) {
+	setupTestDB(t)
+	user := createTestUser(t, "raced@example.com")
+	server := httptest.NewServer(setupRouter(NewServices(DB)))
+	t.Cleanup(server.Close)
+
+	secret, recovery := enableTwoFactor(t, server, user)
+	current, _ := totpCode(secret, totpStep(time.Now()))
+	var stale User
+	DB.First(&stale, user.ID)
+	DB.Model(&User{}).Where("id = ?", user.ID).Update("otp_locked_until", time.Now().Add(otpLockout))
+
+	tests := []struct {
+		name string
+		code string
+	}{
+		{"authenticator code", current},
+		{"recovery code", recovery[0]},
+		{"wrong code", "000000"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := checkSecondFactor(DB, stale, tt.code); !errors.Is(err, ErrOTPLocked) {
+				t.Errorf("checkSecondFactor = %v, want ErrOTPLocked", err)
+			}
+		})
+	}
+
+	var used int64
+	DB.Model(&RecoveryCode{}).Where("user_id = ? AND used_at IS NOT NULL", user.ID).Count(&used)
+	var locked User
+	DB.First(&locked, user.ID)
+	if used != 0 || locked.TOTPLastUsedStep != stale.TOTPLastUsedStep || locked.FailedOTPAttempts != 0 {
+		t.Errorf("after the refused codes: %d recovery codes used, last step %d, %d failed attempts; want none of them counted",
+			used, locked.TOTPLastUsedStep, locked.FailedOTPAttempts)
+	}
+}
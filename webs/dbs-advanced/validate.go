@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/getkin/kin-openapi/routers/gorillamux"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+// OpenAPIValidator rejects requests whose path params, query or body don't
+// match openapi.json before they reach a handler. In debug mode JSON
+// responses are checked too, and a mismatch turns into a 500 so drift
+// between the spec and the handlers is caught while developing.
+func OpenAPIValidator() gin.HandlerFunc {
+	specRouter := loadSpecRouter()
+	validateResponses := gin.IsDebugging()
+
+	return func(c *gin.Context) {
+		route, pathParams, err := specRouter.FindRoute(c.Request)
+		if err != nil {
+			// Undocumented routes are caught by TestOpenAPISpecCoversRoutes
+			c.Next()
+			return
+		}
+
+		// BindBody reads a body without Content-Type as JSON, so validate it as
+		// JSON too. openapi.json lists YAML bodies under application/yaml only,
+		// and BindBody reads application/x-yaml the same way.
+		if c.Request.ContentLength != 0 {
+			switch c.ContentType() {
+			case "":
+				c.Request.Header.Set("Content-Type", binding.MIMEJSON)
+			case binding.MIMEYAML:
+				c.Request.Header.Set("Content-Type", binding.MIMEYAML2)
+			}
+		}
+
+		input := &openapi3filter.RequestValidationInput{
+			Request:    c.Request,
+			PathParams: pathParams,
+			Route:      route,
+			Options: &openapi3filter.Options{
+				MultiError:         true,
+				AuthenticationFunc: openapi3filter.NoopAuthenticationFunc, // AuthMiddleware checks keys
+				ExcludeRequestBody: !isValidatedFormat(c.ContentType()),
+			},
+		}
+
+		if err := openapi3filter.ValidateRequest(c.Request.Context(), input); err != nil {
+			c.Abort()
+			RespondValidationError(c, "", validationIssues(err))
+			return
+		}
+
+		if !validateResponses {
+			c.Next()
+			return
+		}
+
+		writer := &bufferedWriter{ResponseWriter: c.Writer, status: http.StatusOK}
+		c.Writer = writer
+		c.Next()
+		c.Writer = writer.ResponseWriter
+
+		if isValidatedFormat(writer.Header().Get("Content-Type")) {
+			responseInput := &openapi3filter.ResponseValidationInput{
+				RequestValidationInput: input,
+				Status:                 writer.status,
+				Header:                 writer.Header(),
+				Options: &openapi3filter.Options{
+					MultiError:            true,
+					IncludeResponseStatus: true,
+				},
+			}
+			responseInput.SetBodyBytes(writer.body.Bytes())
+
+			if err := openapi3filter.ValidateResponse(c.Request.Context(), responseInput); err != nil {
+				log.Printf("[%s] %s %s: response does not match openapi.json: %v",
+					GetRequestID(c), c.Request.Method, c.Request.URL.Path, err)
+				c.Writer.Header().Del("Content-Type")
+				RespondError(c, CodeInternal, "Response does not match the OpenAPI specification")
+				return
+			}
+		}
+
+		writer.flush()
+	}
+}
+
+// loadSpecRouter parses the embedded openapi.json into a route matcher.
+// The spec is compiled in, so an invalid one is a programming error.
+func loadSpecRouter() routers.Router {
+	doc, err := openapi3.NewLoader().LoadFromData(openAPISpec)
+	if err != nil {
+		log.Fatal("Failed to load openapi.json:", err)
+	}
+	if err := doc.Validate(context.Background()); err != nil {
+		log.Fatal("openapi.json is not a valid OpenAPI document:", err)
+	}
+
+	// Match on path only, whatever host or port the server runs on
+	doc.Servers = nil
+
+	specRouter, err := gorillamux.NewRouter(doc)
+	if err != nil {
+		log.Fatal("Failed to build router from openapi.json:", err)
+	}
+	return specRouter
+}
+
+// isValidatedFormat reports whether a body of this type is checked against
+// the spec. XML and MessagePack have no schema-aware decoder, so they are
+// only checked by BindBody.
+func isValidatedFormat(contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	switch strings.TrimSpace(mediaType) {
+	case binding.MIMEJSON, MIMEProblemJSON, binding.MIMEYAML, binding.MIMEYAML2:
+		return true
+	}
+	return false
+}
+
+// validationIssues flattens kin-openapi errors into ValidationIssue values
+func validationIssues(err error) []ValidationIssue {
+	if multi, ok := err.(openapi3.MultiError); ok {
+		var issues []ValidationIssue
+		for _, e := range multi {
+			issues = append(issues, validationIssues(e)...)
+		}
+		return issues
+	}
+
+	issue := ValidationIssue{Location: "request", Message: err.Error()}
+
+	var requestErr *openapi3filter.RequestError
+	if !errors.As(err, &requestErr) {
+		return []ValidationIssue{issue}
+	}
+
+	issue.Message = requestErr.Reason
+	switch {
+	case requestErr.Parameter != nil:
+		issue.Location = requestErr.Parameter.In
+		issue.Field = requestErr.Parameter.Name
+	case requestErr.RequestBody != nil:
+		issue.Location = "body"
+	}
+
+	if requestErr.Err == nil {
+		return []ValidationIssue{issue}
+	}
+
+	// Schema errors nested in a body or parameter carry the exact field
+	if nested, ok := requestErr.Err.(openapi3.MultiError); ok {
+		var issues []ValidationIssue
+		for _, e := range nested {
+			issues = append(issues, schemaIssue(issue, e))
+		}
+		return issues
+	}
+	return []ValidationIssue{schemaIssue(issue, requestErr.Err)}
+}
+
+// schemaIssue fills in field and reason from a schema error, if err is one
+func schemaIssue(issue ValidationIssue, err error) ValidationIssue {
+	var schemaErr *openapi3.SchemaError
+	if !errors.As(err, &schemaErr) {
+		if issue.Message == "" {
+			issue.Message = err.Error()
+		}
+		return issue
+	}
+
+	if pointer := schemaErr.JSONPointer(); len(pointer) > 0 {
+		issue.Field = strings.Join(pointer, ".")
+	}
+	issue.Message = schemaErr.Reason
+	return issue
+}
+
+// bufferedWriter holds the response back until it has been validated
+type bufferedWriter struct {
+	gin.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *bufferedWriter) WriteHeader(code int) { w.status = code }
+
+func (w *bufferedWriter) WriteHeaderNow() {}
+
+func (w *bufferedWriter) Write(data []byte) (int, error) { return w.body.Write(data) }
+
+func (w *bufferedWriter) WriteString(s string) (int, error) { return w.body.WriteString(s) }
+
+func (w *bufferedWriter) Status() int { return w.status }
+
+func (w *bufferedWriter) Size() int { return w.body.Len() }
+
+func (w *bufferedWriter) Written() bool { return w.body.Len() > 0 }
+
+// flush sends the buffered status and body to the real writer
+func (w *bufferedWriter) flush() {
+	w.ResponseWriter.WriteHeader(w.status)
+	w.ResponseWriter.Write(w.body.Bytes())
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestOpenAPIValidator(t *testing.T) {
+	server := newTestServer(t)
+	user := createTestUser(t, "validated@example.com")
+	album := createTestAlbum(t, "Blue Train", &user.ID)
+	path := "/albums/" + itoa(album.ID)
+
+	tests := []struct {
+		name        string
+		method      string
+		path        string
+		contentType string
+		body        string
+		want        int
+		wantField   string // of the first issue, for a 400
+	}{
+		{"complete album", http.MethodPost, "/albums", "application/json", `{"title":"Jeru","artist":"Gerry Mulligan","price":17.99}`, http.StatusCreated, ""},
+		{"missing field", http.MethodPost, "/albums", "application/json", `{"title":"Jeru","price":17.99}`, http.StatusBadRequest, "artist"},
+		{"empty object", http.MethodPost, "/albums", "application/json", `{}`, http.StatusBadRequest, "title"},
+		{"wrong type", http.MethodPost, "/albums", "application/json", `{"title":"Jeru","artist":"Gerry Mulligan","price":"free"}`, http.StatusBadRequest, "price"},
+		{"negative price", http.MethodPost, "/albums", "application/json", `{"title":"Jeru","artist":"Gerry Mulligan","price":-5}`, http.StatusBadRequest, "price"},
+		{"empty title", http.MethodPost, "/albums", "application/json", `{"title":"","artist":"Gerry Mulligan","price":1}`, http.StatusBadRequest, "title"},
+		{"yaml", http.MethodPost, "/albums", "application/yaml", "title: Jeru\nartist: Gerry Mulligan\nprice: 17.99\n", http.StatusCreated, ""},
+		{"x-yaml", http.MethodPost, "/albums", "application/x-yaml", "title: Jeru\nartist: Gerry Mulligan\nprice: 17.99\n", http.StatusCreated, ""},
+		{"x-yaml missing field", http.MethodPost, "/albums", "application/x-yaml", "title: Jeru\nprice: 17.99\n", http.StatusBadRequest, "artist"},
+		{"xml is left to the handler", http.MethodPost, "/albums", "application/xml", "<album><title>Jeru</title><artist>Gerry Mulligan</artist><price>17.99</price></album>", http.StatusCreated, ""},
+		{"xml NaN price", http.MethodPost, "/albums", "application/xml", "<album><title>Jeru</title><artist>Gerry Mulligan</artist><price>NaN</price></album>", http.StatusBadRequest, "price"},
+		{"xml infinite price", http.MethodPut, path, "application/xml", "<album><price>+Inf</price></album>", http.StatusBadRequest, "price"},
+		{"xml without an artist", http.MethodPost, "/albums", "application/xml", "<album><title>Jeru</title><price>1</price></album>", http.StatusBadRequest, "artist"},
+		{"partial update", http.MethodPut, path, "application/json", `{"price":3}`, http.StatusOK, ""},
+		{"update to an empty title", http.MethodPut, path, "application/json", `{"title":""}`, http.StatusBadRequest, "title"},
+		{"bad query parameter", http.MethodGet, "/my-albums?limit=lots", "", "", http.StatusBadRequest, "limit"},
+		{"query parameter out of range", http.MethodGet, "/my-albums?limit=0", "", "", http.StatusBadRequest, "limit"},
+		{"bad path parameter", http.MethodGet, "/albums/abc", "", "", http.StatusBadRequest, "id"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(tt.method, server.URL+tt.path, strings.NewReader(tt.body))
+			req.Header.Set("X-API-Key", user.APIKey)
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("%s %s: %v", tt.method, tt.path, err)
+			}
+			defer resp.Body.Close()
+
+			var failure ErrorResponse
+			json.NewDecoder(resp.Body).Decode(&failure)
+			if resp.StatusCode != tt.want {
+				t.Fatalf("%s %s = %d with %+v, want %d", tt.method, tt.path, resp.StatusCode, failure, tt.want)
+			}
+			if tt.wantField == "" {
+				return
+			}
+			if failure.Code != CodeValidationFailed || len(failure.Details) == 0 || failure.Details[0].Field != tt.wantField {
+				t.Errorf("error = %+v, want VALIDATION_FAILED on %s", failure, tt.wantField)
+			}
+		})
+	}
+}
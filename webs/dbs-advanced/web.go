@@ -0,0 +1,469 @@
+package main
+
+import (
+	"crypto/subtle"
+	"embed"
+	"errors"
+	"html/template"
+	"io/fs"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/oauth2"
+	"gorm.io/gorm"
+)
+
+// Templates and static assets for the HTML UI are compiled into the binary
+//
+//go:embed web/templates web/static
+var webFiles embed.FS
+
+// pages holds each page template parsed together with the shared layout
+var pages = map[string]*template.Template{}
+
+func init() {
+	names, err := fs.Glob(webFiles, "web/templates/*.html")
+	if err != nil {
+		log.Fatal("Failed to list web templates:", err)
+	}
+	for _, name := range names {
+		page := strings.TrimPrefix(name, "web/templates/")
+		if page == "layout.html" {
+			continue
+		}
+		pages[page] = template.Must(template.ParseFS(webFiles, "web/templates/layout.html", name))
+	}
+}
+
+// setupWeb registers the HTML UI under /ui. It uses cookie sessions and
+// form posts, so it sits outside the JSON API's negotiation and validation.
+func setupWeb(router *gin.Engine, albums *AlbumHandlers, accounts *AccountHandlers, users UserRepository) {
+	loadSessionKey()
+
+	static, _ := fs.Sub(webFiles, "web/static")
+	router.StaticFS("/ui/static", http.FS(static))
+
+	web := router.Group("/ui")
+	web.Use(WebSession(), VerifyCSRF())
+	{
+		web.GET("/", albums.webCatalogue)
+		web.GET("/login", webLoginForm)
+		web.POST("/login", accounts.webLogin)
+		web.GET("/login/sso", webSSOLogin)
+		web.GET("/login/sso/callback", accounts.webSSOCallback)
+		web.GET("/login/2fa", webSecondFactorForm)
+		web.POST("/login/2fa", accounts.webSecondFactor)
+		web.POST("/logout", webLogout)
+	}
+
+	account := web.Group("/")
+	account.Use(WebAuth(users))
+	{
+		account.GET("/my-albums", albums.webMyAlbums)
+		account.POST("/albums", albums.webCreateAlbum)
+		account.GET("/albums/:id/edit", albums.webEditAlbum)
+		account.POST("/albums/:id", albums.webUpdateAlbum)
+		account.POST("/albums/:id/delete", albums.webDeleteAlbum)
+	}
+}
+
+// WebAuth sends visitors without a logged-in session to the login form
+func WebAuth(users UserRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		session := GetSession(c)
+
+		var user User
+		var err error
+		if session.UserID != 0 {
+			user, err = users.Get(session.UserID)
+		}
+		if session.UserID == 0 || err != nil ||
+			(user.SessionsRevokedAt != nil && session.LoggedInAt < user.SessionsRevokedAt.UnixMilli()) {
+			session.UserID = 0
+			session.AddFlash("error", "Please log in first.")
+			c.Abort()
+			redirectTo(c, "/ui/login")
+			return
+		}
+
+		c.Set("user", user)
+		c.Set("user_id", user.ID)
+		c.Next()
+	}
+}
+
+// renderPage saves the session (handing its flashes to the page) and
+// renders a page inside the layout.
+func renderPage(c *gin.Context, status int, page string, data gin.H) {
+	session := GetSession(c)
+
+	data["Flashes"] = session.Flashes
+	data["CSRF"] = session.CSRF
+	data["LoggedIn"] = session.UserID != 0
+	session.Flashes = nil
+	saveSession(c, session)
+
+	c.Status(status)
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	if err := pages[page].ExecuteTemplate(c.Writer, "layout", data); err != nil {
+		log.Printf("[%s] render %s: %v", GetRequestID(c), page, err)
+	}
+}
+
+// redirectTo saves the session and sends the browser on with a GET
+func redirectTo(c *gin.Context, path string) {
+	saveSession(c, GetSession(c))
+	c.Redirect(http.StatusSeeOther, path)
+}
+
+// GET /ui/ - Public album catalogue (same data as GET /albums, owners'
+// email addresses left out)
+func (h *AlbumHandlers) webCatalogue(c *gin.Context) {
+	albums, err := h.albums.List(Tenant{}, nil, Page{})
+	if err != nil {
+		log.Printf("[%s] list albums: %v", GetRequestID(c), err)
+		GetSession(c).AddFlash("error", "Albums could not be loaded, please try again.")
+	}
+
+	renderPage(c, http.StatusOK, "catalogue.html", gin.H{"Albums": albums})
+}
+
+// GET /ui/login - Login form
+func webLoginForm(c *gin.Context) {
+	renderLogin(c, http.StatusOK, "")
+}
+
+// POST /ui/login - Check credentials and start a logged-in session
+func (h *AccountHandlers) webLogin(c *gin.Context) {
+	email := strings.TrimSpace(c.PostForm("email"))
+
+	user, err := authenticate(h.db, email, c.PostForm("password"))
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			log.Printf("[%s] login: %v", GetRequestID(c), err)
+		}
+		GetSession(c).AddFlash("error", "Invalid email or password.")
+		renderLogin(c, http.StatusUnauthorized, email)
+		return
+	}
+	if err := checkSecondFactor(h.db, user, c.PostForm("otp_code")); err != nil {
+		status := secondFactorFailed(c, err)
+		renderLogin(c, status, email)
+		return
+	}
+
+	startWebSession(c, user)
+}
+
+// secondFactorFailed flashes why checkSecondFactor refused a login and
+// returns the status to render the form again with
+func secondFactorFailed(c *gin.Context, err error) int {
+	status, message := http.StatusUnauthorized, "Enter the code from your authenticator app or a recovery code."
+	switch {
+	case errors.Is(err, ErrOTPInvalid):
+		message = "That two-factor code is wrong or was already used."
+	case errors.Is(err, ErrOTPLocked):
+		status, message = http.StatusTooManyRequests, "Too many wrong two-factor codes. Try again later."
+	case !errors.Is(err, ErrOTPRequired):
+		log.Printf("[%s] login: %v", GetRequestID(c), err)
+		status, message = http.StatusInternalServerError, "Login failed, please try again."
+	}
+	GetSession(c).AddFlash("error", message)
+	return status
+}
+
+// GET /ui/login/sso - Send the browser to the identity provider, with PKCE
+func webSSOLogin(c *gin.Context) {
+	session := GetSession(c)
+	if SSO == nil {
+		session.AddFlash("error", "Single sign-on is not set up.")
+		redirectTo(c, "/ui/login")
+		return
+	}
+
+	login := &SSOLogin{State: randomToken(), Nonce: randomToken(), Verifier: oauth2.GenerateVerifier()}
+	target, err := SSO.AuthCodeURL(c.Request.Context(), login.State, login.Nonce, login.Verifier)
+	if err != nil {
+		log.Printf("[%s] sso: %v", GetRequestID(c), err)
+		session.AddFlash("error", "Single sign-on is unavailable, please try again later.")
+		redirectTo(c, "/ui/login")
+		return
+	}
+
+	session.SSO = login
+	redirectTo(c, target)
+}
+
+// GET /ui/login/sso/callback - Finish single sign-on: check the state,
+// exchange the code and log in as the linked (or a new) user
+func (h *AccountHandlers) webSSOCallback(c *gin.Context) {
+	session := GetSession(c)
+	login := session.SSO
+	session.SSO = nil // one callback per login attempt
+
+	if SSO == nil || login == nil ||
+		subtle.ConstantTimeCompare([]byte(c.Query("state")), []byte(login.State)) != 1 {
+		session.AddFlash("error", "That single sign-on attempt expired or was not started here. Please try again.")
+		redirectTo(c, "/ui/login")
+		return
+	}
+	if c.Query("error") != "" {
+		session.AddFlash("error", "Single sign-on was cancelled or refused.")
+		redirectTo(c, "/ui/login")
+		return
+	}
+
+	identity, err := SSO.Exchange(c.Request.Context(), c.Query("code"), login.Verifier, login.Nonce)
+	if err != nil {
+		log.Printf("[%s] sso: %v", GetRequestID(c), err)
+		session.AddFlash("error", "Single sign-on failed, please try again.")
+		redirectTo(c, "/ui/login")
+		return
+	}
+
+	var user User
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		user, err = userForIdentity(tx, identity)
+		return err
+	})
+	if err != nil {
+		message := "Single sign-on failed, please try again."
+		if errors.Is(err, ErrIdentityEmailUnverified) {
+			message = "Your identity provider has not verified your email address, so it can't be used to log in."
+		} else {
+			log.Printf("[%s] sso: %v", GetRequestID(c), err)
+		}
+		session.AddFlash("error", message)
+		redirectTo(c, "/ui/login")
+		return
+	}
+
+	// The account may have been linked by email address alone, so whatever
+	// the identity provider checked, 2FA here is still asked for
+	if user.TwoFactorEnabled() {
+		session.SecondFactor = &PendingLogin{UserID: user.ID, Expires: time.Now().Add(secondFactorTimeout).Unix()}
+		redirectTo(c, "/ui/login/2fa")
+		return
+	}
+
+	startWebSession(c, user)
+}
+
+// pendingLogin returns the login waiting for a second factor, or sends the
+// browser back to the login form when there is none
+func pendingLogin(c *gin.Context) (*PendingLogin, bool) {
+	session := GetSession(c)
+	pending := session.SecondFactor
+	if pending == nil || time.Now().Unix() > pending.Expires {
+		session.SecondFactor = nil
+		session.AddFlash("error", "That login expired or was not started here. Please log in again.")
+		redirectTo(c, "/ui/login")
+		return nil, false
+	}
+	return pending, true
+}
+
+// GET /ui/login/2fa - Ask a single sign-on login for the second factor
+func webSecondFactorForm(c *gin.Context) {
+	if _, ok := pendingLogin(c); !ok {
+		return
+	}
+	renderPage(c, http.StatusOK, "second_factor.html", gin.H{})
+}
+
+// POST /ui/login/2fa - Check the second factor and start the session
+func (h *AccountHandlers) webSecondFactor(c *gin.Context) {
+	pending, ok := pendingLogin(c)
+	if !ok {
+		return
+	}
+
+	var user User
+	err := h.db.First(&user, pending.UserID).Error
+	if err == nil {
+		err = checkSecondFactor(h.db, user, c.PostForm("otp_code"))
+	}
+	if err != nil {
+		status := secondFactorFailed(c, err)
+		renderPage(c, status, "second_factor.html", gin.H{})
+		return
+	}
+
+	startWebSession(c, user)
+}
+
+// startWebSession logs user in. The session (and CSRF token) is new, so a
+// pre-login cookie can't be reused.
+func startWebSession(c *gin.Context, user User) {
+	session := clearSession(c)
+	session.UserID = user.ID
+	session.LoggedInAt = time.Now().UnixMilli()
+	session.AddFlash("success", "Welcome back, "+user.FirstName+"!")
+	redirectTo(c, "/ui/my-albums")
+}
+
+// renderLogin shows the login form, with the SSO button when it is set up
+func renderLogin(c *gin.Context, status int, email string) {
+	renderPage(c, status, "login.html", gin.H{"Email": email, "SSO": SSO != nil})
+}
+
+// POST /ui/logout - End the session
+func webLogout(c *gin.Context) {
+	clearSession(c).AddFlash("success", "You have been logged out.")
+	redirectTo(c, "/ui/")
+}
+
+// GET /ui/my-albums - The logged-in user's albums with a create form
+func (h *AlbumHandlers) webMyAlbums(c *gin.Context) {
+	userID, _ := GetCurrentUserID(c)
+
+	albums, err := h.albums.List(Tenant{}, &userID, Page{})
+	if err != nil {
+		log.Printf("[%s] list my albums: %v", GetRequestID(c), err)
+		GetSession(c).AddFlash("error", "Your albums could not be loaded, please try again.")
+	}
+
+	renderPage(c, http.StatusOK, "my_albums.html", gin.H{"Albums": albums, "Form": albumForm{}})
+}
+
+// POST /ui/albums - Create an album from the form
+func (h *AlbumHandlers) webCreateAlbum(c *gin.Context) {
+	userID, _ := GetCurrentUserID(c)
+	session := GetSession(c)
+
+	form := readAlbumForm(c)
+	album, problems := form.validate()
+	if len(problems) > 0 {
+		for _, problem := range problems {
+			session.AddFlash("error", problem)
+		}
+		albums, _ := h.albums.List(Tenant{}, &userID, Page{})
+		renderPage(c, http.StatusUnprocessableEntity, "my_albums.html", gin.H{"Albums": albums, "Form": form})
+		return
+	}
+
+	album, err := h.albums.Create(Tenant{}, userID, AlbumInput{Title: &album.Title, Artist: &album.Artist, Price: &album.Price})
+	if errors.Is(err, ErrEmailNotVerified) {
+		session.AddFlash("error", "Confirm your email address before adding albums.")
+	} else if err != nil {
+		log.Printf("[%s] create album: %v", GetRequestID(c), err)
+		session.AddFlash("error", "The album could not be saved, please try again.")
+	} else {
+		session.AddFlash("success", "Added “"+album.Title+"”.")
+	}
+	redirectTo(c, "/ui/my-albums")
+}
+
+// GET /ui/albums/:id/edit - Edit form for one of the user's albums
+func (h *AlbumHandlers) webEditAlbum(c *gin.Context) {
+	album, ok := h.findOwnedAlbum(c)
+	if !ok {
+		return
+	}
+
+	form := albumForm{Title: album.Title, Artist: album.Artist, Price: strconv.FormatFloat(album.Price, 'f', 2, 64)}
+	renderPage(c, http.StatusOK, "edit_album.html", gin.H{"Album": album, "Form": form})
+}
+
+// POST /ui/albums/:id - Save the edit form
+func (h *AlbumHandlers) webUpdateAlbum(c *gin.Context) {
+	album, ok := h.findOwnedAlbum(c)
+	if !ok {
+		return
+	}
+	session := GetSession(c)
+
+	form := readAlbumForm(c)
+	changes, problems := form.validate()
+	if len(problems) > 0 {
+		for _, problem := range problems {
+			session.AddFlash("error", problem)
+		}
+		renderPage(c, http.StatusUnprocessableEntity, "edit_album.html", gin.H{"Album": album, "Form": form})
+		return
+	}
+
+	album, err := h.albums.Update(Tenant{}, *album.UserID, album.ID, AlbumInput{Title: &changes.Title, Artist: &changes.Artist, Price: &changes.Price})
+	if err != nil {
+		log.Printf("[%s] update album: %v", GetRequestID(c), err)
+		session.AddFlash("error", "The album could not be saved, please try again.")
+	} else {
+		session.AddFlash("success", "Saved “"+album.Title+"”.")
+	}
+	redirectTo(c, "/ui/my-albums")
+}
+
+// POST /ui/albums/:id/delete - Delete one of the user's albums
+func (h *AlbumHandlers) webDeleteAlbum(c *gin.Context) {
+	album, ok := h.findOwnedAlbum(c)
+	if !ok {
+		return
+	}
+	session := GetSession(c)
+
+	if err := h.albums.Delete(Tenant{}, *album.UserID, album.ID); err != nil {
+		log.Printf("[%s] delete album: %v", GetRequestID(c), err)
+		session.AddFlash("error", "The album could not be deleted, please try again.")
+	} else {
+		session.AddFlash("success", "Deleted “"+album.Title+"”.")
+	}
+	redirectTo(c, "/ui/my-albums")
+}
+
+// findOwnedAlbum loads :id if the logged-in user owns it, otherwise it
+// redirects back to My albums with a flash and returns false.
+func (h *AlbumHandlers) findOwnedAlbum(c *gin.Context) (Album, bool) {
+	userID, _ := GetCurrentUserID(c)
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 0)
+	var album Album
+	if err == nil {
+		album, err = h.albums.Get(Tenant{}, userID, uint(id))
+	}
+	if err != nil || album.UserID == nil || *album.UserID != userID {
+		GetSession(c).AddFlash("error", "That album doesn't exist or isn't yours.")
+		redirectTo(c, "/ui/my-albums")
+		return Album{}, false
+	}
+	return album, true
+}
+
+// albumForm is the create/edit form as typed, so it can be shown again with errors
+type albumForm struct {
+	Title  string
+	Artist string
+	Price  string
+}
+
+func readAlbumForm(c *gin.Context) albumForm {
+	return albumForm{
+		Title:  strings.TrimSpace(c.PostForm("title")),
+		Artist: strings.TrimSpace(c.PostForm("artist")),
+		Price:  strings.TrimSpace(c.PostForm("price")),
+	}
+}
+
+// validate returns the album the form describes, or what's wrong with it
+func (f albumForm) validate() (Album, []string) {
+	var problems []string
+
+	if f.Title == "" {
+		problems = append(problems, "Title is required.")
+	}
+	if f.Artist == "" {
+		problems = append(problems, "Artist is required.")
+	}
+
+	// ParseFloat accepts "NaN" and "Inf", which are no price
+	price, err := strconv.ParseFloat(f.Price, 64)
+	if err != nil || math.IsNaN(price) || math.IsInf(price, 0) || price < 0 {
+		problems = append(problems, "Price must be a number of at least 0.")
+	}
+
+	return Album{Title: f.Title, Artist: f.Artist, Price: price}, problems
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"html"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// browser is a cookie-keeping client for the /ui pages
+type browser struct {
+	t      *testing.T
+	server *httptest.Server
+	client *http.Client
+	csrf   string // from the last page with a form
+}
+
+func newBrowser(t *testing.T, server *httptest.Server) *browser {
+	jar, _ := cookiejar.New(nil)
+	return &browser{t: t, server: server, client: &http.Client{Jar: jar}}
+}
+
+var csrfField = regexp.MustCompile(`name="csrf_token" value="([^"]+)"`)
+
+// get fetches a page and remembers its CSRF token
+func (b *browser) get(path string) (int, string) {
+	b.t.Helper()
+	resp, err := b.client.Get(b.server.URL + path)
+	if err != nil {
+		b.t.Fatalf("GET %s: %v", path, err)
+	}
+	return b.read(resp)
+}
+
+// post submits a form, following the redirect after a success
+func (b *browser) post(path string, form url.Values) (int, string) {
+	b.t.Helper()
+	resp, err := b.client.PostForm(b.server.URL+path, form)
+	if err != nil {
+		b.t.Fatalf("POST %s: %v", path, err)
+	}
+	return b.read(resp)
+}
+
+func (b *browser) read(resp *http.Response) (int, string) {
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if match := csrfField.FindSubmatch(body); match != nil {
+		b.csrf = string(match[1])
+	}
+	return resp.StatusCode, html.UnescapeString(string(body))
+}
+
+// login signs user in through the form and returns the My albums page
+func (b *browser) login(user User) string {
+	b.t.Helper()
+	setTestPassword(b.t, user)
+	b.get("/ui/login")
+	status, page := b.post("/ui/login", url.Values{"csrf_token": {b.csrf}, "email": {user.Email}, "password": {testPassword}})
+	if status != http.StatusOK || !strings.Contains(page, "My albums") {
+		b.t.Fatalf("login as %s = %d, want My albums", user.Email, status)
+	}
+	return page
+}
+
+func TestWebRejectsPostsWithoutCSRFToken(t *testing.T) {
+	server := newTestServer(t)
+	user := createTestUser(t, "web@example.com")
+	album := createTestAlbum(t, "Blue Train", &user.ID)
+	b := newBrowser(t, server)
+	b.login(user)
+
+	tests := []struct {
+		name, path, token string
+	}{
+		{"create without a token", "/ui/albums", ""},
+		{"create with another token", "/ui/albums", "forged"},
+		{"delete without a token", "/ui/albums/" + itoa(album.ID) + "/delete", ""},
+		{"logout with another token", "/ui/logout", "forged"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{"title": {"Jeru"}, "artist": {"Gerry Mulligan"}, "price": {"17.99"}}
+			if tt.token != "" {
+				form.Set("csrf_token", tt.token)
+			}
+			status, page := b.post(tt.path, form)
+			if status != http.StatusForbidden || !strings.Contains(page, "Your form expired") {
+				t.Errorf("POST %s = %d, want 403 with the expired-form page", tt.path, status)
+			}
+		})
+	}
+
+	var count int64
+	DB.Model(&Album{}).Count(&count)
+	if count != 1 {
+		t.Errorf("%d albums after rejected posts, want only the original", count)
+	}
+	if status, page := b.get("/ui/my-albums"); status != http.StatusOK || !strings.Contains(page, "Blue Train") {
+		t.Errorf("still logged in with the album: got %d", status)
+	}
+}
+
+func TestWebAlbumFormErrorsRerenderThePage(t *testing.T) {
+	server := newTestServer(t)
+	user := createTestUser(t, "web@example.com")
+	album := createTestAlbum(t, "Blue Train", &user.ID)
+	b := newBrowser(t, server)
+	b.login(user)
+
+	tests := []struct {
+		name, path        string
+		title, price      string
+		wantPage, wantMsg string
+	}{
+		{"create without title", "/ui/albums", "", "1", "Add an album", "Title is required."},
+		{"create with NaN price", "/ui/albums", "Jeru", "NaN", "Add an album", "Price must be a number of at least 0."},
+		{"create with infinite price", "/ui/albums", "Jeru", "+Inf", "Add an album", "Price must be a number of at least 0."},
+		{"create with negative price", "/ui/albums", "Jeru", "-1", "Add an album", "Price must be a number of at least 0."},
+		{"edit with NaN price", "/ui/albums/" + itoa(album.ID), "Jeru", "nan", "Edit", "Price must be a number of at least 0."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b.get("/ui/my-albums")
+			form := url.Values{"csrf_token": {b.csrf}, "title": {tt.title}, "artist": {"Gerry Mulligan"}, "price": {tt.price}}
+			status, page := b.post(tt.path, form)
+			if status != http.StatusUnprocessableEntity {
+				t.Fatalf("POST %s = %d, want 422", tt.path, status)
+			}
+			if !strings.Contains(page, tt.wantPage) || !strings.Contains(page, tt.wantMsg) {
+				t.Errorf("POST %s page lacks %q or %q:\n%s", tt.path, tt.wantPage, tt.wantMsg, page)
+			}
+			// What was typed is shown again
+			if !strings.Contains(page, `value="`+tt.price+`"`) {
+				t.Errorf("POST %s page doesn't keep the price %q", tt.path, tt.price)
+			}
+		})
+	}
+
+	var stored Album
+	DB.First(&stored, album.ID)
+	var count int64
+	DB.Model(&Album{}).Count(&count)
+	if count != 1 || stored.Title != "Blue Train" || stored.Price != album.Price {
+		t.Errorf("after rejected forms: %d albums, %+v; want the original unchanged", count, stored)
+	}
+}
+
+func TestWebPagesShowTheUsersAlbums(t *testing.T) {
+	server := newTestServer(t)
+	user := createTestUser(t, "web@example.com")
+	other := createTestUser(t, "other@example.com")
+	mine := createTestAlbum(t, "Blue Train", &user.ID)
+	theirs := createTestAlbum(t, "Jeru", &other.ID)
+	b := newBrowser(t, server)
+	b.login(user)
+
+	tests := []struct {
+		name, path string
+		want       []string
+		wantNot    []string
+	}{
+		{"catalogue", "/ui/", []string{mine.Title, theirs.Title}, []string{user.Email, other.Email}},
+		{"my albums", "/ui/my-albums", []string{mine.Title}, []string{theirs.Title}},
+		{"editing mine", "/ui/albums/" + itoa(mine.ID) + "/edit", []string{mine.Title}, nil},
+		{"editing theirs", "/ui/albums/" + itoa(theirs.ID) + "/edit", []string{"That album doesn't exist or isn't yours."}, []string{theirs.Title}},
+		{"editing a missing album", "/ui/albums/999/edit", []string{"That album doesn't exist or isn't yours."}, nil},
+		{"editing a bad ID", "/ui/albums/abc/edit", []string{"That album doesn't exist or isn't yours."}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, page := b.get(tt.path)
+			if status != http.StatusOK {
+				t.Fatalf("GET %s = %d, want 200", tt.path, status)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(page, want) {
+					t.Errorf("GET %s lacks %q", tt.path, want)
+				}
+			}
+			for _, unwanted := range tt.wantNot {
+				if strings.Contains(page, unwanted) {
+					t.Errorf("GET %s shows %q", tt.path, unwanted)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Shortest secret a user may choose; a generated one is 64 hex characters
+const minWebhookSecret = 16
+
+// WebhookInput is the body of POST /webhooks. Without a secret one is generated.
+type WebhookInput struct {
+	URL    string   `json:"url" xml:"url" binding:"required"`
+	Secret string   `json:"secret,omitempty" xml:"secret,omitempty"`
+	Events []string `json:"events" xml:"events>event" binding:"required"`
+}
+
+// WebhookHandlers serve the webhook routes
+type WebhookHandlers struct {
+	webhooks WebhookRepository
+}
+
+// NewWebhookHandlers serves the webhooks in webhooks
+func NewWebhookHandlers(webhooks WebhookRepository) *WebhookHandlers {
+	return &WebhookHandlers{webhooks: webhooks}
+}
+
+// GET /webhooks - The user's webhooks (secrets are only shown on creation)
+func (h *WebhookHandlers) getWebhooks(c *gin.Context) {
+	userID, _ := GetCurrentUserID(c)
+
+	webhooks, err := h.webhooks.List(userID)
+	if err != nil {
+		RespondInternalError(c, err)
+		return
+	}
+	for i := range webhooks {
+		webhooks[i].Secret = ""
+	}
+
+	Respond(c, http.StatusOK, SuccessResponse{
+		Success: true,
+		Data:    webhooks,
+	})
+}
+
+// POST /webhooks - Register an endpoint for events about the user's albums
+func (h *WebhookHandlers) postWebhook(c *gin.Context) {
+	userID, _ := GetCurrentUserID(c)
+	var input WebhookInput
+
+	if err := BindBody(c, &input); err != nil {
+		RespondError(c, CodeValidationFailed, err.Error())
+		return
+	}
+	if issues := input.validate(); issues != nil {
+		RespondValidationError(c, "", issues)
+		return
+	}
+
+	webhook := Webhook{UserID: userID, URL: input.URL, Secret: input.Secret, Events: input.Events}
+	if webhook.Secret == "" {
+		webhook.Secret = randomToken()
+	}
+	if err := h.webhooks.Create(&webhook); err != nil {
+		RespondDBError(c, err, CodeWebhookNotFound)
+		return
+	}
+
+	// The only time the secret is sent back: store it to verify signatures
+	Respond(c, http.StatusCreated, SuccessResponse{
+		Success: true,
+		Data:    webhook,
+	})
+}
+
+// GET /webhooks/:id - One of the user's webhooks
+func (h *WebhookHandlers) getWebhook(c *gin.Context) {
+	webhook, ok := h.findWebhook(c)
+	if !ok {
+		return
+	}
+
+	Respond(c, http.StatusOK, SuccessResponse{
+		Success: true,
+		Data:    webhook,
+	})
+}
+
+// DELETE /webhooks/:id - Stop sending events to a webhook (pending deliveries fail)
+func (h *WebhookHandlers) deleteWebhook(c *gin.Context) {
+	webhook, ok := h.findWebhook(c)
+	if !ok {
+		return
+	}
+
+	if err := h.webhooks.Delete(webhook); err != nil {
+		RespondInternalError(c, err)
+		return
+	}
+
+	Respond(c, http.StatusOK, SuccessResponse{
+		Success: true,
+		Data:    gin.H{"message": "Webhook deleted successfully"},
+	})
+}
+
+// GET /webhooks/:id/deliveries - The webhook's delivery log, ?limit=&offset= to page
+func (h *WebhookHandlers) getWebhookDeliveries(c *gin.Context) {
+	webhook, ok := h.findWebhook(c)
+	if !ok {
+		return
+	}
+
+	page, issues := ParsePage(c)
+	if issues != nil {
+		RespondValidationError(c, "", issues)
+		return
+	}
+
+	deliveries, err := h.webhooks.Deliveries(webhook.ID, page)
+	if err != nil {
+		RespondInternalError(c, err)
+		return
+	}
+
+	Respond(c, http.StatusOK, SuccessResponse{
+		Success: true,
+		Data:    deliveries,
+	})
+}
+
+// POST /webhooks/:id/deliveries/:delivery_id/redeliver - Send a delivery again
+// as soon as the dispatcher next runs. A failed delivery gets one more attempt.
+func (h *WebhookHandlers) redeliverWebhook(c *gin.Context) {
+	webhook, ok := h.findWebhook(c)
+	if !ok {
+		return
+	}
+	deliveryID, ok := uintParam(c, "delivery_id")
+	if !ok {
+		return
+	}
+
+	delivery, err := h.webhooks.Redeliver(webhook.ID, deliveryID)
+	if err != nil {
+		RespondDBError(c, err, CodeDeliveryNotFound)
+		return
+	}
+
+	Respond(c, http.StatusAccepted, SuccessResponse{
+		Success: true,
+		Data:    delivery,
+	})
+}
+
+// findWebhook loads :id if it belongs to the current user. Other users'
+// webhooks are reported as missing rather than forbidden.
+func (h *WebhookHandlers) findWebhook(c *gin.Context) (Webhook, bool) {
+	userID, _ := GetCurrentUserID(c)
+	id, ok := uintParam(c, "id")
+	if !ok {
+		return Webhook{}, false
+	}
+
+	webhook, err := h.webhooks.Get(userID, id)
+	if err != nil {
+		RespondDBError(c, err, CodeWebhookNotFound)
+		return Webhook{}, false
+	}
+	webhook.Secret = ""
+	return webhook, true
+}
+
+// internalHost reports whether host is obviously not public. Names are only
+// resolved when a delivery dials them, where checkWebhookDial catches the rest.
+func internalHost(host string) bool {
+	if addr, err := netip.ParseAddr(host); err == nil {
+		return !publicAddress(addr)
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	return host == "localhost" || strings.HasSuffix(host, ".localhost")
+}
+
+// validate checks what binding tags can't: the URL, the secret's length and
+// the event names
+func (in WebhookInput) validate() []ValidationIssue {
+	var issues []ValidationIssue
+
+	if u, err := url.Parse(in.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		issues = append(issues, ValidationIssue{Location: "body", Field: "url", Message: "must be an absolute http or https URL"})
+	} else if !AllowPrivateWebhooks && internalHost(u.Hostname()) {
+		issues = append(issues, ValidationIssue{Location: "body", Field: "url", Message: "must not point to a loopback or private address"})
+	}
+	if in.Secret != "" && len(in.Secret) < minWebhookSecret {
+		issues = append(issues, ValidationIssue{Location: "body", Field: "secret", Message: "must be at least " + strconv.Itoa(minWebhookSecret) + " characters"})
+	}
+
+	if len(in.Events) == 0 {
+		issues = append(issues, ValidationIssue{Location: "body", Field: "events", Message: "must list at least one event"})
+	}
+	for _, event := range in.Events {
+		if !slices.Contains(webhookEventTypes, event) {
+			issues = append(issues, ValidationIssue{Location: "body", Field: "events", Message: strconv.Quote(event) + " is not an event type"})
+		}
+	}
+
+	return issues
+}
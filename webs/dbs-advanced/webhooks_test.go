@@ -0,0 +1,312 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"example.com/dbs/client"
+)
+
+// webhookReceiver is a local endpoint that records what it is sent and
+// answers with status
+type webhookReceiver struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	status   int
+	requests []receivedWebhook
+}
+
+type receivedWebhook struct {
+	header http.Header
+	body   []byte
+}
+
+// newWebhookReceiver starts a receiver. It listens on loopback, so webhooks
+// may deliver to private addresses until the test ends.
+func newWebhookReceiver(t *testing.T) *webhookReceiver {
+	t.Helper()
+
+	AllowPrivateWebhooks = true
+	t.Cleanup(func() { AllowPrivateWebhooks = false })
+
+	r := &webhookReceiver{status: http.StatusNoContent}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.requests = append(r.requests, receivedWebhook{header: req.Header.Clone(), body: body})
+		w.WriteHeader(r.status)
+	}))
+	t.Cleanup(r.Close)
+	return r
+}
+
+func (r *webhookReceiver) answer(status int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.status = status
+}
+
+func (r *webhookReceiver) received() []receivedWebhook {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]receivedWebhook(nil), r.requests...)
+}
+
+// apiCall sends a JSON request to the router and decodes data from the envelope
+func apiCall(t *testing.T, server *httptest.Server, apiKey, method, path string, body, data interface{}) int {
+	t.Helper()
+
+	var reqBody io.Reader
+	if body != nil {
+		encoded, _ := json.Marshal(body)
+		reqBody = bytes.NewReader(encoded)
+	}
+	req, _ := http.NewRequest(method, server.URL+path, reqBody)
+	req.Header.Set("X-API-Key", apiKey)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+
+	if data != nil && resp.StatusCode < 300 {
+		envelope := struct {
+			Data interface{} `json:"data"`
+		}{Data: data}
+		if err := json.NewDecoder(resp.Body).Decode(&envelope); err != nil {
+			t.Fatalf("%s %s: decode: %v", method, path, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func TestWebhookDeliveriesAreSigned(t *testing.T) {
+	setupTestDB(t)
+	ctx := context.Background()
+	user := createTestUser(t, "hooks@example.com")
+	server := httptest.NewServer(setupRouter(NewServices(DB)))
+	t.Cleanup(server.Close)
+	receiver := newWebhookReceiver(t)
+
+	var webhook Webhook
+	status := apiCall(t, server, user.APIKey, http.MethodPost, "/webhooks",
+		WebhookInput{URL: receiver.URL, Events: []string{EventAlbumCreated}}, &webhook)
+	if status != http.StatusCreated || webhook.Secret == "" {
+		t.Fatalf("POST /webhooks = %d with secret %q, want 201 and a generated secret", status, webhook.Secret)
+	}
+
+	c := client.New(server.URL, client.WithAPIKey(user.APIKey), client.WithRetries(0, 0))
+	album, err := c.CreateAlbum(ctx, client.AlbumInput{Title: "Kind of Blue", Artist: "Miles Davis", Price: 29.99})
+	if err != nil {
+		t.Fatalf("CreateAlbum: %v", err)
+	}
+	if err := c.DeleteAlbum(ctx, album.ID); err != nil { // not subscribed: no delivery
+		t.Fatalf("DeleteAlbum: %v", err)
+	}
+
+	if err := DispatchWebhooks(ctx); err != nil {
+		t.Fatalf("DispatchWebhooks: %v", err)
+	}
+
+	received := receiver.received()
+	if len(received) != 1 {
+		t.Fatalf("receiver got %d requests, want 1", len(received))
+	}
+	got := received[0]
+	if got.header.Get("X-Webhook-Event") != EventAlbumCreated {
+		t.Errorf("X-Webhook-Event = %q, want %q", got.header.Get("X-Webhook-Event"), EventAlbumCreated)
+	}
+
+	// t=<timestamp>,v1=<hex HMAC of "timestamp.body">
+	parts := strings.Split(got.header.Get("X-Webhook-Signature"), ",")
+	if len(parts) != 2 || !strings.HasPrefix(parts[0], "t=") {
+		t.Fatalf("X-Webhook-Signature = %q", got.header.Get("X-Webhook-Signature"))
+	}
+	if want := "v1=" + SignWebhook(webhook.Secret, strings.TrimPrefix(parts[0], "t="), got.body); parts[1] != want {
+		t.Errorf("signature = %q, want %q", parts[1], want)
+	}
+
+	var payload WebhookPayload
+	if err := json.Unmarshal(got.body, &payload); err != nil {
+		t.Fatalf("decode payload: %v", err)
+	}
+	if payload.Type != EventAlbumCreated || payload.Album.ID != album.ID || payload.Album.User != nil {
+		t.Errorf("payload = %+v, want album %d without its owner", payload, album.ID)
+	}
+
+	var deliveries []WebhookDelivery
+	apiCall(t, server, user.APIKey, http.MethodGet, "/webhooks/"+itoa(webhook.ID)+"/deliveries", nil, &deliveries)
+	if len(deliveries) != 1 || deliveries[0].Status != DeliverySucceeded || deliveries[0].LastStatusCode != http.StatusNoContent {
+		t.Errorf("delivery log = %+v, want one succeeded delivery", deliveries)
+	}
+}
+
+func TestWebhookRetryAndRedeliver(t *testing.T) {
+	setupTestDB(t)
+	ctx := context.Background()
+	user := createTestUser(t, "hooks@example.com")
+	other := createTestUser(t, "other@example.com")
+	server := httptest.NewServer(setupRouter(NewServices(DB)))
+	t.Cleanup(server.Close)
+	receiver := newWebhookReceiver(t)
+	receiver.answer(http.StatusServiceUnavailable)
+
+	var webhook Webhook
+	apiCall(t, server, user.APIKey, http.MethodPost, "/webhooks",
+		WebhookInput{URL: receiver.URL, Secret: "a-long-enough-secret", Events: webhookEventTypes}, &webhook)
+
+	if _, err := NewServices(DB).Albums.Create(Tenant{}, user.ID, AlbumInput{Title: strPtr("Jeru"), Artist: strPtr("Gerry Mulligan"), Price: floatPtr(17.99)}); err != nil {
+		t.Fatalf("CreateAlbum: %v", err)
+	}
+	if err := DispatchWebhooks(ctx); err != nil {
+		t.Fatalf("DispatchWebhooks: %v", err)
+	}
+
+	var delivery WebhookDelivery
+	if err := DB.Where("webhook_id = ?", webhook.ID).First(&delivery).Error; err != nil {
+		t.Fatalf("load delivery: %v", err)
+	}
+	if delivery.Status != DeliveryPending || delivery.Attempts != 1 || delivery.LastStatusCode != http.StatusServiceUnavailable {
+		t.Errorf("after a 503: %+v, want pending with 1 attempt", delivery)
+	}
+	if delivery.NextAttemptAt == nil || time.Until(*delivery.NextAttemptAt) < webhookBaseBackoff/2 {
+		t.Errorf("next attempt at %v, want about %v from now", delivery.NextAttemptAt, webhookBaseBackoff)
+	}
+
+	// Not due yet, so nothing is sent
+	if err := DispatchWebhooks(ctx); err != nil {
+		t.Fatalf("DispatchWebhooks: %v", err)
+	}
+	if n := len(receiver.received()); n != 1 {
+		t.Errorf("receiver got %d requests before the backoff ran out, want 1", n)
+	}
+
+	redeliver := "/webhooks/" + itoa(webhook.ID) + "/deliveries/" + itoa(delivery.ID) + "/redeliver"
+	if status := apiCall(t, server, other.APIKey, http.MethodPost, redeliver, nil, nil); status != http.StatusNotFound {
+		t.Errorf("redeliver as another user = %d, want 404", status)
+	}
+	if status := apiCall(t, server, user.APIKey, http.MethodPost, redeliver, nil, nil); status != http.StatusAccepted {
+		t.Fatalf("redeliver = %d, want 202", status)
+	}
+
+	receiver.answer(http.StatusOK)
+	if err := DispatchWebhooks(ctx); err != nil {
+		t.Fatalf("DispatchWebhooks: %v", err)
+	}
+
+	DB.First(&delivery, delivery.ID)
+	if delivery.Status != DeliverySucceeded || delivery.Attempts != 2 || delivery.DeliveredAt == nil {
+		t.Errorf("after redelivery: %+v, want succeeded on attempt 2", delivery)
+	}
+	received := receiver.received()
+	if len(received) != 2 || received[0].header.Get("X-Webhook-ID") != received[1].header.Get("X-Webhook-ID") {
+		t.Errorf("want 2 requests for the same event, got %d", len(received))
+	}
+}
+
+func TestWebhookAddresses(t *testing.T) {
+	tests := []struct {
+		address string
+		public  bool
+	}{
+		{"93.184.216.34:443", true},
+		{"[2606:2800:220:1::1]:80", true},
+		{"127.0.0.1:8080", false},
+		{"[::1]:443", false},
+		{"[::ffff:127.0.0.1]:80", false},
+		{"0.0.0.0:80", false},
+		{"10.1.2.3:80", false},
+		{"172.16.0.1:80", false},
+		{"192.168.1.1:80", false},
+		{"100.100.100.200:80", false}, // shared address space, used for metadata
+		{"169.254.169.254:80", false},
+		{"[fe80::1]:80", false},
+		{"[fd00::1]:80", false},
+		{"224.0.0.1:80", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.address, func(t *testing.T) {
+			err := checkWebhookDial("tcp", tt.address, nil)
+			if (err == nil) != tt.public || (err != nil && !errors.Is(err, errWebhookAddress)) {
+				t.Errorf("checkWebhookDial(%s) = %v, want public %v", tt.address, err, tt.public)
+			}
+		})
+	}
+}
+
+// Webhooks can't be pointed at the server itself or its network, either when
+// they are registered or, for names that resolve there, when they are sent
+func TestWebhooksRejectPrivateAddresses(t *testing.T) {
+	setupTestDB(t)
+	ctx := context.Background()
+	user := createTestUser(t, "hooks@example.com")
+	server := httptest.NewServer(setupRouter(NewServices(DB)))
+	t.Cleanup(server.Close)
+
+	for _, url := range []string{"http://127.0.0.1:8080/hook", "http://[::1]/hook", "http://169.254.169.254/latest/meta-data", "http://10.0.0.1/hook", "http://LocalHost./hook", "http://api.localhost/hook"} {
+		t.Run(url, func(t *testing.T) {
+			status := apiCall(t, server, user.APIKey, http.MethodPost, "/webhooks", WebhookInput{URL: url, Events: webhookEventTypes}, nil)
+			if status != http.StatusBadRequest {
+				t.Errorf("POST /webhooks with %s = %d, want 400", url, status)
+			}
+		})
+	}
+
+	// Registered while allowed, then sent once it no longer is
+	receiver := newWebhookReceiver(t)
+	var webhook Webhook
+	apiCall(t, server, user.APIKey, http.MethodPost, "/webhooks",
+		WebhookInput{URL: strings.Replace(receiver.URL, "127.0.0.1", "localhost", 1), Events: webhookEventTypes}, &webhook)
+	AllowPrivateWebhooks = false
+
+	if _, err := NewServices(DB).Albums.Create(Tenant{}, user.ID, AlbumInput{Title: strPtr("Jeru"), Artist: strPtr("Gerry Mulligan"), Price: floatPtr(17.99)}); err != nil {
+		t.Fatalf("CreateAlbum: %v", err)
+	}
+	if err := DispatchWebhooks(ctx); err != nil {
+		t.Fatalf("DispatchWebhooks: %v", err)
+	}
+
+	var delivery WebhookDelivery
+	DB.Where("webhook_id = ?", webhook.ID).First(&delivery)
+	if n := len(receiver.received()); n != 0 || delivery.Status != DeliveryPending || !strings.Contains(delivery.LastError, errWebhookAddress.Error()) {
+		t.Errorf("receiver got %d requests, delivery %+v; want none and the address error", n, delivery)
+	}
+}
+
+func TestWebhookBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{4, 4 * time.Minute},
+		{7, 32 * time.Minute},
+		{20, time.Hour},
+	}
+	for _, tt := range tests {
+		if got := webhookBackoff(tt.attempts); got != tt.want {
+			t.Errorf("webhookBackoff(%d) = %v, want %v", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+func itoa(id uint) string {
+	return strconv.FormatUint(uint64(id), 10)
+}
@@ -0,0 +1,17 @@
+package main
+
+import (
+	"log"
+
+	"example.com/albums/database"
+)
+
+// Connect to the database DB_DRIVER names: Postgres, or SQLite in DB_PATH
+// (gorm-queries.db by default), as example.com/albums/database explains
+func connectDB() {
+	var err error
+	DB, err = database.Open("gorm-queries")
+	if err != nil {
+		log.Fatal("Failed to connect to database:", err)
+	}
+}
@@ -1,14 +1,16 @@
 package main
 
 import (
-	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
 
+	"example.com/albums/apierror"
+	"example.com/albums/catalog"
+	"example.com/albums/negotiate"
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
-	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 )
 
@@ -16,10 +18,24 @@ import (
 var DB *gorm.DB
 
 func main() {
-	
+
 	loadEnv() // load credentials
 
-	
+	// `migrate ...` manages the schema and `seed ...` loads fixtures, then
+	// they exit (see migrate.go and seed.go)
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrateCommand(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "seed" {
+		if err := runSeedCommand(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	initDB() // Initialize database, check connections if they are working perfectly.
 
 	// Set Gin mode from env
@@ -27,21 +43,27 @@ func main() {
 		gin.SetMode(mode) // set test, release or debug
 	}
 
+	// The handlers keep albums in the database; catalog.NewMemory would keep
+	// them in memory instead
+	albums := NewAlbumHandlers(catalog.NewService(catalog.NewGorm[uint, Album](DB)))
+
 	router := gin.Default()
-	
+	router.Use(RequestID())                    // X-Request-ID for matching client errors to logs
+	router.Use(apiErrors.ContentNegotiation()) // JSON, XML, YAML or MessagePack based on headers
+
 	// Routes
-	router.GET("/albums", getAlbums)
-	router.POST("/albums", postAlbums)
-	router.GET("/albums/:id", getAlbumByID)
-	router.PUT("/albums/:id", updateAlbum)
-	router.DELETE("/albums/:id", deleteAlbum)
-	
+	router.GET("/albums", albums.getAlbums)
+	router.POST("/albums", albums.postAlbums)
+	router.GET("/albums/:id", albums.getAlbumByID)
+	router.PUT("/albums/:id", albums.updateAlbum)
+	router.DELETE("/albums/:id", albums.deleteAlbum)
+
 	// Get port from env or use default
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "8080"
 	}
-	
+
 	log.Printf("Server starting on port %s", port)
 	router.Run(":" + port)
 }
@@ -56,193 +78,172 @@ func loadEnv() {
 	}
 }
 
-// Build database connection string from env variables
-func getDatabaseDSN() string {
-	host := os.Getenv("DB_HOST")
-	user := os.Getenv("DB_USER")
-	password := os.Getenv("DB_PASSWORD")
-	dbname := os.Getenv("DB_NAME")
-	port := os.Getenv("DB_PORT")
-	sslmode := os.Getenv("DB_SSLMODE")
-
-	// Set defaults if not provided
-	if host == "" {
-		host = "localhost"
-	}
-	if port == "" {
-		port = "5432"
-	}
-	if sslmode == "" {
-		sslmode = "disable"
-	}
-
-	// Check required fields
-	if user == "" || password == "" || dbname == "" {
-		log.Fatal("Missing required database credentials: DB_USER, DB_PASSWORD, or DB_NAME")
-	}
-
-	return fmt.Sprintf(
-		"host=%s user=%s password=%s dbname=%s port=%s sslmode=%s",
-		host, user, password, dbname, port, sslmode,
-	)
-}
-
-// Initialize PostgreSQL connection
+// Initialize the database connection
 func initDB() {
-	dsn := getDatabaseDSN()
+	connectDB()
 
-	var err error
-	DB, err = gorm.Open(postgres.Open(dsn), &gorm.Config{})
-	if err != nil {
-		log.Fatal("Failed to connect to database:", err)
-	}
-
-	// Auto migrate the schema
-	if err := DB.AutoMigrate(&Album{}); err != nil {
+	// Apply the pending migrations in migrations/
+	if err := migrator.OnStart(DB); err != nil {
 		log.Fatal("Failed to migrate database:", err)
 	}
-	
+
 	log.Println("Database connected and migrated successfully")
 }
 
 // Response structs
 type SuccessResponse struct {
-	Success bool        `json:"success"`
-	Data    interface{} `json:"data"`
+	Success bool        `json:"success" xml:"success"`
+	Data    interface{} `json:"data" xml:"data"`
 }
 
-type ErrorResponse struct {
-	Success bool   `json:"success"`
-	Error   string `json:"error"`
-}
+// Errors are sent in the envelope shared with the other album services, see
+// example.com/albums/apierror; a missing album is the one error of ours.
+const CodeAlbumNotFound apierror.Code = "ALBUM_NOT_FOUND"
+
+var apiErrors = apierror.New("gorms", apierror.Catalogue{
+	CodeAlbumNotFound: {http.StatusNotFound, "Album not found"},
+})
 
 // Album model with GORM tags
 type Album struct {
-	ID     uint    `gorm:"primaryKey" json:"id"`
-	Title  string  `gorm:"not null" json:"title"`
-	Artist string  `gorm:"not null" json:"artist"`
-	Price  float64 `gorm:"not null" json:"price"`
+	ID     uint    `gorm:"primaryKey" json:"id" xml:"id"`
+	Title  string  `gorm:"not null" json:"title" xml:"title"`
+	Artist string  `gorm:"not null" json:"artist" xml:"artist"`
+	Price  float64 `gorm:"not null" json:"price" xml:"price"`
+}
+
+// AlbumID, WithAlbumID and AlbumFields make Album a catalog.Album, for the
+// shared repositories and service
+func (a Album) AlbumID() uint { return a.ID }
+
+func (a Album) WithAlbumID(id uint) Album {
+	a.ID = id
+	return a
+}
+
+func (a Album) AlbumFields() catalog.Fields {
+	return catalog.Fields{Title: a.Title, Artist: a.Artist, Price: a.Price}
+}
+
+// AlbumHandlers serve the album routes
+type AlbumHandlers struct {
+	albums *catalog.Service[uint, Album]
+}
+
+// NewAlbumHandlers serves the albums in albums
+func NewAlbumHandlers(albums *catalog.Service[uint, Album]) *AlbumHandlers {
+	return &AlbumHandlers{albums: albums}
 }
 
 // GET /albums - Get all albums
-func getAlbums(c *gin.Context) {
-	var albums []Album
-	
-	result := DB.Find(&albums) // retrieves all albums here.
-	if result.Error != nil {
-		c.JSON(http.StatusInternalServerError, ErrorResponse{
-			Success: false,
-			Error:   result.Error.Error(),
-		})
+func (h *AlbumHandlers) getAlbums(c *gin.Context) {
+	albums, err := h.albums.List() // retrieves all albums here.
+	if err != nil {
+		apiErrors.Internal(c, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, SuccessResponse{
+	negotiate.Respond(c, http.StatusOK, SuccessResponse{
 		Success: true,
 		Data:    albums,
 	})
 }
 
 // POST /albums - Create new album
-func postAlbums(c *gin.Context) {
+func (h *AlbumHandlers) postAlbums(c *gin.Context) {
 	var newAlbum Album
 
-	if err := c.BindJSON(&newAlbum); err != nil {
-		c.JSON(http.StatusBadRequest, ErrorResponse{
-			Success: false,
-			Error:   err.Error(),
-		})
+	if err := negotiate.BindBody(c, &newAlbum); err != nil {
+		apiErrors.Respond(c, apierror.CodeValidationFailed, err.Error(), nil)
 		return
 	}
 
-	result := DB.Create(&newAlbum) // create a new record given the payload sent to it
-	if result.Error != nil {
-		c.JSON(http.StatusInternalServerError, ErrorResponse{
-			Success: false,
-			Error:   result.Error.Error(),
-		})
+	newAlbum, err := h.albums.Create(newAlbum) // create a new record given the payload sent to it
+	if err != nil {
+		apiErrors.RespondDB(c, err, CodeAlbumNotFound)
 		return
 	}
 
-	c.JSON(http.StatusCreated, SuccessResponse{
+	negotiate.Respond(c, http.StatusCreated, SuccessResponse{
 		Success: true,
 		Data:    newAlbum,
 	})
 }
 
 // GET /albums/:id - Get album by ID
-func getAlbumByID(c *gin.Context) {
-	id := c.Param("id")
-	var album Album
-
-	result := DB.First(&album, id) // retriving the first album
-	if result.Error != nil {
-		c.JSON(http.StatusNotFound, ErrorResponse{
-			Success: false,
-			Error:   "Album not found",
-		})
+func (h *AlbumHandlers) getAlbumByID(c *gin.Context) {
+	id, ok := albumIDParam(c)
+	if !ok {
+		return
+	}
+
+	album, err := h.albums.Get(id) // retriving the album
+	if err != nil {
+		apiErrors.RespondDB(c, err, CodeAlbumNotFound)
 		return
 	}
 
-	c.JSON(http.StatusOK, SuccessResponse{
+	negotiate.Respond(c, http.StatusOK, SuccessResponse{
 		Success: true,
 		Data:    album,
 	})
 }
 
 // PUT /albums/:id - Update album
-func updateAlbum(c *gin.Context) {
-	id := c.Param("id")
-	var album Album
-
-	if err := DB.First(&album, id).Error; err != nil {
-		c.JSON(http.StatusNotFound, ErrorResponse{
-			Success: false,
-			Error:   "Album not found",
-		})
+func (h *AlbumHandlers) updateAlbum(c *gin.Context) {
+	id, ok := albumIDParam(c)
+	if !ok {
 		return
 	}
 
-	if err := c.BindJSON(&album); err != nil {
-		c.JSON(http.StatusBadRequest, ErrorResponse{
-			Success: false,
-			Error:   err.Error(),
-		})
+	album, err := h.albums.Get(id)
+	if err != nil {
+		apiErrors.RespondDB(c, err, CodeAlbumNotFound)
 		return
 	}
 
-	DB.Save(&album) // save the entrire struct this is a good way to update  a fulll record
+	if err := negotiate.BindBody(c, &album); err != nil {
+		apiErrors.Respond(c, apierror.CodeValidationFailed, err.Error(), nil)
+		return
+	}
 
-	c.JSON(http.StatusOK, SuccessResponse{
+	// save the entrire struct this is a good way to update  a fulll record
+	album, err = h.albums.Update(id, album)
+	if err != nil {
+		apiErrors.RespondDB(c, err, CodeAlbumNotFound)
+		return
+	}
+
+	negotiate.Respond(c, http.StatusOK, SuccessResponse{
 		Success: true,
 		Data:    album,
 	})
 }
 
 // DELETE /albums/:id - Delete album
-func deleteAlbum(c *gin.Context) {
-	id := c.Param("id")
-	var album Album
-
-	result := DB.Delete(&album, id)
-	if result.Error != nil {
-		c.JSON(http.StatusInternalServerError, ErrorResponse{
-			Success: false,
-			Error:   result.Error.Error(),
-		})
+func (h *AlbumHandlers) deleteAlbum(c *gin.Context) {
+	id, ok := albumIDParam(c)
+	if !ok {
 		return
 	}
 
-	if result.RowsAffected == 0 {
-		c.JSON(http.StatusNotFound, ErrorResponse{
-			Success: false,
-			Error:   "Album not found",
-		})
+	if err := h.albums.Delete(id); err != nil {
+		apiErrors.RespondDB(c, err, CodeAlbumNotFound)
 		return
 	}
 
-	c.JSON(http.StatusOK, SuccessResponse{
+	negotiate.Respond(c, http.StatusOK, SuccessResponse{
 		Success: true,
 		Data:    gin.H{"message": "Album deleted successfully"},
 	})
-}
\ No newline at end of file
+}
+
+// albumIDParam parses :id, answering 400 itself when it isn't a positive integer
+func albumIDParam(c *gin.Context) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 0)
+	if err != nil || id == 0 {
+		apiErrors.Respond(c, apierror.CodeValidationFailed, "id must be a positive integer", nil)
+		return 0, false
+	}
+	return uint(id), true
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"embed"
+
+	"example.com/albums/migrate"
+	"gorm.io/gorm"
+)
+
+// The schema is built by the versioned SQL files in migrations/, compiled
+// into the binary (see example.com/albums/migrate). dbs-advanced may share
+// the database and owns the albums table there, so this service's history
+// has a table of its own and undoing it never drops albums.
+//
+//go:embed migrations
+var migrationFiles embed.FS
+
+// migrator applies gorm-queries' migrations, recorded in
+// gorm_queries_schema_migrations
+var migrator = migrate.Migrator{Files: migrationFiles, Dir: "migrations", Table: "gorm_queries_schema_migrations"}
+
+// runMigrateCommand runs `migrate up [N]`, `migrate down [N]`, `migrate status`
+// or `migrate create NAME`
+func runMigrateCommand(args []string) error {
+	return migrator.Command(args, func() *gorm.DB {
+		connectDB()
+		return DB
+	})
+}
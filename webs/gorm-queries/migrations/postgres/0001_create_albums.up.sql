@@ -0,0 +1,10 @@
+-- dbs-advanced owns albums and builds it with more columns when the two
+-- share a database; if it got there first the table is already here
+
+CREATE TABLE IF NOT EXISTS "albums" (
+  "id" bigserial,
+  "title" text NOT NULL,
+  "artist" text NOT NULL,
+  "price" decimal NOT NULL,
+  PRIMARY KEY ("id")
+);
@@ -0,0 +1,3 @@
+-- Nothing to undo: albums may hold dbs-advanced's albums too, and that
+-- service owns the table. Dropping it is left to dbs-advanced's migrations
+-- or to whoever removes the database.
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"embed"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"math/rand"
+	"os"
+	"path"
+
+	"github.com/goccy/go-yaml"
+	"gorm.io/gorm"
+)
+
+// `seed` fills the database with albums from the fixture files in
+// fixtures/<environment>/, YAML or JSON. Albums are matched by title and
+// artist, so seeding again updates what is there rather than adding it twice.
+// This service has no users; dbs-advanced seeds those.
+//
+//go:embed fixtures
+var fixtureFiles embed.FS
+
+// Fixtures is what a fixture file holds
+type Fixtures struct {
+	Albums []AlbumFixture `json:"albums"`
+}
+
+// AlbumFixture is an album to seed
+type AlbumFixture struct {
+	Title  string  `json:"title"`
+	Artist string  `json:"artist"`
+	Price  float64 `json:"price"`
+}
+
+// SeedResult counts what seeding created and updated
+type SeedResult struct {
+	AlbumsCreated, AlbumsUpdated int
+}
+
+// LoadFixtures reads every .yaml, .yml and .json file in fsys's directory
+// for env, in name order
+func LoadFixtures(fsys fs.FS, env string) (Fixtures, error) {
+	entries, err := fs.ReadDir(fsys, env)
+	if errors.Is(err, fs.ErrNotExist) {
+		return Fixtures{}, fmt.Errorf("no fixtures for environment %q", env)
+	}
+	if err != nil {
+		return Fixtures{}, err
+	}
+
+	var all Fixtures
+	for _, entry := range entries {
+		switch path.Ext(entry.Name()) {
+		case ".yaml", ".yml", ".json":
+		default:
+			continue
+		}
+		data, err := fs.ReadFile(fsys, path.Join(env, entry.Name()))
+		if err != nil {
+			return Fixtures{}, err
+		}
+		var fixtures Fixtures
+		if err := yaml.UnmarshalWithOptions(data, &fixtures, yaml.DisallowUnknownField()); err != nil {
+			return Fixtures{}, fmt.Errorf("%s/%s: %w", env, entry.Name(), err)
+		}
+		all.Albums = append(all.Albums, fixtures.Albums...)
+	}
+	return all, nil
+}
+
+// SeedFixtures creates or updates the fixtures' albums in one transaction
+func SeedFixtures(db *gorm.DB, fixtures Fixtures) (SeedResult, error) {
+	var result SeedResult
+	err := db.Transaction(func(tx *gorm.DB) error {
+		for _, fixture := range fixtures.Albums {
+			created, err := seedAlbum(tx, fixture)
+			if err != nil {
+				return fmt.Errorf("album %q by %s: %w", fixture.Title, fixture.Artist, err)
+			}
+			if created {
+				result.AlbumsCreated++
+			} else {
+				result.AlbumsUpdated++
+			}
+		}
+		return nil
+	})
+	return result, err
+}
+
+func seedAlbum(tx *gorm.DB, fixture AlbumFixture) (bool, error) {
+	if fixture.Title == "" || fixture.Artist == "" {
+		return false, errors.New("title and artist are required")
+	}
+
+	var album Album
+	err := tx.Where("title = ? AND artist = ?", fixture.Title, fixture.Artist).First(&album).Error
+	if err == nil {
+		return false, tx.Model(&album).Update("price", fixture.Price).Error
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, err
+	}
+	album = Album{Title: fixture.Title, Artist: fixture.Artist, Price: fixture.Price}
+	return true, tx.Create(&album).Error
+}
+
+// Words fake albums are made of
+var (
+	fakeAdjectives = []string{"Blue", "Midnight", "Electric", "Silent", "Golden", "Broken", "Velvet", "Distant", "Neon", "Wild"}
+	fakeNouns      = []string{"Train", "Horizon", "Echoes", "Garden", "Machine", "River", "Skyline", "Dreams", "Fire", "Harbor"}
+	fakeFirstNames = []string{"Ada", "Miles", "Nina", "Chet", "Ella", "Thelonious", "Billie", "Dexter", "Sonny", "Alice"}
+	fakeLastNames  = []string{"Coltrane", "Mingus", "Simone", "Baker", "Fitzgerald", "Monk", "Holiday", "Gordon", "Rollins", "Shorter"}
+)
+
+// fakeAlbums makes n albums for load testing. They are the same every time,
+// numbered in the title, so asking for more later only adds the new ones.
+func fakeAlbums(n int) []Album {
+	rng := rand.New(rand.NewSource(1))
+	pick := func(words []string) string { return words[rng.Intn(len(words))] }
+
+	albums := make([]Album, n)
+	for i := range albums {
+		albums[i] = Album{
+			Title:  fmt.Sprintf("%s %s No. %d", pick(fakeAdjectives), pick(fakeNouns), i+1),
+			Artist: pick(fakeFirstNames) + " " + pick(fakeLastNames),
+			Price:  float64(rng.Intn(9000)+500) / 100,
+		}
+	}
+	return albums
+}
+
+// SeedFakeAlbums adds the first n fake albums, skipping those already there.
+// It returns how many it added.
+func SeedFakeAlbums(db *gorm.DB, n int) (int, error) {
+	albums := fakeAlbums(n)
+	created := 0
+	err := db.Transaction(func(tx *gorm.DB) error {
+		for start := 0; start < len(albums); start += 1000 {
+			batch := albums[start:min(start+1000, len(albums))]
+			titles := make([]string, len(batch))
+			for i, album := range batch {
+				titles[i] = album.Title
+			}
+			var existing []string
+			if err := tx.Model(&Album{}).Where("title IN ?", titles).Pluck("title", &existing).Error; err != nil {
+				return err
+			}
+			seeded := map[string]bool{}
+			for _, title := range existing {
+				seeded[title] = true
+			}
+
+			var missing []Album
+			for _, album := range batch {
+				if !seeded[album.Title] {
+					missing = append(missing, album)
+				}
+			}
+			if len(missing) == 0 {
+				continue
+			}
+			if err := tx.CreateInBatches(missing, 500).Error; err != nil {
+				return err
+			}
+			created += len(missing)
+		}
+		return nil
+	})
+	return created, err
+}
+
+// runSeedCommand runs `seed [-env NAME] [-dir DIR] [-fake N]`. The
+// environment is SEED_ENV, or development, unless -env says; -dir reads the
+// fixtures from disk instead of those compiled in.
+func runSeedCommand(args []string) error {
+	flags := flag.NewFlagSet("seed", flag.ContinueOnError)
+	env := flags.String("env", os.Getenv("SEED_ENV"), "fixture set to load (default development)")
+	dir := flags.String("dir", "", "directory of fixture sets to use instead of the built-in ones")
+	fake := flags.Int("fake", 0, "also add this many fake albums")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() > 0 || *fake < 0 {
+		return errors.New("usage: seed [-env NAME] [-dir DIR] [-fake N]")
+	}
+	if *env == "" {
+		*env = "development"
+	}
+
+	fsys, err := fs.Sub(fixtureFiles, "fixtures")
+	if err != nil {
+		return err
+	}
+	if *dir != "" {
+		fsys = os.DirFS(*dir)
+	}
+	fixtures, err := LoadFixtures(fsys, *env)
+	if err != nil {
+		return err
+	}
+
+	connectDB()
+	if err := migrator.OnStart(DB); err != nil {
+		return err
+	}
+	result, err := SeedFixtures(DB, fixtures)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Seeded %s: %d albums created, %d updated\n", *env, result.AlbumsCreated, result.AlbumsUpdated)
+
+	if *fake > 0 {
+		created, err := SeedFakeAlbums(DB, *fake)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Added %d fake albums (%d were already there)\n", created, *fake-created)
+	}
+	return nil
+}
@@ -0,0 +1,16 @@
+package main
+
+import "example.com/albums/catalog"
+
+// AlbumID, WithAlbumID and AlbumFields make album a catalog.Album, for the
+// shared repositories and service. Clients pick the IDs here.
+func (a album) AlbumID() string { return a.ID }
+
+func (a album) WithAlbumID(id string) album {
+	a.ID = id
+	return a
+}
+
+func (a album) AlbumFields() catalog.Fields {
+	return catalog.Fields{Title: a.Title, Artist: a.Artist, Price: a.Price}
+}
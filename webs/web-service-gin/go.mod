@@ -4,7 +4,10 @@ module example/web-service-gin
 
 go 1.25.5
 
-require github.com/gin-gonic/gin v1.11.0
+require (
+	example.com/albums v0.0.0-00010101000000-000000000000
+	github.com/gin-gonic/gin v1.11.0
+)
 
 require (
 	github.com/bytedance/sonic v1.14.0 // indirect
@@ -17,6 +20,8 @@ require (
 	github.com/go-playground/validator/v10 v10.27.0 // indirect
 	github.com/goccy/go-json v0.10.2 // indirect
 	github.com/goccy/go-yaml v1.18.0 // indirect
+	github.com/jinzhu/inflection v1.0.0 // indirect
+	github.com/jinzhu/now v1.1.5 // indirect
 	github.com/json-iterator/go v1.1.12 // indirect
 	github.com/klauspost/cpuid/v2 v2.3.0 // indirect
 	github.com/leodido/go-urn v1.4.0 // indirect
@@ -38,4 +43,7 @@ require (
 	golang.org/x/text v0.27.0 // indirect
 	golang.org/x/tools v0.34.0 // indirect
 	google.golang.org/protobuf v1.36.9 // indirect
+	gorm.io/gorm v1.31.1 // indirect
 )
+
+replace example.com/albums => ../albums
@@ -1,77 +1,113 @@
 package main
 
 import (
-	"net/http"
+	"errors"
+	"example.com/albums/catalog"
 	"github.com/gin-gonic/gin"
+	"net/http"
 )
 
+func main() {
+	// the handlers get their albums from the service, which keeps them in memory
+	// under the IDs clients pick (nil: no IDs of our own)
+	handlers := NewAlbumHandlers(catalog.NewService(catalog.NewMemory[string, album](nil, albums...)))
 
-func main(){
 	router := gin.Default()
-	router.GET("/albums", getAlbums)
-	router.POST("/albums", postAlbums)
-	router.GET("/albums/:id", getAlbumByID)
+	router.GET("/albums", handlers.getAlbums)
+	router.POST("/albums", handlers.postAlbums)
+	router.GET("/albums/:id", handlers.getAlbumByID)
 	router.Run("localhost:8080")
 }
 
+// AlbumHandlers serve the album routes
+type AlbumHandlers struct {
+	albums *catalog.Service[string, album]
+}
+
+// NewAlbumHandlers serves the albums in albums
+func NewAlbumHandlers(albums *catalog.Service[string, album]) *AlbumHandlers {
+	return &AlbumHandlers{albums: albums}
+}
+
 type SuccessResponse struct {
-	Success bool `json:"success"`
-	Data interface{} `json:"data"`
+	Success bool        `json:"success"`
+	Data    interface{} `json:"data"`
 }
 
 type ErrorResponse struct {
-	Success bool `json:"success"`
-	Error string `json:"error"`
+	Success bool   `json:"success"`
+	Error   string `json:"error"`
 }
 
 type album struct {
-	ID     string `json:"id"`
+	ID     string  `json:"id"`
 	Title  string  `json:"title"`
-    Artist string  `json:"artist"`
-    Price  float64 `json:"price"`
+	Artist string  `json:"artist"`
+	Price  float64 `json:"price"`
 }
 
 // this is a slice here, this is so cool how the album data struct is populated
-var albums = [] album {
+// (the albums the service starts with)
+var albums = []album{
 	{ID: "1", Title: "Blue Train", Artist: "John Coltrane", Price: 56.99},
-    {ID: "2", Title: "Jeru", Artist: "Gerry Mulligan", Price: 17.99},
-    {ID: "3", Title: "Sarah Vaughan and Clifford Brown", Artist: "Sarah Vaughan", Price: 39.99},
+	{ID: "2", Title: "Jeru", Artist: "Gerry Mulligan", Price: 17.99},
+	{ID: "3", Title: "Sarah Vaughan and Clifford Brown", Artist: "Sarah Vaughan", Price: 39.99},
 }
 
-func getAlbums(c *gin.Context){
+func (h *AlbumHandlers) getAlbums(c *gin.Context) {
 	// the context is very important to carry request details
+	albums, err := h.albums.List()
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, ErrorResponse{Success: false, Error: err.Error()})
+		return
+	}
 	c.IndentedJSON(http.StatusOK, albums) // good for serialisation
 }
 
-func postAlbums(c *gin.Context){
+func (h *AlbumHandlers) postAlbums(c *gin.Context) {
 	var newAlbum album
 
 	if err := c.BindJSON(&newAlbum); err != nil { // if the error is nil everything is fine
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error": err.Error(),
 		})
+		return
 	}
 
-	albums = append(albums, newAlbum)
+	// the service checks the fields and the ID, and adds the album under the same lock
+	_, err := h.albums.Create(newAlbum)
+	var invalid *catalog.ValidationError
+	if errors.Is(err, catalog.ErrMissingID) || errors.Is(err, catalog.ErrDuplicateID) || errors.As(err, &invalid) {
+		c.IndentedJSON(http.StatusBadRequest, ErrorResponse{Success: false, Error: err.Error()})
+		return
+	}
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, ErrorResponse{Success: false, Error: err.Error()})
+		return
+	}
+
+	albums, err := h.albums.List()
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, ErrorResponse{Success: false, Error: err.Error()})
+		return
+	}
 
 	c.IndentedJSON(http.StatusCreated, albums)
 }
 
-func getAlbumByID(c *gin.Context){
+func (h *AlbumHandlers) getAlbumByID(c *gin.Context) {
 	id := c.Param("id")
 
-	for _, val := range albums {
-		if id == val.ID {
-			c.IndentedJSON(http.StatusOK, SuccessResponse{
-				Success: true,
-				Data: val,
-			})
-			return
-		}
+	if val, err := h.albums.Get(id); err == nil {
+		c.IndentedJSON(http.StatusOK, SuccessResponse{
+			Success: true,
+			Data:    val,
+		})
+		return
 	}
 
 	c.IndentedJSON(http.StatusNotFound, ErrorResponse{
 		Success: false,
-		Error:"album not found",
+		Error:   "album not found",
 	})
-}
\ No newline at end of file
+}